@@ -68,6 +68,7 @@ const (
 	VersionAlterSystemJobsAddCreatedByColumns
 	VersionAddScheduledJobsTable
 	VersionUserDefinedSchemas
+	VersionRaftLogQueueDisabled
 
 	// Add new versions here (step one of two).
 )
@@ -517,6 +518,13 @@ var versionsSingleton = keyedVersions([]keyedVersion{
 		Key:     VersionUserDefinedSchemas,
 		Version: roachpb.Version{Major: 20, Minor: 1, Unstable: 8},
 	},
+	{
+		// VersionRaftLogQueueDisabled enables the SetRaftLogQueueDisabled
+		// request, which records in the replicated range state whether the
+		// application of commands offers the range to the raft log queue.
+		Key:     VersionRaftLogQueueDisabled,
+		Version: roachpb.Version{Major: 20, Minor: 1, Unstable: 9},
+	},
 
 	// Add new versions here (step two of two).
 
@@ -95,6 +95,10 @@ var (
 	// LocalLeaseAppliedIndexLegacySuffix is the suffix for the applied lease
 	// index.
 	LocalLeaseAppliedIndexLegacySuffix = []byte("rlla")
+	// LocalRangeRaftLogQueueDisabledSuffix is the suffix for the flag which
+	// stops the range's replicas from being offered to the raft log queue when
+	// commands are applied.
+	LocalRangeRaftLogQueueDisabledSuffix = []byte("rlqd")
 	// LocalRangeStatsLegacySuffix is the suffix for range statistics.
 	LocalRangeStatsLegacySuffix = []byte("stat")
 	// localTxnSpanGCThresholdSuffix is DEPRECATED and remains to prevent reuse.
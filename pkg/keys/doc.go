@@ -178,14 +178,15 @@ var _ = [...]interface{}{
 	//   range as a whole. Though they are replicated, they are unaddressable.
 	//   Typical examples are MVCC stats and the abort span. They all share
 	//   `LocalRangeIDPrefix` and `LocalRangeIDReplicatedInfix`.
-	AbortSpanKey,                // "abc-"
-	RangeLastGCKey,              // "lgc-"
-	RangeAppliedStateKey,        // "rask"
-	RaftAppliedIndexLegacyKey,   // "rfta"
-	RaftTruncatedStateLegacyKey, // "rftt"
-	RangeLeaseKey,               // "rll-"
-	LeaseAppliedIndexLegacyKey,  // "rlla"
-	RangeStatsLegacyKey,         // "stat"
+	AbortSpanKey,                 // "abc-"
+	RangeLastGCKey,               // "lgc-"
+	RangeAppliedStateKey,         // "rask"
+	RaftAppliedIndexLegacyKey,    // "rfta"
+	RaftTruncatedStateLegacyKey,  // "rftt"
+	RangeLeaseKey,                // "rll-"
+	LeaseAppliedIndexLegacyKey,   // "rlla"
+	RangeRaftLogQueueDisabledKey, // "rlqd"
+	RangeStatsLegacyKey,          // "stat"
 
 	//   2. Unreplicated range-ID local keys: These contain metadata that
 	//   pertain to just one replica of a range. They are unreplicated and
@@ -265,6 +265,13 @@ func RangeLastGCKey(rangeID roachpb.RangeID) roachpb.Key {
 	return MakeRangeIDPrefixBuf(rangeID).RangeLastGCKey()
 }
 
+// RangeRaftLogQueueDisabledKey returns a system-local key for the flag which
+// stops the range's replicas from being offered to the raft log queue when
+// commands are applied.
+func RangeRaftLogQueueDisabledKey(rangeID roachpb.RangeID) roachpb.Key {
+	return MakeRangeIDPrefixBuf(rangeID).RangeRaftLogQueueDisabledKey()
+}
+
 // MakeRangeIDUnreplicatedPrefix creates a range-local key prefix from
 // rangeID for all unreplicated data.
 func MakeRangeIDUnreplicatedPrefix(rangeID roachpb.RangeID) roachpb.Key {
@@ -863,6 +870,12 @@ func (b RangeIDPrefixBuf) RangeLastGCKey() roachpb.Key {
 	return append(b.replicatedPrefix(), LocalRangeLastGCSuffix...)
 }
 
+// RangeRaftLogQueueDisabledKey returns a system-local key for the flag which
+// stops the range's replicas from being offered to the raft log queue.
+func (b RangeIDPrefixBuf) RangeRaftLogQueueDisabledKey() roachpb.Key {
+	return append(b.replicatedPrefix(), LocalRangeRaftLogQueueDisabledSuffix...)
+}
+
 // RangeTombstoneKey returns a system-local key for a range tombstone.
 func (b RangeIDPrefixBuf) RangeTombstoneKey() roachpb.Key {
 	return append(b.unreplicatedPrefix(), LocalRangeTombstoneSuffix...)
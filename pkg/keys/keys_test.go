@@ -147,6 +147,7 @@ func TestKeyAddressError(t *testing.T) {
 			RaftTruncatedStateLegacyKey(0),
 			RangeLeaseKey(0),
 			RangeStatsLegacyKey(0),
+			RangeRaftLogQueueDisabledKey(0),
 			RaftHardStateKey(0),
 			RaftLogPrefix(0),
 			RaftLogKey(0, 0),
@@ -37,7 +37,7 @@ func declareKeysComputeChecksum(
 // Version numbers for Replica checksum computation. Requests silently no-op
 // unless the versions are compatible.
 const (
-	ReplicaChecksumVersion    = 4
+	ReplicaChecksumVersion    = 5
 	ReplicaChecksumGCInterval = time.Hour
 )
 
@@ -54,12 +54,16 @@ func ComputeChecksum(
 
 	var pd result.Result
 	pd.Replicated.ComputeChecksum = &kvserverpb.ComputeChecksum{
-		Version:      args.Version,
-		ChecksumID:   reply.ChecksumID,
-		SaveSnapshot: args.Snapshot,
-		Mode:         args.Mode,
-		Checkpoint:   args.Checkpoint,
-		Terminate:    args.Terminate,
+		Version:        args.Version,
+		ChecksumID:     reply.ChecksumID,
+		SaveSnapshot:   args.Snapshot,
+		Mode:           args.Mode,
+		Checkpoint:     args.Checkpoint,
+		Terminate:      args.Terminate,
+		AsOf:           args.AsOf,
+		VerifySnapshot: args.VerifySnapshot,
+		CollectIntents: args.CollectIntents,
+		ExcludedSpans:  args.ExcludedSpans,
 	}
 	return pd, nil
 }
@@ -1031,6 +1031,9 @@ func splitTriggerHelper(
 		// hand side range (i.e. it goes from zero to its stats).
 		RHSDelta: *h.AbsPostSplitRight(),
 	}
+	// The client will go on to address the new right-hand side range, which
+	// must not be lost in a crash once it has been acknowledged.
+	pd.Local.RequireDurableApply = true
 
 	deltaPostSplitLeft := h.DeltaPostSplitLeft()
 	if !rec.ClusterSettings().Version.IsActive(ctx, clusterversion.VersionContainsEstimatesCounter) {
@@ -19,6 +19,7 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/kv/kvserver/spanset"
 	"github.com/cockroachdb/cockroach/pkg/roachpb"
 	"github.com/cockroachdb/cockroach/pkg/storage"
+	"github.com/cockroachdb/cockroach/pkg/storage/enginepb"
 	"github.com/cockroachdb/cockroach/pkg/util/hlc"
 )
 
@@ -74,6 +75,10 @@ func GC(
 	}
 
 	// Garbage collect the specified keys by expiration timestamps.
+	var statsBefore enginepb.MVCCStats
+	if cArgs.Stats != nil {
+		statsBefore = *cArgs.Stats
+	}
 	if err := storage.MVCCGarbageCollect(
 		ctx, readWriter, cArgs.Stats, keys, h.Timestamp,
 	); err != nil {
@@ -111,5 +116,47 @@ func GC(
 	if replState != (kvserverpb.ReplicaState{}) {
 		pd.Replicated.State = &replState
 	}
+
+	// If the GC removed a lot of data, suggest a compaction of the span it
+	// touched so that the space is reclaimed promptly, like ClearRange does.
+	// Suggestions are only acted upon by the compactor of RocksDB stores;
+	// Pebble accounts for deleted data when picking compactions by itself (see
+	// NewStore). The engine of the evaluating replica stands in for those of
+	// the other replicas, which only matters for clusters mixing engines.
+	if cArgs.Stats != nil && cArgs.EvalCtx.Engine().Type() != enginepb.EngineTypePebble {
+		removed := statsBefore.Total() - cArgs.Stats.Total()
+		if removed >= ClearRangeBytesThreshold {
+			if span, ok := gcKeySpan(keys); ok {
+				pd.Replicated.SuggestedCompactions = []kvserverpb.SuggestedCompaction{
+					{
+						StartKey: span.Key,
+						EndKey:   span.EndKey,
+						Compaction: kvserverpb.Compaction{
+							Bytes:            removed,
+							SuggestedAtNanos: h.Timestamp.WallTime,
+						},
+					},
+				}
+			}
+		}
+	}
 	return pd, nil
 }
+
+// gcKeySpan returns the smallest span containing all of the global keys in
+// gcKeys. Range-local keys are ignored as they're not worth compacting.
+func gcKeySpan(gcKeys []roachpb.GCRequest_GCKey) (roachpb.Span, bool) {
+	var span roachpb.Span
+	for _, k := range gcKeys {
+		if keys.IsLocal(k.Key) {
+			continue
+		}
+		if span.Key == nil || k.Key.Compare(span.Key) < 0 {
+			span.Key = k.Key
+		}
+		if span.EndKey == nil || k.Key.Compare(span.EndKey) >= 0 {
+			span.EndKey = k.Key.Next()
+		}
+	}
+	return span, span.Key != nil
+}
@@ -77,6 +77,20 @@ func checkCanReceiveLease(newLease *roachpb.Lease, rec EvalContext) error {
 	return nil
 }
 
+// checkLeaseStart returns an error if the new lease starts more than the
+// maximum clock offset ahead of the evaluating replica's clock. The start of
+// the lease becomes the timestamp cache low water mark of the new
+// leaseholder, so a lease taken out from a clock that is too far ahead would
+// push every subsequent write on the range into the future.
+func checkLeaseStart(newLease *roachpb.Lease, rec EvalContext) error {
+	clock := rec.Clock()
+	if maxStart := clock.Now().Add(clock.MaxOffset().Nanoseconds(), 0); maxStart.Less(newLease.Start) {
+		return errors.Errorf(
+			"lease start %s is more than the maximum clock offset ahead of %s", newLease.Start, maxStart)
+	}
+	return nil
+}
+
 // evalNewLease checks that the lease contains a valid interval and that
 // the new lease holder is still a member of the replica set, and then proceeds
 // to write the new lease to the batch, emitting an appropriate trigger.
@@ -172,6 +186,10 @@ func evalNewLease(
 
 	pd.Local.Metrics = new(result.Metrics)
 	if isTransfer {
+		// The previous leaseholder has stopped serving and is waiting for the
+		// transfer to go through. Don't let it believe that it did until the
+		// new lease can't be lost in a crash.
+		pd.Local.RequireDurableApply = true
 		pd.Local.Metrics.LeaseTransferSuccess = 1
 	} else {
 		pd.Local.Metrics.LeaseRequestSuccess = 1
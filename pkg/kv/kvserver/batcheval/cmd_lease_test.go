@@ -18,6 +18,7 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/base"
 	"github.com/cockroachdb/cockroach/pkg/roachpb"
 	"github.com/cockroachdb/cockroach/pkg/testutils/serverutils"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
 	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
 	"github.com/stretchr/testify/require"
@@ -154,3 +155,37 @@ func TestLeaseCommandLearnerReplica(t *testing.T) {
 		`replica (n2,s2):2LEARNER of type LEARNER cannot hold lease`
 	require.EqualError(t, err, expForLearner)
 }
+
+func TestLeaseCommandFutureStart(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	replicas := []roachpb.ReplicaDescriptor{
+		{NodeID: 1, StoreID: 1, Type: roachpb.ReplicaTypeVoterFull(), ReplicaID: 1},
+	}
+	desc := roachpb.RangeDescriptor{}
+	desc.SetReplicas(roachpb.MakeReplicaDescriptors(replicas))
+	manual := hlc.NewManualClock(123)
+	clock := hlc.NewClock(manual.UnixNano, time.Millisecond)
+	lease := roachpb.Lease{
+		Replica: replicas[0],
+		Start:   clock.Now().Add(2*time.Millisecond.Nanoseconds(), 0),
+	}
+	cArgs := CommandArgs{
+		EvalCtx: (&MockEvalCtx{
+			StoreID: 1,
+			Desc:    &desc,
+			Clock:   clock,
+		}).EvalContext(),
+		Args: &roachpb.TransferLeaseRequest{Lease: lease},
+	}
+
+	// Leases starting more than the maximum clock offset ahead of the local
+	// clock are rejected, see checkLeaseStart.
+	_, err := TransferLease(ctx, nil, cArgs, nil)
+	require.Regexp(t, `lease start .* is more than the maximum clock offset ahead of`, err)
+
+	cArgs.Args = &roachpb.RequestLeaseRequest{Lease: lease}
+	_, err = RequestLease(ctx, nil, cArgs, nil)
+	require.Regexp(t, `lease start .* is more than the maximum clock offset ahead of`, err)
+}
@@ -66,6 +66,9 @@ func TransferLease(
 	if err := checkCanReceiveLease(&args.Lease, cArgs.EvalCtx); err != nil {
 		return newFailedLeaseTrigger(true /* isTransfer */), err
 	}
+	if err := checkLeaseStart(&args.Lease, cArgs.EvalCtx); err != nil {
+		return newFailedLeaseTrigger(true /* isTransfer */), err
+	}
 
 	prevLease, _ := cArgs.EvalCtx.GetLease()
 	log.VEventf(ctx, 2, "lease transfer: prev lease: %+v, new lease: %+v", prevLease, args.Lease)
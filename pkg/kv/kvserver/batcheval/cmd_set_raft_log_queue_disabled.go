@@ -0,0 +1,69 @@
+// Copyright 2020 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package batcheval
+
+import (
+	"context"
+
+	"github.com/cockroachdb/cockroach/pkg/clusterversion"
+	"github.com/cockroachdb/cockroach/pkg/keys"
+	"github.com/cockroachdb/cockroach/pkg/kv/kvserver/batcheval/result"
+	"github.com/cockroachdb/cockroach/pkg/kv/kvserver/kvserverpb"
+	"github.com/cockroachdb/cockroach/pkg/kv/kvserver/spanset"
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/storage"
+	"github.com/cockroachdb/errors"
+)
+
+func init() {
+	RegisterReadWriteCommand(roachpb.SetRaftLogQueueDisabled, declareKeysSetRaftLogQueueDisabled, SetRaftLogQueueDisabled)
+}
+
+func declareKeysSetRaftLogQueueDisabled(
+	_ *roachpb.RangeDescriptor,
+	header roachpb.Header,
+	req roachpb.Request,
+	latchSpans, _ *spanset.SpanSet,
+) {
+	latchSpans.AddNonMVCC(spanset.SpanReadWrite, roachpb.Span{Key: keys.RangeRaftLogQueueDisabledKey(header.RangeID)})
+}
+
+// SetRaftLogQueueDisabled sets whether the application of commands may offer
+// the range's replicas to the raft log queue, which keeps their logs from
+// being truncated aggressively (e.g. while a migration replays its history).
+// The raft log queue's scanner still visits the replicas.
+//
+// The flag is part of the replicated range state: it's written to a
+// replicated range-ID local key, so it applies to every replica, is carried
+// by snapshots and survives restarts, and the replicas pick it up when the
+// command applies. The right-hand side of a split does not inherit it. Since
+// replicas which don't know about the flag would ignore it, the request is
+// refused until the cluster version allows it.
+func SetRaftLogQueueDisabled(
+	ctx context.Context, readWriter storage.ReadWriter, cArgs CommandArgs, resp roachpb.Response,
+) (result.Result, error) {
+	args := cArgs.Args.(*roachpb.SetRaftLogQueueDisabledRequest)
+	if !cArgs.EvalCtx.ClusterSettings().Version.IsActive(ctx, clusterversion.VersionRaftLogQueueDisabled) {
+		return result.Result{}, errors.Errorf("%s requires cluster version %s", args.Method(),
+			clusterversion.VersionByKey(clusterversion.VersionRaftLogQueueDisabled))
+	}
+	if err := MakeStateLoader(cArgs.EvalCtx).SetRaftLogQueueDisabled(
+		ctx, readWriter, cArgs.Stats, args.Disabled,
+	); err != nil {
+		return result.Result{}, err
+	}
+	var pd result.Result
+	pd.Replicated.UpdateRaftLogQueueDisabled = true
+	if args.Disabled {
+		pd.Replicated.State = &kvserverpb.ReplicaState{RaftLogQueueDisabled: true}
+	}
+	return pd, nil
+}
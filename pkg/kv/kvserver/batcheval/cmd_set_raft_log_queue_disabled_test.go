@@ -0,0 +1,73 @@
+// Copyright 2020 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package batcheval
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/clusterversion"
+	"github.com/cockroachdb/cockroach/pkg/kv/kvserver/batcheval/result"
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
+	"github.com/cockroachdb/cockroach/pkg/storage"
+	"github.com/cockroachdb/cockroach/pkg/storage/enginepb"
+	"github.com/cockroachdb/cockroach/pkg/testutils"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/stretchr/testify/require"
+)
+
+// TestSetRaftLogQueueDisabled verifies that SetRaftLogQueueDisabled persists
+// the flag and hands it to the replicas through the ReplicatedEvalResult, and
+// that it's refused before the cluster version allows it.
+func TestSetRaftLogQueueDisabled(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	ctx := context.Background()
+
+	eng := storage.NewDefaultInMem()
+	defer eng.Close()
+	desc := roachpb.RangeDescriptor{RangeID: 99}
+
+	eval := func(st *cluster.Settings, disabled bool) (result.Result, error) {
+		var ms enginepb.MVCCStats
+		return SetRaftLogQueueDisabled(ctx, eng, CommandArgs{
+			EvalCtx: (&MockEvalCtx{ClusterSettings: st, Desc: &desc}).EvalContext(),
+			Args:    &roachpb.SetRaftLogQueueDisabledRequest{Disabled: disabled},
+			Stats:   &ms,
+		}, &roachpb.SetRaftLogQueueDisabledResponse{})
+	}
+	load := func() bool {
+		disabled, err := MakeStateLoader((&MockEvalCtx{Desc: &desc}).EvalContext()).
+			LoadRaftLogQueueDisabled(ctx, eng)
+		require.NoError(t, err)
+		return disabled
+	}
+
+	oldVersion := clusterversion.VersionByKey(clusterversion.VersionRaftLogQueueDisabled - 1)
+	oldSt := cluster.MakeTestingClusterSettingsWithVersions(
+		oldVersion, oldVersion, true /* initializeVersion */)
+	_, err := eval(oldSt, true)
+	require.True(t, testutils.IsError(err, "requires cluster version"), "%v", err)
+	require.False(t, load())
+
+	st := cluster.MakeTestingClusterSettings()
+	res, err := eval(st, true)
+	require.NoError(t, err)
+	require.True(t, res.Replicated.UpdateRaftLogQueueDisabled)
+	require.True(t, res.Replicated.State.RaftLogQueueDisabled)
+	require.True(t, load())
+
+	res, err = eval(st, false)
+	require.NoError(t, err)
+	require.True(t, res.Replicated.UpdateRaftLogQueueDisabled)
+	require.Nil(t, res.Replicated.State)
+	require.False(t, load())
+}
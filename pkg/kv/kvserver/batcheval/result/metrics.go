@@ -22,6 +22,17 @@ type Metrics struct {
 	ResolvePoison        int // poisoning intent abort evaluated successfully
 }
 
+// Failed returns the Metrics to record in place of the receiver for a command
+// which failed to apply after having been evaluated. Lease requests and
+// transfers which evaluated successfully count as errors, while the other
+// outcomes didn't come to pass and aren't recorded at all.
+func (mt Metrics) Failed() Metrics {
+	return Metrics{
+		LeaseRequestError:  mt.LeaseRequestSuccess + mt.LeaseRequestError,
+		LeaseTransferError: mt.LeaseTransferSuccess + mt.LeaseTransferError,
+	}
+}
+
 // Add absorbs the supplied Metrics into the receiver.
 func (mt *Metrics) Add(o Metrics) {
 	mt.LeaseRequestSuccess += o.LeaseRequestSuccess
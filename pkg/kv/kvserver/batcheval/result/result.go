@@ -14,11 +14,11 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/cockroachdb/cockroach/pkg/keys"
 	"github.com/cockroachdb/cockroach/pkg/kv/kvserver/kvserverpb"
 	"github.com/cockroachdb/cockroach/pkg/roachpb"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
 	"github.com/cockroachdb/errors"
-	"github.com/kr/pretty"
 )
 
 // LocalResult is data belonging to an evaluated command that is
@@ -65,6 +65,12 @@ type LocalResult struct {
 	MaybeGossipNodeLiveness *roachpb.Span
 	// Call maybeWatchForMerge.
 	MaybeWatchForMerge bool
+	// RequireDurableApply, when set, delays acknowledging the command to the
+	// client until the batch which applied it has been synced to disk. Most
+	// commands can be acknowledged once committed to the Raft log, since their
+	// application can be replayed on restart, but the proposer of commands like
+	// lease transfers and splits may act on their effects immediately.
+	RequireDurableApply bool
 
 	// Metrics contains counters which are to be passed to the
 	// metrics subsystem.
@@ -83,8 +89,10 @@ func (lResult *LocalResult) IsZero() bool {
 		!lResult.GossipFirstRange &&
 		!lResult.MaybeGossipSystemConfig &&
 		!lResult.MaybeGossipSystemConfigIfHaveFailure &&
+		!lResult.MaybeAddToSplitQueue &&
 		lResult.MaybeGossipNodeLiveness == nil &&
 		!lResult.MaybeWatchForMerge &&
+		!lResult.RequireDurableApply &&
 		lResult.Metrics == nil
 }
 
@@ -97,13 +105,13 @@ func (lResult *LocalResult) String() string {
 		"#updated txns: %d #end txns: %d, "+
 		"GossipFirstRange:%t MaybeGossipSystemConfig:%t "+
 		"MaybeGossipSystemConfigIfHaveFailure:%t MaybeAddToSplitQueue:%t "+
-		"MaybeGossipNodeLiveness:%s MaybeWatchForMerge:%t",
+		"MaybeGossipNodeLiveness:%s MaybeWatchForMerge:%t RequireDurableApply:%t",
 		lResult.Reply,
 		len(lResult.EncounteredIntents), len(lResult.AcquiredLocks), len(lResult.ResolvedLocks),
 		len(lResult.UpdatedTxns), len(lResult.EndTxns),
 		lResult.GossipFirstRange, lResult.MaybeGossipSystemConfig,
 		lResult.MaybeGossipSystemConfigIfHaveFailure, lResult.MaybeAddToSplitQueue,
-		lResult.MaybeGossipNodeLiveness, lResult.MaybeWatchForMerge)
+		lResult.MaybeGossipNodeLiveness, lResult.MaybeWatchForMerge, lResult.RequireDurableApply)
 }
 
 // DetachEncounteredIntents returns (and removes) those encountered
@@ -161,7 +169,7 @@ func (p *Result) IsZero() bool {
 	if !p.Local.IsZero() {
 		return false
 	}
-	if !p.Replicated.Equal(kvserverpb.ReplicatedEvalResult{}) {
+	if !p.Replicated.IsZero() {
 		return false
 	}
 	if p.WriteBatch != nil {
@@ -173,50 +181,264 @@ func (p *Result) IsZero() bool {
 	return true
 }
 
+// WriteBatchSize returns the size in bytes of the result's WriteBatch, which is
+// zero for results without one (e.g. those of lease requests).
+func (p *Result) WriteBatchSize() int {
+	if p.WriteBatch == nil {
+		return 0
+	}
+	return len(p.WriteBatch.Data)
+}
+
+func (p *Result) String() string {
+	return fmt.Sprintf("Result (%s, replicated: %s, write batch: %t, logical op log: %t)",
+		&p.Local, &p.Replicated, p.WriteBatch != nil, p.LogicalOpLog != nil)
+}
+
 // coalesceBool ORs rhs into lhs and then zeroes rhs.
 func coalesceBool(lhs *bool, rhs *bool) {
 	*lhs = *lhs || *rhs
 	*rhs = false
 }
 
+// laterTruncatedState returns whichever of the given truncated states has the
+// higher index. It returns an error if the states are inconsistent, i.e. if
+// the later one has a lower term.
+func laterTruncatedState(a, b *roachpb.RaftTruncatedState) (*roachpb.RaftTruncatedState, error) {
+	if b.Index < a.Index {
+		a, b = b, a
+	}
+	if b.Term < a.Term {
+		return nil, errors.Errorf("conflicting TruncatedState: %+v follows %+v but has a lower term", b, a)
+	}
+	return b, nil
+}
+
+// MergeSummary describes which fields of an EvalResult were contributed by
+// the absorbed result during a call to MergeAndDestroyWithSummary. A field
+// is set only if the absorbed result carried a non-zero value for it.
+type MergeSummary struct {
+	Desc                    bool
+	Lease                   bool
+	TruncatedState          bool
+	GCThreshold             bool
+	Split                   bool
+	Merge                   bool
+	ChangeReplicas          bool
+	ComputeChecksum         bool
+	RaftLogDelta            bool
+	AddSSTable              bool
+	SuggestedCompactions    bool
+	PrevLeaseProposal       bool
+	EncounteredIntents      bool
+	AcquiredLocks           bool
+	ResolvedLocks           bool
+	UpdatedTxns             bool
+	EndTxns                 bool
+	MaybeGossipNodeLiveness bool
+	RaftLogQueueDisabled    bool
+	// GossipFlags is set if any of the boolean gossip, split queue, or merge
+	// watch flags was set on the absorbed result.
+	GossipFlags         bool
+	RequireDurableApply bool
+	Metrics             bool
+	LogicalOpLog        bool
+}
+
 // MergeAndDestroy absorbs the supplied EvalResult while validating that the
 // resulting EvalResult makes sense. For example, it is forbidden to absorb
 // two lease updates or log truncations, or multiple splits and/or merges.
 //
 // The passed EvalResult must not be used once passed to Merge.
 func (p *Result) MergeAndDestroy(q Result) error {
-	if q.Replicated.State != nil {
-		if q.Replicated.State.RaftAppliedIndex != 0 {
-			return errors.New("must not specify RaftApplyIndex")
+	return p.mergeAndDestroy(q, nil /* summary */)
+}
+
+// MergeAndDestroyWithSummary is like MergeAndDestroy, but additionally
+// populates the supplied MergeSummary with the fields that were absorbed
+// from q. The summary is not reset, so it accumulates across calls.
+func (p *Result) MergeAndDestroyWithSummary(q Result, summary *MergeSummary) error {
+	return p.mergeAndDestroy(q, summary)
+}
+
+// ValidateMerge returns all of the reasons for which MergeAndDestroy would
+// refuse to absorb q, or nil if it would succeed. Unlike MergeAndDestroy, which
+// stops at the first conflict, it checks every field, which helps diagnose
+// malformed commands. Neither p nor q is modified.
+func (p *Result) ValidateMerge(q Result) []error {
+	return p.checkMerge(&q, false /* firstOnly */)
+}
+
+// checkMerge returns the reasons for which q can't be absorbed into p. A field
+// set on both results which can't be combined is reported as a
+// MergeConflictError. If firstOnly is set, checkMerge stops at the first
+// reason.
+func (p *Result) checkMerge(q *Result, firstOnly bool) []error {
+	var errs []error
+	add := func(err error) (stop bool) {
+		errs = append(errs, err)
+		return firstOnly
+	}
+	if qState := q.Replicated.State; qState != nil {
+		var pState kvserverpb.ReplicaState
+		if p.Replicated.State != nil {
+			pState = *p.Replicated.State
+		}
+		if qState.RaftAppliedIndex != 0 && add(errors.New("must not specify RaftApplyIndex")) {
+			return errs
+		}
+		if qState.LeaseAppliedIndex != 0 && add(errors.New("must not specify LeaseAppliedIndex")) {
+			return errs
+		}
+		if pState.Desc != nil && qState.Desc != nil &&
+			add(mergeConflict("Desc", errors.New("conflicting RangeDescriptor"))) {
+			return errs
+		}
+		if pState.Lease != nil && qState.Lease != nil &&
+			add(mergeConflict("Lease", errors.New("conflicting Lease"))) {
+			return errs
+		}
+		if pState.TruncatedState != nil && qState.TruncatedState != nil {
+			if _, err := laterTruncatedState(pState.TruncatedState, qState.TruncatedState); err != nil &&
+				add(mergeConflict("TruncatedState", err)) {
+				return errs
+			}
+		}
+		if qState.Stats != nil && add(errors.New("must not specify Stats")) {
+			return errs
+		}
+	}
+
+	if p.Replicated.Split != nil && q.Replicated.Split != nil &&
+		add(mergeConflict("Split", errors.New("conflicting Split"))) {
+		return errs
+	}
+	if p.Replicated.Merge != nil && q.Replicated.Merge != nil &&
+		add(mergeConflict("Merge", errors.New("conflicting Merge"))) {
+		return errs
+	}
+	if p.Replicated.ChangeReplicas != nil && q.Replicated.ChangeReplicas != nil &&
+		add(mergeConflict("ChangeReplicas", errors.New("conflicting ChangeReplicas"))) {
+		return errs
+	}
+	if p.Replicated.ComputeChecksum != nil && q.Replicated.ComputeChecksum != nil &&
+		add(mergeConflict("ComputeChecksum", errors.New("conflicting ComputeChecksum"))) {
+		return errs
+	}
+	if p.Replicated.AddSSTable != nil && q.Replicated.AddSSTable != nil &&
+		add(mergeConflict("AddSSTable", errors.New("conflicting AddSSTable"))) {
+		return errs
+	}
+	if p.Replicated.PrevLeaseProposal != nil && q.Replicated.PrevLeaseProposal != nil &&
+		add(mergeConflict("PrevLeaseProposal", errors.New("conflicting lease expiration"))) {
+		return errs
+	}
+	if p.Replicated.UpdateRaftLogQueueDisabled && q.Replicated.UpdateRaftLogQueueDisabled &&
+		add(mergeConflict("RaftLogQueueDisabled", errors.New("conflicting RaftLogQueueDisabled"))) {
+		return errs
+	}
+	if p.Local.MaybeGossipNodeLiveness != nil && q.Local.MaybeGossipNodeLiveness != nil {
+		if _, ok := coalesceNodeLivenessSpans(
+			*p.Local.MaybeGossipNodeLiveness, *q.Local.MaybeGossipNodeLiveness,
+		); !ok && add(mergeConflict("MaybeGossipNodeLiveness", errors.New("conflicting MaybeGossipNodeLiveness"))) {
+			return errs
 		}
-		if q.Replicated.State.LeaseAppliedIndex != 0 {
-			return errors.New("must not specify RaftApplyIndex")
+	}
+	return errs
+}
+
+// coalesceNodeLivenessSpans returns the union of two spans of node liveness
+// records to be gossiped, so that a single gossip pass covers both. This is
+// only possible if both spans lie within the node liveness keyspace and
+// overlap or abut; otherwise false is returned.
+func coalesceNodeLivenessSpans(a, b roachpb.Span) (roachpb.Span, bool) {
+	if !keys.NodeLivenessSpan.Contains(a) || !keys.NodeLivenessSpan.Contains(b) {
+		return roachpb.Span{}, false
+	}
+	end := func(s roachpb.Span) roachpb.Key {
+		if len(s.EndKey) == 0 {
+			return s.Key.Next()
 		}
+		return s.EndKey
+	}
+	if !a.Overlaps(b) && !end(a).Equal(b.Key) && !end(b).Equal(a.Key) {
+		return roachpb.Span{}, false
+	}
+	return a.Combine(b), true
+}
+
+// MergeConflictError is returned by MergeAndDestroy when both Results set a
+// field which can't be merged.
+type MergeConflictError struct {
+	// Field is the name of the conflicting field, e.g. "Lease".
+	Field string
+	cause error
+}
+
+func (e *MergeConflictError) Error() string {
+	return e.cause.Error()
+}
+
+// Unwrap returns the error describing the conflict.
+func (e *MergeConflictError) Unwrap() error {
+	return e.cause
+}
+
+// mergeConflict returns a MergeConflictError for the given field.
+func mergeConflict(field string, err error) error {
+	return &MergeConflictError{Field: field, cause: err}
+}
+
+func (p *Result) mergeAndDestroy(q Result, summary *MergeSummary) error {
+	// NB: summary may be nil, in which case a throwaway value absorbs the
+	// bookkeeping. It lives on the stack, so the common path doesn't allocate.
+	var scratch MergeSummary
+	if summary == nil {
+		summary = &scratch
+	}
+	// Rule out conflicts up front so that a failed merge leaves p untouched.
+	// Past this point, a field which can't be combined is set on at most one
+	// of p and q.
+	if errs := p.checkMerge(&q, true /* firstOnly */); len(errs) > 0 {
+		return errs[0]
+	}
+	if q.Replicated.State != nil {
 		if p.Replicated.State == nil {
 			p.Replicated.State = &kvserverpb.ReplicaState{}
 		}
+		summary.Desc = summary.Desc || q.Replicated.State.Desc != nil
 		if p.Replicated.State.Desc == nil {
 			p.Replicated.State.Desc = q.Replicated.State.Desc
-		} else if q.Replicated.State.Desc != nil {
-			return errors.New("conflicting RangeDescriptor")
 		}
 		q.Replicated.State.Desc = nil
 
+		summary.Lease = summary.Lease || q.Replicated.State.Lease != nil
 		if p.Replicated.State.Lease == nil {
 			p.Replicated.State.Lease = q.Replicated.State.Lease
-		} else if q.Replicated.State.Lease != nil {
-			return errors.New("conflicting Lease")
 		}
 		q.Replicated.State.Lease = nil
 
+		summary.TruncatedState = summary.TruncatedState || q.Replicated.State.TruncatedState != nil
 		if p.Replicated.State.TruncatedState == nil {
 			p.Replicated.State.TruncatedState = q.Replicated.State.TruncatedState
 		} else if q.Replicated.State.TruncatedState != nil {
-			return errors.New("conflicting TruncatedState")
+			// A later truncation subsumes an earlier one, so keep only the
+			// truncation with the higher index. Both truncations were evaluated
+			// against the same log, so the RaftLogDelta of the later one already
+			// accounts for the entries removed by the earlier one.
+			later, _ := laterTruncatedState(
+				p.Replicated.State.TruncatedState, q.Replicated.State.TruncatedState)
+			if later == q.Replicated.State.TruncatedState {
+				p.Replicated.State.TruncatedState = later
+				p.Replicated.RaftLogDelta = q.Replicated.RaftLogDelta
+			}
+			summary.RaftLogDelta = summary.RaftLogDelta || q.Replicated.RaftLogDelta != 0
+			q.Replicated.RaftLogDelta = 0
 		}
 		q.Replicated.State.TruncatedState = nil
 
 		if q.Replicated.State.GCThreshold != nil {
+			summary.GCThreshold = true
 			if p.Replicated.State.GCThreshold == nil {
 				p.Replicated.State.GCThreshold = q.Replicated.State.GCThreshold
 			} else {
@@ -225,59 +447,55 @@ func (p *Result) MergeAndDestroy(q Result) error {
 			q.Replicated.State.GCThreshold = nil
 		}
 
-		if q.Replicated.State.Stats != nil {
-			return errors.New("must not specify Stats")
+		// The flag is only meaningful along with UpdateRaftLogQueueDisabled,
+		// which is handled below.
+		if q.Replicated.UpdateRaftLogQueueDisabled {
+			p.Replicated.State.RaftLogQueueDisabled = q.Replicated.State.RaftLogQueueDisabled
 		}
-		if (*q.Replicated.State != kvserverpb.ReplicaState{}) {
-			log.Fatalf(context.TODO(), "unhandled EvalResult: %s",
-				pretty.Diff(*q.Replicated.State, kvserverpb.ReplicaState{}))
+		q.Replicated.State.RaftLogQueueDisabled = false
+
+		if !q.Replicated.State.IsZero() {
+			log.Fatalf(context.TODO(), "unhandled EvalResult: %s", q.Replicated.State)
 		}
 		q.Replicated.State = nil
 	}
 
+	summary.Split = summary.Split || q.Replicated.Split != nil
 	if p.Replicated.Split == nil {
 		p.Replicated.Split = q.Replicated.Split
-	} else if q.Replicated.Split != nil {
-		return errors.New("conflicting Split")
 	}
 	q.Replicated.Split = nil
 
+	summary.Merge = summary.Merge || q.Replicated.Merge != nil
 	if p.Replicated.Merge == nil {
 		p.Replicated.Merge = q.Replicated.Merge
-	} else if q.Replicated.Merge != nil {
-		return errors.New("conflicting Merge")
 	}
 	q.Replicated.Merge = nil
 
+	summary.ChangeReplicas = summary.ChangeReplicas || q.Replicated.ChangeReplicas != nil
 	if p.Replicated.ChangeReplicas == nil {
 		p.Replicated.ChangeReplicas = q.Replicated.ChangeReplicas
-	} else if q.Replicated.ChangeReplicas != nil {
-		return errors.New("conflicting ChangeReplicas")
 	}
 	q.Replicated.ChangeReplicas = nil
 
+	summary.ComputeChecksum = summary.ComputeChecksum || q.Replicated.ComputeChecksum != nil
 	if p.Replicated.ComputeChecksum == nil {
 		p.Replicated.ComputeChecksum = q.Replicated.ComputeChecksum
-	} else if q.Replicated.ComputeChecksum != nil {
-		return errors.New("conflicting ComputeChecksum")
 	}
 	q.Replicated.ComputeChecksum = nil
 
-	if p.Replicated.RaftLogDelta == 0 {
-		p.Replicated.RaftLogDelta = q.Replicated.RaftLogDelta
-	} else if q.Replicated.RaftLogDelta != 0 {
-		return errors.New("conflicting RaftLogDelta")
-	}
+	summary.RaftLogDelta = summary.RaftLogDelta || q.Replicated.RaftLogDelta != 0
+	p.Replicated.RaftLogDelta += q.Replicated.RaftLogDelta
 	q.Replicated.RaftLogDelta = 0
 
+	summary.AddSSTable = summary.AddSSTable || q.Replicated.AddSSTable != nil
 	if p.Replicated.AddSSTable == nil {
 		p.Replicated.AddSSTable = q.Replicated.AddSSTable
-	} else if q.Replicated.AddSSTable != nil {
-		return errors.New("conflicting AddSSTable")
 	}
 	q.Replicated.AddSSTable = nil
 
 	if q.Replicated.SuggestedCompactions != nil {
+		summary.SuggestedCompactions = true
 		if p.Replicated.SuggestedCompactions == nil {
 			p.Replicated.SuggestedCompactions = q.Replicated.SuggestedCompactions
 		} else {
@@ -286,13 +504,16 @@ func (p *Result) MergeAndDestroy(q Result) error {
 	}
 	q.Replicated.SuggestedCompactions = nil
 
+	summary.PrevLeaseProposal = summary.PrevLeaseProposal || q.Replicated.PrevLeaseProposal != nil
 	if p.Replicated.PrevLeaseProposal == nil {
 		p.Replicated.PrevLeaseProposal = q.Replicated.PrevLeaseProposal
-	} else if q.Replicated.PrevLeaseProposal != nil {
-		return errors.New("conflicting lease expiration")
 	}
 	q.Replicated.PrevLeaseProposal = nil
 
+	summary.RaftLogQueueDisabled = summary.RaftLogQueueDisabled || q.Replicated.UpdateRaftLogQueueDisabled
+	coalesceBool(&p.Replicated.UpdateRaftLogQueueDisabled, &q.Replicated.UpdateRaftLogQueueDisabled)
+
+	summary.EncounteredIntents = summary.EncounteredIntents || len(q.Local.EncounteredIntents) > 0
 	if p.Local.EncounteredIntents == nil {
 		p.Local.EncounteredIntents = q.Local.EncounteredIntents
 	} else {
@@ -300,6 +521,7 @@ func (p *Result) MergeAndDestroy(q Result) error {
 	}
 	q.Local.EncounteredIntents = nil
 
+	summary.AcquiredLocks = summary.AcquiredLocks || len(q.Local.AcquiredLocks) > 0
 	if p.Local.AcquiredLocks == nil {
 		p.Local.AcquiredLocks = q.Local.AcquiredLocks
 	} else {
@@ -307,6 +529,7 @@ func (p *Result) MergeAndDestroy(q Result) error {
 	}
 	q.Local.AcquiredLocks = nil
 
+	summary.ResolvedLocks = summary.ResolvedLocks || len(q.Local.ResolvedLocks) > 0
 	if p.Local.ResolvedLocks == nil {
 		p.Local.ResolvedLocks = q.Local.ResolvedLocks
 	} else {
@@ -314,6 +537,7 @@ func (p *Result) MergeAndDestroy(q Result) error {
 	}
 	q.Local.ResolvedLocks = nil
 
+	summary.UpdatedTxns = summary.UpdatedTxns || len(q.Local.UpdatedTxns) > 0
 	if p.Local.UpdatedTxns == nil {
 		p.Local.UpdatedTxns = q.Local.UpdatedTxns
 	} else {
@@ -321,6 +545,7 @@ func (p *Result) MergeAndDestroy(q Result) error {
 	}
 	q.Local.UpdatedTxns = nil
 
+	summary.EndTxns = summary.EndTxns || len(q.Local.EndTxns) > 0
 	if p.Local.EndTxns == nil {
 		p.Local.EndTxns = q.Local.EndTxns
 	} else {
@@ -328,19 +553,31 @@ func (p *Result) MergeAndDestroy(q Result) error {
 	}
 	q.Local.EndTxns = nil
 
+	summary.MaybeGossipNodeLiveness = summary.MaybeGossipNodeLiveness || q.Local.MaybeGossipNodeLiveness != nil
 	if p.Local.MaybeGossipNodeLiveness == nil {
 		p.Local.MaybeGossipNodeLiveness = q.Local.MaybeGossipNodeLiveness
 	} else if q.Local.MaybeGossipNodeLiveness != nil {
-		return errors.New("conflicting MaybeGossipNodeLiveness")
+		span, _ := coalesceNodeLivenessSpans(*p.Local.MaybeGossipNodeLiveness, *q.Local.MaybeGossipNodeLiveness)
+		p.Local.MaybeGossipNodeLiveness = &span
 	}
 	q.Local.MaybeGossipNodeLiveness = nil
 
+	summary.GossipFlags = summary.GossipFlags ||
+		q.Local.GossipFirstRange ||
+		q.Local.MaybeGossipSystemConfig ||
+		q.Local.MaybeGossipSystemConfigIfHaveFailure ||
+		q.Local.MaybeAddToSplitQueue ||
+		q.Local.MaybeWatchForMerge
 	coalesceBool(&p.Local.GossipFirstRange, &q.Local.GossipFirstRange)
 	coalesceBool(&p.Local.MaybeGossipSystemConfig, &q.Local.MaybeGossipSystemConfig)
 	coalesceBool(&p.Local.MaybeGossipSystemConfigIfHaveFailure, &q.Local.MaybeGossipSystemConfigIfHaveFailure)
 	coalesceBool(&p.Local.MaybeAddToSplitQueue, &q.Local.MaybeAddToSplitQueue)
 	coalesceBool(&p.Local.MaybeWatchForMerge, &q.Local.MaybeWatchForMerge)
 
+	summary.RequireDurableApply = summary.RequireDurableApply || q.Local.RequireDurableApply
+	coalesceBool(&p.Local.RequireDurableApply, &q.Local.RequireDurableApply)
+
+	summary.Metrics = summary.Metrics || q.Local.Metrics != nil
 	if p.Local.Metrics == nil {
 		p.Local.Metrics = q.Local.Metrics
 	} else if q.Local.Metrics != nil {
@@ -349,6 +586,7 @@ func (p *Result) MergeAndDestroy(q Result) error {
 	q.Local.Metrics = nil
 
 	if q.LogicalOpLog != nil {
+		summary.LogicalOpLog = true
 		if p.LogicalOpLog == nil {
 			p.LogicalOpLog = q.LogicalOpLog
 		} else {
@@ -357,8 +595,10 @@ func (p *Result) MergeAndDestroy(q Result) error {
 	}
 	q.LogicalOpLog = nil
 
+	// NB: TestMergeAndDestroyHandlesAllFields checks that every field is
+	// handled above.
 	if !q.IsZero() {
-		log.Fatalf(context.TODO(), "unhandled EvalResult: %s", pretty.Diff(q, Result{}))
+		log.Fatalf(context.TODO(), "unhandled EvalResult: %s", &q)
 	}
 
 	return nil
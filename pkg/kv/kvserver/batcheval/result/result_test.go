@@ -11,11 +11,23 @@
 package result
 
 import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
 	"reflect"
+	"strings"
 	"testing"
 
+	"github.com/cockroachdb/cockroach/pkg/keys"
 	"github.com/cockroachdb/cockroach/pkg/kv/kvserver/kvserverpb"
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/storage/enginepb"
+	"github.com/cockroachdb/cockroach/pkg/testutils"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
 	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/require"
 )
 
 func TestEvalResultIsZero(t *testing.T) {
@@ -62,6 +74,85 @@ func TestEvalResultIsZero(t *testing.T) {
 	}
 }
 
+// setNonZero sets the settable value v to an arbitrary non-zero value.
+func setNonZero(t *testing.T, v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Bool:
+		v.SetBool(true)
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		v.SetInt(1)
+	case reflect.Uint, reflect.Uint32, reflect.Uint64:
+		v.SetUint(1)
+	case reflect.Ptr:
+		v.Set(reflect.New(v.Type().Elem()))
+	case reflect.Slice:
+		v.Set(reflect.MakeSlice(v.Type(), 1, 1))
+	case reflect.Struct:
+		setNonZero(t, v.Field(0))
+	default:
+		t.Fatalf("unsupported kind %s of type %s", v.Kind(), v.Type())
+	}
+}
+
+// TestIsZeroMatchesComparison verifies that the IsZero methods agree with the
+// comparisons against the zero value that they replace.
+func TestIsZeroMatchesComparison(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	forEachField := func(t *testing.T, typ reflect.Type, f func(t *testing.T, i int)) {
+		for i := 0; i < typ.NumField(); i++ {
+			i := i
+			t.Run(typ.Field(i).Name, func(t *testing.T) { f(t, i) })
+		}
+	}
+
+	t.Run("LocalResult", func(t *testing.T) {
+		check := func(t *testing.T, l LocalResult) {
+			if exp := reflect.DeepEqual(l, LocalResult{}); l.IsZero() != exp {
+				t.Fatalf("expected IsZero() = %t for %s", exp, &l)
+			}
+		}
+		check(t, LocalResult{})
+		forEachField(t, reflect.TypeOf(LocalResult{}), func(t *testing.T, i int) {
+			var l LocalResult
+			setNonZero(t, reflect.ValueOf(&l).Elem().Field(i))
+			check(t, l)
+		})
+	})
+
+	t.Run("ReplicaState", func(t *testing.T) {
+		check := func(t *testing.T, s kvserverpb.ReplicaState) {
+			if exp := s == (kvserverpb.ReplicaState{}); s.IsZero() != exp {
+				t.Fatalf("expected IsZero() = %t for %s", exp, &s)
+			}
+		}
+		check(t, kvserverpb.ReplicaState{})
+		forEachField(t, reflect.TypeOf(kvserverpb.ReplicaState{}), func(t *testing.T, i int) {
+			var s kvserverpb.ReplicaState
+			setNonZero(t, reflect.ValueOf(&s).Elem().Field(i))
+			check(t, s)
+		})
+	})
+
+	t.Run("ReplicatedEvalResult", func(t *testing.T) {
+		check := func(t *testing.T, r kvserverpb.ReplicatedEvalResult) {
+			if exp := r.Equal(kvserverpb.ReplicatedEvalResult{}); r.IsZero() != exp {
+				t.Fatalf("expected IsZero() = %t for %s", exp, &r)
+			}
+		}
+		check(t, kvserverpb.ReplicatedEvalResult{})
+		// Equal treats an empty slice like a nil one.
+		check(t, kvserverpb.ReplicatedEvalResult{
+			SuggestedCompactions: []kvserverpb.SuggestedCompaction{},
+		})
+		forEachField(t, reflect.TypeOf(kvserverpb.ReplicatedEvalResult{}), func(t *testing.T, i int) {
+			var r kvserverpb.ReplicatedEvalResult
+			setNonZero(t, reflect.ValueOf(&r).Elem().Field(i))
+			check(t, r)
+		})
+	})
+}
+
 func TestMergeAndDestroy(t *testing.T) {
 	var r0, r1, r2 Result
 	r1.Local.Metrics = new(Metrics)
@@ -84,3 +175,428 @@ func TestMergeAndDestroy(t *testing.T) {
 		t.Fatalf("expected %d, got %d", exp, f)
 	}
 }
+
+func TestMergeAndDestroyWithSummary(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	var p Result
+	var summary MergeSummary
+
+	var q1 Result
+	q1.Replicated.State = &kvserverpb.ReplicaState{Lease: &roachpb.Lease{Sequence: 1}}
+	q1.Replicated.Split = &kvserverpb.Split{}
+	q1.Local.EncounteredIntents = []roachpb.Intent{{}}
+	q1.Local.MaybeGossipSystemConfig = true
+	if err := p.MergeAndDestroyWithSummary(q1, &summary); err != nil {
+		t.Fatal(err)
+	}
+	if exp := (MergeSummary{
+		Lease:              true,
+		Split:              true,
+		EncounteredIntents: true,
+		GossipFlags:        true,
+	}); summary != exp {
+		t.Fatalf("expected %+v, got %+v", exp, summary)
+	}
+
+	// The summary accumulates across merges, and fields that q didn't carry
+	// stay unset even though p already has them.
+	var q2 Result
+	q2.Local.EncounteredIntents = []roachpb.Intent{{}}
+	q2.Local.Metrics = &Metrics{LeaseRequestSuccess: 1}
+	q2.LogicalOpLog = &kvserverpb.LogicalOpLog{}
+	if err := p.MergeAndDestroyWithSummary(q2, &summary); err != nil {
+		t.Fatal(err)
+	}
+	if exp := (MergeSummary{
+		Lease:              true,
+		Split:              true,
+		EncounteredIntents: true,
+		GossipFlags:        true,
+		Metrics:            true,
+		LogicalOpLog:       true,
+	}); summary != exp {
+		t.Fatalf("expected %+v, got %+v", exp, summary)
+	}
+	if n := len(p.Local.EncounteredIntents); n != 2 {
+		t.Fatalf("expected 2 encountered intents, got %d", n)
+	}
+
+	// Merging an empty result leaves a fresh summary empty.
+	var empty MergeSummary
+	if err := p.MergeAndDestroyWithSummary(Result{}, &empty); err != nil {
+		t.Fatal(err)
+	}
+	if (empty != MergeSummary{}) {
+		t.Fatalf("expected empty summary, got %+v", empty)
+	}
+
+	// A conflicting merge still fails.
+	var q3 Result
+	q3.Replicated.Split = &kvserverpb.Split{}
+	if err := p.MergeAndDestroyWithSummary(q3, &MergeSummary{}); !testutils.IsError(err, "conflicting Split") {
+		t.Fatalf("expected conflicting Split error, got %v", err)
+	}
+}
+
+func TestMergeAndDestroyNodeLivenessSpans(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	nl := func(from, to roachpb.NodeID) roachpb.Span {
+		return roachpb.Span{Key: keys.NodeLivenessKey(from), EndKey: keys.NodeLivenessKey(to)}
+	}
+	for _, tc := range []struct {
+		name   string
+		p, q   roachpb.Span
+		exp    roachpb.Span
+		expErr string
+	}{
+		{name: "overlapping", p: nl(1, 3), q: nl(2, 5), exp: nl(1, 5)},
+		{name: "adjacent", p: nl(3, 5), q: nl(1, 3), exp: nl(1, 5)},
+		{name: "contained", p: keys.NodeLivenessSpan, q: nl(2, 3), exp: keys.NodeLivenessSpan},
+		{
+			name: "point",
+			p:    roachpb.Span{Key: keys.NodeLivenessKey(1)},
+			q:    roachpb.Span{Key: keys.NodeLivenessKey(1).Next(), EndKey: keys.NodeLivenessKey(4)},
+			exp:  nl(1, 4),
+		},
+		{name: "disjoint", p: nl(1, 2), q: nl(3, 4), expErr: "conflicting MaybeGossipNodeLiveness"},
+		{
+			name:   "outside liveness keyspace",
+			p:      nl(1, 2),
+			q:      roachpb.Span{Key: keys.NodeLivenessKeyMax, EndKey: keys.NodeLivenessKeyMax.PrefixEnd()},
+			expErr: "conflicting MaybeGossipNodeLiveness",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var r Result
+			p, q := tc.p, tc.q
+			require.NoError(t, r.MergeAndDestroy(Result{Local: LocalResult{MaybeGossipNodeLiveness: &p}}))
+			err := r.MergeAndDestroy(Result{Local: LocalResult{MaybeGossipNodeLiveness: &q}})
+			if tc.expErr != "" {
+				require.True(t, testutils.IsError(err, tc.expErr), "expected %q, got %v", tc.expErr, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.exp, *r.Local.MaybeGossipNodeLiveness)
+		})
+	}
+}
+
+func TestMergeAndDestroyTruncatedStates(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	truncation := func(index, term uint64, raftLogDelta int64) Result {
+		var r Result
+		r.Replicated.State = &kvserverpb.ReplicaState{
+			TruncatedState: &roachpb.RaftTruncatedState{Index: index, Term: term},
+		}
+		r.Replicated.RaftLogDelta = raftLogDelta
+		return r
+	}
+
+	// Monotonic truncations coalesce into the later one, regardless of the
+	// order in which they're merged.
+	for _, reverse := range []bool{false, true} {
+		earlier, later := truncation(10, 5, -100), truncation(20, 6, -300)
+		p, q := earlier, later
+		if reverse {
+			p, q = later, earlier
+		}
+		var summary MergeSummary
+		if err := p.MergeAndDestroyWithSummary(q, &summary); err != nil {
+			t.Fatalf("reverse=%t: %+v", reverse, err)
+		}
+		if exp := (roachpb.RaftTruncatedState{Index: 20, Term: 6}); *p.Replicated.State.TruncatedState != exp {
+			t.Fatalf("reverse=%t: expected %+v, got %+v", reverse, exp, p.Replicated.State.TruncatedState)
+		}
+		if exp := int64(-300); p.Replicated.RaftLogDelta != exp {
+			t.Fatalf("reverse=%t: expected RaftLogDelta %d, got %d", reverse, exp, p.Replicated.RaftLogDelta)
+		}
+		if !summary.TruncatedState || !summary.RaftLogDelta {
+			t.Fatalf("reverse=%t: unexpected summary %+v", reverse, summary)
+		}
+	}
+
+	// A truncation at a higher index but a lower term is inconsistent.
+	p := truncation(10, 5, -100)
+	if err := p.MergeAndDestroy(truncation(20, 4, -300)); !testutils.IsError(err, "conflicting TruncatedState") {
+		t.Fatalf("expected conflicting TruncatedState error, got %v", err)
+	}
+}
+
+// TestMergeAndDestroyRaftLogDelta verifies that merging results which both
+// carry a RaftLogDelta adds up the deltas rather than failing.
+func TestMergeAndDestroyRaftLogDelta(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	withDelta := func(raftLogDelta int64) Result {
+		return Result{Replicated: kvserverpb.ReplicatedEvalResult{RaftLogDelta: raftLogDelta}}
+	}
+
+	p := withDelta(-100)
+	require.Empty(t, p.ValidateMerge(withDelta(-300)))
+	var summary MergeSummary
+	require.NoError(t, p.MergeAndDestroyWithSummary(withDelta(-300), &summary))
+	require.Equal(t, int64(-400), p.Replicated.RaftLogDelta)
+	require.True(t, summary.RaftLogDelta)
+
+	// A result without a delta leaves the existing one alone.
+	require.NoError(t, p.MergeAndDestroy(withDelta(0)))
+	require.Equal(t, int64(-400), p.Replicated.RaftLogDelta)
+}
+
+// TestValidateMerge verifies that ValidateMerge reports every conflict
+// between two results, while MergeAndDestroy only reports the first one.
+func TestValidateMerge(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	newResult := func() Result {
+		return Result{Replicated: kvserverpb.ReplicatedEvalResult{
+			State:           &kvserverpb.ReplicaState{Lease: &roachpb.Lease{}},
+			Merge:           &kvserverpb.Merge{},
+			ComputeChecksum: &kvserverpb.ComputeChecksum{},
+		}}
+	}
+	p, q := newResult(), newResult()
+	errs := p.ValidateMerge(q)
+	require.Len(t, errs, 3)
+	require.Regexp(t, "conflicting Lease", errs[0])
+	require.Regexp(t, "conflicting Merge", errs[1])
+	require.Regexp(t, "conflicting ComputeChecksum", errs[2])
+	// Neither result was modified.
+	require.Equal(t, newResult(), p)
+	require.Equal(t, newResult(), q)
+
+	require.Regexp(t, "conflicting Lease", p.MergeAndDestroy(q))
+	// A failed merge doesn't absorb any part of q.
+	require.Equal(t, newResult(), p)
+
+	q = Result{Replicated: kvserverpb.ReplicatedEvalResult{
+		State: &kvserverpb.ReplicaState{RaftAppliedIndex: 1, LeaseAppliedIndex: 1},
+	}}
+	errs = p.ValidateMerge(q)
+	require.Len(t, errs, 2)
+	require.Regexp(t, "must not specify RaftApplyIndex", errs[0])
+	require.Regexp(t, "must not specify LeaseAppliedIndex", errs[1])
+
+	// Results that can be merged are reported as such.
+	p = newResult()
+	require.Empty(t, p.ValidateMerge(Result{Local: LocalResult{GossipFirstRange: true}}))
+}
+
+// TestMergeAndDestroyConflictError verifies that each kind of conflict
+// rejected by MergeAndDestroy is reported as a MergeConflictError naming the
+// conflicting field.
+func TestMergeAndDestroyConflictError(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	withState := func(s kvserverpb.ReplicaState) Result {
+		return Result{Replicated: kvserverpb.ReplicatedEvalResult{State: &s}}
+	}
+	truncation := func(index, term uint64) Result {
+		return withState(kvserverpb.ReplicaState{
+			TruncatedState: &roachpb.RaftTruncatedState{Index: index, Term: term},
+		})
+	}
+	liveness := func(from, to roachpb.NodeID) Result {
+		return Result{Local: LocalResult{MaybeGossipNodeLiveness: &roachpb.Span{
+			Key: keys.NodeLivenessKey(from), EndKey: keys.NodeLivenessKey(to),
+		}}}
+	}
+	for _, tc := range []struct {
+		field string
+		p, q  func() Result
+	}{
+		{field: "Desc", p: func() Result {
+			return withState(kvserverpb.ReplicaState{Desc: &roachpb.RangeDescriptor{}})
+		}},
+		{field: "Lease", p: func() Result {
+			return withState(kvserverpb.ReplicaState{Lease: &roachpb.Lease{}})
+		}},
+		{
+			field: "TruncatedState",
+			p:     func() Result { return truncation(10, 5) },
+			q:     func() Result { return truncation(20, 4) },
+		},
+		{field: "Split", p: func() Result {
+			return Result{Replicated: kvserverpb.ReplicatedEvalResult{Split: &kvserverpb.Split{}}}
+		}},
+		{field: "Merge", p: func() Result {
+			return Result{Replicated: kvserverpb.ReplicatedEvalResult{Merge: &kvserverpb.Merge{}}}
+		}},
+		{field: "ChangeReplicas", p: func() Result {
+			return Result{Replicated: kvserverpb.ReplicatedEvalResult{ChangeReplicas: &kvserverpb.ChangeReplicas{}}}
+		}},
+		{field: "ComputeChecksum", p: func() Result {
+			return Result{Replicated: kvserverpb.ReplicatedEvalResult{ComputeChecksum: &kvserverpb.ComputeChecksum{}}}
+		}},
+		{field: "AddSSTable", p: func() Result {
+			return Result{Replicated: kvserverpb.ReplicatedEvalResult{
+				AddSSTable: &kvserverpb.ReplicatedEvalResult_AddSSTable{},
+			}}
+		}},
+		{field: "PrevLeaseProposal", p: func() Result {
+			return Result{Replicated: kvserverpb.ReplicatedEvalResult{PrevLeaseProposal: &hlc.Timestamp{}}}
+		}},
+		{
+			field: "MaybeGossipNodeLiveness",
+			p:     func() Result { return liveness(1, 2) },
+			q:     func() Result { return liveness(3, 4) },
+		},
+	} {
+		t.Run(tc.field, func(t *testing.T) {
+			q := tc.q
+			if q == nil {
+				q = tc.p
+			}
+			p := tc.p()
+			err := p.MergeAndDestroy(q())
+			var conflict *MergeConflictError
+			require.True(t, errors.As(err, &conflict), "%v", err)
+			require.Equal(t, tc.field, conflict.Field)
+		})
+	}
+}
+
+// mergeAndDestroyFieldRefs returns the fields of q referenced by
+// Result.mergeAndDestroy and the Result.checkMerge it relies on, as dotted
+// paths relative to q (e.g. "Replicated.State.Desc"). It parses the source
+// rather than running the method, since an unhandled field makes it fatal.
+func mergeAndDestroyFieldRefs(t *testing.T) map[string]bool {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "result.go", nil /* src */, 0 /* mode */)
+	require.NoError(t, err)
+
+	// selectorPath returns the path of the selector expression relative to q,
+	// or false if it's not rooted at q.
+	var selectorPath func(e ast.Expr) (string, bool)
+	selectorPath = func(e ast.Expr) (string, bool) {
+		switch e := e.(type) {
+		case *ast.Ident:
+			return "", e.Name == "q"
+		case *ast.SelectorExpr:
+			prefix, ok := selectorPath(e.X)
+			if !ok {
+				return "", false
+			}
+			if prefix == "" {
+				return e.Sel.Name, true
+			}
+			return prefix + "." + e.Sel.Name, true
+		default:
+			return "", false
+		}
+	}
+
+	refs := make(map[string]bool)
+	for _, decl := range f.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || (fn.Name.Name != "mergeAndDestroy" && fn.Name.Name != "checkMerge") {
+			continue
+		}
+		ast.Inspect(fn.Body, func(n ast.Node) bool {
+			if sel, ok := n.(*ast.SelectorExpr); ok {
+				if path, ok := selectorPath(sel); ok {
+					refs[path] = true
+				}
+			}
+			return true
+		})
+	}
+	require.NotEmpty(t, refs, "mergeAndDestroy not found")
+	return refs
+}
+
+// TestMergeAndDestroyHandlesAllFields verifies that Result.mergeAndDestroy
+// references every field of the Result it absorbs. A field it doesn't handle
+// makes it fatal at runtime when the field is set, which is otherwise only
+// caught when a command setting it happens to be merged with another.
+func TestMergeAndDestroyHandlesAllFields(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	// Fields which are never set on the results being merged, because they
+	// are only populated on the final result of a command or are not
+	// mergeable. Setting any of them on a merged result is a bug which
+	// mergeAndDestroy reports as an unhandled field.
+	unmerged := map[string]bool{
+		"WriteBatch":                            true,
+		"Local.Reply":                           true,
+		"Replicated.IsLeaseRequest":             true,
+		"Replicated.Timestamp":                  true,
+		"Replicated.DeprecatedDelta":            true,
+		"Replicated.Delta":                      true,
+		"Replicated.State.UsingAppliedStateKey": true,
+	}
+	// Fields with nested fields which are checked individually.
+	nested := map[string]bool{
+		"Local":            true,
+		"Replicated":       true,
+		"Replicated.State": true,
+	}
+
+	refs := mergeAndDestroyFieldRefs(t)
+	var check func(typ reflect.Type, prefix string)
+	check = func(typ reflect.Type, prefix string) {
+		if typ.Kind() == reflect.Ptr {
+			typ = typ.Elem()
+		}
+		for i := 0; i < typ.NumField(); i++ {
+			field := typ.Field(i)
+			if field.PkgPath != "" || strings.HasPrefix(field.Name, "XXX_") {
+				continue
+			}
+			path := field.Name
+			if prefix != "" {
+				path = prefix + "." + path
+			}
+			if nested[path] {
+				check(field.Type, path)
+				continue
+			}
+			if unmerged[path] {
+				require.False(t, refs[path],
+					"%s is handled by mergeAndDestroy and shouldn't be listed as unmerged", path)
+				continue
+			}
+			require.True(t, refs[path],
+				"%s is not handled by mergeAndDestroy; handle it or list it as unmerged", path)
+		}
+	}
+	check(reflect.TypeOf(Result{}), "")
+}
+
+// makeIntentResults returns n results carrying the given number of
+// encountered intents each.
+func makeIntentResults(n, intentsPerResult int) []Result {
+	results := make([]Result, n)
+	for i := range results {
+		intents := make([]roachpb.Intent, intentsPerResult)
+		for j := range intents {
+			intents[j] = roachpb.MakeIntent(
+				&enginepb.TxnMeta{}, roachpb.Key(fmt.Sprintf("%03d-%03d", i, j)))
+		}
+		results[i].Local.EncounteredIntents = intents
+	}
+	return results
+}
+
+// TestLocalResultDetachIsIdempotent verifies that the intents of a
+// LocalResult are handed off only once: detaching them clears them from the
+// result, so that handling the same result a second time finds nothing to
+// resolve.
+func TestLocalResultDetachIsIdempotent(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	lResult := makeIntentResults(1, 3)[0].Local
+	txn := &roachpb.Transaction{}
+	lResult.EndTxns = []EndTxnIntents{{Txn: txn, Always: true}, {Txn: txn}}
+
+	require.Len(t, lResult.DetachEncounteredIntents(), 3)
+	require.Len(t, lResult.DetachEndTxns(false /* alwaysOnly */), 2)
+
+	require.Empty(t, lResult.DetachEncounteredIntents())
+	require.Empty(t, lResult.DetachEndTxns(false /* alwaysOnly */))
+	require.Empty(t, lResult.DetachEndTxns(true /* alwaysOnly */))
+	require.True(t, lResult.IsZero())
+}
@@ -0,0 +1,196 @@
+// Copyright 2020 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package kvserver
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/storage"
+	"github.com/cockroachdb/cockroach/pkg/storage/fs"
+	"github.com/cockroachdb/cockroach/pkg/util/bufalloc"
+	"github.com/cockroachdb/cockroach/pkg/util/protoutil"
+	"github.com/cockroachdb/cockroach/pkg/util/uuid"
+	"github.com/cockroachdb/errors"
+)
+
+// checksumSnapshotSink receives the key-value pairs hashed by a checksum
+// computation which was asked to capture the replica's data, so that they can
+// be diffed against those of the other replicas.
+type checksumSnapshotSink interface {
+	// add records the given key-value pair. The key and value are only valid
+	// for the duration of the call.
+	add(key storage.MVCCKey, value []byte) error
+}
+
+// memSnapshotSink accumulates the key-value pairs in memory.
+type memSnapshotSink struct {
+	data  *roachpb.RaftSnapshotData
+	alloc bufalloc.ByteAllocator
+}
+
+var _ checksumSnapshotSink = &memSnapshotSink{}
+
+func (s *memSnapshotSink) add(key storage.MVCCKey, value []byte) error {
+	kv := roachpb.RaftSnapshotData_KeyValue{Timestamp: key.Timestamp}
+	s.alloc, kv.Key = s.alloc.Copy(key.Key, 0)
+	s.alloc, kv.Value = s.alloc.Copy(value, 0)
+	s.data.KV = append(s.data.KV, kv)
+	return nil
+}
+
+// cappedSnapshotSink forwards the key-value pairs to another sink until their
+// total size exceeds a limit, after which it drops them. It is used to bound
+// the amount of replica data captured for a diff, which is otherwise
+// proportional to the size of the range.
+type cappedSnapshotSink struct {
+	sink checksumSnapshotSink
+	max  int64
+	size int64
+	// truncated is set once a key-value pair has been dropped.
+	truncated bool
+}
+
+var _ checksumSnapshotSink = &cappedSnapshotSink{}
+
+func (s *cappedSnapshotSink) add(key storage.MVCCKey, value []byte) error {
+	if s.truncated {
+		return nil
+	}
+	s.size += int64(key.EncodedSize() + len(value))
+	if s.size > s.max {
+		s.truncated = true
+		return nil
+	}
+	return s.sink.add(key, value)
+}
+
+// raftSnapshotDataKVTag is the protobuf tag of RaftSnapshotData.KV (field 2,
+// length-delimited).
+const raftSnapshotDataKVTag = 2<<3 | 2
+
+// streamSnapshotSink writes the key-value pairs to an io.Writer as they are
+// added instead of holding on to them. The bytes written are exactly the
+// protobuf encoding of the RaftSnapshotData that a memSnapshotSink would have
+// accumulated, so they can be decoded with protoutil.Unmarshal.
+type streamSnapshotSink struct {
+	w   io.Writer
+	buf []byte
+}
+
+var _ checksumSnapshotSink = &streamSnapshotSink{}
+
+func (s *streamSnapshotSink) add(key storage.MVCCKey, value []byte) error {
+	kv := roachpb.RaftSnapshotData_KeyValue{Key: key.Key, Value: value, Timestamp: key.Timestamp}
+	// memSnapshotSink always copies the key and value into non-nil slices, so
+	// they are always present in its encoding.
+	if kv.Key == nil {
+		kv.Key = []byte{}
+	}
+	if kv.Value == nil {
+		kv.Value = []byte{}
+	}
+	size := kv.Size()
+	if n := 1 + binary.MaxVarintLen64 + size; cap(s.buf) < n {
+		s.buf = make([]byte, n)
+	}
+	buf := s.buf[:cap(s.buf)]
+	buf[0] = raftSnapshotDataKVTag
+	n := 1 + binary.PutUvarint(buf[1:], uint64(size))
+	m, err := kv.MarshalTo(buf[n:])
+	if err != nil {
+		return err
+	}
+	_, err = s.w.Write(buf[:n+m])
+	return err
+}
+
+// fileSnapshotSink is a streamSnapshotSink writing to a file in the store's
+// auxiliary directory. The file can be read back with loadChecksumSnapshot.
+type fileSnapshotSink struct {
+	streamSnapshotSink
+	f    fs.File
+	path string
+}
+
+// checksumSnapshotDir returns the directory in which fileSnapshotSinks create
+// their files.
+func checksumSnapshotDir(eng storage.Engine) string {
+	return filepath.Join(eng.GetAuxiliaryDir(), "checksum-snapshots")
+}
+
+// createFileSnapshotSink creates a fileSnapshotSink for the given checksum
+// computation.
+func createFileSnapshotSink(
+	eng storage.Engine, rangeID roachpb.RangeID, id uuid.UUID,
+) (*fileSnapshotSink, error) {
+	dir := checksumSnapshotDir(eng)
+	if err := eng.MkdirAll(dir); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("r%d_%s", rangeID, id))
+	f, err := eng.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &fileSnapshotSink{streamSnapshotSink: streamSnapshotSink{w: f}, f: f, path: path}, nil
+}
+
+func (s *fileSnapshotSink) close() error {
+	return s.f.Close()
+}
+
+// loadChecksumSnapshot reads the snapshot data written to the given file by a
+// fileSnapshotSink. The file is decoded one key-value pair at a time, so only
+// the decoded data is held in memory rather than the file's contents as well.
+func loadChecksumSnapshot(eng storage.Engine, path string) (*roachpb.RaftSnapshotData, error) {
+	f, err := eng.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var snapshot roachpb.RaftSnapshotData
+	var buf []byte
+	for {
+		tag, err := r.ReadByte()
+		if err == io.EOF {
+			return &snapshot, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if tag != raftSnapshotDataKVTag {
+			return nil, errors.Errorf("unexpected tag %d in checksum snapshot %s", tag, path)
+		}
+		size, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading checksum snapshot %s", path)
+		}
+		if uint64(cap(buf)) < size {
+			buf = make([]byte, size)
+		}
+		buf = buf[:size]
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, errors.Wrapf(err, "reading checksum snapshot %s", path)
+		}
+		// Unmarshal copies the key and value, so buf can be reused.
+		var kv roachpb.RaftSnapshotData_KeyValue
+		if err := protoutil.Unmarshal(buf, &kv); err != nil {
+			return nil, err
+		}
+		snapshot.KV = append(snapshot.KV, kv)
+	}
+}
@@ -0,0 +1,190 @@
+// Copyright 2020 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package kvserver
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/storage"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/limit"
+	"github.com/cockroachdb/cockroach/pkg/util/protoutil"
+	"github.com/cockroachdb/cockroach/pkg/util/stop"
+	"github.com/cockroachdb/cockroach/pkg/util/uuid"
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+)
+
+// TestChecksumSnapshotSinks verifies that the replica data streamed by a
+// checksum computation is byte-for-byte the encoding of the snapshot that it
+// would have accumulated in memory, and that it can be read back from a file.
+func TestChecksumSnapshotSinks(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	ctx := context.Background()
+	tc := testContext{}
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+	tc.Start(t, stopper)
+
+	for i := 0; i < 10; i++ {
+		pArgs := putArgs(roachpb.Key(fmt.Sprintf("a%d", i)), []byte(fmt.Sprintf("value%d", i)))
+		if _, pErr := tc.SendWrapped(&pArgs); pErr != nil {
+			t.Fatal(pErr)
+		}
+	}
+
+	desc := *tc.repl.Desc()
+	snap := tc.engine.NewSnapshot()
+	defer snap.Close()
+	limiter := limit.NewLimiter(rate.Inf)
+
+	for _, mode := range []roachpb.ChecksumMode{
+		roachpb.ChecksumMode_CHECK_FULL, roachpb.ChecksumMode_CHECK_STATS,
+	} {
+		t.Run(mode.String(), func(t *testing.T) {
+			var inMem roachpb.RaftSnapshotData
+			memRes, err := tc.repl.sha512(
+				ctx, desc, snap, &memSnapshotSink{data: &inMem}, mode, hlc.Timestamp{}, nil, /* excluded */
+				limiter, nil /* storeLimiter */, 1 /* shards */, nil /* progress */, nil /* intents */)
+			require.NoError(t, err)
+			require.NotEmpty(t, inMem.KV)
+			expected, err := protoutil.Marshal(&inMem)
+			require.NoError(t, err)
+
+			var buf bytes.Buffer
+			streamRes, err := tc.repl.sha512(
+				ctx, desc, snap, &streamSnapshotSink{w: &buf}, mode, hlc.Timestamp{}, nil, /* excluded */
+				limiter, nil /* storeLimiter */, 1 /* shards */, nil /* progress */, nil /* intents */)
+			require.NoError(t, err)
+			require.Equal(t, memRes.SHA512, streamRes.SHA512)
+			require.Equal(t, expected, buf.Bytes())
+
+			fileSink, err := createFileSnapshotSink(tc.engine, desc.RangeID, uuid.MakeV4())
+			require.NoError(t, err)
+			_, err = tc.repl.sha512(ctx, desc, snap, fileSink, mode, hlc.Timestamp{}, nil /* excluded */, limiter, nil, /* storeLimiter */
+				1 /* shards */, nil /* progress */, nil /* intents */)
+			require.NoError(t, err)
+			require.NoError(t, fileSink.close())
+			b, err := tc.engine.ReadFile(fileSink.path)
+			require.NoError(t, err)
+			require.Equal(t, expected, b)
+			loaded, err := loadChecksumSnapshot(tc.engine, fileSink.path)
+			require.NoError(t, err)
+			require.Equal(t, inMem, *loaded)
+		})
+	}
+}
+
+// TestRemoveChecksumSnapshots verifies that the files holding the replica data
+// captured by checksum computations are removed when the replica is destroyed,
+// including those of computations finishing afterwards.
+func TestRemoveChecksumSnapshots(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	ctx := context.Background()
+	tc := testContext{}
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+	tc.Start(t, stopper)
+
+	finish := func() (uuid.UUID, string) {
+		id := uuid.MakeV4()
+		tc.repl.mu.Lock()
+		tc.repl.mu.checksums[id] = ReplicaChecksum{started: true, notify: make(chan struct{})}
+		tc.repl.mu.Unlock()
+		fileSink, err := createFileSnapshotSink(tc.engine, tc.repl.RangeID, id)
+		require.NoError(t, err)
+		require.NoError(t, fileSink.close())
+		tc.repl.computeChecksumDone(ctx, id, &replicaHash{}, nil /* snapshot */, fileSink.path)
+		return id, fileSink.path
+	}
+	exists := func(path string) bool {
+		_, err := tc.engine.Stat(path)
+		return err == nil
+	}
+
+	id, path := finish()
+	require.True(t, exists(path))
+	tc.repl.removeChecksumSnapshots(ctx)
+	require.False(t, exists(path))
+	tc.repl.mu.RLock()
+	require.Empty(t, tc.repl.mu.checksums[id].snapshotPath)
+	tc.repl.mu.RUnlock()
+
+	tc.repl.mu.Lock()
+	tc.repl.mu.destroyStatus.Set(errors.New("destroyed"), destroyReasonRemoved)
+	tc.repl.mu.Unlock()
+	_, path = finish()
+	require.False(t, exists(path))
+	tc.repl.mu.Lock()
+	tc.repl.mu.destroyStatus.Set(nil, destroyReasonAlive)
+	tc.repl.mu.Unlock()
+}
+
+// TestCappedSnapshotSink verifies that a cappedSnapshotSink captures a prefix
+// of the replica data and doesn't affect the checksum.
+func TestCappedSnapshotSink(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	ctx := context.Background()
+	tc := testContext{}
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+	tc.Start(t, stopper)
+
+	for i := 0; i < 10; i++ {
+		pArgs := putArgs(roachpb.Key(fmt.Sprintf("a%d", i)), []byte(fmt.Sprintf("value%d", i)))
+		if _, pErr := tc.SendWrapped(&pArgs); pErr != nil {
+			t.Fatal(pErr)
+		}
+	}
+
+	desc := *tc.repl.Desc()
+	snap := tc.engine.NewSnapshot()
+	defer snap.Close()
+	limiter := limit.NewLimiter(rate.Inf)
+	mode := roachpb.ChecksumMode_CHECK_FULL
+
+	var full roachpb.RaftSnapshotData
+	fullRes, err := tc.repl.sha512(
+		ctx, desc, snap, &memSnapshotSink{data: &full}, mode, hlc.Timestamp{}, nil, /* excluded */
+		limiter, nil /* storeLimiter */, 1 /* shards */, nil /* progress */, nil /* intents */)
+	require.NoError(t, err)
+	require.Greater(t, len(full.KV), 1)
+
+	// A limit covering all of the data doesn't truncate the snapshot.
+	var all roachpb.RaftSnapshotData
+	allSink := &cappedSnapshotSink{sink: &memSnapshotSink{data: &all}, max: 1 << 30}
+	_, err = tc.repl.sha512(ctx, desc, snap, allSink, mode, hlc.Timestamp{}, nil, /* excluded */
+		limiter, nil /* storeLimiter */, 1 /* shards */, nil /* progress */, nil /* intents */)
+	require.NoError(t, err)
+	require.False(t, allSink.truncated)
+	require.Equal(t, full, all)
+
+	// A limit that only fits the first key-value pair truncates the snapshot
+	// after it.
+	first := full.KV[0]
+	var capped roachpb.RaftSnapshotData
+	cappedSink := &cappedSnapshotSink{
+		sink: &memSnapshotSink{data: &capped},
+		max:  int64(storage.MVCCKey{Key: first.Key, Timestamp: first.Timestamp}.EncodedSize() + len(first.Value)),
+	}
+	cappedRes, err := tc.repl.sha512(ctx, desc, snap, cappedSink, mode, hlc.Timestamp{}, nil, /* excluded */
+		limiter, nil /* storeLimiter */, 1 /* shards */, nil /* progress */, nil /* intents */)
+	require.NoError(t, err)
+	require.True(t, cappedSink.truncated)
+	require.Equal(t, full.KV[:1], capped.KV)
+	require.Equal(t, fullRes.SHA512, cappedRes.SHA512)
+}
@@ -17,8 +17,12 @@ import (
 	"math"
 	"testing"
 
+	"github.com/cockroachdb/cockroach/pkg/keys"
 	"github.com/cockroachdb/cockroach/pkg/kv"
 	"github.com/cockroachdb/cockroach/pkg/kv/kvserver"
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/storage"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
 	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
 	"github.com/gogo/protobuf/proto"
 )
@@ -112,3 +116,49 @@ func TestRaftLogQueue(t *testing.T) {
 			afterTruncationIndex, after2ndTruncationIndex)
 	}
 }
+
+// TestRaftLogQueueDisabledAppliedBySnapshot verifies that the flag set by a
+// SetRaftLogQueueDisabledRequest is part of the replicated range state, so
+// that a replica initialized from a snapshot picks it up.
+func TestRaftLogQueueDisabledAppliedBySnapshot(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	mtc := &multiTestContext{}
+	defer mtc.Stop()
+	mtc.Start(t, 2)
+
+	key := roachpb.Key("a")
+	args := &roachpb.SetRaftLogQueueDisabledRequest{
+		RequestHeader: roachpb.RequestHeader{Key: key},
+		Disabled:      true,
+	}
+	if _, pErr := kv.SendWrapped(ctx, mtc.stores[0].TestSender(), args); pErr != nil {
+		t.Fatal(pErr)
+	}
+
+	// The new replica on the second store is initialized by a snapshot.
+	rangeID := mtc.stores[0].LookupReplica(roachpb.RKey(key)).RangeID
+	mtc.replicateRange(rangeID, 1)
+
+	v, _, err := storage.MVCCGet(ctx, mtc.engines[1], keys.RangeRaftLogQueueDisabledKey(rangeID),
+		hlc.Timestamp{}, storage.MVCCGetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v == nil {
+		t.Fatal("expected the snapshot to carry the raft log queue flag")
+	}
+	if disabled, err := v.GetBool(); err != nil {
+		t.Fatal(err)
+	} else if !disabled {
+		t.Fatal("expected the raft log queue to be disabled on the new replica")
+	}
+	repl, err := mtc.stores[1].GetReplica(rangeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !repl.StateSnapshot().RaftLogQueueDisabled {
+		t.Fatal("expected the new replica's state to reflect the raft log queue flag")
+	}
+}
@@ -527,6 +527,72 @@ SELECT count(*), sum(value) FROM crdb_internal.node_metrics WHERE
 	require.Equal(t, numSnapsBefore, numRaftSnaps("after"))
 }
 
+// TestStoreRangeSplitEnqueueAfterSplitPostApply verifies that applying a split
+// doesn't offer the left-hand side to the split queue until the Store
+// reflects the split. Previously, a range in need of a split by size was
+// enqueued before splitPostApply had installed its new descriptor, so that the
+// queue could process it under its pre-split bounds.
+func TestStoreRangeSplitEnqueueAfterSplitPostApply(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	ctx := context.Background()
+	splitKey := roachpb.Key("m")
+
+	var mu syncutil.Mutex
+	var postSplitEvents int
+	var staleDescs []roachpb.RangeDescriptor
+	storeCfg := kvserver.TestStoreConfig(nil)
+	storeCfg.TestingKnobs.DisableSplitQueue = true
+	storeCfg.TestingKnobs.DisableMergeQueue = true
+	storeCfg.TestingKnobs.SplitQueueEnqueueAfterApplyEvent = func(r *kvserver.Replica) {
+		// Compare the in-memory descriptor against the committed one, ignoring
+		// the intent laid down by an in-progress split.
+		desc := r.Desc()
+		var committed roachpb.RangeDescriptor
+		if _, err := storage.MVCCGetProto(
+			ctx, r.Engine(), keys.RangeDescriptorKey(desc.StartKey), hlc.MaxTimestamp,
+			&committed, storage.MVCCGetOptions{Inconsistent: true},
+		); err != nil {
+			t.Error(err)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if !committed.Equal(desc) {
+			staleDescs = append(staleDescs, *desc)
+		}
+		if desc.EndKey.Equal(roachpb.RKey(splitKey)) {
+			postSplitEvents++
+		}
+	}
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+	store := createTestStoreWithConfig(t, stopper, storeCfg)
+
+	// Write some data and make the range too large, so that each command
+	// applied to it offers it to the split queue.
+	pArgs := putArgs(roachpb.Key("c"), []byte("foo"))
+	if _, pErr := kv.SendWrapped(ctx, store.TestSender(), pArgs); pErr != nil {
+		t.Fatal(pErr)
+	}
+	repl := store.LookupReplica(roachpb.RKey(splitKey))
+	zone := zonepb.DefaultZoneConfig()
+	zone.RangeMinBytes = proto.Int64(0)
+	zone.RangeMaxBytes = proto.Int64(1)
+	repl.SetZoneConfig(&zone)
+	repl.DisableSplitQueueThrottle()
+
+	args := adminSplitArgs(splitKey)
+	if _, pErr := kv.SendWrapped(ctx, store.TestSender(), args); pErr != nil {
+		t.Fatal(pErr)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Empty(t, staleDescs)
+	// splitPostApply offered the left-hand side to the queue.
+	require.NotZero(t, postSplitEvents)
+}
+
 // TestStoreRangeSplitIdempotency executes a split of a range and
 // verifies that the resulting ranges respond to the right key ranges
 // and that their stats have been properly accounted for and requests
@@ -41,6 +41,38 @@ var consistencyCheckRate = settings.RegisterPublicValidatedByteSizeSetting(
 	validatePositive,
 )
 
+var consistencyCheckShards = settings.RegisterPositiveIntSetting(
+	"server.consistency_check.shards",
+	"the number of goroutines across which the hashing of a range's data is spread "+
+		"during a consistency check; the data is still read by a single iterator, so "+
+		"this only speeds up checks bound by hashing rather than by reading, and the "+
+		"reads remain subject to server.consistency_check.max_rate",
+	1,
+)
+
+var consistencyCheckStreamSnapshots = settings.RegisterBoolSetting(
+	"server.consistency_check.stream_snapshots.enabled",
+	"if true, the replica data captured by consistency checks in order to "+
+		"produce a diff is written to a temporary file instead of being held in memory",
+	false,
+)
+
+var consistencyCheckMaxSnapshotSize = settings.RegisterByteSizeSetting(
+	"server.consistency_check.max_snapshot_size",
+	"the maximum amount of replica data captured by a consistency check in order "+
+		"to produce a diff; data past the limit is still hashed but left out of the "+
+		"diff (0 to disable)",
+	0,
+)
+
+var consistencyCheckStoreIOBudget = settings.RegisterByteSizeSetting(
+	"server.consistency_check.store_io_budget",
+	"the rate limit (bytes/sec) shared by all consistency checks on a store; unlike "+
+		"server.consistency_check.max_rate, which applies to each check individually, "+
+		"it bounds the combined reads of concurrent checks (0 to disable)",
+	0,
+)
+
 var testingAggressiveConsistencyChecks = envutil.EnvOrDefaultBool("COCKROACH_CONSISTENCY_AGGRESSIVE", false)
 
 type consistencyQueue struct {
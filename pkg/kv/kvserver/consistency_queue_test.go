@@ -17,12 +17,14 @@ import (
 	"io/ioutil"
 	"math/rand"
 	"path/filepath"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/cockroachdb/cockroach/pkg/base"
 	"github.com/cockroachdb/cockroach/pkg/kv"
 	"github.com/cockroachdb/cockroach/pkg/kv/kvserver"
+	"github.com/cockroachdb/cockroach/pkg/kv/kvserver/batcheval"
 	"github.com/cockroachdb/cockroach/pkg/kv/kvserver/kvserverbase"
 	"github.com/cockroachdb/cockroach/pkg/kv/kvserver/stateloader"
 	"github.com/cockroachdb/cockroach/pkg/roachpb"
@@ -123,6 +125,128 @@ func TestCheckConsistencyMultiStore(t *testing.T) {
 	}
 }
 
+// TestCheckConsistencyWriteBatchTransform verifies that the replicas of a
+// range remain consistent when their stores transform the WriteBatches of the
+// commands they apply.
+func TestCheckConsistencyWriteBatchTransform(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	const numNodes = 3
+	var transformed [numNodes]int64
+	serverArgsPerNode := map[int]base.TestServerArgs{}
+	for i := 0; i < numNodes; i++ {
+		i := i
+		serverArgsPerNode[i] = base.TestServerArgs{
+			Knobs: base.TestingKnobs{
+				Store: &kvserver.StoreTestingKnobs{
+					// Mark each WriteBatch as seen, leaving it unchanged.
+					WriteBatchTransform: func(data []byte) ([]byte, error) {
+						atomic.AddInt64(&transformed[i], 1)
+						return data, nil
+					},
+				},
+			},
+		}
+	}
+	tc := testcluster.StartTestCluster(t, numNodes,
+		base.TestClusterArgs{
+			ReplicationMode:   base.ReplicationAuto,
+			ServerArgsPerNode: serverArgsPerNode,
+		},
+	)
+	defer tc.Stopper().Stop(context.Background())
+
+	ts := tc.Servers[0]
+	store, pErr := ts.Stores().GetStore(ts.GetFirstStoreID())
+	if pErr != nil {
+		t.Fatal(pErr)
+	}
+	putArgs := putArgs([]byte("a"), []byte("b"))
+	if _, err := kv.SendWrapped(context.Background(), store.TestSender(), putArgs); err != nil {
+		t.Fatal(err)
+	}
+
+	checkArgs := roachpb.CheckConsistencyRequest{
+		RequestHeader: roachpb.RequestHeader{
+			Key:    []byte("a"),
+			EndKey: []byte("aa"),
+		},
+		Mode: roachpb.ChecksumMode_CHECK_FULL,
+	}
+	resp, err := kv.SendWrapped(context.Background(), store.TestSender(), &checkArgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := resp.(*roachpb.CheckConsistencyResponse).Result
+	require.Len(t, res, 1)
+	require.Equal(t, roachpb.CheckConsistencyResponse_RANGE_CONSISTENT, res[0].Status, "%s", res[0].Detail)
+	for i := range transformed {
+		require.NotZero(t, atomic.LoadInt64(&transformed[i]), "n%d", i+1)
+	}
+}
+
+// TestStoreRunConsistencySweep verifies that a consistency sweep checks every
+// range on the store, and that an interrupted sweep can be resumed.
+func TestStoreRunConsistencySweep(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	tc := testcluster.StartTestCluster(t, 1, base.TestClusterArgs{
+		ReplicationMode: base.ReplicationManual,
+	})
+	defer tc.Stopper().Stop(ctx)
+	tc.SplitRangeOrFatal(t, roachpb.Key("a"))
+	tc.SplitRangeOrFatal(t, roachpb.Key("b"))
+	tc.SplitRangeOrFatal(t, roachpb.Key("c"))
+
+	ts := tc.Servers[0]
+	store, pErr := ts.Stores().GetStore(ts.GetFirstStoreID())
+	if pErr != nil {
+		t.Fatal(pErr)
+	}
+	// rangeIDs returns the ranges on the store starting at or after from.
+	rangeIDs := func(from roachpb.RKey) []roachpb.RangeID {
+		var ids []roachpb.RangeID
+		store.VisitReplicas(func(repl *kvserver.Replica) bool {
+			if !repl.Desc().StartKey.Less(from) {
+				ids = append(ids, repl.RangeID)
+			}
+			return true
+		})
+		return ids
+	}
+	checked := func(summary kvserver.ConsistencySweepSummary) []roachpb.RangeID {
+		var ids []roachpb.RangeID
+		for _, res := range summary.Results {
+			ids = append(ids, res.RangeID)
+		}
+		return ids
+	}
+
+	summary, err := store.RunConsistencySweep(ctx, 0 /* limit */, nil /* resumeKey */)
+	require.NoError(t, err)
+	require.Empty(t, summary.Errors)
+	require.Zero(t, summary.Inconsistent)
+	require.Zero(t, summary.Skipped)
+	require.Nil(t, summary.ResumeKey)
+	require.ElementsMatch(t, rangeIDs(roachpb.RKeyMin), checked(summary))
+	require.Equal(t, len(summary.Results), summary.Consistent)
+
+	// A canceled sweep reports where to pick up.
+	cancelCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	summary, err = store.RunConsistencySweep(cancelCtx, 0 /* limit */, roachpb.RKey("b"))
+	require.Equal(t, context.Canceled, err)
+	require.Empty(t, summary.Results)
+	require.Equal(t, roachpb.RKey("b"), summary.ResumeKey)
+
+	summary, err = store.RunConsistencySweep(ctx, 0 /* limit */, summary.ResumeKey)
+	require.NoError(t, err)
+	require.Empty(t, summary.Errors)
+	require.ElementsMatch(t, rangeIDs(roachpb.RKey("b")), checked(summary))
+	require.Equal(t, len(summary.Results), summary.Consistent)
+}
+
 // TestCheckConsistencyReplay verifies that two ComputeChecksum requests with
 // the same checksum ID are not committed to the Raft log, even if DistSender
 // retries the request.
@@ -647,3 +771,63 @@ func testConsistencyQueueRecomputeStatsImpl(t *testing.T, hadEstimates bool) {
 		assert.True(t, hadEstimates)
 	}
 }
+
+// TestComputeChecksumInvalidatedBySplit verifies that a checksum computation
+// which is in progress when the range splits is cancelled, and that its
+// collectors are told so instead of receiving a digest of the pre-split data.
+func TestComputeChecksumInvalidatedBySplit(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	ctx := context.Background()
+
+	tc := testcluster.StartTestCluster(t, 1, base.TestClusterArgs{
+		ReplicationMode: base.ReplicationManual,
+	})
+	defer tc.Stopper().Stop(ctx)
+	db := tc.Servers[0].DB()
+
+	key := roachpb.Key("a")
+	if err := db.AdminSplit(ctx, key, hlc.MaxTimestamp /* expirationTime */); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if err := db.Put(ctx, fmt.Sprintf("a%03d", i), bytes.Repeat([]byte("x"), 100)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Throttle checksum computations so that the one below is still hashing
+	// the range's data when the split applies.
+	if _, err := tc.ServerConn(0).Exec(
+		`SET CLUSTER SETTING server.consistency_check.max_rate = '100B'`,
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := tc.Servers[0].Stores().GetStore(tc.Servers[0].GetFirstStoreID())
+	require.NoError(t, err)
+	repl := store.LookupReplica(roachpb.RKey(key))
+	require.NotNil(t, repl)
+	resp, pErr := kv.SendWrapped(ctx, store.TestSender(), &roachpb.ComputeChecksumRequest{
+		RequestHeader: roachpb.RequestHeader{Key: key, EndKey: key.Next()},
+		Version:       batcheval.ReplicaChecksumVersion,
+		Mode:          roachpb.ChecksumMode_CHECK_FULL,
+	})
+	require.Nil(t, pErr)
+	id := resp.(*roachpb.ComputeChecksumResponse).ChecksumID
+
+	if err := db.AdminSplit(ctx, "a050", hlc.MaxTimestamp /* expirationTime */); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = repl.GetChecksumForTesting(ctx, id)
+	require.True(t, testutils.IsError(err, "invalidated by topology change"), "%v", err)
+	var found bool
+	for _, status := range repl.PendingChecksums() {
+		if status.ID == id {
+			found = true
+			require.True(t, status.Invalidated)
+			require.False(t, status.Computed)
+		}
+	}
+	require.True(t, found)
+}
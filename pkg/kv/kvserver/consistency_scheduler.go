@@ -0,0 +1,160 @@
+// Copyright 2020 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package kvserver
+
+import (
+	"context"
+
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/settings"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+)
+
+var consistencyCheckConcurrency = settings.RegisterPositiveIntSetting(
+	"server.consistency_check.concurrency",
+	"the maximum number of consistency check computations that a store carries out concurrently",
+	8,
+)
+
+// checksumWaiter is a checksum computation waiting for a slot.
+type checksumWaiter struct {
+	// admitted is closed once the waiter has been handed a slot.
+	admitted chan struct{}
+	// cancelled is set once the waiter has given up. It's left in the queue
+	// and skipped when its turn comes.
+	cancelled bool
+}
+
+// checksumScheduler admits the checksum computations triggered by
+// ComputeChecksum commands. At most limit() computations run at a time.
+// Computations which can't be admitted right away wait for a slot, queued per
+// range, and ranges are served round-robin: when a slot frees up, it goes to
+// the first waiter of the range at the front of the queue, and the range
+// moves to the back if it has more waiters. This ensures that a range which
+// is checked repeatedly can't starve the checks of other ranges, as would
+// otherwise happen during a store-wide consistency sweep.
+//
+// The snapshot of a ComputeChecksum command has to be opened while the command
+// applies, for all replicas to hash the same log position, so computations
+// wait for a slot in their async task, holding on to the snapshot. Waiting on
+// the Raft application path instead would hold up the range's commands. The
+// wait is unbounded: giving up would fail the check for no fault of the range.
+type checksumScheduler struct {
+	limit func() int
+
+	mu struct {
+		syncutil.Mutex
+		// running is the number of admitted computations.
+		running int
+		// queue holds the IDs of the ranges with waiters in the order in which
+		// they'll be served. Each range appears at most once.
+		queue   rangeIDQueue
+		waiters map[roachpb.RangeID][]*checksumWaiter
+	}
+}
+
+func newChecksumScheduler(limit func() int) *checksumScheduler {
+	s := &checksumScheduler{limit: limit}
+	s.mu.waiters = make(map[roachpb.RangeID][]*checksumWaiter)
+	return s
+}
+
+// acquire admits a checksum computation for the given range, waiting for a
+// slot to free up. It returns a function that must be called once the
+// computation has finished, or the context's error if the context is done
+// before the computation is admitted.
+func (s *checksumScheduler) acquire(ctx context.Context, rangeID roachpb.RangeID) (func(), error) {
+	w := &checksumWaiter{admitted: make(chan struct{})}
+	s.mu.Lock()
+	if _, ok := s.mu.waiters[rangeID]; !ok {
+		s.mu.queue.PushBack(rangeID)
+	}
+	s.mu.waiters[rangeID] = append(s.mu.waiters[rangeID], w)
+	s.admitLocked()
+	s.mu.Unlock()
+
+	select {
+	case <-w.admitted:
+		return s.release, nil
+	case <-ctx.Done():
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-w.admitted:
+		// We were admitted while giving up. Pass the slot on.
+		s.mu.running--
+		s.admitLocked()
+	default:
+		w.cancelled = true
+	}
+	return nil, ctx.Err()
+}
+
+func (s *checksumScheduler) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mu.running--
+	s.admitLocked()
+}
+
+// admitLocked hands the free slots to the waiters whose turn it is.
+func (s *checksumScheduler) admitLocked() {
+	for s.mu.running < s.limit() {
+		w := s.nextLocked()
+		if w == nil {
+			return
+		}
+		s.mu.running++
+		close(w.admitted)
+	}
+}
+
+// nextLocked pops the next waiter to admit, if any.
+func (s *checksumScheduler) nextLocked() *checksumWaiter {
+	for {
+		rangeID, ok := s.mu.queue.PopFront()
+		if !ok {
+			return nil
+		}
+		waiters := s.mu.waiters[rangeID]
+		for len(waiters) > 0 && waiters[0].cancelled {
+			waiters[0] = nil // for GC
+			waiters = waiters[1:]
+		}
+		if len(waiters) == 0 {
+			delete(s.mu.waiters, rangeID)
+			continue
+		}
+		w := waiters[0]
+		waiters[0] = nil // for GC
+		if waiters = waiters[1:]; len(waiters) == 0 {
+			delete(s.mu.waiters, rangeID)
+		} else {
+			s.mu.waiters[rangeID] = waiters
+			s.mu.queue.PushBack(rangeID)
+		}
+		return w
+	}
+}
+
+// numWaiting returns the number of computations waiting for a slot, including
+// those that have given up but haven't been skipped yet.
+func (s *checksumScheduler) numWaiting() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int
+	for _, waiters := range s.mu.waiters {
+		n += len(waiters)
+	}
+	return n
+}
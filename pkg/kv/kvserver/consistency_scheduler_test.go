@@ -0,0 +1,113 @@
+// Copyright 2020 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package kvserver
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/testutils"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// TestChecksumSchedulerFairness verifies that a range with many checksum
+// computations waiting for a slot doesn't starve the computations of another
+// range.
+func TestChecksumSchedulerFairness(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	ctx := context.Background()
+
+	s := newChecksumScheduler(func() int { return 1 })
+
+	// Occupy the only slot so that everything below waits.
+	release, err := s.acquire(ctx, 1)
+	require.NoError(t, err)
+
+	// admitted receives the name of each computation once it's admitted. The
+	// computation releases its slot right away, admitting the next one.
+	admitted := make(chan string)
+	wait := func(rangeID roachpb.RangeID, name string) {
+		n := s.numWaiting()
+		go func() {
+			release, err := s.acquire(ctx, rangeID)
+			if err != nil {
+				t.Error(err)
+				admitted <- ""
+				return
+			}
+			admitted <- name
+			release()
+		}()
+		// Queue the computations in a known order.
+		testutils.SucceedsSoon(t, func() error {
+			if s.numWaiting() == n {
+				return errors.Errorf("%s not waiting yet", name)
+			}
+			return nil
+		})
+	}
+
+	const hotChecks, coldChecks = 10, 2
+	for i := 0; i < hotChecks; i++ {
+		wait(1, fmt.Sprintf("hot%d", i))
+	}
+	for i := 0; i < coldChecks; i++ {
+		wait(2, fmt.Sprintf("cold%d", i))
+	}
+	release()
+
+	var order []string
+	for i := 0; i < hotChecks+coldChecks; i++ {
+		order = append(order, <-admitted)
+	}
+
+	// The cold range is served in between the hot range's computations.
+	exp := []string{"hot0", "cold0", "hot1", "cold1"}
+	for i := 2; i < hotChecks; i++ {
+		exp = append(exp, fmt.Sprintf("hot%d", i))
+	}
+	require.Equal(t, exp, order)
+}
+
+// TestChecksumSchedulerCancel verifies that a computation whose context is
+// cancelled while it waits for a slot gives up without holding on to a slot.
+func TestChecksumSchedulerCancel(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	ctx := context.Background()
+
+	s := newChecksumScheduler(func() int { return 1 })
+
+	release1, err := s.acquire(ctx, 1)
+	require.NoError(t, err)
+
+	// A computation waits for as long as it takes, until its context is done.
+	timeoutCtx, cancel := context.WithTimeout(ctx, time.Millisecond)
+	defer cancel()
+	_, err = s.acquire(timeoutCtx, 2)
+	require.True(t, errors.Is(err, context.DeadlineExceeded), "%v", err)
+
+	cancelledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = s.acquire(cancelledCtx, 3)
+	require.True(t, errors.Is(err, context.Canceled), "%v", err)
+
+	// The computations that gave up are skipped once a slot frees up.
+	release1()
+	release2, err := s.acquire(ctx, 2)
+	require.NoError(t, err)
+	require.Zero(t, s.numWaiting())
+	release2()
+}
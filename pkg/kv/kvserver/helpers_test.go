@@ -40,6 +40,7 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/util/quotapool"
 	"github.com/cockroachdb/cockroach/pkg/util/randutil"
 	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/cockroach/pkg/util/uuid"
 	"github.com/cockroachdb/errors"
 	"go.etcd.io/etcd/raft"
 )
@@ -88,6 +89,13 @@ func (s *Store) ComputeMVCCStats() (enginepb.MVCCStats, error) {
 	return totalStats, err
 }
 
+// GetChecksumForTesting waits for the checksum computation with the given ID
+// to complete and returns the checksum.
+func (r *Replica) GetChecksumForTesting(ctx context.Context, id uuid.UUID) ([]byte, error) {
+	c, err := r.getChecksum(ctx, id)
+	return c.Checksum, err
+}
+
 // ConsistencyQueueShouldQueue invokes the shouldQueue method on the
 // store's consistency queue.
 func ConsistencyQueueShouldQueue(
@@ -409,6 +417,15 @@ func (r *Replica) LargestPreviousMaxRangeSizeBytes() int64 {
 	return r.mu.largestPreviousMaxRangeSizeBytes
 }
 
+// DisableSplitQueueThrottle makes the replica offer itself to the split queue
+// after every application of a command that leaves it in need of a split by
+// size, instead of at most once per splitQueueThrottleDuration.
+func (r *Replica) DisableSplitQueueThrottle() {
+	r.splitQueueThrottle.Lock()
+	defer r.splitQueueThrottle.Unlock()
+	r.splitQueueThrottle.N = 0
+}
+
 func MakeSSTable(key, value string, ts hlc.Timestamp) ([]byte, storage.MVCCKeyValue) {
 	sstFile := &storage.MemFile{}
 	sst := storage.MakeIngestionSSTWriter(sstFile)
@@ -569,3 +586,40 @@ func WatchForDisappearingReplicas(t testing.TB, store *Store) {
 		}
 	}
 }
+
+// ApplyReplicatedEvalResultForTesting carries out the below-Raft side effects
+// of the supplied ReplicatedEvalResult on the replica, as if a command carrying
+// it had just been applied. It does not write anything to the engine, so any
+// on-disk state the result relies upon (e.g. the right-hand side of a split)
+// must be set up by the caller. It is intended for replaying results captured
+// from a running cluster.
+//
+// The result goes through the same path as that of an applied command, so
+// results carrying only trivial fields don't have any side effects. It returns
+// whether the result triggered the in-memory/on-disk state assertion, which is
+// stubbed out as the on-disk state is typically not set up to match. Assertion
+// failures on the apply path are returned as an error instead of crashing the
+// process.
+func (r *Replica) ApplyReplicatedEvalResultForTesting(
+	ctx context.Context, rResult kvserverpb.ReplicatedEvalResult,
+) (shouldAssert bool, err error) {
+	ctx = r.AnnotateCtx(ctx)
+	r.raftMu.Lock()
+	defer r.raftMu.Unlock()
+
+	sm := replicaStateMachine{r: r}
+	sm.onFatal = func(_ context.Context, format string, args ...interface{}) {
+		if err == nil {
+			err = errors.AssertionFailedf(format, args...)
+		}
+	}
+	sm.onAssertState = func(context.Context) {
+		shouldAssert = true
+	}
+	if rResult.IsZero() {
+		sm.fatalf(ctx, "zero-value ReplicatedEvalResult passed to ApplyReplicatedEvalResultForTesting")
+		return false, err
+	}
+	sm.handleReplicatedEvalResult(ctx, &rResult, time.Time{} /* proposedAt */)
+	return shouldAssert, err
+}
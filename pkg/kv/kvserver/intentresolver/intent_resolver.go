@@ -430,16 +430,27 @@ func (ir *IntentResolver) CleanupIntentsAsync(
 		return nil
 	}
 	now := ir.clock.Now()
-	return ir.runAsyncTask(ctx, allowSyncProcessing, func(ctx context.Context) {
+	n := int64(len(intents))
+	cleanup := func(ctx context.Context) {
 		err := contextutil.RunWithTimeout(ctx, "async intent resolution",
 			asyncIntentResolutionTimeout, func(ctx context.Context) error {
 				_, err := ir.CleanupIntents(ctx, intents, now, roachpb.PUSH_TOUCH)
 				return err
 			})
-		if err != nil && ir.every.ShouldLog() {
-			log.Warningf(ctx, "%v", err)
+		if err != nil {
+			ir.Metrics.IntentResolverAsyncFailed.Inc(n)
+			if ir.every.ShouldLog() {
+				log.Warningf(ctx, "%v", err)
+			}
+			return
 		}
-	})
+		ir.Metrics.IntentResolverAsyncCompleted.Inc(n)
+	}
+	// The intents are counted before the task is started so that those which
+	// are dropped because it can't be show up as a gap with the completed and
+	// failed ones.
+	ir.Metrics.IntentResolverAsyncHandedOff.Inc(n)
+	return ir.runAsyncTask(ctx, allowSyncProcessing, cleanup)
 }
 
 // CleanupIntents processes a collection of intents by pushing each
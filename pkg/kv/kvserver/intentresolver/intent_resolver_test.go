@@ -409,6 +409,82 @@ func TestCleanupMultipleIntentsAsync(t *testing.T) {
 	assert.Equal(t, []string{"a", "b", "c", "d"}, reqs.resolved)
 }
 
+// TestCleanupIntentsAsyncMetrics verifies that the gap between intents handed
+// off to asynchronous resolution and those for which resolution completed or
+// failed reflects resolution that is stalled or intents that are dropped.
+func TestCleanupIntentsAsyncMetrics(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	ctx := context.Background()
+	clock := hlc.NewClock(hlc.UnixNano, time.Nanosecond)
+	txn := newTransaction("txn", roachpb.Key("a"), 1, clock)
+	testIntents := []roachpb.Intent{
+		roachpb.MakeIntent(&txn.TxnMeta, roachpb.Key("a")),
+		roachpb.MakeIntent(&txn.TxnMeta, roachpb.Key("b")),
+	}
+
+	// Stall the push until unblocked.
+	pushing := make(chan struct{})
+	unblock := make(chan struct{})
+	stalledPushFunc := func(ba roachpb.BatchRequest) (*roachpb.BatchResponse, *roachpb.Error) {
+		close(pushing)
+		<-unblock
+		return singlePushTxnSendFunc(t)(ba)
+	}
+	sf := newSendFuncs(t, stalledPushFunc, resolveIntentsSendFunc(t))
+
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+	cfg := Config{
+		Stopper: stopper,
+		Clock:   clock,
+	}
+	ir := newIntentResolverWithSendFuncs(cfg, sf)
+	gap := func() int64 {
+		return ir.Metrics.IntentResolverAsyncHandedOff.Count() -
+			ir.Metrics.IntentResolverAsyncCompleted.Count() -
+			ir.Metrics.IntentResolverAsyncFailed.Count()
+	}
+
+	assert.Nil(t, ir.CleanupIntentsAsync(ctx, testIntents, false))
+	<-pushing
+	assert.Equal(t, int64(len(testIntents)), ir.Metrics.IntentResolverAsyncHandedOff.Count())
+	assert.Equal(t, int64(len(testIntents)), gap())
+
+	close(unblock)
+	sf.drain(t)
+	testutils.SucceedsSoon(t, func() error {
+		if g := gap(); g != 0 {
+			return errors.Errorf("expected no gap, got %d", g)
+		}
+		return nil
+	})
+	assert.Equal(t, int64(len(testIntents)), ir.Metrics.IntentResolverAsyncCompleted.Count())
+	assert.Equal(t, int64(0), ir.Metrics.IntentResolverAsyncFailed.Count())
+
+	// Intents for which resolution fails are counted as failed, not completed.
+	sf.mu.Lock()
+	sf.pushFrontLocked(func(ba roachpb.BatchRequest) (*roachpb.BatchResponse, *roachpb.Error) {
+		return nil, roachpb.NewErrorf("boom")
+	})
+	sf.mu.Unlock()
+	assert.Nil(t, ir.CleanupIntentsAsync(ctx, testIntents, false))
+	sf.drain(t)
+	testutils.SucceedsSoon(t, func() error {
+		if g := gap(); g != 0 {
+			return errors.Errorf("expected no gap, got %d", g)
+		}
+		return nil
+	})
+	assert.Equal(t, int64(len(testIntents)), ir.Metrics.IntentResolverAsyncCompleted.Count())
+	assert.Equal(t, int64(len(testIntents)), ir.Metrics.IntentResolverAsyncFailed.Count())
+
+	// Intents which can't be handed to an async task are dropped, which
+	// leaves a gap.
+	ir.testingKnobs.DisableAsyncIntentResolution = true
+	assert.NotNil(t, ir.CleanupIntentsAsync(ctx, testIntents, false))
+	assert.Equal(t, int64(len(testIntents)), gap())
+}
+
 func repeat(f sendFunc, n int) []sendFunc {
 	fns := make([]sendFunc, n)
 	for i := range fns {
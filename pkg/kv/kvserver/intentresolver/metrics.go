@@ -20,17 +20,47 @@ var (
 		Measurement: "Intent Resolutions",
 		Unit:        metric.Unit_COUNT,
 	}
+	metaIntentResolverAsyncHandedOff = metric.Metadata{
+		Name:        "intentresolver.async.handedoff",
+		Help:        "Number of encountered intents handed off to asynchronous intent resolution",
+		Measurement: "Intents",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaIntentResolverAsyncCompleted = metric.Metadata{
+		Name:        "intentresolver.async.completed",
+		Help:        "Number of encountered intents for which asynchronous intent resolution succeeded",
+		Measurement: "Intents",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaIntentResolverAsyncFailed = metric.Metadata{
+		Name:        "intentresolver.async.failed",
+		Help:        "Number of encountered intents for which asynchronous intent resolution failed or timed out",
+		Measurement: "Intents",
+		Unit:        metric.Unit_COUNT,
+	}
 )
 
 // Metrics contains the metrics for the IntentResolver.
 type Metrics struct {
 	// Intent resolver metrics.
 	IntentResolverAsyncThrottled *metric.Counter
+	// IntentResolverAsyncHandedOff tracks the encountered intents that were
+	// handed off to asynchronous resolution, and IntentResolverAsyncCompleted
+	// and IntentResolverAsyncFailed those for which resolution succeeded and
+	// failed, respectively. A growing gap between the former and the sum of
+	// the latter indicates that intents are being dropped or that resolution
+	// is stalled.
+	IntentResolverAsyncHandedOff *metric.Counter
+	IntentResolverAsyncCompleted *metric.Counter
+	IntentResolverAsyncFailed    *metric.Counter
 }
 
 func makeMetrics() Metrics {
 	// Intent resolver metrics.
 	return Metrics{
 		IntentResolverAsyncThrottled: metric.NewCounter(metaIntentResolverAsyncThrottled),
+		IntentResolverAsyncHandedOff: metric.NewCounter(metaIntentResolverAsyncHandedOff),
+		IntentResolverAsyncCompleted: metric.NewCounter(metaIntentResolverAsyncCompleted),
+		IntentResolverAsyncFailed:    metric.NewCounter(metaIntentResolverAsyncFailed),
 	}
 }
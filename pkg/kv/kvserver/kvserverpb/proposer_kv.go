@@ -10,7 +10,11 @@
 
 package kvserverpb
 
-import "math"
+import (
+	"math"
+
+	"github.com/cockroachdb/cockroach/pkg/storage/enginepb"
+)
 
 var maxRaftCommandFooterSize = (&RaftCommandFooter{
 	MaxLeaseIndex: math.MaxUint64,
@@ -21,3 +25,23 @@ var maxRaftCommandFooterSize = (&RaftCommandFooter{
 func MaxRaftCommandFooterSize() int {
 	return maxRaftCommandFooterSize
 }
+
+// IsZero reports whether r is the zero value. It agrees with
+// r.Equal(ReplicatedEvalResult{}), but is cheaper to evaluate.
+func (r *ReplicatedEvalResult) IsZero() bool {
+	// NB: keep in order.
+	return r.State == nil &&
+		r.Split == nil &&
+		r.Merge == nil &&
+		r.ComputeChecksum == nil &&
+		!r.IsLeaseRequest &&
+		r.Timestamp.IsEmpty() &&
+		r.DeprecatedDelta == nil &&
+		r.Delta == (enginepb.MVCCStatsDelta{}) &&
+		r.ChangeReplicas == nil &&
+		r.RaftLogDelta == 0 &&
+		r.AddSSTable == nil &&
+		len(r.SuggestedCompactions) == 0 &&
+		r.PrevLeaseProposal == nil &&
+		!r.UpdateRaftLogQueueDisabled
+}
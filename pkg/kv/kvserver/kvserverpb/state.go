@@ -0,0 +1,25 @@
+// Copyright 2020 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package kvserverpb
+
+// IsZero reports whether s is the zero value.
+func (s *ReplicaState) IsZero() bool {
+	// NB: keep in order.
+	return s.RaftAppliedIndex == 0 &&
+		s.LeaseAppliedIndex == 0 &&
+		s.Desc == nil &&
+		s.Lease == nil &&
+		s.TruncatedState == nil &&
+		s.GCThreshold == nil &&
+		s.Stats == nil &&
+		!s.UsingAppliedStateKey &&
+		!s.RaftLogQueueDisabled
+}
@@ -13,6 +13,7 @@ package kvserver
 import (
 	"github.com/cockroachdb/cockroach/pkg/roachpb"
 	"github.com/cockroachdb/cockroach/pkg/util/envutil"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
 	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
 )
 
@@ -67,3 +68,58 @@ func (lh *leaseHistory) get() []roachpb.Lease {
 	copy(result[len(first):], second)
 	return result
 }
+
+// leaseTransitionHistoryMaxEntries bounds the number of lease transitions
+// remembered by each replica.
+const leaseTransitionHistoryMaxEntries = 16
+
+// LeaseTransition describes a change of lease holder applied by a replica.
+type LeaseTransition struct {
+	// PrevStoreID and NewStoreID identify the stores holding the previous and
+	// the new lease.
+	PrevStoreID, NewStoreID roachpb.StoreID
+	// Timestamp is the time at which the replica applied the new lease.
+	Timestamp hlc.Timestamp
+	// Acquired is set if the replica's store became the leaseholder, and Lost
+	// is set if it stopped being the leaseholder.
+	Acquired, Lost bool
+}
+
+// leaseTransitionHistory is a fixed-size log of the most recent lease
+// transitions applied by a replica. Unlike leaseHistory, it is always enabled.
+type leaseTransitionHistory struct {
+	syncutil.Mutex
+	index   int
+	history []LeaseTransition // A circular buffer with index.
+}
+
+func (th *leaseTransitionHistory) add(t LeaseTransition) {
+	th.Lock()
+	defer th.Unlock()
+
+	// Not through the first pass through the buffer.
+	if th.index == len(th.history) {
+		th.history = append(th.history, t)
+	} else {
+		th.history[th.index] = t
+	}
+	th.index++
+	if th.index >= leaseTransitionHistoryMaxEntries {
+		th.index = 0
+	}
+}
+
+// get returns a copy of the recorded transitions, oldest first.
+func (th *leaseTransitionHistory) get() []LeaseTransition {
+	th.Lock()
+	defer th.Unlock()
+	if len(th.history) == 0 {
+		return nil
+	}
+	result := make([]LeaseTransition, 0, len(th.history))
+	if len(th.history) < leaseTransitionHistoryMaxEntries {
+		return append(result, th.history...)
+	}
+	result = append(result, th.history[th.index:]...)
+	return append(result, th.history[:th.index]...)
+}
@@ -58,3 +58,31 @@ func TestLeaseHistory(t *testing.T) {
 		})
 	}
 }
+
+func TestLeaseTransitionHistory(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	var history leaseTransitionHistory
+
+	if h := history.get(); h != nil {
+		t.Fatalf("expected empty history, got %+v", h)
+	}
+	// Overflow the circular buffer and check that only the most recent entries
+	// are retained, oldest first.
+	const n = 2*leaseTransitionHistoryMaxEntries + 3
+	for i := 1; i <= n; i++ {
+		history.add(LeaseTransition{NewStoreID: roachpb.StoreID(i)})
+		h := history.get()
+		expLen := i
+		if expLen > leaseTransitionHistoryMaxEntries {
+			expLen = leaseTransitionHistoryMaxEntries
+		}
+		if len(h) != expLen {
+			t.Fatalf("%d: expected history len %d, got %d", i, expLen, len(h))
+		}
+		for j := range h {
+			if e, a := roachpb.StoreID(i-expLen+j+1), h[j].NewStoreID; e != a {
+				t.Fatalf("%d: expected entry %d to have store %d, got %d", i, j, e, a)
+			}
+		}
+	}
+}
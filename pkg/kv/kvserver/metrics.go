@@ -113,6 +113,12 @@ var (
 		Measurement: "Lease Transfers",
 		Unit:        metric.Unit_COUNT,
 	}
+	metaLeaseLostCount = metric.Metadata{
+		Name:        "leases.lost",
+		Help:        "Number of leases held by this store which moved to another store",
+		Measurement: "Leases",
+		Unit:        metric.Unit_COUNT,
+	}
 	metaLeaseExpirationCount = metric.Metadata{
 		Name:        "leases.expiration",
 		Help:        "Number of replica leaseholders using expiration-based leases",
@@ -125,6 +131,30 @@ var (
 		Measurement: "Replicas",
 		Unit:        metric.Unit_COUNT,
 	}
+	metaLeaseLowWaterJump = metric.Metadata{
+		Name:        "leases.tscache_low_water_jump",
+		Help:        "Histogram of the amount by which the timestamp cache low water mark was raised when this store acquired a lease",
+		Measurement: "Latency",
+		Unit:        metric.Unit_NANOSECONDS,
+	}
+	metaLeaseLowWaterJumpLarge = metric.Metadata{
+		Name:        "leases.tscache_low_water_jump.large",
+		Help:        "Number of lease acquisitions which raised the timestamp cache low water mark by more than a second",
+		Measurement: "Lease Acquisitions",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaLeaseStartSkew = metric.Metadata{
+		Name:        "leases.start_skew",
+		Help:        "Histogram of the absolute difference between the start of a lease changing hands and the local clock at the time it was applied",
+		Measurement: "Latency",
+		Unit:        metric.Unit_NANOSECONDS,
+	}
+	metaLeaseApplyLatency = metric.Metadata{
+		Name:        "leases.apply_latency",
+		Help:        "Histogram of the time between proposing a lease request and applying the resulting lease, for leases proposed by this store",
+		Measurement: "Latency",
+		Unit:        metric.Unit_NANOSECONDS,
+	}
 
 	// Storage metrics.
 	metaLiveBytes = metric.Metadata{
@@ -442,6 +472,12 @@ var (
 		Measurement: "Leader Transfers",
 		Unit:        metric.Unit_COUNT,
 	}
+	metaRangeRaftLeaderTransfersSkipped = metric.Metadata{
+		Name:        "range.raftleadertransfers.skipped",
+		Help:        "Number of times raft leader transfers to the leaseholder started being skipped because its log was too far behind",
+		Measurement: "Leader Transfers",
+		Unit:        metric.Unit_COUNT,
+	}
 
 	// Raft processing metrics.
 	metaRaftTicks = metric.Metadata{
@@ -468,6 +504,12 @@ var (
 		Measurement: "Commands",
 		Unit:        metric.Unit_COUNT,
 	}
+	metaRaftCommandsWriteBytes = metric.Metadata{
+		Name:        "raft.commands.writebytes",
+		Help:        "Number of bytes in the write batches of applied Raft commands",
+		Measurement: "Bytes Written",
+		Unit:        metric.Unit_BYTES,
+	}
 	metaRaftLogCommitLatency = metric.Metadata{
 		Name:        "raft.process.logcommit.latency",
 		Help:        "Latency histogram for committing Raft log entries",
@@ -492,6 +534,18 @@ var (
 		Measurement: "Latency",
 		Unit:        metric.Unit_NANOSECONDS,
 	}
+	metaRaftApplySideEffectsProposerLatency = metric.Metadata{
+		Name:        "raft.process.applysideeffects.proposer.latency",
+		Help:        "Latency histogram for handling the side effects of Raft commands applied by the replica which proposed them",
+		Measurement: "Latency",
+		Unit:        metric.Unit_NANOSECONDS,
+	}
+	metaRaftApplySideEffectsFollowerLatency = metric.Metadata{
+		Name:        "raft.process.applysideeffects.follower.latency",
+		Help:        "Latency histogram for handling the side effects of Raft commands proposed by another replica",
+		Measurement: "Latency",
+		Unit:        metric.Unit_NANOSECONDS,
+	}
 
 	// Raft message metrics.
 	metaRaftRcvdProp = metric.Metadata{
@@ -726,6 +780,42 @@ var (
 		Measurement: "Processing Time",
 		Unit:        metric.Unit_NANOSECONDS,
 	}
+	metaConsistencyQueueOldestChecksumNanos = metric.Metadata{
+		Name:        "queue.consistency.oldest_pending_checksum_nanos",
+		Help:        "Age of the oldest checksum computation in progress on the store's replicas",
+		Measurement: "Checksum Age",
+		Unit:        metric.Unit_NANOSECONDS,
+	}
+	metaConsistencyQueueChecksumsOverGCInterval = metric.Metadata{
+		Name:        "queue.consistency.checksums_over_gc_interval",
+		Help:        "Number of checksum computations on the store's replicas which took longer than the interval for which their results are retained",
+		Measurement: "Checksum Computations",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaStatsQueueSuccesses = metric.Metadata{
+		Name:        "queue.stats.process.success",
+		Help:        "Number of replicas successfully processed by the stats reconciliation queue",
+		Measurement: "Replicas",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaStatsQueueFailures = metric.Metadata{
+		Name:        "queue.stats.process.failure",
+		Help:        "Number of replicas which failed processing in the stats reconciliation queue",
+		Measurement: "Replicas",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaStatsQueuePending = metric.Metadata{
+		Name:        "queue.stats.pending",
+		Help:        "Number of pending replicas in the stats reconciliation queue",
+		Measurement: "Replicas",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaStatsQueueProcessingNanos = metric.Metadata{
+		Name:        "queue.stats.processingnanos",
+		Help:        "Nanoseconds spent processing replicas in the stats reconciliation queue",
+		Measurement: "Processing Time",
+		Unit:        metric.Unit_NANOSECONDS,
+	}
 	metaReplicaGCQueueSuccesses = metric.Metadata{
 		Name:        "queue.replicagc.process.success",
 		Help:        "Number of replicas successfully processed by the replica GC queue",
@@ -1026,6 +1116,23 @@ type StoreMetrics struct {
 	LeaseTransferErrorCount   *metric.Counter
 	LeaseExpirationCount      *metric.Gauge
 	LeaseEpochCount           *metric.Gauge
+	// LeaseLostCount counts the leases held by this store which were
+	// superseded by a lease held by another store, as observed by the local
+	// replica when applying the new lease.
+	LeaseLostCount *metric.Counter
+	// LeaseLowWaterJump records, for each lease acquired by this store, the
+	// amount by which the timestamp cache for the range was raised to the new
+	// lease's start. LeaseLowWaterJumpLarge counts the acquisitions for which
+	// this exceeded largeLowWaterJumpThreshold.
+	LeaseLowWaterJump      *metric.Histogram
+	LeaseLowWaterJumpLarge *metric.Counter
+	// LeaseStartSkew records, for each lease changing hands applied by this
+	// store, how far the lease's start is from the local physical clock. Large
+	// values hint at clock skew between nodes or at replication lag.
+	LeaseStartSkew *metric.Histogram
+	// LeaseApplyLatency records, for each lease request proposed by this
+	// store, the time between the proposal and the application of the lease.
+	LeaseApplyLatency *metric.Histogram
 
 	// Storage metrics.
 	LiveBytes          *metric.Gauge
@@ -1082,24 +1189,32 @@ type StoreMetrics struct {
 	// accordingly.
 
 	// Range event metrics.
-	RangeSplits                  *metric.Counter
-	RangeMerges                  *metric.Counter
-	RangeAdds                    *metric.Counter
-	RangeRemoves                 *metric.Counter
-	RangeSnapshotsGenerated      *metric.Counter
-	RangeSnapshotsNormalApplied  *metric.Counter
-	RangeSnapshotsLearnerApplied *metric.Counter
-	RangeRaftLeaderTransfers     *metric.Counter
+	RangeSplits                     *metric.Counter
+	RangeMerges                     *metric.Counter
+	RangeAdds                       *metric.Counter
+	RangeRemoves                    *metric.Counter
+	RangeSnapshotsGenerated         *metric.Counter
+	RangeSnapshotsNormalApplied     *metric.Counter
+	RangeSnapshotsLearnerApplied    *metric.Counter
+	RangeRaftLeaderTransfers        *metric.Counter
+	RangeRaftLeaderTransfersSkipped *metric.Counter
 
 	// Raft processing metrics.
 	RaftTicks                 *metric.Counter
 	RaftWorkingDurationNanos  *metric.Counter
 	RaftTickingDurationNanos  *metric.Counter
 	RaftCommandsApplied       *metric.Counter
+	RaftCommandsWriteBytes    *metric.Counter
 	RaftLogCommitLatency      *metric.Histogram
 	RaftCommandCommitLatency  *metric.Histogram
 	RaftHandleReadyLatency    *metric.Histogram
 	RaftApplyCommittedLatency *metric.Histogram
+	// RaftApplySideEffectsProposerLatency and
+	// RaftApplySideEffectsFollowerLatency record the time spent handling the
+	// side effects of each applied command, split by whether the command was
+	// proposed locally. Only the proposer handles the LocalResult.
+	RaftApplySideEffectsProposerLatency *metric.Histogram
+	RaftApplySideEffectsFollowerLatency *metric.Histogram
 
 	// Raft message metrics.
 	//
@@ -1136,6 +1251,12 @@ type StoreMetrics struct {
 	ConsistencyQueueFailures                  *metric.Counter
 	ConsistencyQueuePending                   *metric.Gauge
 	ConsistencyQueueProcessingNanos           *metric.Counter
+	ConsistencyQueueOldestChecksumNanos       *metric.Gauge
+	ConsistencyQueueChecksumsOverGCInterval   *metric.Counter
+	StatsQueueSuccesses                       *metric.Counter
+	StatsQueueFailures                        *metric.Counter
+	StatsQueuePending                         *metric.Gauge
+	StatsQueueProcessingNanos                 *metric.Counter
 	ReplicaGCQueueSuccesses                   *metric.Counter
 	ReplicaGCQueueFailures                    *metric.Counter
 	ReplicaGCQueuePending                     *metric.Gauge
@@ -1224,6 +1345,11 @@ func newStoreMetrics(histogramWindow time.Duration) *StoreMetrics {
 		LeaseTransferErrorCount:   metric.NewCounter(metaLeaseTransferErrorCount),
 		LeaseExpirationCount:      metric.NewGauge(metaLeaseExpirationCount),
 		LeaseEpochCount:           metric.NewGauge(metaLeaseEpochCount),
+		LeaseLostCount:            metric.NewCounter(metaLeaseLostCount),
+		LeaseLowWaterJump:         metric.NewLatency(metaLeaseLowWaterJump, histogramWindow),
+		LeaseLowWaterJumpLarge:    metric.NewCounter(metaLeaseLowWaterJumpLarge),
+		LeaseStartSkew:            metric.NewLatency(metaLeaseStartSkew, histogramWindow),
+		LeaseApplyLatency:         metric.NewLatency(metaLeaseApplyLatency, histogramWindow),
 
 		// Storage metrics.
 		LiveBytes:       metric.NewGauge(metaLiveBytes),
@@ -1277,25 +1403,30 @@ func newStoreMetrics(histogramWindow time.Duration) *StoreMetrics {
 		RdbPendingCompaction:        metric.NewGauge(metaRdbPendingCompaction),
 
 		// Range event metrics.
-		RangeSplits:                  metric.NewCounter(metaRangeSplits),
-		RangeMerges:                  metric.NewCounter(metaRangeMerges),
-		RangeAdds:                    metric.NewCounter(metaRangeAdds),
-		RangeRemoves:                 metric.NewCounter(metaRangeRemoves),
-		RangeSnapshotsGenerated:      metric.NewCounter(metaRangeSnapshotsGenerated),
-		RangeSnapshotsNormalApplied:  metric.NewCounter(metaRangeSnapshotsNormalApplied),
-		RangeSnapshotsLearnerApplied: metric.NewCounter(metaRangeSnapshotsLearnerApplied),
-		RangeRaftLeaderTransfers:     metric.NewCounter(metaRangeRaftLeaderTransfers),
+		RangeSplits:                     metric.NewCounter(metaRangeSplits),
+		RangeMerges:                     metric.NewCounter(metaRangeMerges),
+		RangeAdds:                       metric.NewCounter(metaRangeAdds),
+		RangeRemoves:                    metric.NewCounter(metaRangeRemoves),
+		RangeSnapshotsGenerated:         metric.NewCounter(metaRangeSnapshotsGenerated),
+		RangeSnapshotsNormalApplied:     metric.NewCounter(metaRangeSnapshotsNormalApplied),
+		RangeSnapshotsLearnerApplied:    metric.NewCounter(metaRangeSnapshotsLearnerApplied),
+		RangeRaftLeaderTransfers:        metric.NewCounter(metaRangeRaftLeaderTransfers),
+		RangeRaftLeaderTransfersSkipped: metric.NewCounter(metaRangeRaftLeaderTransfersSkipped),
 
 		// Raft processing metrics.
 		RaftTicks:                 metric.NewCounter(metaRaftTicks),
 		RaftWorkingDurationNanos:  metric.NewCounter(metaRaftWorkingDurationNanos),
 		RaftTickingDurationNanos:  metric.NewCounter(metaRaftTickingDurationNanos),
 		RaftCommandsApplied:       metric.NewCounter(metaRaftCommandsApplied),
+		RaftCommandsWriteBytes:    metric.NewCounter(metaRaftCommandsWriteBytes),
 		RaftLogCommitLatency:      metric.NewLatency(metaRaftLogCommitLatency, histogramWindow),
 		RaftCommandCommitLatency:  metric.NewLatency(metaRaftCommandCommitLatency, histogramWindow),
 		RaftHandleReadyLatency:    metric.NewLatency(metaRaftHandleReadyLatency, histogramWindow),
 		RaftApplyCommittedLatency: metric.NewLatency(metaRaftApplyCommittedLatency, histogramWindow),
 
+		RaftApplySideEffectsProposerLatency: metric.NewLatency(metaRaftApplySideEffectsProposerLatency, histogramWindow),
+		RaftApplySideEffectsFollowerLatency: metric.NewLatency(metaRaftApplySideEffectsFollowerLatency, histogramWindow),
+
 		// Raft message metrics.
 		RaftRcvdMessages: [...]*metric.Counter{
 			raftpb.MsgProp:           metric.NewCounter(metaRaftRcvdProp),
@@ -1345,6 +1476,12 @@ func newStoreMetrics(histogramWindow time.Duration) *StoreMetrics {
 		ConsistencyQueueFailures:                  metric.NewCounter(metaConsistencyQueueFailures),
 		ConsistencyQueuePending:                   metric.NewGauge(metaConsistencyQueuePending),
 		ConsistencyQueueProcessingNanos:           metric.NewCounter(metaConsistencyQueueProcessingNanos),
+		ConsistencyQueueOldestChecksumNanos:       metric.NewGauge(metaConsistencyQueueOldestChecksumNanos),
+		ConsistencyQueueChecksumsOverGCInterval:   metric.NewCounter(metaConsistencyQueueChecksumsOverGCInterval),
+		StatsQueueSuccesses:                       metric.NewCounter(metaStatsQueueSuccesses),
+		StatsQueueFailures:                        metric.NewCounter(metaStatsQueueFailures),
+		StatsQueuePending:                         metric.NewGauge(metaStatsQueuePending),
+		StatsQueueProcessingNanos:                 metric.NewCounter(metaStatsQueueProcessingNanos),
 		ReplicaGCQueueSuccesses:                   metric.NewCounter(metaReplicaGCQueueSuccesses),
 		ReplicaGCQueueFailures:                    metric.NewCounter(metaReplicaGCQueueFailures),
 		ReplicaGCQueuePending:                     metric.NewGauge(metaReplicaGCQueuePending),
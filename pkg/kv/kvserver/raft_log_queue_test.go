@@ -22,12 +22,14 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/base"
 	"github.com/cockroachdb/cockroach/pkg/keys"
 	"github.com/cockroachdb/cockroach/pkg/kv"
+	"github.com/cockroachdb/cockroach/pkg/kv/kvserver/stateloader"
 	"github.com/cockroachdb/cockroach/pkg/roachpb"
 	"github.com/cockroachdb/cockroach/pkg/storage"
 	"github.com/cockroachdb/cockroach/pkg/testutils"
 	"github.com/cockroachdb/cockroach/pkg/util/hlc"
 	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
 	"github.com/cockroachdb/cockroach/pkg/util/stop"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
 	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
 	"github.com/cockroachdb/cockroach/pkg/util/uuid"
 	"github.com/cockroachdb/errors"
@@ -793,6 +795,64 @@ func TestTruncateLog(t *testing.T) {
 	}
 }
 
+// TestTruncateLogEntryCacheObserver verifies that the raft entry cache's
+// truncation observer is notified of the entries evicted by a truncation.
+func TestTruncateLogEntryCacheObserver(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	tc := testContext{}
+	cfg := TestStoreConfig(nil)
+	cfg.TestingKnobs.DisableRaftLogQueue = true
+	stopper := stop.NewStopper()
+	defer stopper.Stop(context.Background())
+	tc.StartWithStoreConfig(t, stopper, cfg)
+
+	var indexes []uint64
+	for i := 0; i < 10; i++ {
+		args := incrementArgs([]byte("a"), int64(i))
+		if _, pErr := tc.SendWrapped(args); pErr != nil {
+			t.Fatal(pErr)
+		}
+		idx, err := tc.repl.GetLastIndex()
+		if err != nil {
+			t.Fatal(err)
+		}
+		indexes = append(indexes, idx)
+	}
+
+	rangeID := tc.repl.RangeID
+	var expRemoved int
+	for idx := uint64(1); idx < indexes[5]; idx++ {
+		if _, ok := tc.store.raftEntryCache.Get(rangeID, idx); ok {
+			expRemoved++
+		}
+	}
+	assert.NotZero(t, expRemoved)
+
+	type observation struct {
+		rangeID roachpb.RangeID
+		index   uint64
+		removed int
+	}
+	var mu syncutil.Mutex
+	var observed []observation
+	tc.store.raftEntryCache.SetTruncationObserver(
+		func(id roachpb.RangeID, index uint64, entriesRemoved int) {
+			mu.Lock()
+			defer mu.Unlock()
+			observed = append(observed, observation{id, index, entriesRemoved})
+		})
+	defer tc.store.raftEntryCache.SetTruncationObserver(nil)
+
+	truncateArgs := truncateLogArgs(indexes[5], rangeID)
+	if _, pErr := tc.SendWrappedWith(roachpb.Header{RangeID: 1}, &truncateArgs); pErr != nil {
+		t.Fatal(pErr)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []observation{{rangeID, indexes[5] - 1, expRemoved}}, observed)
+}
+
 func TestRaftLogQueueShouldQueueRecompute(t *testing.T) {
 	defer leaktest.AfterTest(t)()
 
@@ -911,3 +971,82 @@ func TestTruncateLogRecompute(t *testing.T) {
 		put() // make sure we remain trusted and in sync
 	}
 }
+
+// TestRaftLogQueueDisabledOnApply verifies that a range on which a
+// SetRaftLogQueueDisabledRequest set the flag isn't offered to the raft log
+// queue when commands are applied, and that clearing the flag restores this.
+func TestRaftLogQueueDisabledOnApply(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+	store, _ := createTestStore(t,
+		testStoreOpts{
+			// This test was written before test stores could start with more than one
+			// range and was not adapted.
+			createSystemRanges: false,
+		},
+		stopper)
+	// Only the application of commands offers the replica to the queue.
+	store.SetReplicaScannerActive(false)
+
+	r, err := store.GetReplica(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setDisabled := func(disabled bool) {
+		args := &roachpb.SetRaftLogQueueDisabledRequest{
+			RequestHeader: roachpb.RequestHeader{Key: roachpb.Key("a")},
+			Disabled:      disabled,
+		}
+		if _, pErr := kv.SendWrapped(ctx, store.TestSender(), args); pErr != nil {
+			t.Fatal(pErr)
+		}
+		persisted, err := stateloader.Make(r.RangeID).LoadRaftLogQueueDisabled(ctx, store.Engine())
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, disabled, persisted)
+		assert.Equal(t, disabled, r.StateSnapshot().RaftLogQueueDisabled)
+	}
+	putBig := func(key string) {
+		args := putArgs(roachpb.Key(key), bytes.Repeat([]byte("v"), RaftLogQueueStaleSize))
+		if _, pErr := kv.SendWrapped(ctx, store.TestSender(), &args); pErr != nil {
+			t.Fatal(pErr)
+		}
+	}
+
+	oldFirstIndex, err := r.GetFirstIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// With the flag set, growing the log past RaftLogQueueStaleSize doesn't
+	// offer the replica to the queue, so the log is not truncated. This may
+	// give a false negative if the truncation loses the race against
+	// GetFirstIndex, but never a false positive.
+	setDisabled(true)
+	putBig("a")
+	if newFirstIndex, err := r.GetFirstIndex(); err != nil {
+		t.Fatal(err)
+	} else if newFirstIndex != oldFirstIndex {
+		t.Fatalf("log was truncated while disabled, old first index:%d, current first index:%d",
+			oldFirstIndex, newFirstIndex)
+	}
+
+	// Once the flag is cleared, the next check offers the replica again.
+	setDisabled(false)
+	putBig("b")
+	testutils.SucceedsSoon(t, func() error {
+		newFirstIndex, err := r.GetFirstIndex()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if newFirstIndex <= oldFirstIndex {
+			return errors.Errorf("log was not truncated, old first index:%d, current first index:%d",
+				oldFirstIndex, newFirstIndex)
+		}
+		return nil
+	})
+}
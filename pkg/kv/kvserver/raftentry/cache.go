@@ -37,8 +37,17 @@ type Cache struct {
 	mu    syncutil.Mutex
 	lru   partitionList
 	parts map[roachpb.RangeID]*partition
+
+	// truncationObserver, if set, is notified of the entries cleared from the
+	// cache because the raft log of a range was truncated. Accessed under mu.
+	truncationObserver TruncationObserver
 }
 
+// TruncationObserver is notified of the entries cleared from the Cache
+// because the raft log of a range was truncated up to (and including) index.
+// entriesRemoved is the number of entries that were evicted as a result.
+type TruncationObserver func(id roachpb.RangeID, index uint64, entriesRemoved int)
+
 // Design
 //
 // Cache is designed to be a shared store-wide object which incurs low
@@ -194,19 +203,38 @@ func (c *Cache) Add(id roachpb.RangeID, ents []raftpb.Entry, truncate bool) {
 	c.recordUpdate(p, bytesAdded-bytesRemoved, bytesGuessed, entriesAdded-entriesRemoved)
 }
 
-// Clear removes all entries on the given range with index less than hi.
-func (c *Cache) Clear(id roachpb.RangeID, hi uint64) {
+// Clear removes all entries on the given range with index less than hi and
+// returns the number of entries removed.
+func (c *Cache) Clear(id roachpb.RangeID, hi uint64) int {
 	c.mu.Lock()
 	p := c.getPartLocked(id, false /* create */, false /* recordUse */)
 	if p == nil {
 		c.mu.Unlock()
-		return
+		return 0
 	}
 	c.mu.Unlock()
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	bytesRemoved, entriesRemoved := p.clearTo(hi)
 	c.recordUpdate(p, -1*bytesRemoved, 0, -1*entriesRemoved)
+	return int(entriesRemoved)
+}
+
+// SetTruncationObserver sets the observer returned by TruncationObserver. A
+// nil observer, the default, disables the notifications.
+func (c *Cache) SetTruncationObserver(o TruncationObserver) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.truncationObserver = o
+}
+
+// TruncationObserver returns the observer which should be notified after
+// entries were cleared from the cache because of a raft log truncation, or
+// nil if there is none.
+func (c *Cache) TruncationObserver() TruncationObserver {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.truncationObserver
 }
 
 // Get returns the entry for the specified index and true for the second return
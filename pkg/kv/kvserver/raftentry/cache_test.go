@@ -250,8 +250,12 @@ func TestEntryCacheClearTo(t *testing.T) {
 	rangeID := roachpb.RangeID(1)
 	c := NewCache(100)
 	c.Add(rangeID, []raftpb.Entry{newEntry(20, 1), newEntry(21, 1)}, true)
-	c.Clear(rangeID, 21)
-	c.Clear(rangeID, 18)
+	if n := c.Clear(rangeID, 21); n != 1 {
+		t.Errorf("expected 1 entry to be cleared, got %d", n)
+	}
+	if n := c.Clear(rangeID, 18); n != 0 {
+		t.Errorf("expected no entries to be cleared, got %d", n)
+	}
 	if ents, _, _, _ := c.Scan(nil, rangeID, 2, 21, noLimit); len(ents) != 0 {
 		t.Errorf("expected no entries after clearTo")
 	}
@@ -13,6 +13,7 @@ package kvserver
 import (
 	"context"
 	"fmt"
+	"sync"
 	"sync/atomic"
 	"time"
 	"unsafe"
@@ -109,6 +110,19 @@ var MaxCommandSize = settings.RegisterValidatedByteSizeSetting(
 	},
 )
 
+// tolerateUnhandledEvalResultFields controls whether a replica which finds
+// fields it doesn't know how to handle in an evaluation result logs an error
+// and drops them (true) or crashes (false). It is meant as an escape hatch for
+// mixed-version clusters in which commands proposed by nodes running a newer
+// binary carry side effects that this node doesn't know about; enabling it
+// means that those side effects are skipped.
+var tolerateUnhandledEvalResultFields = settings.RegisterBoolSetting(
+	"kv.raft.tolerate_unhandled_eval_result_fields.enabled",
+	"if true, unhandled fields in evaluation results are logged and dropped "+
+		"instead of crashing the node",
+	false,
+)
+
 // StrictGCEnforcement controls whether requests are rejected based on the GC
 // threshold and the current GC TTL (true) or just based on the GC threshold
 // (false).
@@ -241,6 +255,28 @@ type Replica struct {
 
 	// Contains the lease history when enabled.
 	leaseHistory *leaseHistory
+	// Contains the most recent lease transitions, see RecentLeaseHistory.
+	leaseTransitions leaseTransitionHistory
+	// Contains the most recent anomalies tolerated by the replica, see
+	// RecentAnomalies.
+	anomalies anomalyHistory
+
+	// nodeLivenessGossipSeqs maps each span of node liveness records that an
+	// applied command asked to be gossiped (as a nodeLivenessGossipKey) to an
+	// *int64 which is incremented atomically on every such request. An
+	// asynchronous retry of a failed gossip gives up once it observes that a
+	// newer request for the same span has superseded it. Requests for other
+	// spans don't, since MaybeGossipNodeLiveness only gossips the records in
+	// the span it is given.
+	nodeLivenessGossipSeqs sync.Map
+
+	// firstRangeGossipInFlight is set while the task gossiping the first range,
+	// which may block on acquiring the lease, is running, and
+	// firstRangeGossipPending while a gossip of the first range has been
+	// requested but not started yet. See maybeGossipFirstRangeAsync. Accessed
+	// atomically.
+	firstRangeGossipInFlight int32
+	firstRangeGossipPending  int32
 
 	// concMgr sequences incoming requests and provides isolation between
 	// requests that intend to perform conflicting operations. It is the
@@ -319,6 +355,17 @@ type Replica struct {
 		// lease extension that were in flight at the time of the transfer cannot be
 		// used, if they eventually apply.
 		minLeaseProposedTS hlc.Timestamp
+		// tsCacheLowWater is the low water mark which this replica last set in
+		// the timestamp cache for its keys upon acquiring a lease; the RHS of a
+		// split inherits it from the LHS. Forwarded by the low water mark of the
+		// timestamp cache as a whole, it is the low water mark which the next
+		// lease acquisition raises (see recordLowWaterJump).
+		tsCacheLowWater hlc.Timestamp
+		// leaseTransferCooldownUntil is the time until which the lease
+		// rebalancing logic won't transfer the lease away from this replica. It
+		// is set whenever the lease moves to another store (see
+		// kv.allocator.lease_transfer_cooldown).
+		leaseTransferCooldownUntil time.Time
 		// A pointer to the zone config for this replica.
 		zone *zonepb.ZoneConfig
 		// proposalBuf buffers Raft commands as they are passed to the Raft
@@ -437,6 +484,11 @@ type Replica struct {
 		// Computed checksum at a snapshot UUID.
 		checksums map[uuid.UUID]ReplicaChecksum
 
+		// recentReplicaChanges holds the last maxRecentReplicaChanges membership
+		// changes applied by this replica, oldest first. See
+		// RecentReplicaChanges.
+		recentReplicaChanges []ReplicaChangeRecord
+
 		// proposalQuota is the quota pool maintained by the lease holder where
 		// incoming writes acquire quota from a fixed quota pool before going
 		// through. If there is no quota available, the write is throttled
@@ -474,6 +526,12 @@ type Replica struct {
 		// not have all the log entries.
 		draining bool
 
+		// leadershipTransferSkipped is set while raft leadership transfers to
+		// the leaseholder are being skipped because its log is too far behind.
+		// It lets RangeRaftLeaderTransfersSkipped count the transitions into
+		// that state rather than every check.
+		leadershipTransferSkipped bool
+
 		// cachedProtectedTS provides the state of the protected timestamp
 		// subsystem as used on the request serving path to determine the effective
 		// gc threshold given the current TTL when using strict GC enforcement.
@@ -493,6 +551,27 @@ type Replica struct {
 		// zone.MaxRangeBytes increases to surpass the current value.
 		largestPreviousMaxRangeSizeBytes int64
 
+		// splitQueueBackpressure is set on the apply path when the range has
+		// outgrown its split size while the store's split queue is backlogged.
+		// Foreground writes consult it to apply backpressure before the range
+		// reaches backpressureRangeSizeMultiplier times its split size, since
+		// the queue is unlikely to get to the range before it grows further.
+		splitQueueBackpressure bool
+
+		// appliedBytesSinceStatsReconciliation accumulates the size of the write
+		// batches applied since the replica was last flagged for stats
+		// reconciliation. Once it exceeds statsReconciliationAppliedBytes,
+		// statsReconciliationPending is set and the replica is offered to the
+		// stats queue, which clears the flag once it has reconciled the stats.
+		// Both are only maintained on the leaseholder.
+		appliedBytesSinceStatsReconciliation int64
+		statsReconciliationPending           bool
+
+		// statsDeltas accumulates the MVCCStats deltas applied to the replica,
+		// see RecentStatsDeltas and EstimatedTimeToSplit. It is allocated when the first delta is
+		// applied, so that idle replicas don't pay for it.
+		statsDeltas *statsDeltaHistory
+
 		// failureToGossipSystemConfig is set to true when the leaseholder of the
 		// range containing the system config span fails to gossip due to an
 		// outstanding intent (see MaybeGossipSystemConfig). It is reset when the
@@ -907,6 +986,20 @@ func (r *Replica) GetMVCCStats() enginepb.MVCCStats {
 	return *r.mu.state.Stats
 }
 
+// RecentStatsDeltas returns the sum of the MVCCStats deltas applied to the
+// Replica over the given window, which reflects the churn of the range rather
+// than its absolute size. The window is rounded up to a multiple of ten
+// seconds and capped at MaxStatsDeltaWindow.
+func (r *Replica) RecentStatsDeltas(window time.Duration) enginepb.MVCCStats {
+	now := r.store.Clock().PhysicalTime()
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.mu.statsDeltas == nil {
+		return enginepb.MVCCStats{}
+	}
+	return r.mu.statsDeltas.sum(now, window)
+}
+
 // GetSplitQPS returns the Replica's queries/s request rate.
 //
 // NOTE: This should only be used for load based splitting, only
@@ -997,6 +1090,40 @@ func (r *Replica) raftBasicStatusRLocked() raft.BasicStatus {
 	return raft.BasicStatus{}
 }
 
+// maxRecentReplicaChanges is the number of applied membership changes retained
+// by each replica for debugging purposes.
+const maxRecentReplicaChanges = 32
+
+// ReplicaChangeRecord describes a ChangeReplicas trigger applied by a replica.
+type ReplicaChangeRecord struct {
+	// Added and Removed are the replicas added and removed by the change. Both
+	// are empty for the change leaving a joint configuration.
+	Added, Removed []roachpb.ReplicaDescriptor
+	// Generation is the generation of the range descriptor resulting from the
+	// change.
+	Generation int64
+	// Timestamp is the time at which the change was applied by the replica.
+	Timestamp time.Time
+}
+
+// RecentReplicaChanges returns the most recent membership changes applied by
+// the replica, oldest first. Only the last maxRecentReplicaChanges changes are
+// retained, and none survive a restart of the node.
+func (r *Replica) RecentReplicaChanges() []ReplicaChangeRecord {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]ReplicaChangeRecord(nil), r.mu.recentReplicaChanges...)
+}
+
+// StateSnapshot returns a copy of the Replica's in-memory ReplicaState, taken
+// under a single acquisition of Replica.mu so that all of its fields reflect
+// the same point in the application of commands.
+func (r *Replica) StateSnapshot() kvserverpb.ReplicaState {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return *(protoutil.Clone(&r.mu.state)).(*kvserverpb.ReplicaState)
+}
+
 // State returns a copy of the internal state of the Replica, along with some
 // auxiliary information.
 func (r *Replica) State() kvserverpb.RangeInfo {
@@ -1077,6 +1204,50 @@ func (r *Replica) assertStateLocked(ctx context.Context, reader storage.Reader)
 	}
 }
 
+// QuickStateCheck compares the applied indices and the descriptor generation
+// in the Replica's in-memory state against their on-disk counterparts. It is a
+// much cheaper (and weaker) check than assertStateLocked, meant for periodic
+// health sweeps which escalate to the full assertion only on suspicion. If a
+// divergence is found, the name of the first divergent field is returned.
+func (r *Replica) QuickStateCheck() (ok bool, divergentField string) {
+	ctx := r.AnnotateCtx(context.TODO())
+	// Hold raftMu so that no command is applied in between reading the on-disk
+	// and the in-memory state.
+	r.raftMu.Lock()
+	defer r.raftMu.Unlock()
+	r.mu.RLock()
+	state, initialized := r.mu.state, r.isInitializedRLocked()
+	r.mu.RUnlock()
+	if !initialized {
+		return true, ""
+	}
+
+	reader := r.store.Engine()
+	raftAppliedIndex, leaseAppliedIndex, err := r.raftMu.stateLoader.LoadAppliedIndex(ctx, reader)
+	if err != nil {
+		log.Warningf(ctx, "unable to load applied index: %+v", err)
+		return false, "RaftAppliedIndex"
+	}
+	if raftAppliedIndex != state.RaftAppliedIndex {
+		return false, "RaftAppliedIndex"
+	}
+	if leaseAppliedIndex != state.LeaseAppliedIndex {
+		return false, "LeaseAppliedIndex"
+	}
+
+	var diskDesc roachpb.RangeDescriptor
+	if found, err := storage.MVCCGetProto(
+		ctx, reader, keys.RangeDescriptorKey(state.Desc.StartKey), hlc.MaxTimestamp, &diskDesc,
+		storage.MVCCGetOptions{Inconsistent: true},
+	); err != nil {
+		log.Warningf(ctx, "unable to load range descriptor: %+v", err)
+		return false, "Desc.Generation"
+	} else if !found || diskDesc.Generation != state.Desc.Generation {
+		return false, "Desc.Generation"
+	}
+	return true, ""
+}
+
 // checkExecutionCanProceed returns an error if a batch request cannot be
 // executed by the Replica. An error indicates that the Replica is not live and
 // able to serve traffic or that the request is not compatible with the state of
@@ -1491,40 +1662,112 @@ func (r *Replica) maybeWatchForMerge(ctx context.Context) error {
 	return err
 }
 
-func (r *Replica) maybeTransferRaftLeadership(ctx context.Context) {
+func (r *Replica) maybeTransferRaftLeadership(
+	ctx context.Context,
+) raftLeadershipTransferOutcome {
 	r.mu.Lock()
-	r.maybeTransferRaftLeadershipLocked(ctx)
-	r.mu.Unlock()
+	defer r.mu.Unlock()
+	return r.maybeTransferRaftLeadershipLocked(ctx)
+}
+
+// raftLeadershipTransferOutcome is the outcome of
+// maybeTransferRaftLeadershipLocked.
+type raftLeadershipTransferOutcome int
+
+const (
+	// raftLeadershipTransferNotNeeded indicates that no transfer was
+	// considered, for example because this replica isn't the raft leader or
+	// holds the lease.
+	raftLeadershipTransferNotNeeded raftLeadershipTransferOutcome = iota
+	// raftLeadershipTransferInitiated indicates that a transfer to the
+	// leaseholder was initiated.
+	raftLeadershipTransferInitiated
+	// raftLeadershipTransferSkippedBehind indicates that no transfer was
+	// initiated because the leaseholder's log is too far behind the leader's.
+	raftLeadershipTransferSkippedBehind
+)
+
+// maxLeadershipTransfereeLogGap is the number of entries by which the
+// leaseholder's log may lag behind the leader's for the leader to transfer
+// raft leadership to it. Raft only completes a transfer once the transferee
+// has caught up, so transfers to replicas that are far behind are likely to
+// time out, leaving leadership and the lease misaligned for longer.
+var maxLeadershipTransfereeLogGap = settings.RegisterNonNegativeIntSetting(
+	"kv.raft.leadership_transfer.max_log_gap",
+	"maximum number of entries by which the leaseholder's raft log may lag "+
+		"behind the leader's for raft leadership to be transferred to it",
+	10,
+)
+
+// leadershipTransferCheckInterval is the interval at which each store checks
+// whether its replicas hold raft leadership without holding the lease, and if
+// so, transfers leadership to the leaseholder. Zero disables the check.
+var leadershipTransferCheckInterval = settings.RegisterNonNegativeDurationSetting(
+	"kv.raft.leadership_transfer.check_interval",
+	"interval at which raft leaders check whether to transfer leadership to "+
+		"the range's leaseholder (0 to disable)",
+	time.Second,
+)
+
+// leadershipTransfereeCaughtUp returns whether the raft log of the given
+// replica has caught up to the leader's commit index and is within maxGap
+// entries of the leader's last index. The latter guards against transfers
+// while the leader has a long tail of uncommitted entries that the transferee
+// would first have to catch up on.
+func leadershipTransfereeCaughtUp(
+	raftStatus *raft.Status, replicaID, lastIndex, maxGap uint64,
+) bool {
+	pr, ok := raftStatus.Progress[replicaID]
+	return ok && pr.Match >= raftStatus.Commit && pr.Match+maxGap >= lastIndex
 }
 
 // maybeTransferRaftLeadershipLocked attempts to transfer the leadership away
 // from this node to the leaseholder, if this node is the current raft leader
 // but not the leaseholder. We don't attempt to transfer leadership if the
-// leaseholder is behind on applying the log.
+// leaseholder's log is too far behind the leader's (unless draining).
 //
 // We like it when leases and raft leadership are collocated because that
 // facilitates quick command application (requests generally need to make it to
 // both the lease holder and the raft leader before being applied by other
 // replicas).
-func (r *Replica) maybeTransferRaftLeadershipLocked(ctx context.Context) {
+func (r *Replica) maybeTransferRaftLeadershipLocked(
+	ctx context.Context,
+) raftLeadershipTransferOutcome {
+	outcome := r.transferRaftLeadershipLocked(ctx)
+	// The check runs periodically (see leadershipTransferCheckInterval), so
+	// only the transitions into the skipped state are counted.
+	skipped := outcome == raftLeadershipTransferSkippedBehind
+	if skipped && !r.mu.leadershipTransferSkipped {
+		r.store.metrics.RangeRaftLeaderTransfersSkipped.Inc(1)
+	}
+	r.mu.leadershipTransferSkipped = skipped
+	return outcome
+}
+
+func (r *Replica) transferRaftLeadershipLocked(ctx context.Context) raftLeadershipTransferOutcome {
 	if r.store.TestingKnobs().DisableLeaderFollowsLeaseholder {
-		return
+		return raftLeadershipTransferNotNeeded
 	}
 	lease := *r.mu.state.Lease
 	if lease.OwnedBy(r.StoreID()) || !r.isLeaseValidRLocked(lease, r.Clock().Now()) {
-		return
+		return raftLeadershipTransferNotNeeded
 	}
 	raftStatus := r.raftStatusRLocked()
 	if raftStatus == nil || raftStatus.RaftState != raft.StateLeader {
-		return
+		return raftLeadershipTransferNotNeeded
 	}
 	lhReplicaID := uint64(lease.Replica.ReplicaID)
-	lhProgress, ok := raftStatus.Progress[lhReplicaID]
-	if (ok && lhProgress.Match >= raftStatus.Commit) || r.mu.draining {
-		log.VEventf(ctx, 1, "transferring raft leadership to replica ID %v", lhReplicaID)
-		r.store.metrics.RangeRaftLeaderTransfers.Inc(1)
-		r.mu.internalRaftGroup.TransferLeader(lhReplicaID)
+	maxGap := uint64(maxLeadershipTransfereeLogGap.Get(&r.store.cfg.Settings.SV))
+	if !r.mu.draining &&
+		!leadershipTransfereeCaughtUp(raftStatus, lhReplicaID, r.mu.lastIndex, maxGap) {
+		log.VEventf(ctx, 1, "not transferring raft leadership to replica ID %v: too far behind",
+			lhReplicaID)
+		return raftLeadershipTransferSkippedBehind
 	}
+	log.VEventf(ctx, 1, "transferring raft leadership to replica ID %v", lhReplicaID)
+	r.store.metrics.RangeRaftLeaderTransfers.Inc(1)
+	r.mu.internalRaftGroup.TransferLeader(lhReplicaID)
+	return raftLeadershipTransferInitiated
 }
 
 func (r *Replica) mergeInProgressRLocked() bool {
@@ -1590,6 +1833,12 @@ func (r *Replica) GetLeaseHistory() []roachpb.Lease {
 	return r.leaseHistory.get()
 }
 
+// RecentLeaseHistory returns the most recent lease transitions applied by
+// this replica, oldest first.
+func (r *Replica) RecentLeaseHistory() []LeaseTransition {
+	return r.leaseTransitions.get()
+}
+
 // EnableLeaseHistory turns on the lease history for testing purposes. Returns
 // a function to return it to its original state that can be deferred.
 func EnableLeaseHistory(maxEntries int) func() {
@@ -1630,3 +1879,17 @@ func (r *Replica) markSystemConfigGossipFailed() {
 func init() {
 	tracing.RegisterTagRemapping("r", "range")
 }
+
+// cleanupEncounteredIntentsAsync hands the intents encountered by a request to
+// the intent resolver for asynchronous cleanup, after passing them through the
+// EncounteredIntentsInterceptor testing knob if one is installed.
+func (r *Replica) cleanupEncounteredIntentsAsync(
+	ctx context.Context, intents []roachpb.Intent, allowSync bool,
+) error {
+	if fn := r.store.TestingKnobs().EncounteredIntentsInterceptor; fn != nil {
+		if intents = fn(r.RangeID, intents); len(intents) == 0 {
+			return nil
+		}
+	}
+	return r.store.intentResolver.CleanupIntentsAsync(ctx, intents, allowSync)
+}
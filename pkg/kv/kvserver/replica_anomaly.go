@@ -0,0 +1,86 @@
+// Copyright 2020 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package kvserver
+
+import (
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+)
+
+// anomalyHistoryMaxEntries bounds the number of anomalies remembered by each
+// replica.
+const anomalyHistoryMaxEntries = 16
+
+// Anomaly describes a condition detected by a replica that indicates a bug or
+// corruption but that, instead of crashing the node, was tolerated.
+type Anomaly struct {
+	// Condition is a short description of what went wrong.
+	Condition string
+	// Details carries the offending values.
+	Details string
+	// Timestamp is the time at which the anomaly was detected.
+	Timestamp hlc.Timestamp
+}
+
+// anomalyHistory is a fixed-size log of the most recent anomalies detected by
+// a replica.
+type anomalyHistory struct {
+	syncutil.Mutex
+	index   int
+	history []Anomaly // A circular buffer with index.
+}
+
+func (ah *anomalyHistory) add(a Anomaly) {
+	ah.Lock()
+	defer ah.Unlock()
+
+	// Not through the first pass through the buffer.
+	if ah.index == len(ah.history) {
+		ah.history = append(ah.history, a)
+	} else {
+		ah.history[ah.index] = a
+	}
+	ah.index++
+	if ah.index >= anomalyHistoryMaxEntries {
+		ah.index = 0
+	}
+}
+
+// get returns a copy of the recorded anomalies, oldest first.
+func (ah *anomalyHistory) get() []Anomaly {
+	ah.Lock()
+	defer ah.Unlock()
+	if len(ah.history) == 0 {
+		return nil
+	}
+	result := make([]Anomaly, 0, len(ah.history))
+	if len(ah.history) < anomalyHistoryMaxEntries {
+		return append(result, ah.history...)
+	}
+	result = append(result, ah.history[ah.index:]...)
+	return append(result, ah.history[:ah.index]...)
+}
+
+// recordAnomaly remembers that the replica tolerated the given condition,
+// described by err, instead of crashing.
+func (r *Replica) recordAnomaly(condition string, err error) {
+	r.anomalies.add(Anomaly{
+		Condition: condition,
+		Details:   err.Error(),
+		Timestamp: r.store.Clock().Now(),
+	})
+}
+
+// RecentAnomalies returns the most recent anomalies tolerated by this
+// replica, oldest first.
+func (r *Replica) RecentAnomalies() []Anomaly {
+	return r.anomalies.get()
+}
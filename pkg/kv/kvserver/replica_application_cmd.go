@@ -129,8 +129,10 @@ func (c *replicatedCmd) CanAckBeforeApplication() bool {
 	//
 	// We don't try to ack async consensus writes before application because we
 	// know that there isn't a client waiting for the result.
+	//
+	// Nor do we ack commands which require their application to be durable.
 	req := c.proposal.Request
-	return req.IsIntentWrite() && !req.AsyncConsensus
+	return req.IsIntentWrite() && !req.AsyncConsensus && !c.proposal.durableApply.required
 }
 
 // AckSuccess implements the apply.CheckedCommand interface.
@@ -12,6 +12,7 @@ package kvserver
 
 import (
 	"context"
+	"time"
 
 	"github.com/cockroachdb/cockroach/pkg/kv/kvserver/closedts/ctpb"
 	"github.com/cockroachdb/cockroach/pkg/kv/kvserver/kvserverbase"
@@ -20,6 +21,8 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/storage/enginepb"
 	"github.com/cockroachdb/cockroach/pkg/util/hlc"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/errors"
 )
 
 // replica_application_*.go files provide concrete implementations of
@@ -57,7 +60,7 @@ func isTrivial(r *kvserverpb.ReplicatedEvalResult) bool {
 		if stateAllowlist.Stats != nil && (*stateAllowlist.Stats == enginepb.MVCCStats{}) {
 			stateAllowlist.Stats = nil
 		}
-		if stateAllowlist != (kvserverpb.ReplicaState{}) {
+		if !stateAllowlist.IsZero() {
 			return false
 		}
 	}
@@ -70,7 +73,7 @@ func isTrivial(r *kvserverpb.ReplicatedEvalResult) bool {
 	allowlist.DeprecatedDelta = nil
 	allowlist.PrevLeaseProposal = nil
 	allowlist.State = nil
-	return allowlist.Equal(kvserverpb.ReplicatedEvalResult{})
+	return allowlist.IsZero()
 }
 
 // clearTrivialReplicatedEvalResultFields is used to zero out the fields of a
@@ -90,7 +93,7 @@ func clearTrivialReplicatedEvalResultFields(r *kvserverpb.ReplicatedEvalResult)
 	// replica state for this batch.
 	if haveState := r.State != nil; haveState {
 		r.State.Stats = nil
-		if *r.State == (kvserverpb.ReplicaState{}) {
+		if r.State.IsZero() {
 			r.State = nil
 		}
 	}
@@ -166,6 +169,12 @@ func (r *Replica) prepareLocalResult(ctx context.Context, cmd *replicatedCmd) {
 		cmd.localResult = cmd.proposal.Local
 	} else if cmd.localResult != nil {
 		log.Fatalf(ctx, "shouldn't have a local result if command processing failed. pErr: %s", pErr)
+	} else if m := cmd.proposal.Local.Metrics; m != nil {
+		// The local result is dropped, but the metrics evaluation recorded in
+		// it must not be lost: a lease request which evaluated successfully but
+		// failed here is reported as an error rather than not at all.
+		r.store.metrics.handleMetricsResult(ctx, m.Failed())
+		cmd.proposal.Local.Metrics = nil
 	}
 }
 
@@ -225,6 +234,7 @@ func (r *Replica) tryReproposeWithNewLeaseIndex(
 	if pErr != nil {
 		return pErr
 	}
+	p.reproposed = true
 	// NB: The caller already promises that the lease check succeeded, meaning
 	// the sequence numbers match, implying that the lease epoch hasn't changed
 	// from what it was under the proposal-time lease.
@@ -240,10 +250,15 @@ func (r *Replica) tryReproposeWithNewLeaseIndex(
 // inlined.
 
 func (r *Replica) handleSplitResult(ctx context.Context, split *kvserverpb.Split) {
+	r.invalidateInFlightChecksums(ctx)
 	splitPostApply(ctx, split.RHSDelta, &split.SplitTrigger, r)
 }
 
 func (r *Replica) handleMergeResult(ctx context.Context, merge *kvserverpb.Merge) {
+	if err := checkMergeTrigger(r.RangeID, &merge.MergeTrigger); err != nil {
+		log.Fatalf(ctx, "%v", err)
+	}
+	r.invalidateInFlightChecksums(ctx)
 	if err := r.store.MergeRange(
 		ctx, r, merge.LeftDesc, merge.RightDesc, merge.FreezeStart,
 	); err != nil {
@@ -252,24 +267,84 @@ func (r *Replica) handleMergeResult(ctx context.Context, merge *kvserverpb.Merge
 	}
 }
 
+// checkMergeTrigger verifies that a merge trigger applied by the range with the
+// given ID names that range as the left-hand side of the merge. Anything else
+// can only be the result of a bug in the construction of the trigger, and
+// applying it would corrupt the store.
+func checkMergeTrigger(rangeID roachpb.RangeID, merge *roachpb.MergeTrigger) error {
+	if merge.LeftDesc.RangeID != rangeID {
+		return errors.Errorf("r%d applying merge trigger with left-hand side r%d "+
+			"(right-hand side r%d): trigger is corrupt",
+			rangeID, merge.LeftDesc.RangeID, merge.RightDesc.RangeID)
+	}
+	if merge.RightDesc.RangeID == rangeID {
+		return errors.Errorf("r%d applying merge trigger that subsumes itself: trigger is corrupt",
+			rangeID)
+	}
+	return nil
+}
+
+// checkDescBounds verifies that a descriptor update which is not part of a
+// split or merge does not contract the key bounds of the range. Such an update
+// can only be the result of corruption.
+func checkDescBounds(prev, next *roachpb.RangeDescriptor, splitOrMerge bool) error {
+	if splitOrMerge || !prev.IsInitialized() {
+		return nil
+	}
+	if prev.StartKey.Less(next.StartKey) || next.EndKey.Less(prev.EndKey) {
+		return errors.Errorf("descriptor update contracts range bounds from %s to %s",
+			prev.RSpan(), next.RSpan())
+	}
+	return nil
+}
+
 func (r *Replica) handleDescResult(ctx context.Context, desc *roachpb.RangeDescriptor) {
 	r.setDescRaftMuLocked(ctx, desc)
 }
 
-func (r *Replica) handleLeaseResult(ctx context.Context, lease *roachpb.Lease) {
-	r.leasePostApply(ctx, *lease, false /* permitJump */)
+func (r *Replica) handleLeaseResult(
+	ctx context.Context, lease *roachpb.Lease, proposedAt time.Time,
+) {
+	// A lease identical to the one already installed (e.g. a re-proposed
+	// request for an epoch-based lease the replica already holds) changes
+	// nothing, so there's no need to repeat the post-apply work. Note that
+	// splitPostApply and snapshot application call leasePostApply with an
+	// unchanged lease for its side effects, so the check can't live there.
+	r.mu.RLock()
+	noop := r.mu.state.Lease.Equal(lease)
+	r.mu.RUnlock()
+	if noop {
+		return
+	}
+	r.leasePostApply(ctx, *lease, false /* permitJump */, proposedAt)
 }
 
 func (r *Replica) handleTruncatedStateResult(
 	ctx context.Context, t *roachpb.RaftTruncatedState,
 ) (raftLogDelta int64) {
 	r.mu.Lock()
-	r.mu.state.TruncatedState = t
-	r.mu.Unlock()
+	prev := r.mu.state.TruncatedState
+	if prev != nil && t.Index < prev.Index {
+		// Regressing truncations are discarded below Raft (see
+		// handleTruncatedStateBelowRaft), so this should never happen. Keep the
+		// more recent truncated state rather than resurrecting log entries that
+		// are already gone.
+		r.mu.Unlock()
+		err := errors.Errorf("truncated state regressed from %+v to %+v", prev, t)
+		log.Errorf(ctx, "%v", roachpb.NewReplicaCorruptionError(err))
+		r.recordAnomaly("truncated state regression", err)
+		t = prev
+	} else {
+		r.mu.state.TruncatedState = t
+		r.mu.Unlock()
+	}
 
 	// Clear any entries in the Raft log entry cache for this range up
 	// to and including the most recently truncated index.
-	r.store.raftEntryCache.Clear(r.RangeID, t.Index+1)
+	removed := r.store.raftEntryCache.Clear(r.RangeID, t.Index+1)
+	if observer := r.store.raftEntryCache.TruncationObserver(); observer != nil {
+		observer(r.RangeID, t.Index, removed)
+	}
 
 	// Truncate the sideloaded storage. Note that this is safe only if the new truncated state
 	// is durably on disk (i.e.) synced. This is true at the time of writing but unfortunately
@@ -284,6 +359,35 @@ func (r *Replica) handleTruncatedStateResult(
 	return -size
 }
 
+// ForceTruncatedState installs the given truncated state on the replica out of
+// band. It is meant for recovery tooling that has repaired a corrupted Raft
+// log, not for use on the Raft path. The in-memory state, the Raft entry cache
+// and the sideloaded storage are updated the same way as when a log truncation
+// is applied. Nothing is persisted: the caller is expected to have written the
+// truncated state and removed the log entries itself. A truncated state that
+// would regress the current one, or that lies beyond the applied index, is
+// refused.
+func (r *Replica) ForceTruncatedState(ctx context.Context, t roachpb.RaftTruncatedState) error {
+	r.raftMu.Lock()
+	defer r.raftMu.Unlock()
+	if !r.IsInitialized() {
+		return errors.Errorf("%s: cannot force truncated state on uninitialized replica", r)
+	}
+	r.mu.RLock()
+	prev := r.mu.state.TruncatedState
+	appliedIndex := r.mu.state.RaftAppliedIndex
+	r.mu.RUnlock()
+	if prev != nil && t.Index < prev.Index {
+		return errors.Errorf("%s: refusing to regress truncated state from %+v to %+v", r, prev, t)
+	}
+	if t.Index > appliedIndex {
+		return errors.Errorf("%s: refusing to truncate to %+v beyond applied index %d", r, t, appliedIndex)
+	}
+	log.Infof(ctx, "forcing truncated state %+v (previously %+v)", t, prev)
+	r.handleRaftLogDeltaResult(ctx, r.handleTruncatedStateResult(ctx, &t))
+	return nil
+}
+
 func (r *Replica) handleGCThresholdResult(ctx context.Context, thresh *hlc.Timestamp) {
 	if thresh.IsEmpty() {
 		return
@@ -299,6 +403,12 @@ func (r *Replica) handleUsingAppliedStateKeyResult(ctx context.Context) {
 	r.mu.Unlock()
 }
 
+func (r *Replica) handleRaftLogQueueDisabledResult(ctx context.Context, disabled bool) {
+	r.mu.Lock()
+	r.mu.state.RaftLogQueueDisabled = disabled
+	r.mu.Unlock()
+}
+
 func (r *Replica) handleComputeChecksumResult(ctx context.Context, cc *kvserverpb.ComputeChecksum) {
 	r.computeChecksumPostApply(ctx, *cc)
 }
@@ -306,6 +416,8 @@ func (r *Replica) handleComputeChecksumResult(ctx context.Context, cc *kvserverp
 func (r *Replica) handleChangeReplicasResult(
 	ctx context.Context, chng *kvserverpb.ChangeReplicas,
 ) (changeRemovedReplica bool) {
+	r.recordReplicaChange(chng)
+
 	// If this command removes us then we would have set the destroy status
 	// to destroyReasonRemoved which we detect here.
 	//
@@ -348,6 +460,26 @@ func (r *Replica) handleChangeReplicasResult(
 	return true
 }
 
+// recordReplicaChange adds the given change to the replica's log of recent
+// membership changes, evicting the oldest entry if the log is full.
+func (r *Replica) recordReplicaChange(chng *kvserverpb.ChangeReplicas) {
+	rec := ReplicaChangeRecord{
+		Added:     chng.Added(),
+		Removed:   chng.Removed(),
+		Timestamp: timeutil.Now(),
+	}
+	if chng.Desc != nil {
+		rec.Generation = chng.Desc.Generation
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.mu.recentReplicaChanges) == maxRecentReplicaChanges {
+		copy(r.mu.recentReplicaChanges, r.mu.recentReplicaChanges[1:])
+		r.mu.recentReplicaChanges = r.mu.recentReplicaChanges[:maxRecentReplicaChanges-1]
+	}
+	r.mu.recentReplicaChanges = append(r.mu.recentReplicaChanges, rec)
+}
+
 func (r *Replica) handleRaftLogDeltaResult(ctx context.Context, delta int64) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
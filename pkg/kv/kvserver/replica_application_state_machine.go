@@ -13,6 +13,8 @@ package kvserver
 import (
 	"context"
 	"fmt"
+	"reflect"
+	"strings"
 	"time"
 
 	"github.com/cockroachdb/cockroach/pkg/clusterversion"
@@ -22,11 +24,12 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/roachpb"
 	"github.com/cockroachdb/cockroach/pkg/storage"
 	"github.com/cockroachdb/cockroach/pkg/storage/enginepb"
+	"github.com/cockroachdb/cockroach/pkg/util"
+	"github.com/cockroachdb/cockroach/pkg/util/envutil"
 	"github.com/cockroachdb/cockroach/pkg/util/hlc"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
 	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
 	"github.com/cockroachdb/errors"
-	"github.com/kr/pretty"
 	"go.etcd.io/etcd/raft"
 	"go.etcd.io/etcd/raft/raftpb"
 )
@@ -113,6 +116,24 @@ type replicaStateMachine struct {
 	ephemeralBatch ephemeralReplicaAppBatch
 	// stats are updated during command application and reset by moveStats.
 	stats applyCommittedEntriesStats
+	// onFatal, if set, is called instead of log.Fatalf when an assertion on the
+	// apply path fails. It is only set by ApplyReplicatedEvalResultForTesting.
+	onFatal func(ctx context.Context, format string, args ...interface{})
+	// onAssertState, if set, is called instead of assertStateLocked when the
+	// side effects of a command call for the in-memory and on-disk states of
+	// the replica to be compared. It is only set by
+	// ApplyReplicatedEvalResultForTesting.
+	onAssertState func(ctx context.Context)
+}
+
+// fatalf reports a failed assertion on the apply path. Outside of tests, it
+// crashes the process.
+func (sm *replicaStateMachine) fatalf(ctx context.Context, format string, args ...interface{}) {
+	if sm.onFatal != nil {
+		sm.onFatal(ctx, format, args...)
+		return
+	}
+	log.FatalfDepth(ctx, 1, format, args...)
 }
 
 // getStateMachine returns the Replica's apply.StateMachine. The Replica's
@@ -381,11 +402,18 @@ type replicaAppBatch struct {
 	// changeRemovesReplica tracks whether the command in the batch (there must
 	// be only one) removes this replica from the range.
 	changeRemovesReplica bool
+	// containsSplit tracks whether the command in the batch (there must be only
+	// one) splits the range.
+	containsSplit bool
+	// durableApply holds the local proposals of the commands in the batch which
+	// require their application to be synced before they are acknowledged.
+	durableApply []*ProposalData
 
 	// Statistics.
 	entries      int
 	emptyEntries int
 	mutations    int
+	writeBytes   int
 	start        time.Time
 }
 
@@ -486,6 +514,9 @@ func (b *replicaAppBatch) Stage(cmdI apply.Command) (apply.CheckedCommand, error
 	// non-trivial ReplicatedState updates until later (without ever staging
 	// them in the batch) is sufficient.
 	b.stageTrivialReplicatedEvalResult(ctx, cmd)
+	if cmd.IsLocal() && cmd.proposal.durableApply.required && !cmd.Rejected() {
+		b.durableApply = append(b.durableApply, cmd.proposal)
+	}
 	b.entries++
 	if len(cmd.ent.Data) == 0 {
 		b.emptyEntries++
@@ -518,12 +549,20 @@ func (b *replicaAppBatch) stageWriteBatch(ctx context.Context, cmd *replicatedCm
 	if wb == nil {
 		return nil
 	}
-	if mutations, err := storage.RocksDBBatchCount(wb.Data); err != nil {
+	data := wb.Data
+	if fn := b.r.store.TestingKnobs().WriteBatchTransform; fn != nil {
+		var err error
+		if data, err = fn(data); err != nil {
+			return wrapWithNonDeterministicFailure(err, "unable to transform WriteBatch")
+		}
+	}
+	b.writeBytes += len(wb.Data)
+	if mutations, err := storage.RocksDBBatchCount(data); err != nil {
 		log.Errorf(ctx, "unable to read header of committed WriteBatch: %+v", err)
 	} else {
 		b.mutations += mutations
 	}
-	if err := b.batch.ApplyBatchRepr(wb.Data, false); err != nil {
+	if err := b.batch.ApplyBatchRepr(data, false); err != nil {
 		return wrapWithNonDeterministicFailure(err, "unable to apply WriteBatch")
 	}
 	return nil
@@ -617,6 +656,7 @@ func (b *replicaAppBatch) runPreApplyTriggersAfterStagingWriteBatch(
 		// Alternatively if we discover that the RHS has already been removed
 		// from this store, clean up its data.
 		splitPreApply(ctx, b.batch, res.Split.SplitTrigger, b.r)
+		b.containsSplit = true
 
 		// The rangefeed processor will no longer be provided logical ops for
 		// its entire range, so it needs to be shut down and all registrations
@@ -822,11 +862,70 @@ func (b *replicaAppBatch) stageTrivialReplicatedEvalResult(
 	if res.Split != nil && deltaStats.ContainsEstimates == 0 {
 		b.state.Stats.ContainsEstimates = 0
 	}
+	_ = b.r.maybeValidateAppliedStats(ctx, b.state.Stats, deltaStats)
 	if res.State != nil && res.State.UsingAppliedStateKey && !b.state.UsingAppliedStateKey {
 		b.migrateToAppliedStateKey = true
 	}
 }
 
+// validateAppliedStats, if set, causes a replica's MVCC stats to be checked
+// for negative values whenever a command's stats delta is applied to them and
+// whenever a split installs the stats of the resulting ranges. No correct
+// sequence of commands drives these values negative, so violations are
+// logged as replica corruption errors along with the offending delta. This
+// is a debugging aid which is off by default since it adds overhead to the
+// application of every command.
+var validateAppliedStats = envutil.EnvOrDefaultBool("COCKROACH_VALIDATE_APPLIED_STATS", false)
+
+// checkStatsNonNegative returns an error naming the fields of the given stats
+// which are negative.
+func checkStatsNonNegative(ms *enginepb.MVCCStats) error {
+	var neg []string
+	for _, f := range []struct {
+		name string
+		val  int64
+	}{
+		{"LiveBytes", ms.LiveBytes},
+		{"KeyBytes", ms.KeyBytes},
+		{"ValBytes", ms.ValBytes},
+		{"IntentBytes", ms.IntentBytes},
+		{"LiveCount", ms.LiveCount},
+		{"KeyCount", ms.KeyCount},
+		{"ValCount", ms.ValCount},
+		{"IntentCount", ms.IntentCount},
+		{"SysBytes", ms.SysBytes},
+		{"SysCount", ms.SysCount},
+	} {
+		if f.val < 0 {
+			neg = append(neg, fmt.Sprintf("%s=%d", f.name, f.val))
+		}
+	}
+	if len(neg) == 0 {
+		return nil
+	}
+	return errors.Errorf("negative MVCC stats: %s", strings.Join(neg, ", "))
+}
+
+// maybeValidateAppliedStats checks the given stats, which resulted from the
+// application of delta, for invariant violations if validateAppliedStats is
+// set. Stats containing estimates are exempt, as estimates can legitimately
+// drive them negative. Violations are logged and returned.
+func (r *Replica) maybeValidateAppliedStats(
+	ctx context.Context, ms *enginepb.MVCCStats, delta enginepb.MVCCStats,
+) error {
+	if !validateAppliedStats || ms.ContainsEstimates != 0 {
+		return nil
+	}
+	if err := checkStatsNonNegative(ms); err != nil {
+		corruptErr := roachpb.NewReplicaCorruptionError(
+			errors.Wrapf(err, "after applying delta %+v", delta))
+		log.Errorf(ctx, "%v", corruptErr)
+		r.recordAnomaly("negative MVCC stats", corruptErr)
+		return corruptErr
+	}
+	return nil
+}
+
 // ApplyToStateMachine implements the apply.Batch interface. The method handles
 // the second phase of applying a command to the replica state machine. It
 // writes the application batch's accumulated RocksDB batch to the storage
@@ -859,20 +958,41 @@ func (b *replicaAppBatch) ApplyToStateMachine(ctx context.Context) error {
 	// applied again upon startup. However, if we're removing the replica's data
 	// then we sync this batch as it is not safe to call postDestroyRaftMuLocked
 	// before ensuring that the replica's data has been synchronously removed.
-	// See handleChangeReplicasResult().
-	sync := b.changeRemovesReplica
+	// See handleChangeReplicasResult(). The batch is also synced if any of its
+	// commands must not be acknowledged before their application is durable.
+	sync := b.changeRemovesReplica || len(b.durableApply) > 0
 	if err := b.batch.Commit(sync); err != nil {
 		return wrapWithNonDeterministicFailure(err, "unable to commit Raft entry batch")
 	}
 	b.batch.Close()
 	b.batch = nil
-
-	// Update the replica's applied indexes and mvcc stats.
+	for _, p := range b.durableApply {
+		p.signalDurableApply()
+	}
+	// Account for the bytes written.
+	r.store.metrics.RaftCommandsWriteBytes.Inc(int64(b.writeBytes))
+
+	// Consult the split queue's length and the settings which inform the
+	// queuing decisions before acquiring r.mu, keeping them out of the critical
+	// section.
+	splitQueueBacklogged := r.splitQueueBacklogged()
+	statsReconciliationThreshold := statsReconciliationAppliedBytes.Get(&r.store.cfg.Settings.SV)
+	statsNow := r.store.Clock().PhysicalTime()
+
+	// Update the replica's applied indexes and mvcc stats. The critical section
+	// is kept to the updates themselves and to snapshotting the fields needed
+	// to decide whether to queue the replica; r.mu is contended by reads on hot
+	// ranges.
 	r.mu.Lock()
 	r.mu.state.RaftAppliedIndex = b.state.RaftAppliedIndex
 	r.mu.state.LeaseAppliedIndex = b.state.LeaseAppliedIndex
-	prevStats := *r.mu.state.Stats
+	deltaStats := *b.state.Stats
+	deltaStats.Subtract(*r.mu.state.Stats)
 	*r.mu.state.Stats = *b.state.Stats
+	if r.mu.statsDeltas == nil {
+		r.mu.statsDeltas = &statsDeltaHistory{}
+	}
+	r.mu.statsDeltas.record(statsNow, &deltaStats)
 
 	// If the range is now less than its RangeMaxBytes, clear the history of its
 	// largest previous max bytes.
@@ -880,15 +1000,18 @@ func (b *replicaAppBatch) ApplyToStateMachine(ctx context.Context) error {
 		r.mu.largestPreviousMaxRangeSizeBytes = 0
 	}
 
-	// Check the queuing conditions while holding the lock.
-	needsSplitBySize := r.needsSplitBySizeRLocked()
-	needsMergeBySize := r.needsMergeBySizeRLocked()
+	size := r.rangeSizeRLocked()
+	r.updateSplitQueueBackpressureLocked(size, splitQueueBacklogged)
 	needsTruncationByLogSize := r.needsRaftLogTruncationLocked()
+	raftLogQueueDisabled := r.mu.state.RaftLogQueueDisabled
+	needsStatsReconciliation := r.noteAppliedBytesLocked(int64(b.writeBytes), statsReconciliationThreshold)
 	r.mu.Unlock()
 
+	// Check the size-based queuing conditions against the snapshot.
+	needsSplitBySize := size.needsSplit()
+	needsMergeBySize := size.needsMerge()
+
 	// Record the stats delta in the StoreMetrics.
-	deltaStats := *b.state.Stats
-	deltaStats.Subtract(prevStats)
 	r.store.metrics.addMVCCStats(deltaStats)
 
 	// Record the write activity, passing a 0 nodeID because replica.writeStats
@@ -896,14 +1019,26 @@ func (b *replicaAppBatch) ApplyToStateMachine(ctx context.Context) error {
 	b.r.writeStats.recordCount(float64(b.mutations), 0 /* nodeID */)
 
 	now := timeutil.Now()
-	if needsSplitBySize && r.splitQueueThrottle.ShouldProcess(now) {
-		r.store.splitQueue.MaybeAddAsync(ctx, r, r.store.Clock().Now())
+	// A split leaves it to splitPostApply to offer both halves to the split
+	// queue once the Store reflects the split. Doing so here would hand the
+	// queue a replica whose descriptor still predates the split.
+	if needsSplitBySize && !b.containsSplit && r.splitQueueThrottle.ShouldProcess(now) {
+		r.maybeAddToSplitQueueAfterApply(ctx, r.store.Clock().Now())
 	}
 	if needsMergeBySize && r.mergeQueueThrottle.ShouldProcess(now) {
 		r.store.mergeQueue.MaybeAddAsync(ctx, r, r.store.Clock().Now())
 	}
 	if needsTruncationByLogSize {
-		r.store.raftLogQueue.MaybeAddAsync(ctx, r, r.store.Clock().Now())
+		// The range may have opted out of being offered to the raft log queue
+		// on apply (see batcheval.SetRaftLogQueueDisabled). Note that the last
+		// check size has already advanced, so re-enabling takes effect at the
+		// next check.
+		if !raftLogQueueDisabled {
+			r.store.raftLogQueue.MaybeAddAsync(ctx, r, r.store.Clock().Now())
+		}
+	}
+	if needsStatsReconciliation {
+		r.store.statsQueue.MaybeAddAsync(ctx, r, r.store.Clock().Now())
 	}
 
 	b.recordStatsOnCommit()
@@ -1016,6 +1151,7 @@ func (sm *replicaStateMachine) ApplySideEffects(
 ) (apply.AppliedCommand, error) {
 	cmd := cmdI.(*replicatedCmd)
 	ctx := cmd.ctx
+	start := timeutil.Now()
 
 	// Deal with locking during side-effect handling, which is sometimes
 	// associated with complex commands such as splits and merged.
@@ -1036,26 +1172,12 @@ func (sm *replicaStateMachine) ApplySideEffects(
 	//
 	// Note that this must happen after committing (the engine.Batch), but
 	// before notifying a potentially waiting client.
-	clearTrivialReplicatedEvalResultFields(cmd.replicatedResult())
-	if !cmd.IsTrivial() {
-		shouldAssert, isRemoved := sm.handleNonTrivialReplicatedEvalResult(ctx, cmd.replicatedResult())
-
-		if isRemoved {
-			return nil, apply.ErrRemoved
-		}
-		// NB: Perform state assertion before acknowledging the client.
-		// Some tests (TestRangeStatsInit) assumes that once the store has started
-		// and the first range has a lease that there will not be a later hard-state.
-		if shouldAssert {
-			// Assert that the on-disk state doesn't diverge from the in-memory
-			// state as a result of the side effects.
-			sm.r.mu.Lock()
-			sm.r.assertStateLocked(ctx, sm.r.store.Engine())
-			sm.r.mu.Unlock()
-			sm.stats.stateAssertions++
-		}
-	} else if res := cmd.replicatedResult(); !res.Equal(kvserverpb.ReplicatedEvalResult{}) {
-		log.Fatalf(ctx, "failed to handle all side-effects of ReplicatedEvalResult: %v", res)
+	var proposedAt time.Time
+	if cmd.IsLocal() {
+		proposedAt = cmd.proposal.createdAt
+	}
+	if _, isRemoved := sm.handleReplicatedEvalResult(ctx, cmd.replicatedResult(), proposedAt); isRemoved {
+		return nil, apply.ErrRemoved
 	}
 
 	// On ConfChange entries, inform the raft.RawNode.
@@ -1100,99 +1222,355 @@ func (sm *replicaStateMachine) ApplySideEffects(
 		}
 		cmd.proposal.applied = true
 	}
+
+	// Record the time spent handling the side effects separately for commands
+	// proposed by this replica, which carry out the proposer-only side effects
+	// of the LocalResult, and commands proposed elsewhere.
+	elapsed := timeutil.Since(start).Nanoseconds()
+	if cmd.IsLocal() {
+		sm.r.store.metrics.RaftApplySideEffectsProposerLatency.RecordValue(elapsed)
+	} else {
+		sm.r.store.metrics.RaftApplySideEffectsFollowerLatency.RecordValue(elapsed)
+	}
 	return cmd, nil
 }
 
-// handleNonTrivialReplicatedEvalResult carries out the side-effects of
-// non-trivial commands. It is run with the raftMu locked. It is illegal
-// to pass a replicatedResult that does not imply any side-effects.
-func (sm *replicaStateMachine) handleNonTrivialReplicatedEvalResult(
-	ctx context.Context, rResult *kvserverpb.ReplicatedEvalResult,
-) (shouldAssert, isRemoved bool) {
-	// Assert that this replicatedResult implies at least one side-effect.
-	if rResult.Equal(kvserverpb.ReplicatedEvalResult{}) {
-		log.Fatalf(ctx, "zero-value ReplicatedEvalResult passed to handleNonTrivialReplicatedEvalResult")
-	}
+// replicatedEvalResultStep names a step in the application of the side effects
+// of a ReplicatedEvalResult by handleNonTrivialReplicatedEvalResult, see
+// replicatedEvalResultHandlers.
+type replicatedEvalResultStep string
+
+const (
+	stepLease                replicatedEvalResultStep = "lease"
+	stepTruncatedState       replicatedEvalResultStep = "truncated-state"
+	stepGCThreshold          replicatedEvalResultStep = "gc-threshold"
+	stepRaftLogDelta         replicatedEvalResultStep = "raft-log-delta"
+	stepSuggestedCompactions replicatedEvalResultStep = "suggested-compactions"
+	stepSplit                replicatedEvalResultStep = "split"
+	stepMerge                replicatedEvalResultStep = "merge"
+	stepDesc                 replicatedEvalResultStep = "desc"
+	stepUsingAppliedStateKey replicatedEvalResultStep = "using-applied-state-key"
+	stepRaftLogQueueDisabled replicatedEvalResultStep = "raft-log-queue-disabled"
+	stepChangeReplicas       replicatedEvalResultStep = "change-replicas"
+	stepComputeChecksum      replicatedEvalResultStep = "compute-checksum"
+)
 
-	if rResult.State != nil {
-		if newLease := rResult.State.Lease; newLease != nil {
-			sm.r.handleLeaseResult(ctx, newLease)
-			rResult.State.Lease = nil
-		}
+// replicatedEvalResultApplication carries the state shared by the handlers
+// applying the side effects of a single ReplicatedEvalResult.
+type replicatedEvalResultApplication struct {
+	sm      *replicaStateMachine
+	rResult *kvserverpb.ReplicatedEvalResult
+	// splitOrMerge is set if the result splits or merges the range, which
+	// legitimately changes the bounds of its descriptor.
+	splitOrMerge bool
+	// isRemoved is set if the replica was removed by the result.
+	isRemoved bool
+	// proposedAt is the time at which the command was proposed if it was
+	// proposed by this replica, and zero otherwise.
+	proposedAt time.Time
+}
 
-		if rResult.State.TruncatedState != nil {
-			rResult.RaftLogDelta += sm.r.handleTruncatedStateResult(ctx, rResult.State.TruncatedState)
-			rResult.State.TruncatedState = nil
-		}
+// replicatedEvalResultHandler carries out one step of the application of the
+// side effects of a ReplicatedEvalResult. A handler is only invoked if the
+// result carries the side effect it is responsible for, as reported by
+// applies, and clears the corresponding fields of the result once it's done.
+type replicatedEvalResultHandler struct {
+	step replicatedEvalResultStep
+	// nontrivial is set for side effects which may have large effects on the
+	// in-memory and on-disk ReplicaStates, in which case we want to assert that
+	// these two states do not diverge.
+	nontrivial bool
+	applies    func(rResult *kvserverpb.ReplicatedEvalResult) bool
+	handle     func(ctx context.Context, a *replicatedEvalResultApplication)
+}
 
-		if newThresh := rResult.State.GCThreshold; newThresh != nil {
-			sm.r.handleGCThresholdResult(ctx, newThresh)
-			rResult.State.GCThreshold = nil
-		}
+// replicatedEvalResultHandlers lists the handlers which
+// handleNonTrivialReplicatedEvalResult runs, in order, to carry out the side
+// effects of a ReplicatedEvalResult. Each step is carried out at most once per
+// result, and the trivial steps all precede the nontrivial ones.
+//
+// The order is observable by commands carrying several side effects (for
+// example, a split which also updates the lease), so it must not change
+// without considering all of them. TestReplicatedEvalResultStepOrder verifies
+// that the side effects are carried out in this order.
+var replicatedEvalResultHandlers = []replicatedEvalResultHandler{
+	{
+		step: stepLease,
+		applies: func(rResult *kvserverpb.ReplicatedEvalResult) bool {
+			return rResult.State != nil && rResult.State.Lease != nil
+		},
+		handle: func(ctx context.Context, a *replicatedEvalResultApplication) {
+			// Capture the lease before clearing it from the result. applies
+			// guarantees that it is set.
+			newLease := a.rResult.State.Lease
+			a.rResult.State.Lease = nil
+			// The lease is installed before the descriptor which accompanies it,
+			// if any. The leaseholder must be part of that descriptor, or the
+			// range would end up with a lease held by a non-member.
+			if desc := a.rResult.State.Desc; desc != nil {
+				if repl, ok := desc.GetReplicaDescriptorByID(newLease.Replica.ReplicaID); !ok ||
+					repl.StoreID != newLease.Replica.StoreID {
+					a.sm.fatalf(ctx, "lease %s names a replica missing from the accompanying descriptor %s",
+						newLease, desc)
+					return
+				}
+			}
+			a.sm.r.handleLeaseResult(ctx, newLease, a.proposedAt)
+		},
+	},
+	{
+		step: stepTruncatedState,
+		applies: func(rResult *kvserverpb.ReplicatedEvalResult) bool {
+			return rResult.State != nil && rResult.State.TruncatedState != nil
+		},
+		handle: func(ctx context.Context, a *replicatedEvalResultApplication) {
+			a.rResult.RaftLogDelta += a.sm.r.handleTruncatedStateResult(ctx, a.rResult.State.TruncatedState)
+			a.rResult.State.TruncatedState = nil
+		},
+	},
+	{
+		step: stepGCThreshold,
+		applies: func(rResult *kvserverpb.ReplicatedEvalResult) bool {
+			return rResult.State != nil && rResult.State.GCThreshold != nil
+		},
+		handle: func(ctx context.Context, a *replicatedEvalResultApplication) {
+			a.sm.r.handleGCThresholdResult(ctx, a.rResult.State.GCThreshold)
+			a.rResult.State.GCThreshold = nil
+		},
+	},
+	{
+		step: stepRaftLogDelta,
+		applies: func(rResult *kvserverpb.ReplicatedEvalResult) bool {
+			return rResult.RaftLogDelta != 0
+		},
+		handle: func(ctx context.Context, a *replicatedEvalResultApplication) {
+			a.sm.r.handleRaftLogDeltaResult(ctx, a.rResult.RaftLogDelta)
+			a.rResult.RaftLogDelta = 0
+		},
+	},
+	{
+		step: stepSuggestedCompactions,
+		applies: func(rResult *kvserverpb.ReplicatedEvalResult) bool {
+			return rResult.SuggestedCompactions != nil
+		},
+		handle: func(ctx context.Context, a *replicatedEvalResultApplication) {
+			a.sm.r.handleSuggestedCompactionsResult(ctx, a.rResult.SuggestedCompactions)
+			a.rResult.SuggestedCompactions = nil
+		},
+	},
+	{
+		step:       stepSplit,
+		nontrivial: true,
+		applies: func(rResult *kvserverpb.ReplicatedEvalResult) bool {
+			return rResult.Split != nil
+		},
+		handle: func(ctx context.Context, a *replicatedEvalResultApplication) {
+			a.sm.r.handleSplitResult(ctx, a.rResult.Split)
+			a.rResult.Split = nil
+		},
+	},
+	{
+		step:       stepMerge,
+		nontrivial: true,
+		applies: func(rResult *kvserverpb.ReplicatedEvalResult) bool {
+			return rResult.Merge != nil
+		},
+		handle: func(ctx context.Context, a *replicatedEvalResultApplication) {
+			a.sm.r.handleMergeResult(ctx, a.rResult.Merge)
+			a.rResult.Merge = nil
+		},
+	},
+	{
+		step:       stepDesc,
+		nontrivial: true,
+		applies: func(rResult *kvserverpb.ReplicatedEvalResult) bool {
+			return rResult.State != nil && rResult.State.Desc != nil
+		},
+		handle: func(ctx context.Context, a *replicatedEvalResultApplication) {
+			newDesc := a.rResult.State.Desc
+			a.rResult.State.Desc = nil
+			if err := checkDescBounds(a.sm.r.Desc(), newDesc, a.splitOrMerge); err != nil {
+				a.sm.fatalf(ctx, "%v", roachpb.NewReplicaCorruptionError(err))
+				return
+			}
+			a.sm.r.handleDescResult(ctx, newDesc)
+		},
+	},
+	{
+		step:       stepUsingAppliedStateKey,
+		nontrivial: true,
+		applies: func(rResult *kvserverpb.ReplicatedEvalResult) bool {
+			return rResult.State != nil && rResult.State.UsingAppliedStateKey
+		},
+		handle: func(ctx context.Context, a *replicatedEvalResultApplication) {
+			a.sm.r.handleUsingAppliedStateKeyResult(ctx)
+			a.rResult.State.UsingAppliedStateKey = false
+		},
+	},
+	{
+		step:       stepRaftLogQueueDisabled,
+		nontrivial: true,
+		applies: func(rResult *kvserverpb.ReplicatedEvalResult) bool {
+			return rResult.UpdateRaftLogQueueDisabled
+		},
+		handle: func(ctx context.Context, a *replicatedEvalResultApplication) {
+			var disabled bool
+			if a.rResult.State != nil {
+				disabled = a.rResult.State.RaftLogQueueDisabled
+				a.rResult.State.RaftLogQueueDisabled = false
+			}
+			a.sm.r.handleRaftLogQueueDisabledResult(ctx, disabled)
+			a.rResult.UpdateRaftLogQueueDisabled = false
+		},
+	},
+	{
+		step:       stepChangeReplicas,
+		nontrivial: true,
+		applies: func(rResult *kvserverpb.ReplicatedEvalResult) bool {
+			return rResult.ChangeReplicas != nil
+		},
+		handle: func(ctx context.Context, a *replicatedEvalResultApplication) {
+			a.isRemoved = a.sm.r.handleChangeReplicasResult(ctx, a.rResult.ChangeReplicas)
+			a.rResult.ChangeReplicas = nil
+		},
+	},
+	{
+		step:       stepComputeChecksum,
+		nontrivial: true,
+		applies: func(rResult *kvserverpb.ReplicatedEvalResult) bool {
+			return rResult.ComputeChecksum != nil
+		},
+		handle: func(ctx context.Context, a *replicatedEvalResultApplication) {
+			a.sm.r.handleComputeChecksumResult(ctx, a.rResult.ComputeChecksum)
+			a.rResult.ComputeChecksum = nil
+		},
+	},
+}
 
-		if (*rResult.State == kvserverpb.ReplicaState{}) {
-			rResult.State = nil
+// handleReplicatedEvalResult carries out the side effects of a command's
+// ReplicatedEvalResult once the command has been committed to the engine. If
+// the side effects were non-trivial and didn't remove the replica, it then
+// asserts that the in-memory and on-disk states of the replica haven't
+// diverged. It returns whether that assertion was carried out.
+func (sm *replicaStateMachine) handleReplicatedEvalResult(
+	ctx context.Context, rResult *kvserverpb.ReplicatedEvalResult, proposedAt time.Time,
+) (asserted, isRemoved bool) {
+	clearTrivialReplicatedEvalResultFields(rResult)
+	if isTrivial(rResult) {
+		if !rResult.IsZero() {
+			sm.fatalf(ctx, "failed to handle all side-effects of ReplicatedEvalResult: %v", rResult)
 		}
+		return false, false
 	}
-
-	if rResult.RaftLogDelta != 0 {
-		sm.r.handleRaftLogDeltaResult(ctx, rResult.RaftLogDelta)
-		rResult.RaftLogDelta = 0
-	}
-
-	if rResult.SuggestedCompactions != nil {
-		sm.r.handleSuggestedCompactionsResult(ctx, rResult.SuggestedCompactions)
-		rResult.SuggestedCompactions = nil
+	shouldAssert, isRemoved := sm.handleNonTrivialReplicatedEvalResult(ctx, rResult, proposedAt)
+	if isRemoved {
+		return false, true
 	}
-
-	// The rest of the actions are "nontrivial" and may have large effects on the
-	// in-memory and on-disk ReplicaStates. If any of these actions are present,
-	// we want to assert that these two states do not diverge.
-	shouldAssert = !rResult.Equal(kvserverpb.ReplicatedEvalResult{})
-	if !shouldAssert {
-		return false, false
+	// NB: Perform state assertion before acknowledging the client.
+	// Some tests (TestRangeStatsInit) assumes that once the store has started
+	// and the first range has a lease that there will not be a later hard-state.
+	if shouldAssert {
+		sm.assertState(ctx)
 	}
+	return shouldAssert, false
+}
 
-	if rResult.Split != nil {
-		sm.r.handleSplitResult(ctx, rResult.Split)
-		rResult.Split = nil
+// assertState asserts that the on-disk state of the replica doesn't diverge
+// from its in-memory state as a result of the side effects of a command.
+func (sm *replicaStateMachine) assertState(ctx context.Context) {
+	if sm.onAssertState != nil {
+		sm.onAssertState(ctx)
+	} else {
+		sm.r.mu.Lock()
+		sm.r.assertStateLocked(ctx, sm.r.store.Engine())
+		sm.r.mu.Unlock()
 	}
+	sm.stats.stateAssertions++
+}
 
-	if rResult.Merge != nil {
-		sm.r.handleMergeResult(ctx, rResult.Merge)
-		rResult.Merge = nil
+// onStep informs the ReplicatedEvalResultStepEvent testing knob, if set, that
+// the given step is being carried out.
+func (sm *replicaStateMachine) onStep(step replicatedEvalResultStep) {
+	if fn := sm.r.store.TestingKnobs().ReplicatedEvalResultStepEvent; fn != nil {
+		fn(sm.r.RangeID, string(step))
 	}
+}
 
-	if rResult.State != nil {
-		if newDesc := rResult.State.Desc; newDesc != nil {
-			sm.r.handleDescResult(ctx, newDesc)
-			rResult.State.Desc = nil
+// handleNonTrivialReplicatedEvalResult carries out the side-effects of
+// non-trivial commands. It is run with the raftMu locked. It is illegal
+// to pass a replicatedResult that does not imply any side-effects. The side
+// effects are carried out by the replicatedEvalResultHandlers, in order.
+// proposedAt is the time at which the command was proposed, if it was proposed
+// by this replica.
+func (sm *replicaStateMachine) handleNonTrivialReplicatedEvalResult(
+	ctx context.Context, rResult *kvserverpb.ReplicatedEvalResult, proposedAt time.Time,
+) (shouldAssert, isRemoved bool) {
+	// Assert that this replicatedResult implies at least one side-effect.
+	if rResult.IsZero() {
+		sm.fatalf(ctx, "zero-value ReplicatedEvalResult passed to handleNonTrivialReplicatedEvalResult")
+	}
+
+	app := replicatedEvalResultApplication{sm: sm, rResult: rResult, proposedAt: proposedAt}
+	for i := range replicatedEvalResultHandlers {
+		h := &replicatedEvalResultHandlers[i]
+		if h.nontrivial && !shouldAssert {
+			// The rest of the actions are "nontrivial" and may have large effects
+			// on the in-memory and on-disk ReplicaStates. If any of these actions
+			// are present, we want to assert that these two states do not diverge.
+			shouldAssert = !rResult.IsZero()
+			if !shouldAssert {
+				return false, false
+			}
+			// In race builds, record which fields triggered the assertion so that
+			// surprising assertions can be traced back to the command causing
+			// them.
+			if util.RaceEnabled && log.ExpensiveLogEnabled(ctx, 3) {
+				log.VEventf(ctx, 3, "asserting replica state due to nontrivial fields %v",
+					nontrivialReplicatedEvalResultFields(rResult))
+			}
+			// Splits and merges legitimately change the bounds of the range. Any
+			// other descriptor update must leave them intact.
+			app.splitOrMerge = rResult.Split != nil || rResult.Merge != nil
 		}
-
-		if rResult.State.UsingAppliedStateKey {
-			sm.r.handleUsingAppliedStateKeyResult(ctx)
-			rResult.State.UsingAppliedStateKey = false
+		if h.applies(rResult) {
+			sm.onStep(h.step)
+			h.handle(ctx, &app)
 		}
-
-		if (*rResult.State == kvserverpb.ReplicaState{}) {
+		if rResult.State != nil && rResult.State.IsZero() {
 			rResult.State = nil
 		}
 	}
 
-	if rResult.ChangeReplicas != nil {
-		isRemoved = sm.r.handleChangeReplicasResult(ctx, rResult.ChangeReplicas)
-		rResult.ChangeReplicas = nil
-	}
-
-	if rResult.ComputeChecksum != nil {
-		sm.r.handleComputeChecksumResult(ctx, rResult.ComputeChecksum)
-		rResult.ComputeChecksum = nil
+	if !rResult.IsZero() {
+		if !sm.r.tolerateUnhandledEvalResult(ctx, "ReplicatedEvalResult", rResult) {
+			sm.fatalf(ctx, "unhandled field in ReplicatedEvalResult: %s", rResult)
+		}
+		*rResult = kvserverpb.ReplicatedEvalResult{}
 	}
+	return true, app.isRemoved
+}
 
-	if !rResult.Equal(kvserverpb.ReplicatedEvalResult{}) {
-		log.Fatalf(ctx, "unhandled field in ReplicatedEvalResult: %s", pretty.Diff(rResult, kvserverpb.ReplicatedEvalResult{}))
+// nontrivialReplicatedEvalResultFields returns the names of the fields that are
+// set on the given ReplicatedEvalResult, descending into its State. It relies
+// on reflection and is only meant to explain state assertions.
+func nontrivialReplicatedEvalResultFields(rResult *kvserverpb.ReplicatedEvalResult) []string {
+	var fields []string
+	var walk func(prefix string, v reflect.Value)
+	walk = func(prefix string, v reflect.Value) {
+		for i := 0; i < v.NumField(); i++ {
+			f, name := v.Field(i), v.Type().Field(i).Name
+			if f.IsZero() || strings.HasPrefix(name, "XXX_") {
+				continue
+			}
+			if state, ok := f.Interface().(*kvserverpb.ReplicaState); ok {
+				walk(prefix+name+".", reflect.ValueOf(state).Elem())
+				continue
+			}
+			fields = append(fields, prefix+name)
+		}
 	}
-	return true, isRemoved
+	walk("", reflect.ValueOf(rResult).Elem())
+	return fields
 }
 
 func (sm *replicaStateMachine) maybeApplyConfChange(ctx context.Context, cmd *replicatedCmd) error {
@@ -12,14 +12,26 @@ package kvserver
 
 import (
 	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/cockroachdb/cockroach/pkg/kv/kvserver/apply"
+	"github.com/cockroachdb/cockroach/pkg/kv/kvserver/batcheval/result"
 	"github.com/cockroachdb/cockroach/pkg/kv/kvserver/kvserverpb"
 	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/storage"
+	"github.com/cockroachdb/cockroach/pkg/storage/enginepb"
 	"github.com/cockroachdb/cockroach/pkg/testutils"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
 	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
 	"github.com/cockroachdb/cockroach/pkg/util/stop"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/errors"
 	"github.com/stretchr/testify/require"
 	"go.etcd.io/etcd/raft/raftpb"
 )
@@ -167,3 +179,1070 @@ func TestReplicaStateMachineChangeReplicas(t *testing.T) {
 		})
 	})
 }
+
+// TestReplicaStateMachineAtomicChangeReplicas tests applying a ChangeReplicas
+// trigger which adds a replica and removes the local one in a single command.
+// Such atomic changes are expressed as lists of additions and removals on a
+// single trigger, and both take effect when the command applies.
+func TestReplicaStateMachineAtomicChangeReplicas(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	tc := testContext{}
+	ctx := context.Background()
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+	tc.Start(t, stopper)
+
+	// Lock the replica for the entire test.
+	r := tc.repl
+	r.raftMu.Lock()
+	defer r.raftMu.Unlock()
+	sm := r.getStateMachine()
+
+	desc := r.Desc()
+	replDesc, ok := desc.GetReplicaDescriptor(r.store.StoreID())
+	require.True(t, ok)
+
+	newDesc := *desc
+	newDesc.InternalReplicas = append([]roachpb.ReplicaDescriptor(nil), desc.InternalReplicas...)
+	addedReplDesc := newDesc.AddReplica(replDesc.NodeID+1, replDesc.StoreID+1, roachpb.VOTER_FULL)
+	removedReplDesc, ok := newDesc.RemoveReplica(replDesc.NodeID, replDesc.StoreID)
+	require.True(t, ok)
+	trigger := roachpb.ChangeReplicasTrigger{
+		Desc:                    &newDesc,
+		InternalAddedReplicas:   []roachpb.ReplicaDescriptor{addedReplDesc},
+		InternalRemovedReplicas: []roachpb.ReplicaDescriptor{removedReplDesc},
+	}
+	confChange := raftpb.ConfChangeV2{
+		Changes: []raftpb.ConfChangeSingle{
+			{Type: raftpb.ConfChangeAddNode, NodeID: uint64(addedReplDesc.ReplicaID)},
+			{Type: raftpb.ConfChangeRemoveNode, NodeID: uint64(removedReplDesc.ReplicaID)},
+		},
+	}
+
+	b := sm.NewBatch(false /* ephemeral */).(*replicaAppBatch)
+	defer b.Close()
+
+	cmd := &replicatedCmd{
+		ctx: ctx,
+		ent: &raftpb.Entry{
+			Index: r.mu.state.RaftAppliedIndex + 1,
+			Type:  raftpb.EntryConfChangeV2,
+		},
+		decodedRaftEntry: decodedRaftEntry{
+			idKey: makeIDKey(),
+			raftCmd: kvserverpb.RaftCommand{
+				ProposerLeaseSequence: r.mu.state.Lease.Sequence,
+				MaxLeaseIndex:         r.mu.state.LeaseAppliedIndex + 1,
+				ReplicatedEvalResult: kvserverpb.ReplicatedEvalResult{
+					State:          &kvserverpb.ReplicaState{Desc: &newDesc},
+					ChangeReplicas: &kvserverpb.ChangeReplicas{ChangeReplicasTrigger: trigger},
+					Timestamp:      r.mu.state.GCThreshold.Add(1, 0),
+				},
+			},
+			confChange: &decodedConfChange{
+				ConfChangeI: confChange,
+			},
+		},
+	}
+
+	checkedCmd, err := b.Stage(cmd)
+	require.NoError(t, err)
+	require.True(t, b.changeRemovesReplica)
+
+	// Both changes are reflected in the staged descriptor.
+	_, ok = b.state.Desc.GetReplicaDescriptor(addedReplDesc.StoreID)
+	require.True(t, ok)
+	_, ok = b.state.Desc.GetReplicaDescriptor(replDesc.StoreID)
+	require.False(t, ok)
+	reason, _ := r.IsDestroyed()
+	require.Equal(t, destroyReasonRemoved, reason)
+
+	require.NoError(t, b.ApplyToStateMachine(ctx))
+
+	// The removal of the local replica is carried out when the side effects
+	// are applied.
+	_, err = sm.ApplySideEffects(checkedCmd)
+	require.Equal(t, apply.ErrRemoved, err)
+	_, err = tc.store.GetReplica(r.RangeID)
+	require.IsType(t, &roachpb.RangeNotFoundError{}, err)
+}
+
+// TestReplicaStateMachineWriteBytes verifies that the sizes of the write
+// batches of applied commands are accumulated in the store's metrics.
+func TestReplicaStateMachineWriteBytes(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	tc := testContext{}
+	ctx := context.Background()
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+	tc.Start(t, stopper)
+
+	// Lock the replica for the entire test so that no other command applies.
+	r := tc.repl
+	r.raftMu.Lock()
+	defer r.raftMu.Unlock()
+	sm := r.getStateMachine()
+	before := tc.store.Metrics().RaftCommandsWriteBytes.Count()
+
+	makeWriteBatch := func(key, value string) *kvserverpb.WriteBatch {
+		batch := tc.engine.NewBatch()
+		defer batch.Close()
+		require.NoError(t, batch.Put(storage.MakeMVCCMetadataKey(roachpb.Key(key)), []byte(value)))
+		return &kvserverpb.WriteBatch{Data: batch.Repr()}
+	}
+
+	b := sm.NewBatch(false /* ephemeral */).(*replicaAppBatch)
+	defer b.Close()
+	var expBytes int
+	for i, wb := range []*kvserverpb.WriteBatch{
+		makeWriteBatch("a", "value"),
+		// A command without a write batch (e.g. a lease request) contributes
+		// nothing.
+		nil,
+		makeWriteBatch("b", strings.Repeat("x", 1000)),
+	} {
+		cmd := &replicatedCmd{
+			ctx: ctx,
+			ent: &raftpb.Entry{Index: r.mu.state.RaftAppliedIndex + uint64(i) + 1},
+			decodedRaftEntry: decodedRaftEntry{
+				idKey: makeIDKey(),
+				raftCmd: kvserverpb.RaftCommand{
+					ProposerLeaseSequence: r.mu.state.Lease.Sequence,
+					MaxLeaseIndex:         r.mu.state.LeaseAppliedIndex + uint64(i) + 1,
+					ReplicatedEvalResult: kvserverpb.ReplicatedEvalResult{
+						Timestamp: r.mu.state.GCThreshold.Add(1, 0),
+					},
+					WriteBatch: wb,
+				},
+			},
+		}
+		expBytes += (&result.Result{WriteBatch: wb}).WriteBatchSize()
+		_, err := b.Stage(cmd)
+		require.NoError(t, err)
+	}
+	require.NoError(t, b.ApplyToStateMachine(ctx))
+	require.Equal(t, int64(expBytes), tc.store.Metrics().RaftCommandsWriteBytes.Count()-before)
+	require.Greater(t, expBytes, 1000)
+}
+
+// TestCheckDescBounds tests the sanity check applied to descriptor updates
+// that are not part of a split or merge.
+func TestCheckDescBounds(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	prev := &roachpb.RangeDescriptor{
+		RangeID:  1,
+		StartKey: roachpb.RKey("b"),
+		EndKey:   roachpb.RKey("y"),
+	}
+	withBounds := func(start, end string) *roachpb.RangeDescriptor {
+		desc := *prev
+		desc.StartKey, desc.EndKey = roachpb.RKey(start), roachpb.RKey(end)
+		return &desc
+	}
+	replicasChanged := *prev
+	replicasChanged.InternalReplicas = []roachpb.ReplicaDescriptor{{NodeID: 1, StoreID: 1, ReplicaID: 1}}
+	replicasChanged.NextReplicaID = 2
+
+	testCases := []struct {
+		name         string
+		next         *roachpb.RangeDescriptor
+		splitOrMerge bool
+		expErr       string
+	}{
+		{name: "legal update", next: &replicasChanged},
+		{name: "split", next: withBounds("b", "m"), splitOrMerge: true},
+		{name: "merge", next: withBounds("b", "z"), splitOrMerge: true},
+		{name: "contracted end key", next: withBounds("b", "m"), expErr: "contracts range bounds"},
+		{name: "contracted start key", next: withBounds("c", "y"), expErr: "contracts range bounds"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := checkDescBounds(prev, tc.next, tc.splitOrMerge)
+			if tc.expErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.Regexp(t, tc.expErr, err)
+			}
+		})
+	}
+}
+
+// TestCheckMergeTrigger tests the sanity check applied to merge triggers
+// before they are applied.
+func TestCheckMergeTrigger(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	trigger := func(lhs, rhs roachpb.RangeID) *roachpb.MergeTrigger {
+		return &roachpb.MergeTrigger{
+			LeftDesc:  roachpb.RangeDescriptor{RangeID: lhs},
+			RightDesc: roachpb.RangeDescriptor{RangeID: rhs},
+		}
+	}
+	require.NoError(t, checkMergeTrigger(1, trigger(1, 2)))
+	require.Regexp(t, "r2 applying merge trigger with left-hand side r1",
+		checkMergeTrigger(2, trigger(1, 2)))
+	require.Regexp(t, "r3 applying merge trigger with left-hand side r1",
+		checkMergeTrigger(3, trigger(1, 2)))
+	require.Regexp(t, "subsumes itself", checkMergeTrigger(1, trigger(1, 1)))
+}
+
+// TestNontrivialReplicatedEvalResultFields verifies that the fields reported
+// as having triggered a state assertion are those that are set on the
+// ReplicatedEvalResult once its trivial fields have been cleared.
+func TestNontrivialReplicatedEvalResultFields(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	testCases := []struct {
+		name string
+		res  kvserverpb.ReplicatedEvalResult
+		exp  []string
+	}{
+		{name: "empty"},
+		{
+			name: "split",
+			res: kvserverpb.ReplicatedEvalResult{
+				Split: &kvserverpb.Split{},
+				State: &kvserverpb.ReplicaState{Desc: &roachpb.RangeDescriptor{}},
+			},
+			exp: []string{"State.Desc", "Split"},
+		},
+		{
+			name: "change replicas",
+			res: kvserverpb.ReplicatedEvalResult{
+				ChangeReplicas: &kvserverpb.ChangeReplicas{},
+				State: &kvserverpb.ReplicaState{
+					Desc:                 &roachpb.RangeDescriptor{},
+					UsingAppliedStateKey: true,
+				},
+			},
+			exp: []string{"State.Desc", "State.UsingAppliedStateKey", "ChangeReplicas"},
+		},
+		{
+			name: "trivial fields",
+			res: kvserverpb.ReplicatedEvalResult{
+				Timestamp:       hlc.Timestamp{WallTime: 1},
+				IsLeaseRequest:  true,
+				ComputeChecksum: &kvserverpb.ComputeChecksum{},
+			},
+			exp: []string{"ComputeChecksum"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res := tc.res
+			clearTrivialReplicatedEvalResultFields(&res)
+			require.Equal(t, tc.exp, nontrivialReplicatedEvalResultFields(&res))
+		})
+	}
+}
+
+// TestReplicaApplyReplicatedEvalResultForTesting verifies that a captured
+// ReplicatedEvalResult can be replayed against a replica, and that results
+// which would otherwise crash the process are reported as errors.
+func TestReplicaApplyReplicatedEvalResultForTesting(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	tc := testContext{}
+	ctx := context.Background()
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+	tc.Start(t, stopper)
+	r := tc.repl
+
+	// The ReplicatedEvalResult of a split of the range at "m". The right-hand
+	// side was never created on this store, which mirrors replaying the result
+	// against a replica that didn't take part in the original split.
+	splitKey := roachpb.RKey("m")
+	oldDesc := *r.Desc()
+	leftDesc := oldDesc
+	leftDesc.EndKey = splitKey
+	rightDesc := *roachpb.NewRangeDescriptor(
+		oldDesc.RangeID+100, splitKey, oldDesc.EndKey, oldDesc.Replicas())
+	rResult := kvserverpb.ReplicatedEvalResult{
+		State: &kvserverpb.ReplicaState{Desc: &leftDesc},
+		Split: &kvserverpb.Split{
+			SplitTrigger: roachpb.SplitTrigger{LeftDesc: leftDesc, RightDesc: rightDesc},
+		},
+	}
+	shouldAssert, err := r.ApplyReplicatedEvalResultForTesting(ctx, rResult)
+	require.NoError(t, err)
+	require.True(t, shouldAssert)
+	require.Equal(t, oldDesc.StartKey, r.Desc().StartKey)
+	require.Equal(t, splitKey, r.Desc().EndKey)
+	require.Nil(t, tc.store.LookupReplica(splitKey))
+
+	// A zero-value result is reported rather than crashing.
+	_, err = r.ApplyReplicatedEvalResultForTesting(ctx, kvserverpb.ReplicatedEvalResult{})
+	require.True(t, testutils.IsError(err, "zero-value ReplicatedEvalResult"), "%v", err)
+
+	// So is a descriptor update contracting the range outside of a split or
+	// merge, which leaves the old descriptor in place.
+	contracted := *r.Desc()
+	contracted.EndKey = roachpb.RKey("c")
+	_, err = r.ApplyReplicatedEvalResultForTesting(ctx, kvserverpb.ReplicatedEvalResult{
+		State: &kvserverpb.ReplicaState{Desc: &contracted},
+	})
+	require.True(t, testutils.IsError(err, "contracts range bounds"), "%v", err)
+	require.Equal(t, splitKey, r.Desc().EndKey)
+
+	// So is a side effect that isn't handled below Raft.
+	_, err = r.ApplyReplicatedEvalResultForTesting(ctx, kvserverpb.ReplicatedEvalResult{
+		AddSSTable: &kvserverpb.ReplicatedEvalResult_AddSSTable{},
+	})
+	require.True(t, testutils.IsError(err, "unhandled field in ReplicatedEvalResult"), "%v", err)
+}
+
+// TestReplicaApplyReplicatedEvalResultAssertsState verifies that the side
+// effects of a result carrying a lease call for the in-memory and on-disk
+// states of the replica to be compared, while those of a result carrying only
+// trivial fields don't.
+func TestReplicaApplyReplicatedEvalResultAssertsState(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	tc := testContext{}
+	ctx := context.Background()
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+	cfg := TestStoreConfig(nil)
+	cfg.TestingKnobs.DisableAutomaticLeaseRenewal = true
+	tc.StartWithStoreConfig(t, stopper, cfg)
+	r := tc.repl
+
+	lease, _ := r.GetLease()
+	extended := lease
+	extended.Expiration = lease.Expiration.Add(1, 0).Clone()
+	shouldAssert, err := r.ApplyReplicatedEvalResultForTesting(ctx, kvserverpb.ReplicatedEvalResult{
+		State: &kvserverpb.ReplicaState{Lease: &extended},
+	})
+	require.NoError(t, err)
+	require.True(t, shouldAssert)
+
+	shouldAssert, err = r.ApplyReplicatedEvalResultForTesting(ctx, kvserverpb.ReplicatedEvalResult{
+		Timestamp: tc.Clock().Now(),
+		Delta:     enginepb.MVCCStatsDelta{KeyCount: 1},
+	})
+	require.NoError(t, err)
+	require.False(t, shouldAssert)
+}
+
+// TestReplicatedEvalResultStepOrder verifies that the side effects of a
+// ReplicatedEvalResult are carried out in the order given by
+// replicatedEvalResultHandlers, each of them at most once.
+func TestReplicatedEvalResultStepOrder(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	// The steps are all distinct.
+	seen := map[replicatedEvalResultStep]bool{}
+	for _, h := range replicatedEvalResultHandlers {
+		require.False(t, seen[h.step], "step %s listed twice", h.step)
+		seen[h.step] = true
+	}
+
+	tc := testContext{}
+	ctx := context.Background()
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+	cfg := TestStoreConfig(nil)
+	cfg.TestingKnobs.DisableAutomaticLeaseRenewal = true
+	var recording int32
+	var mu syncutil.Mutex
+	var steps []replicatedEvalResultStep
+	cfg.TestingKnobs.ReplicatedEvalResultStepEvent = func(_ roachpb.RangeID, step string) {
+		if atomic.LoadInt32(&recording) == 1 {
+			mu.Lock()
+			defer mu.Unlock()
+			steps = append(steps, replicatedEvalResultStep(step))
+		}
+	}
+	tc.StartWithStoreConfig(t, stopper, cfg)
+	r := tc.repl
+
+	// Build a result carrying every side effect but a merge, which can't be
+	// replayed without setting up the right-hand side on disk. Most of them
+	// leave the replica's state as it is.
+	state := r.StateSnapshot()
+	require.NotNil(t, state.TruncatedState)
+	splitKey := roachpb.RKey("m")
+	leftDesc := *state.Desc
+	leftDesc.EndKey = splitKey
+	rightDesc := *roachpb.NewRangeDescriptor(
+		state.Desc.RangeID+100, splitKey, state.Desc.EndKey, state.Desc.Replicas())
+	rResult := kvserverpb.ReplicatedEvalResult{
+		State: &kvserverpb.ReplicaState{
+			Desc:                 &leftDesc,
+			Lease:                state.Lease,
+			TruncatedState:       state.TruncatedState,
+			GCThreshold:          &hlc.Timestamp{},
+			UsingAppliedStateKey: true,
+		},
+		Split: &kvserverpb.Split{
+			SplitTrigger: roachpb.SplitTrigger{LeftDesc: leftDesc, RightDesc: rightDesc},
+		},
+		ChangeReplicas: &kvserverpb.ChangeReplicas{
+			ChangeReplicasTrigger: roachpb.ChangeReplicasTrigger{Desc: &leftDesc},
+		},
+		ComputeChecksum:            &kvserverpb.ComputeChecksum{Version: 1},
+		RaftLogDelta:               1,
+		SuggestedCompactions:       []kvserverpb.SuggestedCompaction{},
+		UpdateRaftLogQueueDisabled: true,
+	}
+
+	atomic.StoreInt32(&recording, 1)
+	_, err := r.ApplyReplicatedEvalResultForTesting(ctx, rResult)
+	atomic.StoreInt32(&recording, 0)
+	require.NoError(t, err)
+
+	var exp []replicatedEvalResultStep
+	for _, h := range replicatedEvalResultHandlers {
+		if h.step != stepMerge {
+			exp = append(exp, h.step)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, exp, steps)
+}
+
+// TestReplicatedEvalResultLeaseHandler verifies that the lease handler hands
+// the lease carried by a ReplicatedEvalResult to leasePostApply before
+// clearing it from the result, and that it only applies to results carrying a
+// lease.
+func TestReplicatedEvalResultLeaseHandler(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	tc := testContext{}
+	ctx := context.Background()
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+	cfg := TestStoreConfig(nil)
+	cfg.TestingKnobs.DisableAutomaticLeaseRenewal = true
+	var mu syncutil.Mutex
+	var applied []roachpb.Lease
+	cfg.TestingKnobs.LeasePostApplyInterceptor = func(
+		_ context.Context, lease roachpb.Lease,
+	) (time.Duration, bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		applied = append(applied, lease)
+		return 0, false
+	}
+	tc.StartWithStoreConfig(t, stopper, cfg)
+	r := tc.repl
+
+	lease, _ := r.GetLease()
+	extended := lease
+	extended.Expiration = lease.Expiration.Add(1, 0).Clone()
+	mu.Lock()
+	applied = nil
+	mu.Unlock()
+	_, err := r.ApplyReplicatedEvalResultForTesting(ctx, kvserverpb.ReplicatedEvalResult{
+		State: &kvserverpb.ReplicaState{Lease: &extended},
+	})
+	require.NoError(t, err)
+	mu.Lock()
+	require.Equal(t, []roachpb.Lease{extended}, applied)
+	applied = nil
+	mu.Unlock()
+	newLease, _ := r.GetLease()
+	require.Equal(t, extended, newLease)
+
+	// The handler is never invoked on a result without a lease, so it can
+	// hand the lease on without checking it.
+	var h *replicatedEvalResultHandler
+	for i := range replicatedEvalResultHandlers {
+		if replicatedEvalResultHandlers[i].step == stepLease {
+			h = &replicatedEvalResultHandlers[i]
+		}
+	}
+	require.NotNil(t, h)
+	require.False(t, h.applies(&kvserverpb.ReplicatedEvalResult{}))
+	require.False(t, h.applies(&kvserverpb.ReplicatedEvalResult{State: &kvserverpb.ReplicaState{}}))
+	require.True(t, h.applies(&kvserverpb.ReplicatedEvalResult{
+		State: &kvserverpb.ReplicaState{Lease: &extended},
+	}))
+}
+
+// TestReplicatedEvalResultLeaseOutsideDesc verifies that a lease which comes
+// with a descriptor update is only installed if the leaseholder is part of the
+// new descriptor.
+func TestReplicatedEvalResultLeaseOutsideDesc(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	tc := testContext{}
+	ctx := context.Background()
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+	cfg := TestStoreConfig(nil)
+	cfg.TestingKnobs.DisableAutomaticLeaseRenewal = true
+	tc.StartWithStoreConfig(t, stopper, cfg)
+	r := tc.repl
+
+	lease, _ := r.GetLease()
+	desc := *r.Desc()
+
+	// A lease naming a replica which the descriptor doesn't contain.
+	bogus := lease
+	bogus.Expiration = lease.Expiration.Add(1, 0).Clone()
+	bogus.Replica = roachpb.ReplicaDescriptor{NodeID: 2, StoreID: 2, ReplicaID: 2}
+	_, err := r.ApplyReplicatedEvalResultForTesting(ctx, kvserverpb.ReplicatedEvalResult{
+		State: &kvserverpb.ReplicaState{Desc: &desc, Lease: &bogus},
+	})
+	require.True(t, testutils.IsError(err, "missing from the accompanying descriptor"), "%v", err)
+	cur, _ := r.GetLease()
+	require.Equal(t, lease, cur)
+
+	// A lease naming one of the descriptor's replicas is installed.
+	extended := lease
+	extended.Expiration = lease.Expiration.Add(1, 0).Clone()
+	_, err = r.ApplyReplicatedEvalResultForTesting(ctx, kvserverpb.ReplicatedEvalResult{
+		State: &kvserverpb.ReplicaState{Desc: &desc, Lease: &extended},
+	})
+	require.NoError(t, err)
+	cur, _ = r.GetLease()
+	require.Equal(t, extended, cur)
+}
+
+// TestReplicatedEvalResultHandlers verifies that running a representative
+// command through replicatedEvalResultHandlers leaves the replica in the same
+// state as invoking the underlying side effect handlers directly.
+func TestReplicatedEvalResultHandlers(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+	start := func() *Replica {
+		tc := testContext{manualClock: hlc.NewManualClock(123)}
+		cfg := TestStoreConfig(hlc.NewClock(tc.manualClock.UnixNano, time.Nanosecond))
+		cfg.TestingKnobs.DisableAutomaticLeaseRenewal = true
+		tc.StartWithStoreConfig(t, stopper, cfg)
+		return tc.repl
+	}
+	viaHandlers, direct := start(), start()
+	raftLogSize := func(r *Replica) int64 {
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+		return r.mu.raftLogSize
+	}
+	viaHandlersLogSize, directLogSize := raftLogSize(viaHandlers), raftLogSize(direct)
+
+	gcThreshold := hlc.Timestamp{WallTime: 100}
+	const raftLogDelta = 1000
+	newResult := func() kvserverpb.ReplicatedEvalResult {
+		ts := gcThreshold
+		return kvserverpb.ReplicatedEvalResult{
+			State: &kvserverpb.ReplicaState{
+				GCThreshold:          &ts,
+				UsingAppliedStateKey: true,
+			},
+			RaftLogDelta: raftLogDelta,
+		}
+	}
+
+	shouldAssert, err := viaHandlers.ApplyReplicatedEvalResultForTesting(ctx, newResult())
+	require.NoError(t, err)
+	require.True(t, shouldAssert)
+
+	func() {
+		rResult := newResult()
+		direct.raftMu.Lock()
+		defer direct.raftMu.Unlock()
+		direct.handleGCThresholdResult(ctx, rResult.State.GCThreshold)
+		direct.handleRaftLogDeltaResult(ctx, rResult.RaftLogDelta)
+		direct.handleUsingAppliedStateKeyResult(ctx)
+	}()
+
+	type replicaState struct {
+		gcThreshold          hlc.Timestamp
+		usingAppliedStateKey bool
+		raftLogGrowth        int64
+	}
+	stateOf := func(r *Replica, initialLogSize int64) replicaState {
+		logSize := raftLogSize(r)
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+		return replicaState{
+			gcThreshold:          *r.mu.state.GCThreshold,
+			usingAppliedStateKey: r.mu.state.UsingAppliedStateKey,
+			raftLogGrowth:        logSize - initialLogSize,
+		}
+	}
+	got := stateOf(viaHandlers, viaHandlersLogSize)
+	require.Equal(t, stateOf(direct, directLogSize), got)
+	require.Equal(t, replicaState{
+		gcThreshold:          gcThreshold,
+		usingAppliedStateKey: true,
+		raftLogGrowth:        raftLogDelta,
+	}, got)
+}
+
+// TestReplicaTolerateUnhandledEvalResultFields verifies that, when the
+// kv.raft.tolerate_unhandled_eval_result_fields setting is enabled, fields of
+// evaluation results which a replica doesn't handle are logged and dropped
+// instead of crashing the node.
+func TestReplicaTolerateUnhandledEvalResultFields(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	ctx := context.Background()
+	tc := testContext{}
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+	tc.Start(t, stopper)
+	r := tc.repl
+
+	// Strict mode is the default.
+	_, err := r.ApplyReplicatedEvalResultForTesting(ctx, kvserverpb.ReplicatedEvalResult{
+		AddSSTable: &kvserverpb.ReplicatedEvalResult_AddSSTable{},
+	})
+	require.True(t, testutils.IsError(err, "unhandled field in ReplicatedEvalResult"), "%v", err)
+
+	tolerateUnhandledEvalResultFields.Override(&tc.store.cfg.Settings.SV, true)
+
+	var mu syncutil.Mutex
+	var logged []string
+	log.Intercept(ctx, func(entry log.Entry) {
+		if strings.Contains(entry.Message, "unhandled field in") {
+			mu.Lock()
+			defer mu.Unlock()
+			logged = append(logged, entry.Message)
+		}
+	})
+	defer log.Intercept(ctx, nil)
+
+	// Simulate a replicated field which this node doesn't know about by
+	// passing one which isn't handled below Raft.
+	shouldAssert, err := r.ApplyReplicatedEvalResultForTesting(ctx, kvserverpb.ReplicatedEvalResult{
+		AddSSTable: &kvserverpb.ReplicatedEvalResult_AddSSTable{},
+	})
+	require.NoError(t, err)
+	require.True(t, shouldAssert)
+
+	// Do the same for a local result on the read-only path, which doesn't
+	// handle GossipFirstRange.
+	var ba roachpb.BatchRequest
+	pErr := r.handleReadOnlyLocalEvalResult(ctx, &ba, result.LocalResult{GossipFirstRange: true})
+	require.Nil(t, pErr)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, logged, 2)
+	require.Contains(t, logged[0], "ReplicatedEvalResult")
+	require.Contains(t, logged[1], "LocalEvalResult")
+}
+
+// TestReplicaRecentReplicaChanges verifies that a replica keeps a bounded log
+// of the membership changes it applied, oldest first.
+func TestReplicaRecentReplicaChanges(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	tc := testContext{}
+	ctx := context.Background()
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+	tc.Start(t, stopper)
+
+	require.Empty(t, tc.repl.RecentReplicaChanges())
+
+	// Alternately add and remove a replica, for more changes than are retained.
+	const numChanges = maxRecentReplicaChanges + 5
+	desc := *tc.repl.Desc()
+	for i := 0; i < numChanges; i++ {
+		newDesc := desc
+		newDesc.Generation = desc.Generation + int64(i) + 1
+		target := roachpb.ReplicaDescriptor{NodeID: 2, StoreID: 2, ReplicaID: roachpb.ReplicaID(2 + i/2)}
+		trigger := roachpb.ChangeReplicasTrigger{Desc: &newDesc}
+		if i%2 == 0 {
+			trigger.InternalAddedReplicas = []roachpb.ReplicaDescriptor{target}
+		} else {
+			trigger.InternalRemovedReplicas = []roachpb.ReplicaDescriptor{target}
+		}
+		_, err := tc.repl.ApplyReplicatedEvalResultForTesting(ctx, kvserverpb.ReplicatedEvalResult{
+			ChangeReplicas: &kvserverpb.ChangeReplicas{ChangeReplicasTrigger: trigger},
+		})
+		require.NoError(t, err)
+	}
+
+	changes := tc.repl.RecentReplicaChanges()
+	require.Len(t, changes, maxRecentReplicaChanges)
+	for j, c := range changes {
+		i := numChanges - maxRecentReplicaChanges + j
+		require.Equal(t, desc.Generation+int64(i)+1, c.Generation)
+		target := []roachpb.ReplicaDescriptor{{NodeID: 2, StoreID: 2, ReplicaID: roachpb.ReplicaID(2 + i/2)}}
+		if i%2 == 0 {
+			require.Equal(t, target, c.Added)
+			require.Empty(t, c.Removed)
+		} else {
+			require.Empty(t, c.Added)
+			require.Equal(t, target, c.Removed)
+		}
+		if j > 0 {
+			require.False(t, c.Timestamp.Before(changes[j-1].Timestamp))
+		}
+	}
+}
+
+// TestReplicaStateSnapshot verifies that Replica.StateSnapshot reflects all of
+// the fields updated by an applied result, and that it is a deep copy of the
+// replica's state.
+func TestReplicaStateSnapshot(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	ctx := context.Background()
+	tc := testContext{}
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+	tc.Start(t, stopper)
+	r := tc.repl
+
+	before := r.StateSnapshot()
+	gcThreshold := hlc.Timestamp{WallTime: 123}
+	truncState := roachpb.RaftTruncatedState{
+		Index: before.TruncatedState.Index + 1,
+		Term:  before.TruncatedState.Term,
+	}
+	_, err := r.ApplyReplicatedEvalResultForTesting(ctx, kvserverpb.ReplicatedEvalResult{
+		State: &kvserverpb.ReplicaState{
+			GCThreshold:          &gcThreshold,
+			TruncatedState:       &truncState,
+			UsingAppliedStateKey: true,
+		},
+	})
+	require.NoError(t, err)
+
+	snap := r.StateSnapshot()
+	require.Equal(t, gcThreshold, *snap.GCThreshold)
+	require.Equal(t, truncState, *snap.TruncatedState)
+	require.True(t, snap.UsingAppliedStateKey)
+	require.Equal(t, before.RaftAppliedIndex, snap.RaftAppliedIndex)
+	require.Equal(t, before.LeaseAppliedIndex, snap.LeaseAppliedIndex)
+	require.Equal(t, *before.Lease, *snap.Lease)
+	require.Equal(t, *before.Desc, *snap.Desc)
+
+	// Mutating the snapshot leaves the replica's state alone.
+	snap.GCThreshold.WallTime++
+	snap.Desc.EndKey = roachpb.RKey("foo")
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	require.Equal(t, gcThreshold, *r.mu.state.GCThreshold)
+	require.Equal(t, before.Desc.EndKey, r.mu.state.Desc.EndKey)
+}
+
+// TestReplicaTruncatedStateRegression verifies that a truncated state which
+// regresses below the one already applied isn't installed, and that the Raft
+// entry cache is still cleared up to the higher of the two indexes.
+func TestReplicaTruncatedStateRegression(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	tc := testContext{}
+	ctx := context.Background()
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+	tc.Start(t, stopper)
+	r := tc.repl
+
+	r.raftMu.Lock()
+	defer r.raftMu.Unlock()
+
+	r.mu.RLock()
+	base := *r.mu.state.TruncatedState
+	r.mu.RUnlock()
+
+	populateCache := func() {
+		var ents []raftpb.Entry
+		for i := uint64(1); i <= 30; i++ {
+			ents = append(ents, raftpb.Entry{Index: base.Index + i, Term: base.Term})
+		}
+		tc.store.raftEntryCache.Add(r.RangeID, ents, false /* truncate */)
+	}
+	cached := func(idx uint64) bool {
+		_, ok := tc.store.raftEntryCache.Get(r.RangeID, idx)
+		return ok
+	}
+
+	populateCache()
+	newer := roachpb.RaftTruncatedState{Index: base.Index + 20, Term: base.Term}
+	r.handleTruncatedStateResult(ctx, &newer)
+	require.False(t, cached(newer.Index))
+	require.True(t, cached(newer.Index+1))
+
+	// Apply an older truncation after the newer one.
+	populateCache()
+	older := roachpb.RaftTruncatedState{Index: base.Index + 10, Term: base.Term}
+	r.handleTruncatedStateResult(ctx, &older)
+
+	r.mu.RLock()
+	require.Equal(t, newer, *r.mu.state.TruncatedState)
+	r.mu.RUnlock()
+	require.False(t, cached(older.Index+1))
+	require.False(t, cached(newer.Index))
+	require.True(t, cached(newer.Index+1))
+}
+
+// TestReplicaForceTruncatedState verifies that ForceTruncatedState installs a
+// truncated state and clears the Raft entry cache accordingly, and that it
+// refuses to regress the truncated state or to truncate beyond the applied
+// index.
+func TestReplicaForceTruncatedState(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	tc := testContext{}
+	ctx := context.Background()
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+	tc.Start(t, stopper)
+	r := tc.repl
+
+	// Advance the applied index past a few entries to truncate.
+	for i := 0; i < 5; i++ {
+		put := putArgs(roachpb.Key(fmt.Sprintf("k%d", i)), []byte("v"))
+		if _, pErr := tc.SendWrapped(&put); pErr != nil {
+			t.Fatal(pErr)
+		}
+	}
+	r.mu.RLock()
+	base := *r.mu.state.TruncatedState
+	appliedIndex := r.mu.state.RaftAppliedIndex
+	r.mu.RUnlock()
+	require.Greater(t, appliedIndex, base.Index+2)
+
+	var ents []raftpb.Entry
+	for idx := base.Index + 1; idx <= appliedIndex; idx++ {
+		ents = append(ents, raftpb.Entry{Index: idx, Term: base.Term})
+	}
+	tc.store.raftEntryCache.Add(r.RangeID, ents, false /* truncate */)
+	cached := func(idx uint64) bool {
+		_, ok := tc.store.raftEntryCache.Get(r.RangeID, idx)
+		return ok
+	}
+
+	forced := roachpb.RaftTruncatedState{Index: appliedIndex - 1, Term: base.Term}
+	require.NoError(t, r.ForceTruncatedState(ctx, forced))
+	r.mu.RLock()
+	require.Equal(t, forced, *r.mu.state.TruncatedState)
+	r.mu.RUnlock()
+	require.False(t, cached(base.Index+1))
+	require.False(t, cached(forced.Index))
+	require.True(t, cached(forced.Index+1))
+
+	// Neither a regression nor a truncation beyond the applied index is
+	// installed.
+	older := roachpb.RaftTruncatedState{Index: forced.Index - 1, Term: base.Term}
+	require.Error(t, r.ForceTruncatedState(ctx, older))
+	beyond := roachpb.RaftTruncatedState{Index: appliedIndex + 1, Term: base.Term}
+	require.Error(t, r.ForceTruncatedState(ctx, beyond))
+	r.mu.RLock()
+	require.Equal(t, forced, *r.mu.state.TruncatedState)
+	r.mu.RUnlock()
+	require.True(t, cached(forced.Index+1))
+}
+
+// TestReplicaStateMachineValidateAppliedStats verifies that, if enabled, the
+// application of a stats delta that drives a replica's stats negative is
+// flagged.
+func TestReplicaStateMachineValidateAppliedStats(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	testutils.RunTrueAndFalse(t, "validate", func(t *testing.T, validate bool) {
+		defer func(prev bool) { validateAppliedStats = prev }(validateAppliedStats)
+		validateAppliedStats = validate
+
+		tc := testContext{}
+		ctx := context.Background()
+		stopper := stop.NewStopper()
+		defer stopper.Stop(ctx)
+		tc.Start(t, stopper)
+
+		var mu syncutil.Mutex
+		var flagged []string
+		log.Intercept(ctx, func(entry log.Entry) {
+			if strings.Contains(entry.Message, "negative MVCC stats") {
+				mu.Lock()
+				defer mu.Unlock()
+				flagged = append(flagged, entry.Message)
+			}
+		})
+		defer log.Intercept(ctx, nil)
+
+		r := tc.repl
+		r.raftMu.Lock()
+		defer r.raftMu.Unlock()
+		sm := r.getStateMachine()
+
+		b := sm.NewBatch(false /* ephemeral */).(*replicaAppBatch)
+		defer b.Close()
+
+		// Stage a command whose delta removes more live bytes than the range
+		// has.
+		delta := enginepb.MVCCStats{LiveBytes: -(r.GetMVCCStats().LiveBytes + 1)}
+		cmd := &replicatedCmd{
+			ctx: ctx,
+			ent: &raftpb.Entry{
+				Index: r.mu.state.RaftAppliedIndex + 1,
+				Type:  raftpb.EntryNormal,
+			},
+			decodedRaftEntry: decodedRaftEntry{
+				idKey: makeIDKey(),
+				raftCmd: kvserverpb.RaftCommand{
+					ProposerLeaseSequence: r.mu.state.Lease.Sequence,
+					MaxLeaseIndex:         r.mu.state.LeaseAppliedIndex + 1,
+					ReplicatedEvalResult: kvserverpb.ReplicatedEvalResult{
+						Timestamp: r.mu.state.GCThreshold.Add(1, 0),
+						Delta:     delta.ToStatsDelta(),
+					},
+				},
+			},
+		}
+		_, err := b.Stage(cmd)
+		require.NoError(t, err)
+		require.True(t, b.state.Stats.LiveBytes < 0)
+
+		mu.Lock()
+		defer mu.Unlock()
+		if !validate {
+			require.Empty(t, flagged)
+			return
+		}
+		require.Len(t, flagged, 1)
+		require.Contains(t, flagged[0], "LiveBytes=-1")
+	})
+}
+
+// TestCheckStatsNonNegative tests the invariant check applied to MVCC stats
+// by maybeValidateAppliedStats.
+func TestCheckStatsNonNegative(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	require.NoError(t, checkStatsNonNegative(&enginepb.MVCCStats{}))
+	require.NoError(t, checkStatsNonNegative(&enginepb.MVCCStats{
+		LiveBytes: 1, KeyCount: 1, GCBytesAge: -1,
+	}))
+	err := checkStatsNonNegative(&enginepb.MVCCStats{LiveBytes: -3, KeyCount: 2, ValCount: -1})
+	require.EqualError(t, err, "negative MVCC stats: LiveBytes=-3, ValCount=-1")
+}
+
+// applyStatsDeltaForTesting applies a command carrying the given stats delta
+// through a replicaAppBatch. The caller must hold r.raftMu.
+func applyStatsDeltaForTesting(
+	ctx context.Context, t testing.TB, r *Replica, delta enginepb.MVCCStats,
+) {
+	sm := r.getStateMachine()
+	b := sm.NewBatch(false /* ephemeral */).(*replicaAppBatch)
+	defer b.Close()
+	r.mu.RLock()
+	cmd := &replicatedCmd{
+		ctx: ctx,
+		ent: &raftpb.Entry{Index: r.mu.state.RaftAppliedIndex + 1},
+		decodedRaftEntry: decodedRaftEntry{
+			idKey: makeIDKey(),
+			raftCmd: kvserverpb.RaftCommand{
+				ProposerLeaseSequence: r.mu.state.Lease.Sequence,
+				MaxLeaseIndex:         r.mu.state.LeaseAppliedIndex + 1,
+				ReplicatedEvalResult: kvserverpb.ReplicatedEvalResult{
+					Timestamp: r.mu.state.GCThreshold.Add(1, 0),
+					Delta:     delta.ToStatsDelta(),
+				},
+			},
+		},
+	}
+	r.mu.RUnlock()
+	_, err := b.Stage(cmd)
+	require.NoError(t, err)
+	require.NoError(t, b.ApplyToStateMachine(ctx))
+}
+
+// TestReplicaStateMachineStatsUnderContention verifies that the stats and
+// applied indexes published by ApplyToStateMachine are never observed in a
+// torn state by concurrent readers of r.mu, and that they end up reflecting
+// every applied command.
+func TestReplicaStateMachineStatsUnderContention(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	tc := testContext{}
+	ctx := context.Background()
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+	tc.Start(t, stopper)
+
+	// Lock the replica for the entire test so that no other command applies.
+	r := tc.repl
+	r.raftMu.Lock()
+	defer r.raftMu.Unlock()
+
+	const numCmds = 200
+	const bytesPerKey = 10
+	delta := enginepb.MVCCStats{LiveBytes: bytesPerKey, LiveCount: 1, KeyCount: 1}
+	initial := r.GetMVCCStats()
+	r.mu.RLock()
+	initialIndex := r.mu.state.LeaseAppliedIndex
+	r.mu.RUnlock()
+
+	var done int32
+	errCh := make(chan error, 4)
+	for i := 0; i < cap(errCh); i++ {
+		go func() {
+			errCh <- func() error {
+				for atomic.LoadInt32(&done) == 0 {
+					r.mu.RLock()
+					ms := *r.mu.state.Stats
+					applied := r.mu.state.LeaseAppliedIndex - initialIndex
+					r.mu.RUnlock()
+					// Every command increments the lease applied index and adds
+					// one key, so the two must always move in lockstep.
+					if n := ms.KeyCount - initial.KeyCount; n != int64(applied) {
+						return errors.Errorf("observed %d keys added after %d commands", n, applied)
+					}
+					if n := ms.LiveBytes - initial.LiveBytes; n != bytesPerKey*int64(applied) {
+						return errors.Errorf("observed %d live bytes added after %d commands", n, applied)
+					}
+				}
+				return nil
+			}()
+		}()
+	}
+
+	for i := 0; i < numCmds; i++ {
+		applyStatsDeltaForTesting(ctx, t, r, delta)
+	}
+	atomic.StoreInt32(&done, 1)
+	for i := 0; i < cap(errCh); i++ {
+		require.NoError(t, <-errCh)
+	}
+
+	expected := initial
+	for i := 0; i < numCmds; i++ {
+		expected.Add(delta)
+	}
+	require.Equal(t, expected, r.GetMVCCStats())
+	persisted, err := r.raftMu.stateLoader.LoadMVCCStats(ctx, tc.engine)
+	require.NoError(t, err)
+	require.Equal(t, expected, persisted)
+}
+
+// BenchmarkReplicaStateMachineApplyContended measures the application of
+// commands while other goroutines contend for r.mu as reads on a hot range
+// would.
+func BenchmarkReplicaStateMachineApplyContended(b *testing.B) {
+	defer leaktest.AfterTest(b)()
+	tc := testContext{}
+	ctx := context.Background()
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+	tc.Start(b, stopper)
+
+	r := tc.repl
+	r.raftMu.Lock()
+	defer r.raftMu.Unlock()
+
+	var done int32
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for atomic.LoadInt32(&done) == 0 {
+				_ = r.GetMVCCStats()
+			}
+		}()
+	}
+
+	delta := enginepb.MVCCStats{LiveBytes: 10, LiveCount: 1, KeyCount: 1}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		applyStatsDeltaForTesting(ctx, b, r, delta)
+	}
+	b.StopTimer()
+	atomic.StoreInt32(&done, 1)
+	wg.Wait()
+}
@@ -71,6 +71,21 @@ var backpressureByteTolerance = settings.RegisterByteSizeSetting(
 		"backpressure will not apply",
 	32<<20 /* 32 MiB */)
 
+// backpressureSplitQueueBacklog is the number of replicas waiting in the split
+// queue at or above which the queue is considered backlogged. While the queue
+// is backlogged, a range backpressures writes as soon as it has outgrown its
+// split size rather than backpressureRangeSizeMultiplier times that. This
+// targets bulk ingestion, where ranges can grow much faster than the split
+// queue is able to split them. Ranges exceeding the regular backpressure
+// threshold by more than backpressureByteTolerance remain exempt.
+var backpressureSplitQueueBacklog = settings.RegisterNonNegativeIntSetting(
+	"kv.range.backpressure_split_queue_backlog",
+	"number of replicas queued for splitting at or above which writes to ranges "+
+		"that exceed their split size are blocked even below the "+
+		"backpressure_range_size_multiplier, or 0 to disable",
+	0,
+)
+
 // backpressurableSpans contains spans of keys where write backpressuring
 // is permitted. Writes to any keys within these spans may cause a batch
 // to be backpressured.
@@ -109,9 +124,10 @@ func canBackpressureBatch(ba *roachpb.BatchRequest) bool {
 // shouldBackpressureWrites returns whether writes to the range should be
 // subject to backpressure. This is based on the size of the range in
 // relation to the split size. The method returns true if the range is more
-// than backpressureRangeSizeMultiplier times larger than the split size but not
-// larger than that by more than backpressureByteTolerance (see that comment for
-// further explanation).
+// than backpressureRangeSizeMultiplier times larger than the split size (or
+// larger than the split size at all while the split queue is backlogged, see
+// backpressureSplitQueueBacklog) but not larger than the former by more than
+// backpressureByteTolerance (see that comment for further explanation).
 func (r *Replica) shouldBackpressureWrites() bool {
 	mult := backpressureRangeSizeMultiplier.Get(&r.store.cfg.Settings.SV)
 	if mult == 0 {
@@ -122,13 +138,37 @@ func (r *Replica) shouldBackpressureWrites() bool {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 	exceeded, bytesOver := r.exceedsMultipleOfSplitSizeRLocked(mult)
-	if !exceeded {
+	if bytesOver > backpressureByteTolerance.Get(&r.store.cfg.Settings.SV) {
 		return false
 	}
-	if bytesOver > backpressureByteTolerance.Get(&r.store.cfg.Settings.SV) {
+	return exceeded || r.mu.splitQueueBackpressure
+}
+
+// splitQueueBacklogged returns whether the store's split queue holds at least
+// backpressureSplitQueueBacklog replicas. It is consulted on the apply path, so
+// the length is read from the queue's pending gauge rather than from the queue
+// itself, which would mean acquiring the queue's lock.
+func (r *Replica) splitQueueBacklogged() bool {
+	threshold := backpressureSplitQueueBacklog.Get(&r.store.cfg.Settings.SV)
+	if threshold == 0 {
+		// Disabled.
 		return false
 	}
-	return true
+	return r.store.metrics.SplitQueuePending.Value() >= threshold
+}
+
+// updateSplitQueueBackpressureLocked records whether writes to the range
+// should be backpressured because it has outgrown its split size while the
+// split queue is backlogged, as obtained from splitQueueBacklogged. It is
+// called on the apply path after the range's stats have been updated.
+//
+// Requires that r.mu is held.
+func (r *Replica) updateSplitQueueBackpressureLocked(size rangeSize, backlogged bool) {
+	var exceeded bool
+	if backlogged {
+		exceeded, _ = size.exceedsMultipleOfSplitSize(1)
+	}
+	r.mu.splitQueueBackpressure = exceeded
 }
 
 // maybeBackpressureBatch blocks to apply backpressure if the replica deems
@@ -16,6 +16,7 @@ import (
 	"crypto/sha512"
 	"encoding/binary"
 	"fmt"
+	"hash"
 	"math"
 	"sort"
 	"sync"
@@ -25,13 +26,16 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/keys"
 	"github.com/cockroachdb/cockroach/pkg/kv"
 	"github.com/cockroachdb/cockroach/pkg/kv/kvserver/batcheval"
+	"github.com/cockroachdb/cockroach/pkg/kv/kvserver/kvserverpb"
 	"github.com/cockroachdb/cockroach/pkg/kv/kvserver/rditer"
 	"github.com/cockroachdb/cockroach/pkg/kv/kvserver/stateloader"
 	"github.com/cockroachdb/cockroach/pkg/roachpb"
 	"github.com/cockroachdb/cockroach/pkg/rpc"
+	"github.com/cockroachdb/cockroach/pkg/settings"
 	"github.com/cockroachdb/cockroach/pkg/storage"
 	"github.com/cockroachdb/cockroach/pkg/storage/enginepb"
 	"github.com/cockroachdb/cockroach/pkg/util/bufalloc"
+	"github.com/cockroachdb/cockroach/pkg/util/ctxgroup"
 	"github.com/cockroachdb/cockroach/pkg/util/envutil"
 	"github.com/cockroachdb/cockroach/pkg/util/hlc"
 	"github.com/cockroachdb/cockroach/pkg/util/limit"
@@ -40,6 +44,7 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
 	"github.com/cockroachdb/cockroach/pkg/util/uuid"
 	"github.com/cockroachdb/errors"
+	"golang.org/x/time/rate"
 )
 
 // fatalOnStatsMismatch, if true, turns stats mismatches into fatal errors. A
@@ -61,12 +66,72 @@ type ReplicaChecksum struct {
 	CollectChecksumResponse
 	// started is true if the checksum computation has started.
 	started bool
+	// startTime is the time at which the computation started.
+	startTime time.Time
 	// If gcTimestamp is nonzero, GC this checksum after gcTimestamp. gcTimestamp
 	// is zero if and only if the checksum computation is in progress.
 	gcTimestamp time.Time
-	// This channel is closed after the checksum is computed, and is used
-	// as a notification.
+	// This channel is closed after the checksum is computed, which notifies
+	// all of the collectors waiting for it. It is created by whoever creates
+	// the entry first, be it a collector or the computation, and shared by
+	// everyone arriving later.
 	notify chan struct{}
+	// progress tracks how much of the replica data has been hashed. It is set
+	// when the computation starts.
+	progress *checksumProgress
+	// snapshotPath, if set, is the file to which the computation streamed the
+	// replica data (see consistencyCheckStreamSnapshots). It is loaded into
+	// Snapshot when the checksum is collected, and removed when the entry is
+	// GCed.
+	snapshotPath string
+	// skippedDraining is set if the computation was skipped because the store
+	// was draining when the ComputeChecksum command applied.
+	skippedDraining bool
+	// cancel aborts the computation. It is set while the computation is in
+	// progress.
+	cancel context.CancelFunc
+	// invalidated is set if the computation was cancelled because a split or
+	// merge changed the range's descriptor while it was in progress. Its
+	// result would not describe the replica anymore, so collectors should
+	// reissue the check.
+	invalidated bool
+}
+
+// done returns whether the computation has finished and notified its waiters.
+func (c *ReplicaChecksum) done() bool {
+	return c.started && !c.gcTimestamp.IsZero()
+}
+
+// checksumProgress tracks the progress of a checksum computation. It is
+// updated atomically as the data is hashed, so that it can be read without
+// holding Replica.mu and without slowing down the computation.
+type checksumProgress struct {
+	// hashed is the number of key and value bytes hashed so far.
+	hashed int64
+	// total is an estimate of the number of bytes to be hashed, derived from
+	// the replica's MVCCStats when the computation started.
+	total int64
+}
+
+// add records that n more bytes have been hashed.
+func (p *checksumProgress) add(n int) {
+	if p != nil {
+		atomic.AddInt64(&p.hashed, int64(n))
+	}
+}
+
+// fraction returns the estimated fraction of the computation that has
+// completed, in [0, 1].
+func (p *checksumProgress) fraction() float64 {
+	if p == nil || p.total <= 0 {
+		return 0
+	}
+	f := float64(atomic.LoadInt64(&p.hashed)) / float64(p.total)
+	if f > 1 {
+		// The total is only an estimate.
+		f = 1
+	}
+	return f
 }
 
 // CheckConsistency runs a consistency check on the range. It first applies a
@@ -83,12 +148,13 @@ func (r *Replica) CheckConsistency(
 	startKey := r.Desc().StartKey.AsRawKey()
 
 	checkArgs := roachpb.ComputeChecksumRequest{
-		RequestHeader: roachpb.RequestHeader{Key: startKey},
-		Version:       batcheval.ReplicaChecksumVersion,
-		Snapshot:      args.WithDiff,
-		Mode:          args.Mode,
-		Checkpoint:    args.Checkpoint,
-		Terminate:     args.Terminate,
+		RequestHeader:  roachpb.RequestHeader{Key: startKey},
+		Version:        batcheval.ReplicaChecksumVersion,
+		Snapshot:       args.WithDiff,
+		Mode:           args.Mode,
+		Checkpoint:     args.Checkpoint,
+		Terminate:      args.Terminate,
+		CollectIntents: args.CollectIntents,
 	}
 
 	isQueue := args.Mode == roachpb.ChecksumMode_CHECK_VIA_QUEUE
@@ -136,11 +202,13 @@ func (r *Replica) CheckConsistency(
 			}
 			for _, idx := range idxs {
 				_, _ = fmt.Fprintf(&buf, "%s: checksum %x%s\n"+
+					"- snapshot applied index: %d\n"+
 					"- stats: %+v\n"+
 					"- stats.Sub(recomputation): %+v\n",
 					&results[idx].Replica,
 					sha,
 					minority,
+					results[idx].Response.SnapshotAppliedIndex,
 					&results[idx].Response.Persisted,
 					&results[idx].Response.Delta,
 				)
@@ -153,6 +221,16 @@ func (r *Replica) CheckConsistency(
 					report(*r.store.Ident, diff)
 				}
 				_, _ = fmt.Fprintf(&buf, "====== diff(%x, [minority]) ======\n", sha)
+				if results[shaToIdxs[minoritySHA][0]].Response.SnapshotTruncated ||
+					results[shaToIdxs[sha][0]].Response.SnapshotTruncated {
+					_, _ = buf.WriteString("(partial: replica data exceeded " +
+						"server.consistency_check.max_snapshot_size and was truncated)\n")
+				}
+				if a, b := results[shaToIdxs[minoritySHA][0]].Response.SnapshotAppliedIndex,
+					results[shaToIdxs[sha][0]].Response.SnapshotAppliedIndex; a != b {
+					_, _ = fmt.Fprintf(&buf, "(replicas hashed snapshots at different "+
+						"applied indexes: %d [minority] vs %d)\n", a, b)
+				}
 				_, _ = diff.WriteTo(&buf)
 			}
 		}
@@ -167,6 +245,12 @@ func (r *Replica) CheckConsistency(
 	for _, result := range missing {
 		res.Detail += fmt.Sprintf("%s: error: %v\n", result.Replica, result.Err)
 	}
+	if args.CollectIntents {
+		res.Detail += fmt.Sprintf("intents: %d\n", results[0].Response.IntentCount)
+		for _, diff := range diffChecksumIntents(results) {
+			res.Detail += fmt.Sprintf("intent mismatch: %s\n", diff)
+		}
+	}
 
 	delta := enginepb.MVCCStats(results[0].Response.Delta)
 	var haveDelta bool
@@ -406,7 +490,9 @@ func (r *Replica) RunConsistencyCheck(
 
 // getChecksum waits for the result of ComputeChecksum and returns it.
 // It returns false if there is no checksum being computed for the id,
-// or it has already been GCed.
+// or it has already been GCed. Any number of callers may wait for the same
+// id concurrently; callers arriving after the computation finished return
+// its result right away.
 func (r *Replica) getChecksum(ctx context.Context, id uuid.UUID) (ReplicaChecksum, error) {
 	now := timeutil.Now()
 	r.mu.Lock()
@@ -422,20 +508,26 @@ func (r *Replica) getChecksum(ctx context.Context, id uuid.UUID) (ReplicaChecksu
 		c.notify = make(chan struct{})
 		r.mu.checksums[id] = c
 	}
+	done := c.done()
 	r.mu.Unlock()
 
-	// Wait for the checksum to compute or at least to start.
-	computed, err := r.checksumInitialWait(ctx, id, c.notify)
-	if err != nil {
-		return ReplicaChecksum{}, err
-	}
-	// If the checksum started, but has not completed commit
-	// to waiting the full deadline.
-	if !computed {
-		_, err = r.checksumWait(ctx, id, c.notify, nil)
+	if !done {
+		// Wait for the checksum to compute or at least to start.
+		computed, err := r.checksumInitialWait(ctx, id, c.notify)
 		if err != nil {
 			return ReplicaChecksum{}, err
 		}
+		// If the checksum started, but has not completed commit
+		// to waiting the full deadline.
+		if !computed {
+			if f, ok := r.ChecksumProgress(id); ok {
+				log.VEventf(ctx, 1, "r%d checksum computation (ID = %s) %.0f%% complete",
+					r.RangeID, id, 100*f)
+			}
+			if _, err := r.checksumWait(ctx, id, c.notify, nil); err != nil {
+				return ReplicaChecksum{}, err
+			}
+		}
 	}
 
 	if log.V(1) {
@@ -444,15 +536,106 @@ func (r *Replica) getChecksum(ctx context.Context, id uuid.UUID) (ReplicaChecksu
 	r.mu.RLock()
 	c, ok = r.mu.checksums[id]
 	r.mu.RUnlock()
+	if ok && c.skippedDraining {
+		return ReplicaChecksum{}, errors.Errorf(
+			"checksum computation skipped because the store is draining (ID = %s)", id)
+	}
+	if ok && c.invalidated {
+		return ReplicaChecksum{}, errors.Errorf(
+			"checksum computation invalidated by topology change (ID = %s)", id)
+	}
 	// If the checksum wasn't found or the checksum could not be computed, error out.
 	// The latter case can occur when there's a version mismatch or, more generally,
 	// when the (async) checksum computation fails.
 	if !ok || c.Checksum == nil {
 		return ReplicaChecksum{}, errors.Errorf("no checksum found (ID = %s)", id)
 	}
+	if c.snapshotPath != "" {
+		snapshot, err := loadChecksumSnapshot(r.store.engine, c.snapshotPath)
+		if err != nil {
+			return ReplicaChecksum{}, errors.Wrapf(err, "loading snapshot for checksum (ID = %s)", id)
+		}
+		c.Snapshot = snapshot
+	}
 	return c, nil
 }
 
+// ChecksumProgress returns the estimated fraction, in [0, 1], of the checksum
+// computation with the given ID that has completed. It returns false if no
+// such computation has started.
+func (r *Replica) ChecksumProgress(id uuid.UUID) (float64, bool) {
+	r.mu.RLock()
+	c, ok := r.mu.checksums[id]
+	r.mu.RUnlock()
+	if !ok || !c.started {
+		return 0, false
+	}
+	if c.done() {
+		return 1, true
+	}
+	return c.progress.fraction(), true
+}
+
+// oldestPendingChecksum returns the start time of the oldest checksum
+// computation in progress on the replica. It returns false if there is none.
+func (r *Replica) oldestPendingChecksum() (time.Time, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var oldest time.Time
+	for _, c := range r.mu.checksums {
+		if !c.started || c.done() {
+			continue
+		}
+		if oldest.IsZero() || c.startTime.Before(oldest) {
+			oldest = c.startTime
+		}
+	}
+	return oldest, !oldest.IsZero()
+}
+
+// ChecksumStatus describes a checksum computation tracked by a replica. See
+// Replica.PendingChecksums.
+type ChecksumStatus struct {
+	ID uuid.UUID
+	// Started is true if the computation has started, i.e. the replica has
+	// applied the corresponding ComputeChecksum command.
+	Started bool
+	// Computed is true if the computation has finished and produced a checksum.
+	Computed bool
+	// SkippedDraining is true if the computation was skipped because the store
+	// was draining.
+	SkippedDraining bool
+	// Invalidated is true if the computation was cancelled by a split or merge
+	// of the range.
+	Invalidated bool
+	// GCTimestamp is the time after which the entry is removed. It is zero
+	// while the computation is in progress.
+	GCTimestamp time.Time
+}
+
+// PendingChecksums returns the status of all checksum computations currently
+// tracked by the replica, ordered by ID. This includes computations which have
+// finished but whose results haven't been garbage collected yet.
+func (r *Replica) PendingChecksums() []ChecksumStatus {
+	r.mu.RLock()
+	statuses := make([]ChecksumStatus, 0, len(r.mu.checksums))
+	for id, c := range r.mu.checksums {
+		statuses = append(statuses, ChecksumStatus{
+			ID:              id,
+			Started:         c.started,
+			Computed:        c.Checksum != nil,
+			SkippedDraining: c.skippedDraining,
+			Invalidated:     c.invalidated,
+			GCTimestamp:     c.gcTimestamp,
+		})
+	}
+	r.mu.RUnlock()
+	sort.Slice(statuses, func(i, j int) bool {
+		return bytes.Compare(statuses[i].ID.GetBytes(), statuses[j].ID.GetBytes()) < 0
+	})
+	return statuses
+}
+
 // Waits for the checksum to be available or for the checksum to start computing.
 // If we waited for 10% of the deadline and it has not started, then it's
 // unlikely to start because this replica is most likely being restored from
@@ -508,49 +691,689 @@ func (r *Replica) checksumWait(
 	}
 }
 
+// ComputeChecksumSync computes the checksum of the replica's data as requested
+// by args and returns the digest directly. Unlike a ComputeChecksum command, it
+// doesn't go through Raft and doesn't record its result in r.mu.checksums, so
+// it's only suitable for debugging and admin tooling: the replicas of a range
+// computing it independently will in general not see the same data. The
+// computation counts against the store's limit on concurrent checksum
+// computations (see checksumScheduler), like those triggered by
+// ComputeChecksum commands.
+func (r *Replica) ComputeChecksumSync(
+	ctx context.Context, args *roachpb.ComputeChecksumRequest,
+) ([]byte, error) {
+	if args.Version != batcheval.ReplicaChecksumVersion {
+		return nil, errors.Errorf("incompatible checksum version (requested: %d, have: %d)",
+			args.Version, batcheval.ReplicaChecksumVersion)
+	}
+
+	if args.Mode != roachpb.ChecksumMode_CHECK_APPLIED_STATE {
+		if r.store.IsDraining() {
+			return nil, errors.New("checksum computation skipped because the store is draining")
+		}
+		// Wait for a slot before taking raftMu, which would stall Raft
+		// application in the meantime.
+		release, err := r.store.checksumScheduler.acquire(ctx, r.RangeID)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+	}
+
+	// Holding raftMu while opening the snapshot makes it consistent with the
+	// descriptor and applied state.
+	r.raftMu.Lock()
+	r.mu.RLock()
+	desc := *r.mu.state.Desc
+	raftAppliedIndex, leaseAppliedIndex := r.mu.state.RaftAppliedIndex, r.mu.state.LeaseAppliedIndex
+	stats := *r.mu.state.Stats
+	r.mu.RUnlock()
+	if args.Mode == roachpb.ChecksumMode_CHECK_APPLIED_STATE {
+		r.raftMu.Unlock()
+		return appliedStateDigest(raftAppliedIndex, leaseAppliedIndex, &desc).SHA512[:], nil
+	}
+	snap := r.store.engine.NewSnapshot()
+	r.raftMu.Unlock()
+	defer snap.Close()
+
+	var asOf hlc.Timestamp
+	if args.AsOf != nil {
+		asOf = *args.AsOf
+	}
+	limiter := limit.NewLimiter(rate.Limit(consistencyCheckRate.Get(&r.store.ClusterSettings().SV)))
+	shards := int(consistencyCheckShards.Get(&r.store.ClusterSettings().SV))
+	progress := &checksumProgress{total: stats.Total() + stats.SysBytes}
+
+	res, err := r.sha512(
+		ctx, desc, snap, nil /* snapshot */, args.Mode, asOf, args.ExcludedSpans, limiter,
+		r.store.consistencyIOLimiter(), shards, progress, nil, /* intents */
+	)
+	if err != nil {
+		return nil, err
+	}
+	return res.SHA512[:], nil
+}
+
+// ChecksumDiff re-scans the replica's data and compares it against ref, the
+// replica data captured by an earlier checksum computation (see the Snapshot
+// field of ComputeChecksumRequest). It returns the spans of keys over which
+// the two differ, which is empty if they match. Like ComputeChecksumSync, it
+// doesn't go through Raft, so it's only suitable for debugging and admin
+// tooling.
+func (r *Replica) ChecksumDiff(
+	ctx context.Context, ref *roachpb.RaftSnapshotData,
+) ([]KeyRangeDiff, error) {
+	if ref == nil {
+		return nil, errors.New("no reference snapshot to compare against")
+	}
+
+	// Holding raftMu while opening the snapshot makes it consistent with the
+	// descriptor.
+	r.raftMu.Lock()
+	r.mu.RLock()
+	desc := *r.mu.state.Desc
+	stats := *r.mu.state.Stats
+	r.mu.RUnlock()
+	snap := r.store.engine.NewSnapshot()
+	r.raftMu.Unlock()
+	defer snap.Close()
+
+	limiter := limit.NewLimiter(rate.Limit(consistencyCheckRate.Get(&r.store.ClusterSettings().SV)))
+	shards := int(consistencyCheckShards.Get(&r.store.ClusterSettings().SV))
+	progress := &checksumProgress{total: stats.Total() + stats.SysBytes}
+	cur := &roachpb.RaftSnapshotData{}
+	if _, err := r.sha512(
+		ctx, desc, snap, &memSnapshotSink{data: cur}, roachpb.ChecksumMode_CHECK_FULL,
+		hlc.Timestamp{}, nil /* excluded */, limiter, r.store.consistencyIOLimiter(),
+		shards, progress, nil, /* intents */
+	); err != nil {
+		return nil, err
+	}
+	return diffKeyRanges(ref, cur), nil
+}
+
 // computeChecksumDone adds the computed checksum, sets a deadline for GCing the
-// checksum, and sends out a notification.
+// checksum, and sends out a notification. The replica data captured by the
+// computation, if any, is passed either as snapshot or as the path of the file
+// it was streamed to.
 func (r *Replica) computeChecksumDone(
-	ctx context.Context, id uuid.UUID, result *replicaHash, snapshot *roachpb.RaftSnapshotData,
+	ctx context.Context,
+	id uuid.UUID,
+	result *replicaHash,
+	snapshot *roachpb.RaftSnapshotData,
+	snapshotPath string,
 ) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	if c, ok := r.mu.checksums[id]; ok {
-		if result != nil {
+		if c.done() {
+			// The first caller wins. A late call, typically an error path
+			// reporting a nil result after the computation already stored its
+			// digest, must not clobber that digest (which the collector would
+			// take for a failed computation) nor notify the waiters again.
+			log.VEventf(ctx, 2, "ignoring repeated completion of checksum (ID = %s)", id)
+			if snapshotPath != "" && snapshotPath != c.snapshotPath {
+				if err := r.store.engine.Remove(snapshotPath); err != nil {
+					log.Warningf(ctx, "unable to remove checksum snapshot %s: %v", snapshotPath, err)
+				}
+			}
+			return
+		}
+		if c.cancel != nil {
+			c.cancel()
+			c.cancel = nil
+		}
+		if result != nil && !c.invalidated {
 			c.Checksum = result.SHA512[:]
 
 			delta := result.PersistedMS
 			delta.Subtract(result.RecomputedMS)
 			c.Delta = enginepb.MVCCStatsDelta(delta)
 			c.Persisted = result.PersistedMS
+			c.SnapshotTruncated = result.SnapshotTruncated
+			c.SnapshotAppliedIndex = result.SnapshotAppliedIndex
+			c.IntentCount = result.IntentCount
+			c.Intents = result.Intents
 		}
-		c.gcTimestamp = timeutil.Now().Add(batcheval.ReplicaChecksumGCInterval)
+		now := timeutil.Now()
+		gcInterval := r.checksumGCInterval()
+		if c.started && now.Sub(c.startTime) > gcInterval {
+			// The computation took longer than its result is retained for,
+			// which suggests that the GC interval is too short.
+			r.store.metrics.ConsistencyQueueChecksumsOverGCInterval.Inc(1)
+		}
+		c.gcTimestamp = now.Add(gcInterval)
 		c.Snapshot = snapshot
+		c.snapshotPath = snapshotPath
+		if snapshotPath != "" && r.mu.destroyStatus.Removed() {
+			// The replica's files were already cleaned up; nobody will collect
+			// the snapshot.
+			if err := r.store.engine.Remove(snapshotPath); err != nil {
+				log.Warningf(ctx, "unable to remove checksum snapshot %s: %v", snapshotPath, err)
+			}
+			c.snapshotPath = ""
+		}
 		r.mu.checksums[id] = c
-		// Notify
+		// Notify all waiters.
 		close(c.notify)
 	} else {
 		// ComputeChecksum adds an entry into the map, and the entry can
 		// only be GCed once the gcTimestamp is set above. Something
 		// really bad happened.
 		log.Errorf(ctx, "no map entry for checksum (ID = %s)", id)
+		if snapshotPath != "" {
+			if err := r.store.engine.Remove(snapshotPath); err != nil {
+				log.Warningf(ctx, "unable to remove checksum snapshot %s: %v", snapshotPath, err)
+			}
+		}
+	}
+}
+
+// removeChecksumSnapshots removes the files to which the replica's finished
+// checksum computations streamed the replica data they captured. It is called
+// when the replica is destroyed.
+func (r *Replica) removeChecksumSnapshots(ctx context.Context) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for id, c := range r.mu.checksums {
+		if c.snapshotPath == "" {
+			continue
+		}
+		if err := r.store.engine.Remove(c.snapshotPath); err != nil {
+			log.Warningf(ctx, "unable to remove checksum snapshot %s: %v", c.snapshotPath, err)
+		}
+		c.snapshotPath = ""
+		r.mu.checksums[id] = c
+	}
+}
+
+// checksumGCInterval returns the time for which the result of a checksum
+// computation is retained after it finishes.
+func (r *Replica) checksumGCInterval() time.Duration {
+	if d := r.store.cfg.TestingKnobs.ConsistencyTestingKnobs.ChecksumGCInterval; d != 0 {
+		return d
+	}
+	return batcheval.ReplicaChecksumGCInterval
+}
+
+// computeChecksumSkippedDraining marks the checksum computation with the given
+// ID as skipped because the store is draining, and notifies its waiters.
+func (r *Replica) computeChecksumSkippedDraining(ctx context.Context, id uuid.UUID) {
+	r.mu.Lock()
+	if c, ok := r.mu.checksums[id]; ok {
+		c.skippedDraining = true
+		r.mu.checksums[id] = c
+	}
+	r.mu.Unlock()
+	r.computeChecksumDone(ctx, id, nil, nil, "")
+}
+
+// invalidateInFlightChecksums cancels the checksum computations which are in
+// progress on the replica. It is called when a split or merge applies, since
+// the computations hash the replica's data under its previous descriptor.
+func (r *Replica) invalidateInFlightChecksums(ctx context.Context) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for id, c := range r.mu.checksums {
+		if c.cancel == nil {
+			continue
+		}
+		log.VEventf(ctx, 1, "invalidating checksum computation (ID = %s) due to topology change", id)
+		c.cancel()
+		c.cancel = nil
+		c.invalidated = true
+		r.mu.checksums[id] = c
 	}
 }
 
 type replicaHash struct {
 	SHA512                    [sha512.Size]byte
 	PersistedMS, RecomputedMS enginepb.MVCCStats
+	// SnapshotTruncated is set if the replica data captured alongside the
+	// checksum was cut short by server.consistency_check.max_snapshot_size.
+	SnapshotTruncated bool
+	// SnapshotAppliedIndex is the raft applied index of the engine snapshot
+	// the checksum was computed over. The engine doesn't expose snapshot
+	// sequence numbers, so the applied index identifies the snapshot.
+	SnapshotAppliedIndex uint64
+	// IntentCount and Intents summarize the intents encountered by the
+	// computation, if it was asked to collect them.
+	IntentCount int64
+	Intents     []roachpb.Intent
+}
+
+// appliedStateDigest returns the result of a CHECK_APPLIED_STATE checksum
+// computation, which hashes the replica's applied indexes and descriptor
+// generation at the time of the computation instead of its data.
+func appliedStateDigest(
+	raftAppliedIndex, leaseAppliedIndex uint64, desc *roachpb.RangeDescriptor,
+) *replicaHash {
+	var buf [24]byte
+	binary.BigEndian.PutUint64(buf[0:8], raftAppliedIndex)
+	binary.BigEndian.PutUint64(buf[8:16], leaseAppliedIndex)
+	binary.BigEndian.PutUint64(buf[16:24], uint64(desc.Generation))
+	return &replicaHash{SHA512: sha512.Sum512(buf[:])}
+}
+
+// checksumWithoutSnapshotMaxSysBytes is the largest amount of range-local data
+// (e.g. abort span entries and transaction records, which aren't counted as
+// keys) that a replica without keys may hold for its checksum to be computed
+// without a snapshot. The computation then runs synchronously on the apply
+// path, outside of the store's checksumScheduler, so it has to stay cheap.
+const checksumWithoutSnapshotMaxSysBytes = 64 << 10 // 64 KiB
+
+// canChecksumWithoutSnapshot returns whether the checksum computation
+// described by cc can hash the replica's data directly from the engine, while
+// raftMu is held, instead of from an engine snapshot in an async task. This is
+// the case if the replica's stats are accurate and report no keys and little
+// range-local data, and the computation doesn't need to produce anything
+// beyond the digest.
+func canChecksumWithoutSnapshot(cc kvserverpb.ComputeChecksum, stats *enginepb.MVCCStats) bool {
+	if stats.KeyCount != 0 || stats.ContainsEstimates != 0 ||
+		stats.SysBytes > checksumWithoutSnapshotMaxSysBytes {
+		return false
+	}
+	switch cc.Mode {
+	case roachpb.ChecksumMode_CHECK_VIA_QUEUE, roachpb.ChecksumMode_CHECK_FULL:
+	default:
+		return false
+	}
+	return !cc.SaveSnapshot && !cc.Checkpoint && !cc.CollectIntents && len(cc.Terminate) == 0
+}
+
+// newConsistencyIOLimiter returns a limiter enforcing the store-wide budget for
+// the reads of checksum computations set by
+// server.consistency_check.store_io_budget.
+func newConsistencyIOLimiter(sv *settings.Values) *limit.LimiterBurstDisabled {
+	budget := consistencyCheckStoreIOBudget.Get(sv)
+	if budget <= 0 {
+		return limit.NewLimiter(rate.Inf)
+	}
+	return limit.NewLimiter(rate.Limit(budget))
+}
+
+// consistencyIOLimiter returns the limiter enforcing the store-wide budget for
+// the reads of checksum computations.
+func (s *Store) consistencyIOLimiter() *limit.LimiterBurstDisabled {
+	return s.consistencyIOBudget.Load().(*limit.LimiterBurstDisabled)
+}
+
+// checksumChunkBytes is the approximate amount of key/value data that is
+// hashed into each chunk digest. The replica checksum is the hash of the chunk
+// digests in key order. Chunk boundaries depend only on the data, so identical
+// replicas arrive at the same checksum regardless of how many shards the
+// chunks were spread across.
+var checksumChunkBytes = int64(4 << 20)
+
+// checksumKVHasher writes key/value pairs into a hash using the encoding
+// that replica checksums are defined over.
+type checksumKVHasher struct {
+	hash.Hash
+	intBuf          [8]byte
+	legacyTimestamp hlc.LegacyTimestamp
+	timestampBuf    []byte
+}
+
+func (h *checksumKVHasher) add(key storage.MVCCKey, value []byte) error {
+	// Encode the length of the key and value.
+	binary.LittleEndian.PutUint64(h.intBuf[:], uint64(len(key.Key)))
+	if _, err := h.Write(h.intBuf[:]); err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint64(h.intBuf[:], uint64(len(value)))
+	if _, err := h.Write(h.intBuf[:]); err != nil {
+		return err
+	}
+	if _, err := h.Write(key.Key); err != nil {
+		return err
+	}
+	h.legacyTimestamp = hlc.LegacyTimestamp(key.Timestamp)
+	if size := h.legacyTimestamp.Size(); size > cap(h.timestampBuf) {
+		h.timestampBuf = make([]byte, size)
+	} else {
+		h.timestampBuf = h.timestampBuf[:size]
+	}
+	if _, err := protoutil.MarshalTo(&h.legacyTimestamp, h.timestampBuf); err != nil {
+		return err
+	}
+	if _, err := h.Write(h.timestampBuf); err != nil {
+		return err
+	}
+	_, err := h.Write(value)
+	return err
+}
+
+// checksumChunk is a contiguous run of key/value pairs that is hashed into a
+// single digest on one of the shards.
+type checksumChunk struct {
+	keys   []storage.MVCCKey
+	values [][]byte
+	digest *[sha512.Size]byte
+}
+
+// checksumChunker splits the key/value pairs visited by Replica.sha512 into
+// chunks and collects the chunk digests in order. With a concurrency of one,
+// chunks are hashed inline. Otherwise, they are copied and handed to a pool of
+// shard goroutines. Only the hashing is parallelized: the pairs are still
+// produced by a single iterator, rate limited and copied on the caller's
+// goroutine, which bounds the speedup when reading the data dominates.
+type checksumChunker struct {
+	concurrency int
+	// size is the number of key and value bytes in the current chunk.
+	size    int64
+	digests []*[sha512.Size]byte
+
+	// inline hashes the current chunk when concurrency is one.
+	inline checksumKVHasher
+
+	// The fields below are only used when concurrency is above one.
+	alloc  bufalloc.ByteAllocator
+	cur    checksumChunk
+	work   chan checksumChunk
+	g      ctxgroup.Group
+	closed bool
+}
+
+func newChecksumChunker(ctx context.Context, concurrency int) *checksumChunker {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	c := &checksumChunker{
+		concurrency: concurrency,
+		inline:      checksumKVHasher{Hash: sha512.New()},
+	}
+	if concurrency == 1 {
+		return c
+	}
+	c.work = make(chan checksumChunk, concurrency)
+	c.g = ctxgroup.WithContext(ctx)
+	for i := 0; i < concurrency; i++ {
+		c.g.GoCtx(func(ctx context.Context) error {
+			h := checksumKVHasher{Hash: sha512.New()}
+			var err error
+			// Keep draining the work channel after an error so that the
+			// producer never blocks.
+			for chunk := range c.work {
+				if err != nil {
+					continue
+				}
+				h.Reset()
+				for i := range chunk.keys {
+					if err = h.add(chunk.keys[i], chunk.values[i]); err != nil {
+						break
+					}
+				}
+				h.Sum(chunk.digest[:0])
+			}
+			return err
+		})
+	}
+	return c
+}
+
+// add adds a key/value pair to the current chunk. The key and value may be
+// unsafe; they are copied if necessary.
+func (c *checksumChunker) add(key storage.MVCCKey, value []byte) error {
+	if c.concurrency == 1 {
+		if err := c.inline.add(key, value); err != nil {
+			return err
+		}
+	} else {
+		k := key
+		c.alloc, k.Key = c.alloc.Copy(key.Key, 0)
+		var v []byte
+		c.alloc, v = c.alloc.Copy(value, 0)
+		c.cur.keys = append(c.cur.keys, k)
+		c.cur.values = append(c.cur.values, v)
+	}
+	c.size += int64(len(key.Key) + len(value))
+	if c.size >= checksumChunkBytes {
+		c.flush()
+	}
+	return nil
+}
+
+// flush completes the current chunk, if it is non-empty.
+func (c *checksumChunker) flush() {
+	if c.size == 0 {
+		return
+	}
+	digest := new([sha512.Size]byte)
+	c.digests = append(c.digests, digest)
+	c.size = 0
+	if c.concurrency == 1 {
+		c.inline.Sum(digest[:0])
+		c.inline.Reset()
+		return
+	}
+	c.cur.digest = digest
+	c.work <- c.cur
+	c.cur = checksumChunk{}
+}
+
+// close stops the shard goroutines and waits for them to finish. It is
+// idempotent.
+func (c *checksumChunker) close() error {
+	if c.concurrency == 1 || c.closed {
+		return nil
+	}
+	c.closed = true
+	close(c.work)
+	return c.g.Wait()
+}
+
+// finish completes the last chunk and writes all of the chunk digests, in
+// order, into the supplied hasher.
+func (c *checksumChunker) finish(hasher hash.Hash) error {
+	c.flush()
+	if err := c.close(); err != nil {
+		return err
+	}
+	for _, digest := range c.digests {
+		if _, err := hasher.Write(digest[:]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verifyChecksumSnapshot hashes the given snapshot data, captured by a
+// checksum computation in the given mode, the same way Replica.sha512 hashed
+// the replica data, and returns an error if the result doesn't match the
+// digest of the computation.
+func verifyChecksumSnapshot(
+	ctx context.Context,
+	snapshot *roachpb.RaftSnapshotData,
+	mode roachpb.ChecksumMode,
+	digest [sha512.Size]byte,
+) error {
+	kvs := snapshot.KV
+	// In statsOnly mode, the RangeAppliedState is captured last. What was
+	// hashed is its encoding rather than that of the value holding it.
+	var appliedState *roachpb.RaftSnapshotData_KeyValue
+	if mode == roachpb.ChecksumMode_CHECK_STATS {
+		if len(kvs) == 0 {
+			return errors.AssertionFailedf("snapshot data is missing the range applied state")
+		}
+		appliedState, kvs = &kvs[len(kvs)-1], kvs[:len(kvs)-1]
+	}
+
+	hasher := sha512.New()
+	chunker := newChecksumChunker(ctx, 1 /* concurrency */)
+	defer func() { _ = chunker.close() }()
+	for _, kv := range kvs {
+		key := storage.MVCCKey{Key: kv.Key, Timestamp: kv.Timestamp}
+		if err := chunker.add(key, kv.Value); err != nil {
+			return err
+		}
+	}
+	if err := chunker.finish(hasher); err != nil {
+		return err
+	}
+	if appliedState != nil {
+		v := roachpb.Value{RawBytes: appliedState.Value}
+		var state enginepb.RangeAppliedState
+		if err := v.GetProto(&state); err != nil {
+			return errors.Wrap(err, "decoding range applied state from snapshot data")
+		}
+		b, err := protoutil.Marshal(&state)
+		if err != nil {
+			return err
+		}
+		if _, err := hasher.Write(b); err != nil {
+			return err
+		}
+	}
+
+	var computed [sha512.Size]byte
+	hasher.Sum(computed[:0])
+	if computed != digest {
+		return errors.AssertionFailedf(
+			"snapshot data hashes to %x, but the checksum computation produced %x", computed, digest)
+	}
+	return nil
+}
+
+// assertChecksumSnapshot verifies that the snapshot data captured by a
+// checksum computation, held either in memory or in the given file, matches
+// the digest of the computation. A mismatch indicates a bug in the capture of
+// the snapshot data and is fatal, as the data would be misleading when diffed
+// against that of other replicas.
+func (r *Replica) assertChecksumSnapshot(
+	ctx context.Context,
+	mode roachpb.ChecksumMode,
+	result *replicaHash,
+	snapshot *roachpb.RaftSnapshotData,
+	snapshotPath string,
+) {
+	if snapshotPath != "" {
+		var err error
+		if snapshot, err = loadChecksumSnapshot(r.store.engine, snapshotPath); err != nil {
+			log.Warningf(ctx, "unable to load checksum snapshot for verification: %+v", err)
+			return
+		}
+	}
+	if err := verifyChecksumSnapshot(ctx, snapshot, mode, result.SHA512); err != nil {
+		log.Fatalf(ctx, "checksum snapshot verification failed: %+v", err)
+	}
+}
+
+// maxChecksumIntents bounds the number of intents reported by a checksum
+// computation that collects intents. The count of intents is not bounded.
+const maxChecksumIntents = 1000
+
+// checksumIntents collects the intents encountered by a checksum computation
+// so that they can be cross-checked across replicas. It only records what is
+// found in the replica's data and doesn't look up the intents' transaction
+// records, which would turn the snapshot scan into a series of remote reads;
+// whether the intents are resolvable is left to intent resolution.
+type checksumIntents struct {
+	// count is the number of intents encountered.
+	count int64
+	// intents holds the first maxChecksumIntents intents encountered.
+	intents []roachpb.Intent
+}
+
+// add records the given key-value pair if it is an intent. The key must be
+// an MVCC metadata key.
+func (ci *checksumIntents) add(key storage.MVCCKey, value []byte) error {
+	var meta enginepb.MVCCMetadata
+	if err := protoutil.Unmarshal(value, &meta); err != nil {
+		return errors.Wrapf(err, "unable to decode MVCCMetadata for key %s", key)
+	}
+	if meta.Txn == nil {
+		// An inline value.
+		return nil
+	}
+	ci.count++
+	if len(ci.intents) < maxChecksumIntents {
+		ci.intents = append(ci.intents, roachpb.MakeIntent(meta.Txn, append(roachpb.Key(nil), key.Key...)))
+	}
+	return nil
+}
+
+// diffChecksumIntents compares the intents reported by the replicas of a
+// consistency check against those reported by the first (local) one, and
+// returns a description of each replica whose intents differ.
+func diffChecksumIntents(results []ConsistencyCheckResult) []string {
+	var diffs []string
+	if len(results) == 0 || results[0].Err != nil {
+		return diffs
+	}
+	master := &results[0]
+	for i := 1; i < len(results); i++ {
+		other := &results[i]
+		if other.Err != nil {
+			continue
+		}
+		if a, b := master.Response.IntentCount, other.Response.IntentCount; a != b {
+			diffs = append(diffs, fmt.Sprintf("%s: %d intents, %s has %d",
+				&other.Replica, b, &master.Replica, a))
+			continue
+		}
+		a, b := master.Response.Intents, other.Response.Intents
+		for j := 0; j < len(a) || j < len(b); j++ {
+			if j >= len(a) || j >= len(b) {
+				diffs = append(diffs, fmt.Sprintf("%s: reported %d intents, %s reported %d",
+					&other.Replica, len(b), &master.Replica, len(a)))
+				break
+			}
+			if !a[j].Equal(&b[j]) {
+				diffs = append(diffs, fmt.Sprintf("%s: intent %s differs from %s's intent %s",
+					&other.Replica, &b[j], &master.Replica, &a[j]))
+				break
+			}
+		}
+	}
+	return diffs
+}
+
+// visibleAsOf returns whether the given key-value pair is part of the data
+// visible at the given timestamp, for the purpose of computing a checksum as
+// of that timestamp. Versioned values are visible if they were written at or
+// below the timestamp. So are intents, along with their provisional values;
+// whether they are later committed or aborted is not taken into account, so
+// all replicas that have applied the same commands agree on the result.
+// Inline values can't be placed in time and are always visible, except for the
+// replicated range-ID-local bookkeeping (such as the applied state), which
+// changes with every command and would defeat the purpose of the timestamp.
+func visibleAsOf(key storage.MVCCKey, value []byte, asOf hlc.Timestamp) (bool, error) {
+	if key.IsValue() {
+		return key.Timestamp.LessEq(asOf), nil
+	}
+	var meta enginepb.MVCCMetadata
+	if err := protoutil.Unmarshal(value, &meta); err != nil {
+		return false, errors.Wrapf(err, "unable to decode MVCCMetadata for key %s", key)
+	}
+	if meta.IsInline() {
+		return !bytes.HasPrefix(key.Key, keys.LocalRangeIDPrefix), nil
+	}
+	return hlc.Timestamp(meta.Timestamp).LessEq(asOf), nil
 }
 
 // sha512 computes the SHA512 hash of all the replica data at the snapshot.
-// It will dump all the kv data into snapshot if it is provided.
+// If asOf is set, only the data visible at that timestamp is hashed (see
+// visibleAsOf), and the keys within the excluded spans are never hashed; the
+// recomputed stats still cover all of the data.
+// The scan is paced by limiter and, if it's non-nil, by storeLimiter, the
+// store-wide budget shared with the other checks (see consistencyIOLimiter).
+// It will pass all the kv data to snapshot if it is provided. The data is
+// read by a single iterator and hashed in chunks which are spread across up to
+// the given number of shards (see checksumChunker). If progress is non-nil, it is
+// updated as the data is scanned. If intents is non-nil, the hashed intents
+// are recorded in it and summarized in the result.
 func (r *Replica) sha512(
 	ctx context.Context,
 	desc roachpb.RangeDescriptor,
 	snap storage.Reader,
-	snapshot *roachpb.RaftSnapshotData,
+	snapshot checksumSnapshotSink,
 	mode roachpb.ChecksumMode,
-	limiter *limit.LimiterBurstDisabled,
+	asOf hlc.Timestamp,
+	excluded []roachpb.Span,
+	limiter, storeLimiter *limit.LimiterBurstDisabled,
+	shards int,
+	progress *checksumProgress,
+	intents *checksumIntents,
 ) (*replicaHash, error) {
 	statsOnly := mode == roachpb.ChecksumMode_CHECK_STATS
 
@@ -558,54 +1381,52 @@ func (r *Replica) sha512(
 	iter := snap.NewIterator(storage.IterOptions{UpperBound: desc.EndKey.AsRawKey()})
 	defer iter.Close()
 
-	var alloc bufalloc.ByteAllocator
-	var intBuf [8]byte
-	var legacyTimestamp hlc.LegacyTimestamp
-	var timestampBuf []byte
 	hasher := sha512.New()
+	chunker := newChecksumChunker(ctx, shards)
+	defer func() { _ = chunker.close() }()
 
 	visitor := func(unsafeKey storage.MVCCKey, unsafeValue []byte) error {
-		// Rate Limit the scan through the range
+		// Rate Limit the scan through the range, both on its own and against
+		// the other checks on the store.
 		if err := limiter.WaitN(ctx, len(unsafeKey.Key)+len(unsafeValue)); err != nil {
 			return err
 		}
-
-		if snapshot != nil {
-			// Add (a copy of) the kv pair into the debug message.
-			kv := roachpb.RaftSnapshotData_KeyValue{
-				Timestamp: unsafeKey.Timestamp,
+		if storeLimiter != nil {
+			if err := storeLimiter.WaitN(ctx, len(unsafeKey.Key)+len(unsafeValue)); err != nil {
+				return err
 			}
-			alloc, kv.Key = alloc.Copy(unsafeKey.Key, 0)
-			alloc, kv.Value = alloc.Copy(unsafeValue, 0)
-			snapshot.KV = append(snapshot.KV, kv)
 		}
+		progress.add(len(unsafeKey.Key) + len(unsafeValue))
 
-		// Encode the length of the key and value.
-		binary.LittleEndian.PutUint64(intBuf[:], uint64(len(unsafeKey.Key)))
-		if _, err := hasher.Write(intBuf[:]); err != nil {
-			return err
-		}
-		binary.LittleEndian.PutUint64(intBuf[:], uint64(len(unsafeValue)))
-		if _, err := hasher.Write(intBuf[:]); err != nil {
-			return err
-		}
-		if _, err := hasher.Write(unsafeKey.Key); err != nil {
-			return err
+		if !asOf.IsEmpty() {
+			visible, err := visibleAsOf(unsafeKey, unsafeValue, asOf)
+			if err != nil {
+				return err
+			}
+			if !visible {
+				return nil
+			}
 		}
-		legacyTimestamp = hlc.LegacyTimestamp(unsafeKey.Timestamp)
-		if size := legacyTimestamp.Size(); size > cap(timestampBuf) {
-			timestampBuf = make([]byte, size)
-		} else {
-			timestampBuf = timestampBuf[:size]
+		for i := range excluded {
+			if excluded[i].ContainsKey(unsafeKey.Key) {
+				return nil
+			}
 		}
-		if _, err := protoutil.MarshalTo(&legacyTimestamp, timestampBuf); err != nil {
-			return err
+
+		if snapshot != nil {
+			// Add the kv pair to the debug message.
+			if err := snapshot.add(unsafeKey, unsafeValue); err != nil {
+				return err
+			}
 		}
-		if _, err := hasher.Write(timestampBuf); err != nil {
-			return err
+
+		if intents != nil && !unsafeKey.IsValue() {
+			if err := intents.add(unsafeKey, unsafeValue); err != nil {
+				return err
+			}
 		}
-		_, err := hasher.Write(unsafeValue)
-		return err
+
+		return chunker.add(unsafeKey, unsafeValue)
 	}
 
 	var ms enginepb.MVCCStats
@@ -622,9 +1443,16 @@ func (r *Replica) sha512(
 			ms.Add(spanMS)
 		}
 	}
+	if err := chunker.finish(hasher); err != nil {
+		return nil, err
+	}
 
 	var result replicaHash
 	result.RecomputedMS = ms
+	if intents != nil {
+		result.IntentCount = intents.count
+		result.Intents = intents.intents
+	}
 
 	rangeAppliedState, err := stateloader.Make(desc.RangeID).LoadRangeAppliedState(ctx, snap)
 	if err != nil {
@@ -646,16 +1474,14 @@ func (r *Replica) sha512(
 		}
 		if snapshot != nil {
 			// Add LeaseAppliedState to the diff.
-			kv := roachpb.RaftSnapshotData_KeyValue{
-				Timestamp: hlc.Timestamp{},
-			}
-			kv.Key = keys.RangeAppliedStateKey(desc.RangeID)
 			var v roachpb.Value
 			if err := v.SetProto(rangeAppliedState); err != nil {
 				return nil, err
 			}
-			kv.Value = v.RawBytes
-			snapshot.KV = append(snapshot.KV, kv)
+			key := storage.MVCCKey{Key: keys.RangeAppliedStateKey(desc.RangeID)}
+			if err := snapshot.add(key, v.RawBytes); err != nil {
+				return nil, err
+			}
 		}
 		if _, err := hasher.Write(b); err != nil {
 			return nil, err
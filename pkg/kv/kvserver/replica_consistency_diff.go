@@ -79,6 +79,59 @@ func (rsds ReplicaSnapshotDiffSlice) String() string {
 	return buf.String()
 }
 
+// KeyRangeDiff is a span of keys over which two kv dumps of a replica's data
+// differ, along with the differing key-value pairs.
+type KeyRangeDiff struct {
+	// Span starts at the first differing key and ends right after the last one.
+	Span roachpb.Span
+	// Diff holds the differing key-value pairs in key order. Pairs which are
+	// only present in the left-hand (reference) dump have LeaseHolder set.
+	Diff ReplicaSnapshotDiffSlice
+}
+
+// diffKeyRanges diffs two kv dumps like diffRange, and groups the differing
+// key-value pairs into spans of keys. Differing keys end up in the same span
+// unless they're separated by a key which the dumps agree on.
+func diffKeyRanges(l, r *roachpb.RaftSnapshotData) []KeyRangeDiff {
+	diff := diffRange(l, r)
+	if len(diff) == 0 {
+		return nil
+	}
+
+	// Number the distinct keys of both dumps in key order, which tells us
+	// whether two differing keys are adjacent.
+	pos := make(map[string]int)
+	addKey := func(key roachpb.Key) {
+		if _, ok := pos[string(key)]; !ok {
+			pos[string(key)] = len(pos)
+		}
+	}
+	i, j := 0, 0
+	for i < len(l.KV) || j < len(r.KV) {
+		if j == len(r.KV) || (i < len(l.KV) && bytes.Compare(l.KV[i].Key, r.KV[j].Key) <= 0) {
+			addKey(l.KV[i].Key)
+			i++
+		} else {
+			addKey(r.KV[j].Key)
+			j++
+		}
+	}
+
+	var res []KeyRangeDiff
+	var last int
+	for _, d := range diff {
+		p := pos[string(d.Key)]
+		if len(res) == 0 || p > last+1 {
+			res = append(res, KeyRangeDiff{Span: roachpb.Span{Key: d.Key}})
+		}
+		krd := &res[len(res)-1]
+		krd.Span.EndKey = d.Key.Next()
+		krd.Diff = append(krd.Diff, d)
+		last = p
+	}
+	return res
+}
+
 // diffs the two kv dumps between the lease holder and the replica.
 func diffRange(l, r *roachpb.RaftSnapshotData) ReplicaSnapshotDiffSlice {
 	if l == nil || r == nil {
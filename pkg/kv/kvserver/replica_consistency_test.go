@@ -11,18 +11,28 @@
 package kvserver
 
 import (
+	"bytes"
 	"context"
+	"fmt"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/cockroachdb/cockroach/pkg/kv/kvserver/batcheval"
 	"github.com/cockroachdb/cockroach/pkg/kv/kvserver/kvserverpb"
 	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/storage"
+	"github.com/cockroachdb/cockroach/pkg/storage/enginepb"
 	"github.com/cockroachdb/cockroach/pkg/testutils"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
 	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/limit"
 	"github.com/cockroachdb/cockroach/pkg/util/stop"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
 	"github.com/cockroachdb/cockroach/pkg/util/uuid"
+	"github.com/cockroachdb/errors"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
 )
 
 func TestReplicaChecksumVersion(t *testing.T) {
@@ -116,3 +126,1113 @@ func TestGetChecksumNotSuccessfulExitConditions(t *testing.T) {
 	}
 	require.Nil(t, rc.Checksum)
 }
+
+// TestReplicaChecksumMultipleWaiters verifies that all of the collectors
+// waiting for a checksum computation are notified of its result, and that a
+// collector arriving after it finished gets the result without waiting.
+func TestReplicaChecksumMultipleWaiters(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	tc := testContext{}
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+	tc.Start(t, stopper)
+
+	cc := kvserverpb.ComputeChecksum{
+		ChecksumID: uuid.FastMakeV4(),
+		Version:    batcheval.ReplicaChecksumVersion,
+		Mode:       roachpb.ChecksumMode_CHECK_APPLIED_STATE,
+	}
+	var wg sync.WaitGroup
+	checksums := make([][]byte, 2)
+	errs := make([]error, 2)
+	for i := range checksums {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			rc, err := tc.repl.getChecksum(ctx, cc.ChecksumID)
+			checksums[i], errs[i] = rc.Checksum, err
+		}(i)
+	}
+	// Let the collectors register before the computation starts.
+	testutils.SucceedsSoon(t, func() error {
+		tc.repl.mu.RLock()
+		defer tc.repl.mu.RUnlock()
+		if _, ok := tc.repl.mu.checksums[cc.ChecksumID]; !ok {
+			return errors.New("no collector waiting yet")
+		}
+		return nil
+	})
+	tc.repl.computeChecksumPostApply(ctx, cc)
+	wg.Wait()
+	for i := range checksums {
+		require.NoError(t, errs[i])
+		require.NotNil(t, checksums[i])
+	}
+	require.Equal(t, checksums[0], checksums[1])
+
+	// A late collector gets the result right away, even if it isn't willing to
+	// wait at all.
+	lateCtx, lateCancel := context.WithCancel(context.Background())
+	lateCancel()
+	rc, err := tc.repl.getChecksum(lateCtx, cc.ChecksumID)
+	require.NoError(t, err)
+	require.Equal(t, checksums[0], rc.Checksum)
+}
+
+// TestReplicaComputeChecksumSync verifies that a checksum computed
+// synchronously matches the one computed by a ComputeChecksum command for the
+// same data.
+func TestReplicaComputeChecksumSync(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	tc := testContext{}
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+	tc.Start(t, stopper)
+
+	for i := 0; i < 10; i++ {
+		put := putArgs(roachpb.Key(fmt.Sprintf("key-%03d", i)), []byte(fmt.Sprintf("value-%03d", i)))
+		if _, pErr := tc.SendWrapped(&put); pErr != nil {
+			t.Fatal(pErr)
+		}
+	}
+
+	for _, mode := range []roachpb.ChecksumMode{
+		roachpb.ChecksumMode_CHECK_FULL,
+		roachpb.ChecksumMode_CHECK_STATS,
+		roachpb.ChecksumMode_CHECK_APPLIED_STATE,
+	} {
+		t.Run(mode.String(), func(t *testing.T) {
+			cc := kvserverpb.ComputeChecksum{
+				ChecksumID: uuid.FastMakeV4(),
+				Version:    batcheval.ReplicaChecksumVersion,
+				Mode:       mode,
+			}
+			tc.repl.computeChecksumPostApply(ctx, cc)
+			rc, err := tc.repl.getChecksum(ctx, cc.ChecksumID)
+			require.NoError(t, err)
+
+			checksum, err := tc.repl.ComputeChecksumSync(ctx, &roachpb.ComputeChecksumRequest{
+				Version: batcheval.ReplicaChecksumVersion,
+				Mode:    mode,
+			})
+			require.NoError(t, err)
+			require.Equal(t, rc.Checksum, checksum)
+		})
+	}
+
+	_, err := tc.repl.ComputeChecksumSync(ctx, &roachpb.ComputeChecksumRequest{
+		Version: batcheval.ReplicaChecksumVersion + 1,
+	})
+	require.Error(t, err)
+}
+
+// TestReplicaChecksumAppliedState verifies that a CHECK_APPLIED_STATE checksum
+// is derived from the applied state of the replica: replicas which have
+// applied the same commands agree on it, while replicas at different applied
+// indexes don't.
+func TestReplicaChecksumAppliedState(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	tc := testContext{}
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+	tc.Start(t, stopper)
+
+	// computeChecksum runs a checksum computation on the replica, returning the
+	// resulting checksum along with that of a replica in sync with it.
+	computeChecksum := func() (checksum, inSync []byte) {
+		// Hold raftMu, as is the case during command application, so that the
+		// replica's applied state doesn't change underneath us.
+		tc.repl.raftMu.Lock()
+		defer tc.repl.raftMu.Unlock()
+		tc.repl.mu.Lock()
+		raftAppliedIndex := tc.repl.mu.state.RaftAppliedIndex
+		leaseAppliedIndex := tc.repl.mu.state.LeaseAppliedIndex
+		desc := *tc.repl.mu.state.Desc
+		tc.repl.mu.Unlock()
+
+		cc := kvserverpb.ComputeChecksum{
+			ChecksumID: uuid.FastMakeV4(),
+			Version:    batcheval.ReplicaChecksumVersion,
+			Mode:       roachpb.ChecksumMode_CHECK_APPLIED_STATE,
+		}
+		tc.repl.computeChecksumPostApply(ctx, cc)
+		rc, err := tc.repl.getChecksum(ctx, cc.ChecksumID)
+		require.NoError(t, err)
+		require.Nil(t, rc.Snapshot)
+		require.Equal(t, enginepb.MVCCStatsDelta{}, rc.Delta)
+		return rc.Checksum, appliedStateDigest(raftAppliedIndex, leaseAppliedIndex, &desc).SHA512[:]
+	}
+
+	checksum1, inSync1 := computeChecksum()
+	require.Equal(t, inSync1, checksum1)
+
+	// Apply another command, moving the replica's applied indexes forward.
+	put := putArgs(roachpb.Key("a"), []byte("value"))
+	if _, pErr := tc.SendWrapped(&put); pErr != nil {
+		t.Fatal(pErr)
+	}
+
+	checksum2, inSync2 := computeChecksum()
+	require.Equal(t, inSync2, checksum2)
+	require.NotEqual(t, checksum1, checksum2)
+}
+
+// TestReplicaChecksumSnapshotAppliedIndex verifies that a checksum records the
+// applied index of the snapshot it was computed over.
+func TestReplicaChecksumSnapshotAppliedIndex(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	tc := testContext{}
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+	tc.Start(t, stopper)
+
+	testutils.RunTrueAndFalse(t, "appliedState", func(t *testing.T, appliedState bool) {
+		// Apply a command so that each subtest sees a different applied index.
+		put := putArgs(roachpb.Key("a"), []byte("value"))
+		if _, pErr := tc.SendWrapped(&put); pErr != nil {
+			t.Fatal(pErr)
+		}
+
+		cc := kvserverpb.ComputeChecksum{
+			ChecksumID: uuid.FastMakeV4(),
+			Version:    batcheval.ReplicaChecksumVersion,
+			Mode:       roachpb.ChecksumMode_CHECK_FULL,
+		}
+		if appliedState {
+			cc.Mode = roachpb.ChecksumMode_CHECK_APPLIED_STATE
+		}
+		// Hold raftMu, as is the case during command application, so that the
+		// applied index can't move between reading it and taking the snapshot.
+		tc.repl.raftMu.Lock()
+		tc.repl.mu.Lock()
+		raftAppliedIndex := tc.repl.mu.state.RaftAppliedIndex
+		tc.repl.mu.Unlock()
+		tc.repl.computeChecksumPostApply(ctx, cc)
+		tc.repl.raftMu.Unlock()
+
+		rc, err := tc.repl.getChecksum(ctx, cc.ChecksumID)
+		require.NoError(t, err)
+		require.NotNil(t, rc.Checksum)
+		require.NotZero(t, rc.SnapshotAppliedIndex)
+		require.Equal(t, raftAppliedIndex, rc.SnapshotAppliedIndex)
+	})
+}
+
+// TestReplicaChecksumInjectedSnapshot verifies that the ChecksumSnapshot
+// testing knob feeds the replica data hashed by a checksum computation.
+func TestReplicaChecksumInjectedSnapshot(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+
+	var source storage.Engine
+	tc := testContext{}
+	cfg := TestStoreConfig(nil)
+	cfg.TestingKnobs.ConsistencyTestingKnobs.ChecksumSnapshot = func(
+		roachpb.RangeID,
+	) (storage.Reader, func()) {
+		snap := source.NewSnapshot()
+		return snap, snap.Close
+	}
+	tc.StartWithStoreConfig(t, stopper, cfg)
+
+	// newSource returns an engine holding a fixed set of keys, the last of
+	// which has the given value.
+	newSource := func(last string) storage.Engine {
+		eng := storage.NewDefaultInMem()
+		stopper.AddCloser(eng)
+		ts := hlc.Timestamp{WallTime: 1}
+		for _, kv := range [][2]string{{"a", "1"}, {"b", "2"}, {"c", last}} {
+			var v roachpb.Value
+			v.SetString(kv[1])
+			require.NoError(t, storage.MVCCPut(
+				ctx, eng, nil /* ms */, roachpb.Key(kv[0]), ts, v, nil, /* txn */
+			))
+		}
+		return eng
+	}
+	checksum := func(eng storage.Engine) []byte {
+		source = eng
+		cc := kvserverpb.ComputeChecksum{
+			ChecksumID: uuid.FastMakeV4(),
+			Version:    batcheval.ReplicaChecksumVersion,
+			Mode:       roachpb.ChecksumMode_CHECK_FULL,
+		}
+		tc.repl.computeChecksumPostApply(ctx, cc)
+		rc, err := tc.repl.getChecksum(ctx, cc.ChecksumID)
+		require.NoError(t, err)
+		require.NotNil(t, rc.Checksum)
+		return rc.Checksum
+	}
+
+	good := newSource("3")
+	snap := good.NewSnapshot()
+	expected, err := tc.repl.sha512(
+		ctx, *tc.repl.Desc(), snap, nil /* snapshot */, roachpb.ChecksumMode_CHECK_FULL,
+		hlc.Timestamp{}, nil /* excluded */, limit.NewLimiter(rate.Inf), nil /* storeLimiter */, 1 /* shards */, nil /* progress */, nil, /* intents */
+	)
+	snap.Close()
+	require.NoError(t, err)
+
+	require.Equal(t, expected.SHA512[:], checksum(good))
+	require.Equal(t, expected.SHA512[:], checksum(newSource("3")))
+	require.NotEqual(t, expected.SHA512[:], checksum(newSource("corrupt")))
+}
+
+// TestReplicaChecksumEmptyRange verifies that a checksum computation on a
+// replica without any keys doesn't open an engine snapshot nor wait on the
+// rate limits, and yields the same checksum and stats as hashing a snapshot
+// of the replica.
+func TestReplicaChecksumEmptyRange(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+
+	var snapshots int
+	tc := testContext{bootstrapMode: bootstrapRangeOnly}
+	cfg := TestStoreConfig(nil)
+	cfg.TestingKnobs.ConsistencyTestingKnobs.ChecksumSnapshot = func(
+		roachpb.RangeID,
+	) (storage.Reader, func()) {
+		snapshots++
+		snap := tc.engine.NewSnapshot()
+		return snap, snap.Close
+	}
+	tc.StartWithStoreConfig(t, stopper, cfg)
+
+	checksum := func(mode roachpb.ChecksumMode) ReplicaChecksum {
+		cc := kvserverpb.ComputeChecksum{
+			ChecksumID: uuid.FastMakeV4(),
+			Version:    batcheval.ReplicaChecksumVersion,
+			Mode:       mode,
+		}
+		tc.repl.raftMu.Lock()
+		tc.repl.computeChecksumPostApply(ctx, cc)
+		tc.repl.raftMu.Unlock()
+		rc, err := tc.repl.getChecksum(ctx, cc.ChecksumID)
+		require.NoError(t, err)
+		require.NotNil(t, rc.Checksum)
+		return rc
+	}
+	expected := func() *replicaHash {
+		snap := tc.engine.NewSnapshot()
+		defer snap.Close()
+		res, err := tc.repl.sha512(
+			ctx, *tc.repl.Desc(), snap, nil /* snapshot */, roachpb.ChecksumMode_CHECK_FULL,
+			hlc.Timestamp{}, nil /* excluded */, limit.NewLimiter(rate.Inf), nil /* storeLimiter */, 1 /* shards */, nil /* progress */, nil, /* intents */
+		)
+		require.NoError(t, err)
+		return res
+	}
+
+	require.Zero(t, tc.repl.GetMVCCStats().KeyCount)
+	want := expected()
+	// The range-local state is hashed, so the checksum isn't that of no data.
+	require.NotZero(t, want.RecomputedMS.SysCount)
+	wantDelta := want.PersistedMS
+	wantDelta.Subtract(want.RecomputedMS)
+	for _, mode := range []roachpb.ChecksumMode{
+		roachpb.ChecksumMode_CHECK_VIA_QUEUE, roachpb.ChecksumMode_CHECK_FULL,
+	} {
+		rc := checksum(mode)
+		require.Equal(t, want.SHA512[:], rc.Checksum)
+		// The stats are recomputed rather than taken from memory.
+		require.Equal(t, enginepb.MVCCStatsDelta(wantDelta), rc.Delta)
+	}
+	require.Zero(t, snapshots)
+
+	// The range is hashed on the Raft application path, so the scan doesn't
+	// wait on the per-check rate limit or on the store's IO budget. Either
+	// would take minutes to let the range-local data through at 1B/s.
+	sv := &tc.store.ClusterSettings().SV
+	consistencyCheckRate.Override(sv, 1)
+	consistencyCheckStoreIOBudget.Override(sv, 1)
+	start := timeutil.Now()
+	require.Equal(t, want.SHA512[:], checksum(roachpb.ChecksumMode_CHECK_FULL).Checksum)
+	require.Less(t, int64(timeutil.Since(start)), int64(10*time.Second))
+	consistencyCheckRate.Override(sv, 8<<20)
+	consistencyCheckStoreIOBudget.Override(sv, 0)
+
+	// A stats-only check hashes the replica's applied state, so it still takes
+	// a snapshot.
+	require.NotEqual(t, want.SHA512[:], checksum(roachpb.ChecksumMode_CHECK_STATS).Checksum)
+	require.Equal(t, 1, snapshots)
+
+	// So does a range holding a lot of range-local data. Pretend that it does;
+	// the checksum is the same as it only depends on the data.
+	tc.repl.mu.Lock()
+	tc.repl.mu.state.Stats.SysBytes += checksumWithoutSnapshotMaxSysBytes
+	tc.repl.mu.Unlock()
+	require.Equal(t, want.SHA512[:], checksum(roachpb.ChecksumMode_CHECK_FULL).Checksum)
+	require.Equal(t, 2, snapshots)
+	tc.repl.mu.Lock()
+	tc.repl.mu.state.Stats.SysBytes -= checksumWithoutSnapshotMaxSysBytes
+	tc.repl.mu.Unlock()
+
+	// Once the range holds a key, the data is hashed from a snapshot again.
+	put := putArgs(roachpb.Key("a"), []byte("value"))
+	if _, pErr := tc.SendWrapped(&put); pErr != nil {
+		t.Fatal(pErr)
+	}
+	require.Equal(t, expected().SHA512[:], checksum(roachpb.ChecksumMode_CHECK_FULL).Checksum)
+	require.Equal(t, 3, snapshots)
+}
+
+// TestReplicaChecksumDiff verifies that ChecksumDiff pinpoints the keys at
+// which the replica's data has diverged from a reference snapshot.
+func TestReplicaChecksumDiff(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	tc := testContext{}
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+	tc.Start(t, stopper)
+
+	for _, k := range []string{"a", "b", "c"} {
+		put := putArgs(roachpb.Key(k), []byte("value"))
+		if _, pErr := tc.SendWrapped(&put); pErr != nil {
+			t.Fatal(pErr)
+		}
+	}
+
+	// Capture the replica data as a checksum computation asked to return a
+	// snapshot would.
+	ref := &roachpb.RaftSnapshotData{}
+	snap := tc.engine.NewSnapshot()
+	_, err := tc.repl.sha512(
+		ctx, *tc.repl.Desc(), snap, &memSnapshotSink{data: ref}, roachpb.ChecksumMode_CHECK_FULL,
+		hlc.Timestamp{}, nil /* excluded */, limit.NewLimiter(rate.Inf), nil /* storeLimiter */, 1 /* shards */, nil /* progress */, nil, /* intents */
+	)
+	snap.Close()
+	require.NoError(t, err)
+
+	diffs, err := tc.repl.ChecksumDiff(ctx, ref)
+	require.NoError(t, err)
+	require.Empty(t, diffs)
+
+	// Write a new version of one of the keys. This also updates the range's
+	// applied state, which is part of the replicated range-local data, so only
+	// the user keyspace is checked.
+	put := putArgs(roachpb.Key("b"), []byte("other"))
+	if _, pErr := tc.SendWrapped(&put); pErr != nil {
+		t.Fatal(pErr)
+	}
+	diffs, err = tc.repl.ChecksumDiff(ctx, ref)
+	require.NoError(t, err)
+	var userDiffs []KeyRangeDiff
+	for _, d := range diffs {
+		if !d.Span.Key.Less(roachpb.Key("a")) {
+			userDiffs = append(userDiffs, d)
+		}
+	}
+	require.Len(t, userDiffs, 1)
+	require.Equal(t, roachpb.Span{Key: roachpb.Key("b"), EndKey: roachpb.Key("b").Next()}, userDiffs[0].Span)
+	require.Len(t, userDiffs[0].Diff, 1)
+	require.False(t, userDiffs[0].Diff[0].LeaseHolder)
+
+	_, err = tc.repl.ChecksumDiff(ctx, nil /* ref */)
+	require.Error(t, err)
+}
+
+// TestReplicaChecksumStoreIOBudget verifies that checksum computations pace
+// their reads according to server.consistency_check.store_io_budget.
+func TestReplicaChecksumStoreIOBudget(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	tc := testContext{}
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+	tc.Start(t, stopper)
+
+	// Write about 100KB of data.
+	value := bytes.Repeat([]byte("v"), 10<<10)
+	for i := 0; i < 10; i++ {
+		put := putArgs(roachpb.Key(fmt.Sprintf("k%02d", i)), value)
+		if _, pErr := tc.SendWrapped(&put); pErr != nil {
+			t.Fatal(pErr)
+		}
+	}
+
+	scan := func() time.Duration {
+		snap := tc.engine.NewSnapshot()
+		defer snap.Close()
+		start := timeutil.Now()
+		_, err := tc.repl.sha512(
+			ctx, *tc.repl.Desc(), snap, nil /* snapshot */, roachpb.ChecksumMode_CHECK_FULL,
+			hlc.Timestamp{}, nil /* excluded */, limit.NewLimiter(rate.Inf), tc.store.consistencyIOLimiter(),
+			1 /* shards */, nil /* progress */, nil, /* intents */
+		)
+		require.NoError(t, err)
+		return timeutil.Since(start)
+	}
+
+	// The budget is unlimited by default.
+	require.Less(t, int64(scan()), int64(time.Second))
+
+	// With a budget of 40KB/s, of which the first second's worth is available
+	// right away, reading the remaining 60KB takes about 1.5s.
+	consistencyCheckStoreIOBudget.Override(&tc.store.ClusterSettings().SV, 40<<10)
+	require.GreaterOrEqual(t, int64(scan()), int64(time.Second))
+
+	consistencyCheckStoreIOBudget.Override(&tc.store.ClusterSettings().SV, 0)
+	require.Less(t, int64(scan()), int64(time.Second))
+}
+
+// blockingReader is a storage.Reader whose iterators can only be created once
+// unblock is closed.
+type blockingReader struct {
+	storage.Reader
+	unblock chan struct{}
+}
+
+func (r blockingReader) NewIterator(opts storage.IterOptions) storage.Iterator {
+	<-r.unblock
+	return r.Reader.NewIterator(opts)
+}
+
+// TestReplicaChecksumOutlivesGCInterval verifies that the entry of a checksum
+// computation which takes longer than the GC interval isn't GCed before the
+// computation finishes, and that such computations are counted.
+func TestReplicaChecksumOutlivesGCInterval(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+
+	unblock := make(chan struct{})
+	tc := testContext{}
+	cfg := TestStoreConfig(nil)
+	cfg.TestingKnobs.ConsistencyTestingKnobs.ChecksumGCInterval = time.Nanosecond
+	cfg.TestingKnobs.ConsistencyTestingKnobs.ChecksumSnapshot = func(
+		roachpb.RangeID,
+	) (storage.Reader, func()) {
+		snap := tc.engine.NewSnapshot()
+		return blockingReader{Reader: snap, unblock: unblock}, snap.Close
+	}
+	tc.StartWithStoreConfig(t, stopper, cfg)
+
+	metrics := tc.store.Metrics()
+	before := metrics.ConsistencyQueueChecksumsOverGCInterval.Count()
+	cc := kvserverpb.ComputeChecksum{
+		ChecksumID: uuid.FastMakeV4(),
+		Version:    batcheval.ReplicaChecksumVersion,
+		Mode:       roachpb.ChecksumMode_CHECK_FULL,
+	}
+	tc.repl.raftMu.Lock()
+	tc.repl.computeChecksumPostApply(ctx, cc)
+	tc.repl.raftMu.Unlock()
+
+	// The computation is stuck, and its entry survives a GC far past its GC
+	// interval.
+	time.Sleep(time.Millisecond)
+	tc.repl.mu.Lock()
+	tc.repl.gcOldChecksumEntriesLocked(timeutil.Now().Add(time.Hour))
+	c, ok := tc.repl.mu.checksums[cc.ChecksumID]
+	tc.repl.mu.Unlock()
+	require.True(t, ok)
+	require.True(t, c.started)
+	require.Nil(t, c.Checksum)
+
+	// Wait for the computation to finish. Use the notification directly, as
+	// getChecksum would GC the entry before looking it up.
+	close(unblock)
+	<-c.notify
+	tc.repl.mu.Lock()
+	c, ok = tc.repl.mu.checksums[cc.ChecksumID]
+	tc.repl.mu.Unlock()
+	require.True(t, ok)
+	require.NotNil(t, c.Checksum)
+	require.Equal(t, before+1, metrics.ConsistencyQueueChecksumsOverGCInterval.Count())
+
+	// Once finished, the entry is GCed as usual.
+	tc.repl.mu.Lock()
+	tc.repl.gcOldChecksumEntriesLocked(timeutil.Now().Add(time.Hour))
+	_, ok = tc.repl.mu.checksums[cc.ChecksumID]
+	tc.repl.mu.Unlock()
+	require.False(t, ok)
+}
+
+// TestReplicaChecksumShardDeterminism verifies that the checksum of a replica
+// does not depend on the number of shards its data is hashed across, nor on
+// how the chunks happen to be scheduled onto them.
+func TestReplicaChecksumShardDeterminism(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	// Use small chunks so that the data is spread across many of them.
+	defer func(old int64) { checksumChunkBytes = old }(checksumChunkBytes)
+	checksumChunkBytes = 64
+
+	ctx := context.Background()
+	tc := testContext{}
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+	tc.Start(t, stopper)
+
+	for i := 0; i < 100; i++ {
+		put := putArgs(roachpb.Key(fmt.Sprintf("key-%03d", i)), []byte(fmt.Sprintf("value-%03d", i)))
+		if _, pErr := tc.SendWrapped(&put); pErr != nil {
+			t.Fatal(pErr)
+		}
+	}
+
+	snap := tc.engine.NewSnapshot()
+	defer snap.Close()
+	desc := *tc.repl.Desc()
+
+	for _, mode := range []roachpb.ChecksumMode{
+		roachpb.ChecksumMode_CHECK_FULL, roachpb.ChecksumMode_CHECK_STATS,
+	} {
+		t.Run(mode.String(), func(t *testing.T) {
+			var expected *replicaHash
+			for _, shards := range []int{1, 2, 3, 8, 32} {
+				// Run each configuration repeatedly to exercise different
+				// schedules of the chunks onto the shards.
+				for i := 0; i < 3; i++ {
+					res, err := tc.repl.sha512(
+						ctx, desc, snap, nil /* snapshot */, mode, hlc.Timestamp{}, nil /* excluded */, limit.NewLimiter(rate.Inf), nil, /* storeLimiter */
+						shards, nil /* progress */, nil, /* intents */
+					)
+					require.NoError(t, err)
+					if expected == nil {
+						expected = res
+						continue
+					}
+					require.Equal(t, expected.SHA512, res.SHA512, "shards=%d", shards)
+					require.Equal(t, expected.RecomputedMS, res.RecomputedMS, "shards=%d", shards)
+				}
+			}
+		})
+	}
+}
+
+// TestReplicaChecksumAsOf verifies that a checksum computed as of a timestamp
+// only covers the data visible at that timestamp, and so is not affected by
+// later writes. Intents at or below the timestamp and inline values are
+// covered.
+func TestReplicaChecksumAsOf(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	tc := testContext{}
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+	tc.Start(t, stopper)
+
+	write := func(ts hlc.Timestamp, value string) {
+		for i := 0; i < 10; i++ {
+			put := putArgs(roachpb.Key(fmt.Sprintf("key-%03d", i)), []byte(value))
+			if _, pErr := tc.SendWrappedWith(roachpb.Header{Timestamp: ts}, &put); pErr != nil {
+				t.Fatal(pErr)
+			}
+		}
+	}
+	asOf := tc.Clock().Now()
+	write(asOf, "old")
+
+	checksum := func(asOf hlc.Timestamp) []byte {
+		snap := tc.engine.NewSnapshot()
+		defer snap.Close()
+		res, err := tc.repl.sha512(
+			ctx, *tc.repl.Desc(), snap, nil /* snapshot */, roachpb.ChecksumMode_CHECK_FULL,
+			asOf, nil /* excluded */, limit.NewLimiter(rate.Inf), nil /* storeLimiter */, 1 /* shards */, nil /* progress */, nil, /* intents */
+		)
+		require.NoError(t, err)
+		return res.SHA512[:]
+	}
+	before, beforeFull := checksum(asOf), checksum(hlc.Timestamp{})
+
+	write(tc.Clock().Now(), "new")
+	require.Equal(t, before, checksum(asOf))
+	require.NotEqual(t, beforeFull, checksum(hlc.Timestamp{}))
+
+	// The writes below bypass Raft, standing in for replicas which diverged.
+	// Intents above the timestamp are ignored, while those at or below it are
+	// hashed along with their provisional values.
+	putIntent := func(key string, ts hlc.Timestamp) {
+		txn := roachpb.MakeTransaction(key, roachpb.Key(key), roachpb.NormalUserPriority, ts, 0)
+		require.NoError(t, storage.MVCCPut(
+			ctx, tc.engine, nil /* ms */, roachpb.Key(key), ts, roachpb.MakeValueFromString(key), &txn,
+		))
+	}
+	putIntent("intent-above", tc.Clock().Now())
+	require.Equal(t, before, checksum(asOf))
+	putIntent("intent-below", asOf)
+	withIntent := checksum(asOf)
+	require.NotEqual(t, before, withIntent)
+
+	// Inline values can't be placed in time, so they are always hashed.
+	require.NoError(t, storage.MVCCPut(
+		ctx, tc.engine, nil /* ms */, roachpb.Key("inline"), hlc.Timestamp{},
+		roachpb.MakeValueFromString("inline"), nil, /* txn */
+	))
+	require.NotEqual(t, withIntent, checksum(asOf))
+}
+
+// TestReplicaChecksumExcludedSpans verifies that keys within the excluded
+// spans don't contribute to the checksum, so that replicas whose data only
+// differs within those spans produce matching checksums.
+func TestReplicaChecksumExcludedSpans(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	tc := testContext{}
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+	tc.Start(t, stopper)
+
+	for _, key := range []string{"a", "b", "c"} {
+		put := putArgs(roachpb.Key(key), []byte("value"))
+		if _, pErr := tc.SendWrapped(&put); pErr != nil {
+			t.Fatal(pErr)
+		}
+	}
+	excluded := []roachpb.Span{{Key: roachpb.Key("b"), EndKey: roachpb.Key("c")}}
+
+	checksum := func(reader storage.Reader, excluded []roachpb.Span) []byte {
+		res, err := tc.repl.sha512(
+			ctx, *tc.repl.Desc(), reader, nil /* snapshot */, roachpb.ChecksumMode_CHECK_FULL,
+			hlc.Timestamp{}, excluded, limit.NewLimiter(rate.Inf), nil /* storeLimiter */, 1 /* shards */, nil /* progress */, nil, /* intents */
+		)
+		require.NoError(t, err)
+		return res.SHA512[:]
+	}
+
+	// Stand in for a replica which diverged within the excluded span by
+	// writing to the engine directly, bypassing Raft.
+	snap := tc.engine.NewSnapshot()
+	defer snap.Close()
+	diverged := roachpb.MakeValueFromString("diverged")
+	require.NoError(t, storage.MVCCPut(
+		ctx, tc.engine, nil /* ms */, roachpb.Key("b"), tc.Clock().Now(), diverged, nil, /* txn */
+	))
+	require.NotEqual(t, checksum(snap, nil /* excluded */), checksum(tc.engine, nil /* excluded */))
+	require.Equal(t, checksum(snap, excluded), checksum(tc.engine, excluded))
+
+	// A divergence outside of the excluded spans is still detected.
+	require.NoError(t, storage.MVCCPut(
+		ctx, tc.engine, nil /* ms */, roachpb.Key("c"), tc.Clock().Now(), diverged, nil, /* txn */
+	))
+	require.NotEqual(t, checksum(snap, excluded), checksum(tc.engine, excluded))
+}
+
+// TestReplicaChecksumIntents verifies that a checksum computation can collect
+// the intents in the replica's data, and that the intent summaries of
+// replicas are cross-checked.
+func TestReplicaChecksumIntents(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	tc := testContext{}
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+	tc.Start(t, stopper)
+
+	key := roachpb.Key("a")
+	txn := newTransaction("test", key, 1, tc.Clock())
+	put := putArgs(key, []byte("value"))
+	if _, pErr := tc.SendWrappedWith(roachpb.Header{Txn: txn}, &put); pErr != nil {
+		t.Fatal(pErr)
+	}
+
+	summarize := func(reader storage.Reader) CollectChecksumResponse {
+		res, err := tc.repl.sha512(
+			ctx, *tc.repl.Desc(), reader, nil /* snapshot */, roachpb.ChecksumMode_CHECK_FULL,
+			hlc.Timestamp{}, nil /* excluded */, limit.NewLimiter(rate.Inf), nil /* storeLimiter */, 1 /* shards */, nil /* progress */, &checksumIntents{},
+		)
+		require.NoError(t, err)
+		return CollectChecksumResponse{IntentCount: res.IntentCount, Intents: res.Intents}
+	}
+	results := func(resps ...CollectChecksumResponse) []ConsistencyCheckResult {
+		var results []ConsistencyCheckResult
+		for i, resp := range resps {
+			results = append(results, ConsistencyCheckResult{
+				Replica:  roachpb.ReplicaDescriptor{NodeID: roachpb.NodeID(i + 1), ReplicaID: roachpb.ReplicaID(i + 1)},
+				Response: resp,
+			})
+		}
+		return results
+	}
+
+	snap := tc.engine.NewSnapshot()
+	defer snap.Close()
+	first := summarize(snap)
+	require.EqualValues(t, 1, first.IntentCount)
+	require.Len(t, first.Intents, 1)
+	require.Equal(t, key, first.Intents[0].Key)
+	require.Equal(t, txn.ID, first.Intents[0].Txn.ID)
+	require.Equal(t, txn.WriteTimestamp, first.Intents[0].Txn.WriteTimestamp)
+
+	// Replicas with the same intents agree.
+	require.Empty(t, diffChecksumIntents(results(first, summarize(snap))))
+
+	// A replica holding an additional intent is flagged.
+	otherTxn := newTransaction("other", roachpb.Key("b"), 1, tc.Clock())
+	batch := tc.engine.NewBatch()
+	defer batch.Close()
+	require.NoError(t, storage.MVCCPut(
+		ctx, batch, nil /* ms */, roachpb.Key("b"), otherTxn.WriteTimestamp,
+		roachpb.MakeValueFromString("value"), otherTxn,
+	))
+	second := summarize(batch)
+	require.EqualValues(t, 2, second.IntentCount)
+	require.Len(t, diffChecksumIntents(results(first, second)), 1)
+
+	// As is a replica whose intent belongs to another transaction.
+	third := first
+	third.Intents = []roachpb.Intent{roachpb.MakeIntent(&otherTxn.TxnMeta, key)}
+	require.Len(t, diffChecksumIntents(results(first, first, third)), 1)
+}
+
+// pausingReader is a storage.Reader whose first iterator, once it has been
+// advanced pauseAfter times, closes paused and waits for resume to be closed
+// before advancing any further.
+type pausingReader struct {
+	storage.Reader
+	it *pausingIterator
+}
+
+func (r pausingReader) NewIterator(opts storage.IterOptions) storage.Iterator {
+	if r.it.Iterator != nil {
+		return r.Reader.NewIterator(opts)
+	}
+	r.it.Iterator = r.Reader.NewIterator(opts)
+	return r.it
+}
+
+type pausingIterator struct {
+	storage.Iterator
+	pauseAfter     int
+	paused, resume chan struct{}
+}
+
+func (it *pausingIterator) Next() {
+	if it.pauseAfter--; it.pauseAfter == 0 {
+		close(it.paused)
+		<-it.resume
+	}
+	it.Iterator.Next()
+}
+
+// TestReplicaChecksumProgress verifies that the progress of an in-flight
+// checksum computation can be observed and never moves backwards.
+func TestReplicaChecksumProgress(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	tc := testContext{}
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+	tc.Start(t, stopper)
+
+	const numKeys = 200
+	for i := 0; i < numKeys; i++ {
+		put := putArgs(roachpb.Key(fmt.Sprintf("key-%03d", i)), []byte(fmt.Sprintf("value-%03d", i)))
+		if _, pErr := tc.SendWrapped(&put); pErr != nil {
+			t.Fatal(pErr)
+		}
+	}
+
+	id := uuid.FastMakeV4()
+	stats := tc.repl.GetMVCCStats()
+	progress := &checksumProgress{total: stats.Total() + stats.SysBytes}
+	tc.repl.mu.Lock()
+	tc.repl.mu.checksums[id] = ReplicaChecksum{
+		started: true, notify: make(chan struct{}), progress: progress,
+	}
+	tc.repl.mu.Unlock()
+
+	f, ok := tc.repl.ChecksumProgress(id)
+	require.True(t, ok)
+	require.Zero(t, f)
+
+	snap := tc.engine.NewSnapshot()
+	defer snap.Close()
+	// Pause the computation halfway through the user keys.
+	it := &pausingIterator{
+		pauseAfter: numKeys / 2, paused: make(chan struct{}), resume: make(chan struct{}),
+	}
+	done := make(chan error, 1)
+	go func() {
+		res, err := tc.repl.sha512(
+			ctx, *tc.repl.Desc(), pausingReader{Reader: snap, it: it},
+			nil /* snapshot */, roachpb.ChecksumMode_CHECK_FULL, hlc.Timestamp{}, nil, /* excluded */
+			limit.NewLimiter(rate.Inf), nil /* storeLimiter */, 1 /* shards */, progress, nil, /* intents */
+		)
+		if err == nil {
+			tc.repl.computeChecksumDone(ctx, id, res, nil /* snapshot */, "" /* snapshotPath */)
+		}
+		done <- err
+	}()
+
+	<-it.paused
+	intermediate, ok := tc.repl.ChecksumProgress(id)
+	require.True(t, ok)
+	require.Greater(t, intermediate, 0.0)
+	require.Less(t, intermediate, 1.0)
+
+	close(it.resume)
+	require.NoError(t, <-done)
+	f, ok = tc.repl.ChecksumProgress(id)
+	require.True(t, ok)
+	require.Equal(t, 1.0, f)
+}
+
+// TestReplicaOldestPendingChecksumMetric verifies that the store reports the
+// age of a checksum computation which is stuck in progress, and stops doing so
+// once it completes.
+func TestReplicaOldestPendingChecksumMetric(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	tc := testContext{}
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+	tc.Start(t, stopper)
+
+	gauge := func() int64 {
+		require.NoError(t, tc.store.updateReplicationGauges(ctx))
+		return tc.store.metrics.ConsistencyQueueOldestChecksumNanos.Value()
+	}
+	require.Zero(t, gauge())
+
+	// A computation which has been stuck for an hour, and a younger one which
+	// doesn't change the reported age.
+	now := timeutil.Now()
+	ids := []uuid.UUID{uuid.FastMakeV4(), uuid.FastMakeV4()}
+	tc.repl.mu.Lock()
+	for i, startTime := range []time.Time{now.Add(-time.Hour), now.Add(-time.Minute)} {
+		tc.repl.mu.checksums[ids[i]] = ReplicaChecksum{
+			started: true, startTime: startTime, notify: make(chan struct{}),
+			progress: &checksumProgress{},
+		}
+	}
+	tc.repl.mu.Unlock()
+
+	age := gauge()
+	require.GreaterOrEqual(t, age, time.Hour.Nanoseconds())
+	require.Less(t, age, (time.Hour + time.Minute).Nanoseconds())
+
+	// Once the oldest computation completes, the younger one is reported.
+	tc.repl.computeChecksumDone(ctx, ids[0], nil /* result */, nil /* snapshot */, "" /* snapshotPath */)
+	age = gauge()
+	require.GreaterOrEqual(t, age, time.Minute.Nanoseconds())
+	require.Less(t, age, time.Hour.Nanoseconds())
+
+	tc.repl.computeChecksumDone(ctx, ids[1], nil /* result */, nil /* snapshot */, "" /* snapshotPath */)
+	require.Zero(t, gauge())
+}
+
+// TestReplicaChecksumDoneFirstWriterWins verifies that a late call to
+// computeChecksumDone with a nil result doesn't clobber a digest stored by an
+// earlier call.
+func TestReplicaChecksumDoneFirstWriterWins(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	tc := testContext{}
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+	tc.Start(t, stopper)
+
+	id := uuid.FastMakeV4()
+	notify := make(chan struct{})
+	tc.repl.mu.Lock()
+	tc.repl.mu.checksums[id] = ReplicaChecksum{started: true, startTime: timeutil.Now(), notify: notify}
+	tc.repl.mu.Unlock()
+
+	res := &replicaHash{}
+	res.SHA512[0] = 1
+	tc.repl.computeChecksumDone(ctx, id, res, nil /* snapshot */, "" /* snapshotPath */)
+	<-notify
+	// This would panic on closing notify a second time if it weren't ignored.
+	tc.repl.computeChecksumDone(ctx, id, nil /* result */, nil /* snapshot */, "" /* snapshotPath */)
+
+	tc.repl.mu.RLock()
+	c, ok := tc.repl.mu.checksums[id]
+	tc.repl.mu.RUnlock()
+	require.True(t, ok)
+	require.Equal(t, res.SHA512[:], c.Checksum)
+}
+
+// TestReplicaPendingChecksums verifies that a checksum computation is listed
+// by Replica.PendingChecksums once it has started, and that a computation which
+// has to wait for a slot of the store's checksum scheduler doesn't hold up the
+// application of the command and completes once a slot frees up.
+func TestReplicaPendingChecksums(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	tc := testContext{}
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+	tc.Start(t, stopper)
+	sv := &tc.store.ClusterSettings().SV
+
+	require.Empty(t, tc.repl.PendingChecksums())
+
+	// Occupy all of the store's checksum slots so that the computation below
+	// has to wait.
+	var releases []func()
+	for i := 0; i < int(consistencyCheckConcurrency.Get(sv)); i++ {
+		release, err := tc.store.checksumScheduler.acquire(ctx, roachpb.RangeID(1000+i))
+		require.NoError(t, err)
+		releases = append(releases, release)
+	}
+
+	cc := kvserverpb.ComputeChecksum{
+		ChecksumID: uuid.FastMakeV4(),
+		Version:    batcheval.ReplicaChecksumVersion,
+		Mode:       roachpb.ChecksumMode_CHECK_FULL,
+	}
+	tc.repl.computeChecksumPostApply(ctx, cc)
+	statuses := tc.repl.PendingChecksums()
+	require.Len(t, statuses, 1)
+	require.Equal(t, cc.ChecksumID, statuses[0].ID)
+	require.True(t, statuses[0].Started)
+	require.False(t, statuses[0].Computed)
+	require.True(t, statuses[0].GCTimestamp.IsZero())
+	testutils.SucceedsSoon(t, func() error {
+		if tc.store.checksumScheduler.numWaiting() == 0 {
+			return errors.New("computation not waiting for a slot yet")
+		}
+		return nil
+	})
+
+	for _, release := range releases {
+		release()
+	}
+	rc, err := tc.repl.getChecksum(ctx, cc.ChecksumID)
+	require.NoError(t, err)
+	require.NotNil(t, rc.Checksum)
+	statuses = tc.repl.PendingChecksums()
+	require.Len(t, statuses, 1)
+	require.True(t, statuses[0].Started)
+	require.True(t, statuses[0].Computed)
+	require.False(t, statuses[0].GCTimestamp.IsZero())
+}
+
+// TestReplicaChecksumSkippedDraining verifies that a checksum computation is
+// skipped, without opening an engine snapshot, if the store is draining, and
+// that collectors are told so.
+func TestReplicaChecksumSkippedDraining(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	tc := testContext{}
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+	tc.Start(t, stopper)
+
+	tc.store.SetDraining(true, nil /* reporter */)
+	cc := kvserverpb.ComputeChecksum{
+		ChecksumID: uuid.FastMakeV4(),
+		Version:    batcheval.ReplicaChecksumVersion,
+		Mode:       roachpb.ChecksumMode_CHECK_FULL,
+	}
+	tc.repl.computeChecksumPostApply(ctx, cc)
+
+	// A skipped computation completes right away.
+	statuses := tc.repl.PendingChecksums()
+	require.Len(t, statuses, 1)
+	require.True(t, statuses[0].SkippedDraining)
+	require.False(t, statuses[0].Computed)
+	require.False(t, statuses[0].GCTimestamp.IsZero())
+
+	_, err := tc.repl.getChecksum(ctx, cc.ChecksumID)
+	if !testutils.IsError(err, "skipped because the store is draining") {
+		t.Fatal(err)
+	}
+
+	// Once the store is no longer draining, checksums are computed again.
+	tc.store.SetDraining(false, nil /* reporter */)
+	cc.ChecksumID = uuid.FastMakeV4()
+	tc.repl.computeChecksumPostApply(ctx, cc)
+	rc, err := tc.repl.getChecksum(ctx, cc.ChecksumID)
+	require.NoError(t, err)
+	require.NotNil(t, rc.Checksum)
+}
+
+// TestVerifyChecksumSnapshot verifies that the snapshot data captured by a
+// checksum computation hashes to its checksum, and that a mismatch is
+// detected.
+func TestVerifyChecksumSnapshot(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	tc := testContext{}
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+	tc.Start(t, stopper)
+
+	for i := 0; i < 20; i++ {
+		put := putArgs(roachpb.Key(fmt.Sprintf("key-%03d", i)), []byte(fmt.Sprintf("value-%03d", i)))
+		if _, pErr := tc.SendWrapped(&put); pErr != nil {
+			t.Fatal(pErr)
+		}
+	}
+
+	snap := tc.engine.NewSnapshot()
+	defer snap.Close()
+	for _, mode := range []roachpb.ChecksumMode{
+		roachpb.ChecksumMode_CHECK_FULL, roachpb.ChecksumMode_CHECK_STATS,
+	} {
+		t.Run(mode.String(), func(t *testing.T) {
+			var data roachpb.RaftSnapshotData
+			res, err := tc.repl.sha512(
+				ctx, *tc.repl.Desc(), snap, &memSnapshotSink{data: &data}, mode, hlc.Timestamp{}, nil, /* excluded */
+				limit.NewLimiter(rate.Inf), nil /* storeLimiter */, 3 /* shards */, nil /* progress */, nil, /* intents */
+			)
+			require.NoError(t, err)
+			require.NoError(t, verifyChecksumSnapshot(ctx, &data, mode, res.SHA512))
+
+			bad := res.SHA512
+			bad[0]++
+			err = verifyChecksumSnapshot(ctx, &data, mode, bad)
+			if !testutils.IsError(err, "snapshot data hashes to") {
+				t.Fatalf("expected mismatch, got %v", err)
+			}
+
+			if mode == roachpb.ChecksumMode_CHECK_FULL {
+				// Corrupt a value of the snapshot data.
+				kv := &data.KV[len(data.KV)/2]
+				kv.Value = append([]byte(nil), kv.Value...)
+				kv.Value[len(kv.Value)-1]++
+				err = verifyChecksumSnapshot(ctx, &data, mode, res.SHA512)
+				if !testutils.IsError(err, "snapshot data hashes to") {
+					t.Fatalf("expected mismatch, got %v", err)
+				}
+			}
+		})
+	}
+
+	// A computation asked to verify its snapshot data completes normally.
+	cc := kvserverpb.ComputeChecksum{
+		ChecksumID:     uuid.FastMakeV4(),
+		Version:        batcheval.ReplicaChecksumVersion,
+		Mode:           roachpb.ChecksumMode_CHECK_FULL,
+		SaveSnapshot:   true,
+		VerifySnapshot: true,
+	}
+	tc.repl.computeChecksumPostApply(ctx, cc)
+	rc, err := tc.repl.getChecksum(ctx, cc.ChecksumID)
+	require.NoError(t, err)
+	require.NotNil(t, rc.Checksum)
+	require.NotNil(t, rc.Snapshot)
+}
@@ -110,6 +110,11 @@ func (r *Replica) postDestroyRaftMuLocked(ctx context.Context, ms enginepb.MVCCS
 		})
 	}
 
+	// Remove the replica data captured by checksum computations. Computations
+	// which are still running remove theirs when they finish (see
+	// computeChecksumDone).
+	r.removeChecksumSnapshots(ctx)
+
 	// NB: we need the nil check below because it's possible that we're GC'ing a
 	// Replica without a replicaID, in which case it does not have a sideloaded
 	// storage.
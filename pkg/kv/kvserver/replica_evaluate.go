@@ -19,6 +19,7 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/kv/kvserver/kvserverbase"
 	"github.com/cockroachdb/cockroach/pkg/kv/kvserver/spanset"
 	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/server/telemetry"
 	"github.com/cockroachdb/cockroach/pkg/storage"
 	"github.com/cockroachdb/cockroach/pkg/storage/enginepb"
 	"github.com/cockroachdb/cockroach/pkg/util/errorutil"
@@ -497,6 +498,7 @@ func evaluateCommand(
 	// Create a roachpb.Error by initializing txn from the request/response header.
 	var pErr *roachpb.Error
 	if err != nil {
+		maybeCountMergeConflict(err)
 		txn := reply.Header().Txn
 		if txn == nil {
 			txn = h.Txn
@@ -507,6 +509,16 @@ func evaluateCommand(
 	return pd, pErr
 }
 
+// maybeCountMergeConflict increments the telemetry counter of the conflicting
+// field if err is a result.MergeConflictError. The counters show which
+// combinations of command results fail to merge.
+func maybeCountMergeConflict(err error) {
+	var conflict *result.MergeConflictError
+	if errors.As(err, &conflict) {
+		telemetry.Inc(telemetry.GetCounter("kv.eval_result.merge_conflict." + conflict.Field))
+	}
+}
+
 // returnRangeInfo populates RangeInfos in the response if the batch
 // requested them.
 func returnRangeInfo(reply roachpb.Response, rec batcheval.EvalContext) {
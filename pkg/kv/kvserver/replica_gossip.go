@@ -12,6 +12,8 @@ package kvserver
 
 import (
 	"context"
+	"sync/atomic"
+	"time"
 
 	"github.com/cockroachdb/cockroach/pkg/config"
 	"github.com/cockroachdb/cockroach/pkg/gossip"
@@ -20,11 +22,51 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/kv/kvserver/kvserverpb"
 	"github.com/cockroachdb/cockroach/pkg/roachpb"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/retry"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
 	"github.com/cockroachdb/errors"
 )
 
 const configGossipTTL = 0 // does not expire
 
+// firstRangeGossipThrottleDuration is the minimum interval between the gossips
+// of the first range requested by commands (see LocalResult.GossipFirstRange).
+// A burst of such commands results in one gossip right away and one at the end
+// of the interval; the first range is gossiped periodically by the store
+// regardless.
+const firstRangeGossipThrottleDuration = time.Second
+
+// firstRangeGossipThrottle spaces out the gossips of the first range requested
+// by commands by firstRangeGossipThrottleDuration.
+type firstRangeGossipThrottle struct {
+	syncutil.Mutex
+	// next is the earliest time at which the next gossip may happen.
+	next time.Time
+}
+
+// reserve reserves the earliest slot for a gossip at or after now and returns
+// how long to wait for it.
+func (t *firstRangeGossipThrottle) reserve(now time.Time) time.Duration {
+	t.Lock()
+	defer t.Unlock()
+	if now.After(t.next) {
+		t.next = now
+	}
+	wait := t.next.Sub(now)
+	t.next = t.next.Add(firstRangeGossipThrottleDuration)
+	return wait
+}
+
+// nodeLivenessGossipRetryOptions controls the backoff with which a failed
+// attempt to gossip node liveness records after a command application is
+// retried.
+var nodeLivenessGossipRetryOptions = retry.Options{
+	InitialBackoff: 50 * time.Millisecond,
+	MaxBackoff:     time.Second,
+	Multiplier:     2,
+	MaxRetries:     5,
+}
+
 func (r *Replica) gossipFirstRange(ctx context.Context) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
@@ -51,6 +93,75 @@ func (r *Replica) gossipFirstRange(ctx context.Context) {
 	}
 }
 
+// maybeGossipFirstRangeAsync gossips the first range in an async task, as
+// requested by LocalResult.GossipFirstRange. Requests are coalesced: a request
+// that arrives while the task is running, which it is for as long as acquiring
+// the lease stalls or the store gossiped the first range recently, is picked up
+// by the task once the current gossip is done and the throttle allows. Thus the
+// first range is always gossiped again after the last request.
+func (r *Replica) maybeGossipFirstRangeAsync(ctx context.Context) {
+	atomic.StoreInt32(&r.firstRangeGossipPending, 1)
+	if !atomic.CompareAndSwapInt32(&r.firstRangeGossipInFlight, 0, 1) {
+		log.VEventf(ctx, 2, "deferring first range gossip; already in flight")
+		return
+	}
+	if err := r.store.Stopper().RunAsyncTask(
+		ctx, "storage.Replica: gossipping first range", r.runFirstRangeGossips,
+	); err != nil {
+		atomic.StoreInt32(&r.firstRangeGossipInFlight, 0)
+		log.Infof(ctx, "unable to gossip first range: %s", err)
+	}
+}
+
+// runFirstRangeGossips gossips the first range until no more gossips are
+// requested. It's run by the task started by maybeGossipFirstRangeAsync.
+func (r *Replica) runFirstRangeGossips(ctx context.Context) {
+	for {
+		for atomic.LoadInt32(&r.firstRangeGossipPending) == 1 {
+			if wait := r.store.firstRangeGossipThrottle.reserve(
+				r.store.Clock().PhysicalTime(),
+			); wait > 0 {
+				log.VEventf(ctx, 2, "delaying first range gossip by %s; gossiped recently", wait)
+				select {
+				case <-time.After(wait):
+				case <-r.store.Stopper().ShouldQuiesce():
+					atomic.StoreInt32(&r.firstRangeGossipInFlight, 0)
+					return
+				}
+			}
+			// Requests that arrive from here on need another gossip, since this
+			// one may have read the descriptor already.
+			atomic.StoreInt32(&r.firstRangeGossipPending, 0)
+			r.gossipFirstRangeWithLease(ctx)
+		}
+		atomic.StoreInt32(&r.firstRangeGossipInFlight, 0)
+		// A request that arrived after the pending flag was last checked but
+		// before the in flight flag was cleared didn't start a task, so it's up
+		// to us to serve it, unless another request got to it first.
+		if atomic.LoadInt32(&r.firstRangeGossipPending) == 0 ||
+			!atomic.CompareAndSwapInt32(&r.firstRangeGossipInFlight, 0, 1) {
+			return
+		}
+	}
+}
+
+// gossipFirstRangeWithLease gossips the first range if this replica holds, or
+// can acquire, the lease.
+func (r *Replica) gossipFirstRangeWithLease(ctx context.Context) {
+	getLease := r.getLeaseForGossip
+	if fn := r.store.TestingKnobs().GossipFirstRangeLeaseCheck; fn != nil {
+		getLease = fn
+	}
+	hasLease, pErr := getLease(ctx)
+
+	if pErr != nil {
+		log.Infof(ctx, "unable to gossip first range; hasLease=%t, err=%s", hasLease, pErr)
+	} else if !hasLease {
+		return
+	}
+	r.gossipFirstRange(ctx)
+}
+
 // shouldGossip returns true if this replica should be gossiping. Gossip is
 // inherently inconsistent and asynchronous, we're using the lease as a way to
 // ensure that only one node gossips at a time.
@@ -183,6 +294,68 @@ func (r *Replica) MaybeGossipNodeLiveness(ctx context.Context, span roachpb.Span
 	return nil
 }
 
+// nodeLivenessGossipKey identifies a span in Replica.nodeLivenessGossipSeqs.
+type nodeLivenessGossipKey struct {
+	key, endKey string
+}
+
+// maybeGossipNodeLivenessWithRetry calls MaybeGossipNodeLiveness for the given
+// span. If that fails, the gossip is retried asynchronously with backoff so
+// that a transient failure doesn't leave the liveness records stale in gossip
+// until the next liveness update. Pending retries are abandoned as soon as a
+// newer call for the same span supersedes them. The method never blocks on the
+// retries, which makes it safe to call from below Raft.
+func (r *Replica) maybeGossipNodeLivenessWithRetry(ctx context.Context, span roachpb.Span) {
+	seqI, _ := r.nodeLivenessGossipSeqs.LoadOrStore(
+		nodeLivenessGossipKey{key: string(span.Key), endKey: string(span.EndKey)}, new(int64))
+	seqPtr := seqI.(*int64)
+	seq := atomic.AddInt64(seqPtr, 1)
+	err := r.maybeGossipNodeLivenessOnce(ctx, span)
+	if err == nil {
+		return
+	}
+	log.Errorf(ctx, "%v; retrying", err)
+
+	// The retries may outlive the command's context, so they run under a fresh
+	// context carrying the replica's log tags.
+	retryCtx := r.AnnotateCtx(context.Background())
+	if err := r.store.Stopper().RunAsyncTask(
+		retryCtx, "storage.Replica: retrying node liveness gossip",
+		func(ctx context.Context) {
+			opts := nodeLivenessGossipRetryOptions
+			opts.Closer = r.store.Stopper().ShouldQuiesce()
+			re := retry.StartWithCtx(ctx, opts)
+			// The first attempt was made synchronously above.
+			re.Next()
+			lastErr := err
+			for re.Next() {
+				if atomic.LoadInt64(seqPtr) != seq {
+					log.VEventf(ctx, 2, "node liveness gossip superseded; abandoning retry")
+					return
+				}
+				if lastErr = r.maybeGossipNodeLivenessOnce(ctx, span); lastErr == nil {
+					return
+				}
+				log.VEventf(ctx, 2, "retrying node liveness gossip: %v", lastErr)
+			}
+			log.Warningf(ctx, "giving up on gossiping node liveness: %v", lastErr)
+		}); err != nil {
+		log.Infof(ctx, "unable to retry node liveness gossip: %s", err)
+	}
+}
+
+// maybeGossipNodeLivenessOnce performs a single MaybeGossipNodeLiveness
+// attempt, giving the NodeLivenessGossipFilter testing knob a chance to fail
+// it first.
+func (r *Replica) maybeGossipNodeLivenessOnce(ctx context.Context, span roachpb.Span) error {
+	if filter := r.store.cfg.TestingKnobs.NodeLivenessGossipFilter; filter != nil {
+		if err := filter(span); err != nil {
+			return err
+		}
+	}
+	return r.MaybeGossipNodeLiveness(ctx, span)
+}
+
 var errSystemConfigIntent = errors.New("must retry later due to intent on SystemConfigSpan")
 
 // loadSystemConfig scans the system config span and returns the system
@@ -210,7 +383,7 @@ func (r *Replica) loadSystemConfig(ctx context.Context) (*config.SystemConfigEnt
 		// This is called from handleReadWriteLocalEvalResult (with raftMu
 		// locked), so disallow synchronous processing (which blocks that mutex
 		// for too long and is a potential deadlock).
-		if err := r.store.intentResolver.CleanupIntentsAsync(ctx, intents, false /* allowSync */); err != nil {
+		if err := r.cleanupEncounteredIntentsAsync(ctx, intents, false /* allowSync */); err != nil {
 			log.Warningf(ctx, "%v", err)
 		}
 		return nil, errSystemConfigIntent
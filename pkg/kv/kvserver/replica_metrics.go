@@ -12,6 +12,7 @@ package kvserver
 
 import (
 	"context"
+	"time"
 
 	"github.com/cockroachdb/cockroach/pkg/base"
 	"github.com/cockroachdb/cockroach/pkg/config/zonepb"
@@ -242,15 +243,6 @@ func (r *Replica) WritesPerSecond() float64 {
 	return wps
 }
 
-func (r *Replica) needsSplitBySizeRLocked() bool {
-	exceeded, _ := r.exceedsMultipleOfSplitSizeRLocked(1)
-	return exceeded
-}
-
-func (r *Replica) needsMergeBySizeRLocked() bool {
-	return r.mu.state.Stats.Total() < *r.mu.zone.RangeMinBytes
-}
-
 func (r *Replica) needsRaftLogTruncationLocked() bool {
 	// We don't want to check the Raft log for truncation on every write
 	// operation or even every operation which occurs after the Raft log exceeds
@@ -265,20 +257,84 @@ func (r *Replica) needsRaftLogTruncationLocked() bool {
 }
 
 // exceedsMultipleOfSplitSizeRLocked returns whether the current size of the
-// range exceeds the max size times mult. If so, the bytes overage is also
-// returned. Note that the max size is determined by either the current maximum
-// size as dictated by the zone config or a previous max size indicating that
-// the max size has changed relatively recently and thus we should not
-// backpressure for being over.
+// range exceeds the max size times mult. See rangeSize.exceedsMultipleOfSplitSize.
 func (r *Replica) exceedsMultipleOfSplitSizeRLocked(mult float64) (exceeded bool, bytesOver int64) {
+	return r.rangeSizeRLocked().exceedsMultipleOfSplitSize(mult)
+}
+
+// rangeSize captures the fields of a Replica which determine whether the range
+// should be split, merged or backpressured because of its size. It allows
+// these decisions to be made after r.mu has been released.
+type rangeSize struct {
+	// total is the range's total size in bytes.
+	total int64
+	// maxBytes is the zone's RangeMaxBytes or, if larger, the replica's
+	// largestPreviousMaxRangeSizeBytes.
+	maxBytes int64
+	// minBytes is the zone's RangeMinBytes.
+	minBytes int64
+}
+
+// rangeSizeRLocked returns the current rangeSize of the replica.
+//
+// Requires that r.mu is held.
+func (r *Replica) rangeSizeRLocked() rangeSize {
 	maxBytes := *r.mu.zone.RangeMaxBytes
 	if r.mu.largestPreviousMaxRangeSizeBytes > maxBytes {
 		maxBytes = r.mu.largestPreviousMaxRangeSizeBytes
 	}
-	size := r.mu.state.Stats.Total()
-	maxSize := int64(float64(maxBytes)*mult) + 1
-	if maxBytes <= 0 || size <= maxSize {
+	return rangeSize{
+		total:    r.mu.state.Stats.Total(),
+		maxBytes: maxBytes,
+		minBytes: *r.mu.zone.RangeMinBytes,
+	}
+}
+
+func (s rangeSize) needsSplit() bool {
+	exceeded, _ := s.exceedsMultipleOfSplitSize(1)
+	return exceeded
+}
+
+func (s rangeSize) needsMerge() bool {
+	return s.total < s.minBytes
+}
+
+// exceedsMultipleOfSplitSize returns whether the size of the range exceeds the
+// max size times mult. If so, the bytes overage is also returned. Note that the
+// max size is determined by either the current maximum size as dictated by the
+// zone config or a previous max size indicating that the max size has changed
+// relatively recently and thus we should not backpressure for being over.
+func (s rangeSize) exceedsMultipleOfSplitSize(mult float64) (exceeded bool, bytesOver int64) {
+	maxSize := int64(float64(s.maxBytes)*mult) + 1
+	if s.maxBytes <= 0 || s.total <= maxSize {
 		return false, 0
 	}
-	return true, size - maxSize
+	return true, s.total - maxSize
+}
+
+// EstimatedTimeToSplit extrapolates the range's recent write rate to estimate
+// how long it will take until the range exceeds its split size (see
+// rangeSize.needsSplit). It returns zero if the range already needs a split.
+// ok is false if there isn't enough history to form an estimate or if the
+// range isn't growing.
+func (r *Replica) EstimatedTimeToSplit() (_ time.Duration, ok bool) {
+	now := r.store.Clock().PhysicalTime()
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	size := r.rangeSizeRLocked()
+	if size.maxBytes <= 0 {
+		return 0, false
+	}
+	if size.needsSplit() {
+		return 0, true
+	}
+	if r.mu.statsDeltas == nil {
+		return 0, false
+	}
+	rate, ok := r.mu.statsDeltas.growthRate(now)
+	if !ok || rate <= 0 {
+		return 0, false
+	}
+	remaining := float64(size.maxBytes + 1 - size.total)
+	return time.Duration(remaining / rate * float64(time.Second)), true
 }
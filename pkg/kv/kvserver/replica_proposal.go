@@ -41,7 +41,7 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
 	"github.com/cockroachdb/cockroach/pkg/util/tracing"
 	"github.com/cockroachdb/errors"
-	"github.com/kr/pretty"
+	"github.com/cockroachdb/logtags"
 	opentracing "github.com/opentracing/opentracing-go"
 	"golang.org/x/time/rate"
 )
@@ -71,6 +71,11 @@ type ProposalData struct {
 	// last (re-)proposed.
 	proposedAtTicks int
 
+	// createdAt is the wall time at which the proposal was created, after the
+	// request was evaluated. Unlike proposedAtTicks, it is not reset by
+	// reproposals, and is used to measure the latency of lease acquisitions.
+	createdAt time.Time
+
 	// command is serialized and proposed to raft. In the event of
 	// reproposals its MaxLeaseIndex field is mutated.
 	command *kvserverpb.RaftCommand
@@ -97,6 +102,10 @@ type ProposalData struct {
 	// proposal succeeded in applying.
 	applied bool
 
+	// reproposed is set when the command is reproposed with a new lease index
+	// (see tryReproposeWithNewLeaseIndex).
+	reproposed bool
+
 	// doneCh is used to signal the waiting RPC handler (the contents of
 	// proposalResult come from LocalEvalResult).
 	//
@@ -104,6 +113,17 @@ type ProposalData struct {
 	// Always use ProposalData.finishApplication().
 	doneCh chan proposalResult
 
+	// durableApply tracks the durability of the command's application for
+	// proposals whose LocalResult sets RequireDurableApply. Until the batch
+	// which applied the command has been synced, a successful result is held
+	// back in pending rather than being sent on doneCh. It is only accessed
+	// on the Raft goroutine.
+	durableApply struct {
+		required bool
+		synced   bool
+		pending  *proposalResult
+	}
+
 	// Local contains the results of evaluating the request tying the upstream
 	// evaluation of the request to the downstream application of the command.
 	// Nil when the proposal came from another node (i.e. the evaluation wasn't
@@ -132,11 +152,27 @@ type ProposalData struct {
 // is canceled, it won't be listening to this done channel, and so it can't be
 // counted on to invoke endCmds itself.)
 //
+// If the command requires a durable application, a successful result is only
+// sent once signalDurableApply has been called. endCmds is invoked regardless,
+// and errors are returned right away since the command didn't apply.
+//
 // The method is safe to call more than once, but only the first result will be
 // returned to the client.
 func (proposal *ProposalData) finishApplication(ctx context.Context, pr proposalResult) {
+	var onEndCmdsDone func(kvserverbase.CmdIDKey, bool)
+	if repl := proposal.ec.repl; repl != nil {
+		onEndCmdsDone = repl.store.TestingKnobs().OnProposalEndCmdsDone
+	}
 	proposal.ec.done(ctx, proposal.Request, pr.Reply, pr.Err)
-	proposal.signalProposalResult(pr)
+	if onEndCmdsDone != nil {
+		onEndCmdsDone(proposal.idKey, proposal.reproposed)
+	}
+	if pr.Err == nil && proposal.durableApply.required && !proposal.durableApply.synced {
+		log.Event(ctx, "waiting for durable application before acknowledging")
+		proposal.durableApply.pending = &pr
+	} else {
+		proposal.signalProposalResult(pr)
+	}
 	if proposal.sp != nil {
 		tracing.FinishSpan(proposal.sp)
 		proposal.sp = nil
@@ -157,6 +193,17 @@ func (proposal *ProposalData) signalProposalResult(pr proposalResult) {
 	}
 }
 
+// signalDurableApply is called once the application of a command requiring a
+// durable application has been synced to disk. It sends the result held back
+// by finishApplication, if any, on the proposal's done channel.
+func (proposal *ProposalData) signalDurableApply() {
+	proposal.durableApply.synced = true
+	if pr := proposal.durableApply.pending; pr != nil {
+		proposal.durableApply.pending = nil
+		proposal.signalProposalResult(*pr)
+	}
+}
+
 // releaseQuota releases the proposal's quotaAlloc and sets it to nil.
 // If the quotaAlloc is already nil it is a no-op.
 func (proposal *ProposalData) releaseQuota() {
@@ -173,8 +220,18 @@ func (proposal *ProposalData) releaseQuota() {
 
 func (r *Replica) gcOldChecksumEntriesLocked(now time.Time) {
 	for id, val := range r.mu.checksums {
+		if val.started && !val.done() {
+			// The computation is still going to store its result in the entry.
+			continue
+		}
 		// The timestamp is valid only if set.
 		if !val.gcTimestamp.IsZero() && now.After(val.gcTimestamp) {
+			if val.snapshotPath != "" {
+				if err := r.store.engine.Remove(val.snapshotPath); err != nil {
+					log.Warningf(context.Background(), "unable to remove checksum snapshot %s: %v",
+						val.snapshotPath, err)
+				}
+			}
 			delete(r.mu.checksums, id)
 		}
 	}
@@ -199,26 +256,93 @@ func (r *Replica) computeChecksumPostApply(ctx context.Context, cc kvserverpb.Co
 	r.gcOldChecksumEntriesLocked(now)
 
 	// Create an entry with checksum == nil and gcTimestamp unset.
-	r.mu.checksums[cc.ChecksumID] = ReplicaChecksum{started: true, notify: notify}
+	stats := r.mu.state.Stats
+	progress := &checksumProgress{total: stats.Total() + stats.SysBytes}
+	// The computation is cancelled if a split or merge applies while it is in
+	// progress (see invalidateInFlightChecksums). It runs under a context of
+	// its own rather than the one of the command application, which doesn't
+	// outlive it.
+	taskCtx, cancel := context.WithCancel(r.AnnotateCtx(context.Background()))
+	r.mu.checksums[cc.ChecksumID] = ReplicaChecksum{
+		started: true, startTime: now, notify: notify, progress: progress, cancel: cancel,
+	}
 	desc := *r.mu.state.Desc
+	raftAppliedIndex, leaseAppliedIndex := r.mu.state.RaftAppliedIndex, r.mu.state.LeaseAppliedIndex
 	r.mu.Unlock()
 
 	if cc.Version != batcheval.ReplicaChecksumVersion {
-		r.computeChecksumDone(ctx, cc.ChecksumID, nil, nil)
+		r.computeChecksumDone(ctx, cc.ChecksumID, nil, nil, "")
 		log.Infof(ctx, "incompatible ComputeChecksum versions (requested: %d, have: %d)",
 			cc.Version, batcheval.ReplicaChecksumVersion)
 		return
 	}
 
+	if cc.Mode == roachpb.ChecksumMode_CHECK_APPLIED_STATE {
+		// The digest is available right away; there's no need for a snapshot.
+		result := appliedStateDigest(raftAppliedIndex, leaseAppliedIndex, &desc)
+		result.PersistedMS, result.RecomputedMS = *stats, *stats
+		result.SnapshotAppliedIndex = raftAppliedIndex
+		r.computeChecksumDone(ctx, cc.ChecksumID, result, nil, "")
+		return
+	}
+
+	if canChecksumWithoutSnapshot(cc, stats) {
+		// The range holds no user keys and little range-local data, which is
+		// common for freshly split ranges checked en masse by consistency
+		// sweeps. Hash it right away
+		// rather than opening an engine snapshot; the engine can't change
+		// while raftMu is held. All of the replicated spans are still scanned,
+		// so the digest and the recomputed stats are those the regular path
+		// would produce, and inaccurate stats can't hide any data.
+		var asOf hlc.Timestamp
+		if cc.AsOf != nil {
+			asOf = *cc.AsOf
+		}
+		// The scan isn't rate limited, nor does it count against the store's IO
+		// budget: waiting on either would stall Raft application, and the
+		// range is small enough for the scan not to need pacing.
+		result, err := r.sha512(
+			ctx, desc, r.store.engine, nil /* snapshot */, cc.Mode, asOf, cc.ExcludedSpans,
+			limit.NewLimiter(rate.Inf), nil /* storeLimiter */, 1 /* shards */, progress, nil, /* intents */
+		)
+		if err != nil {
+			log.Errorf(ctx, "%v", err)
+			result = nil
+		}
+		if result != nil {
+			result.SnapshotAppliedIndex = raftAppliedIndex
+		}
+		r.computeChecksumDone(ctx, cc.ChecksumID, result, nil, "")
+		return
+	}
+
+	if r.store.IsDraining() {
+		// Don't open an engine snapshot (and queue up a scan of the range) while
+		// the store is shutting down. Collectors are told that the computation
+		// was skipped instead of finding no checksum, which would be
+		// indistinguishable from a failed computation.
+		log.VEventf(ctx, 1, "skipping checksum computation (ID = %s): store draining", cc.ChecksumID)
+		r.computeChecksumSkippedDraining(ctx, cc.ChecksumID)
+		return
+	}
+
 	// Caller is holding raftMu, so an engine snapshot is automatically
 	// Raft-consistent (i.e. not in the middle of an AddSSTable).
-	snap := r.store.engine.NewSnapshot()
+	var snap storage.Reader
+	var releaseSnap func()
+	if fn := r.store.cfg.TestingKnobs.ConsistencyTestingKnobs.ChecksumSnapshot; fn != nil {
+		snap, releaseSnap = fn(r.RangeID)
+	} else {
+		engSnap := r.store.engine.NewSnapshot()
+		snap, releaseSnap = engSnap, engSnap.Close
+	}
+	// Record the applied index the snapshot was taken at, which lets the
+	// collector tell whether the replicas hashed the same logical state.
+	rai, _, err := stateloader.Make(r.RangeID).LoadAppliedIndex(ctx, snap)
+	if err != nil {
+		log.Warningf(ctx, "unable to load applied index, continuing anyway")
+	}
 	if cc.Checkpoint {
-		sl := stateloader.Make(r.RangeID)
-		rai, _, err := sl.LoadAppliedIndex(ctx, snap)
-		if err != nil {
-			log.Warningf(ctx, "unable to load applied index, continuing anyway")
-		}
 		// NB: the names here will match on all nodes, which is nice for debugging.
 		tag := fmt.Sprintf("r%d_at_%d", r.RangeID, rai)
 		if dir, err := r.store.checkpoint(ctx, tag); err != nil {
@@ -229,22 +353,92 @@ func (r *Replica) computeChecksumPostApply(ctx context.Context, cc kvserverpb.Co
 	}
 
 	limiter := limit.NewLimiter(rate.Limit(consistencyCheckRate.Get(&r.store.ClusterSettings().SV)))
+	shards := int(consistencyCheckShards.Get(&r.store.ClusterSettings().SV))
 
 	// Compute SHA asynchronously and store it in a map by UUID.
-	if err := stopper.RunAsyncTask(ctx, "storage.Replica: computing checksum", func(ctx context.Context) {
+	if err := stopper.RunAsyncTask(taskCtx, "storage.Replica: computing checksum", func(ctx context.Context) {
 		func() {
-			defer snap.Close()
+			defer releaseSnap()
+			// Wait for a slot here rather than on the Raft application path, which
+			// would hold up the range's commands in the meantime. The wait is only
+			// cut short if the computation is invalidated or the server shuts
+			// down, either of which would cancel the computation anyway.
+			waitCtx, cancelWait := stopper.WithCancelOnQuiesce(ctx)
+			release, err := r.store.checksumScheduler.acquire(waitCtx, r.RangeID)
+			cancelWait()
+			if err != nil {
+				log.Infof(ctx, "checksum computation (ID = %s) cancelled: %v", cc.ChecksumID, err)
+				r.computeChecksumDone(ctx, cc.ChecksumID, nil, nil, "")
+				return
+			}
+			defer release()
+
+			var sink checksumSnapshotSink
 			var snapshot *roachpb.RaftSnapshotData
+			var fileSink *fileSnapshotSink
+			var cappedSink *cappedSnapshotSink
 			if cc.SaveSnapshot {
-				snapshot = &roachpb.RaftSnapshotData{}
+				if consistencyCheckStreamSnapshots.Get(&r.store.ClusterSettings().SV) {
+					var err error
+					if fileSink, err = createFileSnapshotSink(
+						r.store.engine, r.RangeID, cc.ChecksumID,
+					); err != nil {
+						log.Warningf(ctx, "unable to create checksum snapshot file, "+
+							"holding snapshot in memory: %+v", err)
+					}
+				}
+				if fileSink != nil {
+					sink = fileSink
+				} else {
+					snapshot = &roachpb.RaftSnapshotData{}
+					sink = &memSnapshotSink{data: snapshot}
+				}
+				if max := consistencyCheckMaxSnapshotSize.Get(&r.store.ClusterSettings().SV); max > 0 {
+					cappedSink = &cappedSnapshotSink{sink: sink, max: max}
+					sink = cappedSink
+				}
 			}
 
-			result, err := r.sha512(ctx, desc, snap, snapshot, cc.Mode, limiter)
+			var asOf hlc.Timestamp
+			if cc.AsOf != nil {
+				asOf = *cc.AsOf
+			}
+			var intents *checksumIntents
+			if cc.CollectIntents {
+				intents = &checksumIntents{}
+			}
+			result, err := r.sha512(
+				ctx, desc, snap, sink, cc.Mode, asOf, cc.ExcludedSpans, limiter, r.store.consistencyIOLimiter(),
+				shards, progress, intents,
+			)
 			if err != nil {
-				log.Errorf(ctx, "%v", err)
+				if ctx.Err() != nil {
+					log.Infof(ctx, "checksum computation (ID = %s) cancelled: %v", cc.ChecksumID, err)
+				} else {
+					log.Errorf(ctx, "%v", err)
+				}
 				result = nil
 			}
-			r.computeChecksumDone(ctx, cc.ChecksumID, result, snapshot)
+			var snapshotPath string
+			if fileSink != nil {
+				snapshotPath = fileSink.path
+				if err := fileSink.close(); err != nil {
+					log.Errorf(ctx, "%v", err)
+					result = nil
+				}
+			}
+			if result != nil {
+				result.SnapshotAppliedIndex = rai
+			}
+			if result != nil && cappedSink != nil {
+				result.SnapshotTruncated = cappedSink.truncated
+			}
+			// A truncated snapshot can't reproduce the checksum, so it is not
+			// verified.
+			if result != nil && sink != nil && cc.VerifySnapshot && !result.SnapshotTruncated {
+				r.assertChecksumSnapshot(ctx, cc.Mode, result, snapshot, snapshotPath)
+			}
+			r.computeChecksumDone(ctx, cc.ChecksumID, result, snapshot, snapshotPath)
 		}()
 
 		var shouldFatal bool
@@ -290,13 +484,91 @@ A file preventing this node from restarting was placed at:
 				log.Fatalf(r.AnnotateCtx(context.Background()), preventStartupMsg)
 			}
 		}
-
 	}); err != nil {
-		defer snap.Close()
+		releaseSnap()
 		log.Errorf(ctx, "could not run async checksum computation (ID = %s): %v", cc.ChecksumID, err)
 		// Set checksum to nil.
-		r.computeChecksumDone(ctx, cc.ChecksumID, nil, nil)
+		r.computeChecksumDone(ctx, cc.ChecksumID, nil, nil, "")
+	}
+}
+
+// delayTimestampCacheLowWaterMark sets the low water mark of the timestamp
+// cache for the replica's keys after the given delay, either blocking for the
+// delay or doing so asynchronously. It is only used when the
+// LeasePostApplyInterceptor testing knob requests that the update be delayed.
+func (r *Replica) delayTimestampCacheLowWaterMark(
+	ctx context.Context, ts hlc.Timestamp, delay time.Duration, async bool,
+) {
+	desc := r.Desc()
+	if !async {
+		time.Sleep(delay)
+		setTimestampCacheLowWaterMark(r.store.tsCache, desc, ts)
+		return
+	}
+	stopper := r.store.Stopper()
+	if err := stopper.RunAsyncTask(ctx, "storage.Replica: delayed timestamp cache low water",
+		func(ctx context.Context) {
+			select {
+			case <-time.After(delay):
+				setTimestampCacheLowWaterMark(r.store.tsCache, desc, ts)
+			case <-stopper.ShouldQuiesce():
+			}
+		}); err != nil {
+		log.Infof(ctx, "unable to set timestamp cache low water: %s", err)
+	}
+}
+
+// largeLowWaterJumpThreshold is the amount by which a lease acquisition needs
+// to raise the timestamp cache low water mark to be counted in the
+// leases.tscache_low_water_jump.large metric.
+const largeLowWaterJumpThreshold = time.Second
+
+// recordLowWaterJump records the amount by which setting the timestamp cache
+// low water mark to the given lease start raises the low water mark for the
+// range's keys. Requests that would have been permitted to write below the new
+// lease start are pushed, so large jumps can cause client retries.
+//
+// The previous low water mark is the one this replica last set, forwarded by
+// that of the timestamp cache as a whole; looking it up doesn't scan the
+// timestamp cache, whose entries for the range reflect the reads it served
+// rather than its low water mark.
+func (r *Replica) recordLowWaterJump(leaseStart hlc.Timestamp) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	prev := r.store.tsCache.GetLowWater()
+	prev.Forward(r.mu.tsCacheLowWater)
+	r.mu.tsCacheLowWater.Forward(leaseStart)
+	var jump int64
+	if prev.Less(leaseStart) {
+		jump = leaseStart.WallTime - prev.WallTime
+	}
+	r.store.metrics.LeaseLowWaterJump.RecordValue(jump)
+	if jump > largeLowWaterJumpThreshold.Nanoseconds() {
+		r.store.metrics.LeaseLowWaterJumpLarge.Inc(1)
+	}
+}
+
+// leaseTransitionLogTags annotates the context with the fields of a lease
+// transition. The "new range lease" message is logged under these tags so that
+// log processing can index the transition by its fields instead of having to
+// parse the formatted leases.
+func leaseTransitionLogTags(
+	ctx context.Context,
+	rangeID roachpb.RangeID,
+	prevLease, newLease roachpb.Lease,
+	physicalTime time.Time,
+) context.Context {
+	ctx = logtags.AddTag(ctx, "range_id", int64(rangeID))
+	ctx = logtags.AddTag(ctx, "prev_store", int64(prevLease.Replica.StoreID))
+	ctx = logtags.AddTag(ctx, "new_store", int64(newLease.Replica.StoreID))
+	// The timestamps are rendered without commas, which delimit the tags.
+	ctx = logtags.AddTag(ctx, "lease_start", newLease.Start.GoTime().UTC().Format(time.RFC3339Nano))
+	leaseType := "expiration"
+	if newLease.Type() == roachpb.LeaseEpoch {
+		leaseType = "epoch"
 	}
+	ctx = logtags.AddTag(ctx, "lease_type", leaseType)
+	return logtags.AddTag(ctx, "physical_time", physicalTime.UTC().Format(time.RFC3339Nano))
 }
 
 // leasePostApply updates the Replica's internal state to reflect the
@@ -306,7 +578,9 @@ A file preventing this node from restarting was placed at:
 // default, the method will also panic if passed a lease that indicates a
 // forward sequence number jump (i.e. a skipped lease). This behavior can
 // be disabled by passing permitJump as true.
-func (r *Replica) leasePostApply(ctx context.Context, newLease roachpb.Lease, permitJump bool) {
+func (r *Replica) leasePostApply(
+	ctx context.Context, newLease roachpb.Lease, permitJump bool, proposedAt time.Time,
+) {
 	r.mu.Lock()
 	replicaID := r.mu.replicaID
 	// Pull out the last lease known to this Replica. It's possible that this is
@@ -325,11 +599,39 @@ func (r *Replica) leasePostApply(ctx context.Context, newLease roachpb.Lease, pe
 	// timestamp cache.
 	leaseChangingHands := prevLease.Replica.StoreID != newLease.Replica.StoreID || prevLease.Sequence != newLease.Sequence
 
+	if leaseChangingHands {
+		// The start of the new lease was taken from the proposer's clock, so its
+		// distance from the local clock hints at clock skew and replication lag.
+		skew := r.store.Clock().PhysicalNow() - newLease.Start.WallTime
+		if skew < 0 {
+			skew = -skew
+		}
+		r.store.metrics.LeaseStartSkew.RecordValue(skew)
+	}
+	// Only the replica which proposed the lease knows when it did so.
+	if !proposedAt.IsZero() {
+		r.store.metrics.LeaseApplyLatency.RecordValue(timeutil.Since(proposedAt).Nanoseconds())
+	}
+
+	var lowWaterDelay time.Duration
+	var suppressLowWater bool
 	if iAmTheLeaseHolder {
+		if fn := r.store.TestingKnobs().LeasePostApplyInterceptor; fn != nil {
+			lowWaterDelay, suppressLowWater = fn(ctx, newLease)
+		}
 		// Log lease acquisition whenever an Epoch-based lease changes hands (or verbose
 		// logging is enabled).
 		if newLease.Type() == roachpb.LeaseEpoch && leaseChangingHands || log.V(1) {
-			log.VEventf(ctx, 1, "new range lease %s following %s", newLease, prevLease)
+			logCtx := leaseTransitionLogTags(ctx, r.RangeID, prevLease, newLease, r.store.Clock().PhysicalTime())
+			log.VEventf(logCtx, 1, "new range lease %s following %s", newLease, prevLease)
+		}
+	} else if leaseChangingHands && prevLease.OwnedBy(r.store.StoreID()) {
+		// This store held the previous lease and it moved elsewhere.
+		r.store.metrics.LeaseLostCount.Inc(1)
+		if newLease.Type() == roachpb.LeaseEpoch || log.V(1) {
+			logCtx := leaseTransitionLogTags(ctx, r.RangeID, prevLease, newLease, r.store.Clock().PhysicalTime())
+			log.VEventf(logCtx, 1, "range lease %s lost to s%d, replaced by %s",
+				prevLease, newLease.Replica.StoreID, newLease)
 		}
 	}
 
@@ -356,7 +658,22 @@ func (r *Replica) leasePostApply(ctx context.Context, newLease roachpb.Lease, pe
 		// requests, this is kosher). This means that we don't use the old
 		// lease's expiration but instead use the new lease's start to initialize
 		// the timestamp cache low water.
-		setTimestampCacheLowWaterMark(r.store.tsCache, r.Desc(), newLease.Start)
+		//
+		// Leases starting more than the maximum clock offset ahead of the
+		// evaluating replica's clock are rejected during evaluation, so a start
+		// that far ahead of the local clock here points at clock skew between
+		// the evaluating and the applying node. Log it, but install the low
+		// water mark at the start of the lease regardless.
+		if maxStart := r.store.Clock().Now().Add(r.store.Clock().MaxOffset().Nanoseconds(), 0); maxStart.Less(newLease.Start) {
+			log.Warningf(ctx, "lease %s starts more than the maximum clock offset ahead of the "+
+				"local clock (%s)", newLease, maxStart)
+		}
+		r.recordLowWaterJump(newLease.Start)
+		if lowWaterDelay == 0 {
+			setTimestampCacheLowWaterMark(r.store.tsCache, r.Desc(), newLease.Start)
+		} else {
+			r.delayTimestampCacheLowWaterMark(ctx, newLease.Start, lowWaterDelay, suppressLowWater)
+		}
 
 		// Reset the request counts used to make lease placement decisions whenever
 		// starting a new lease.
@@ -397,6 +714,16 @@ func (r *Replica) leasePostApply(ctx context.Context, newLease roachpb.Lease, pe
 	// in serializability violations.
 	r.mu.Lock()
 	r.mu.state.Lease = &newLease
+	// Start the cooldown whenever the lease moves to another store, but not
+	// when a store reacquires its own lease, e.g. after it expired or after a
+	// restart, nor for the first lease of a range. Leases taken over from
+	// another store after they expired count: applying the lease can't tell
+	// them apart from transfers, and moving such a lease again right away
+	// thrashes the range just the same.
+	if prevLease.Replica.StoreID != 0 && prevLease.Replica.StoreID != newLease.Replica.StoreID {
+		cooldown := leaseTransferCooldown.Get(&r.store.cfg.Settings.SV)
+		r.mu.leaseTransferCooldownUntil = timeutil.Now().Add(cooldown)
+	}
 	expirationBasedLease := r.requiresExpiringLeaseRLocked()
 	r.mu.Unlock()
 
@@ -419,15 +746,23 @@ func (r *Replica) leasePostApply(ctx context.Context, newLease roachpb.Lease, pe
 	}
 
 	// If we're the current raft leader, may want to transfer the leadership to
-	// the new leaseholder. Note that this condition is also checked periodically
-	// when ticking the replica.
-	r.maybeTransferRaftLeadership(ctx)
+	// the new leaseholder. Lease extensions don't change where leadership
+	// belongs; the condition is checked periodically by the store's leadership
+	// transfer checker.
+	if leaseChangingHands {
+		r.maybeTransferRaftLeadership(ctx)
+	}
 
 	// Notify the store that a lease change occurred and it may need to
 	// gossip the updated store descriptor (with updated capacity).
 	prevOwner := prevLease.OwnedBy(r.store.StoreID())
 	currentOwner := newLease.OwnedBy(r.store.StoreID())
 	if leaseChangingHands && (prevOwner || currentOwner) {
+		// Publish the new leaseholder, which is either us or the replica that
+		// took the lease from us.
+		if dir := r.store.cfg.LeaseholderDirectory; dir != nil {
+			dir.UpdateLeaseholder(r.RangeID, newLease.Replica)
+		}
 		if currentOwner {
 			r.store.maybeGossipOnCapacityChange(ctx, leaseAddEvent)
 		} else if prevOwner {
@@ -471,6 +806,15 @@ func (r *Replica) leasePostApply(ctx context.Context, newLease roachpb.Lease, pe
 	if r.leaseHistory != nil {
 		r.leaseHistory.add(newLease)
 	}
+	if leaseChangingHands {
+		r.leaseTransitions.add(LeaseTransition{
+			PrevStoreID: prevLease.Replica.StoreID,
+			NewStoreID:  newLease.Replica.StoreID,
+			Timestamp:   r.store.Clock().Now(),
+			Acquired:    currentOwner && !prevOwner,
+			Lost:        prevOwner && !currentOwner,
+		})
+	}
 }
 
 func addSSTablePreApply(
@@ -601,6 +945,8 @@ func (r *Replica) handleReadWriteLocalEvalResult(ctx context.Context, lResult re
 	// that all fields were handled).
 	{
 		lResult.Reply = nil
+		// Handled by ProposalData.finishApplication.
+		lResult.RequireDurableApply = false
 	}
 
 	// The caller is required to detach and handle the following three fields.
@@ -642,25 +988,15 @@ func (r *Replica) handleReadWriteLocalEvalResult(ctx context.Context, lResult re
 		// blocks waiting for the lease acquisition to finish but it can't finish
 		// because we're not processing raft messages due to holding
 		// processRaftMu (and running on the processRaft goroutine).
-		if err := r.store.Stopper().RunAsyncTask(
-			ctx, "storage.Replica: gossipping first range",
-			func(ctx context.Context) {
-				hasLease, pErr := r.getLeaseForGossip(ctx)
-
-				if pErr != nil {
-					log.Infof(ctx, "unable to gossip first range; hasLease=%t, err=%s", hasLease, pErr)
-				} else if !hasLease {
-					return
-				}
-				r.gossipFirstRange(ctx)
-			}); err != nil {
-			log.Infof(ctx, "unable to gossip first range: %s", err)
-		}
+		//
+		// Gossips requested in quick succession or while another one is still
+		// running are coalesced; see maybeGossipFirstRangeAsync.
+		r.maybeGossipFirstRangeAsync(ctx)
 		lResult.GossipFirstRange = false
 	}
 
 	if lResult.MaybeAddToSplitQueue {
-		r.store.splitQueue.MaybeAddAsync(ctx, r, r.store.Clock().Now())
+		r.maybeAddToSplitQueueAfterApply(ctx, r.store.Clock().Now())
 		lResult.MaybeAddToSplitQueue = false
 	}
 
@@ -679,9 +1015,7 @@ func (r *Replica) handleReadWriteLocalEvalResult(ctx context.Context, lResult re
 	}
 
 	if lResult.MaybeGossipNodeLiveness != nil {
-		if err := r.MaybeGossipNodeLiveness(ctx, *lResult.MaybeGossipNodeLiveness); err != nil {
-			log.Errorf(ctx, "%v", err)
-		}
+		r.maybeGossipNodeLivenessWithRetry(ctx, *lResult.MaybeGossipNodeLiveness)
 		lResult.MaybeGossipNodeLiveness = nil
 	}
 
@@ -690,9 +1024,27 @@ func (r *Replica) handleReadWriteLocalEvalResult(ctx context.Context, lResult re
 		lResult.Metrics = nil
 	}
 
-	if !lResult.IsZero() {
-		log.Fatalf(ctx, "unhandled field in LocalEvalResult: %s", pretty.Diff(lResult, result.LocalResult{}))
+	if !lResult.IsZero() && !r.tolerateUnhandledEvalResult(ctx, "LocalEvalResult", &lResult) {
+		log.Fatalf(ctx, "unhandled field in LocalEvalResult: %s", &lResult)
+	}
+}
+
+// tolerateUnhandledEvalResult is called when res, an evaluation result of the
+// given kind, still has fields set after all of the fields known to this node
+// have been handled. If the kv.raft.tolerate_unhandled_eval_result_fields
+// setting is enabled, it logs an error and returns true, in which case the
+// caller drops the remaining fields. Otherwise, the caller is expected to
+// crash.
+func (r *Replica) tolerateUnhandledEvalResult(
+	ctx context.Context, kind string, res fmt.Stringer,
+) bool {
+	if !tolerateUnhandledEvalResultFields.Get(&r.store.cfg.Settings.SV) {
+		return false
 	}
+	err := errors.AssertionFailedf("unhandled field in %s: %s", kind, res)
+	log.Errorf(ctx, "%v", err)
+	r.recordAnomaly("unhandled eval result field", err)
+	return true
 }
 
 // proposalResult indicates the result of a proposal. Exactly one of
@@ -774,7 +1126,7 @@ func (r *Replica) evaluateProposal(
 	// 3. the request has replicated side-effects.
 	needConsensus := !batch.Empty() ||
 		ms != (enginepb.MVCCStats{}) ||
-		!res.Replicated.Equal(kvserverpb.ReplicatedEvalResult{})
+		!res.Replicated.IsZero()
 
 	if needConsensus {
 		// Set the proposal's WriteBatch, which is the serialized representation of
@@ -856,14 +1208,16 @@ func (r *Replica) requestToProposal(
 
 	// Fill out the results even if pErr != nil; we'll return the error below.
 	proposal := &ProposalData{
-		ctx:     ctx,
-		idKey:   idKey,
-		doneCh:  make(chan proposalResult, 1),
-		Local:   &res.Local,
-		Request: ba,
+		ctx:       ctx,
+		idKey:     idKey,
+		doneCh:    make(chan proposalResult, 1),
+		createdAt: timeutil.Now(),
+		Local:     &res.Local,
+		Request:   ba,
 	}
 
 	if needConsensus {
+		proposal.durableApply.required = res.Local.RequireDurableApply
 		proposal.command = &kvserverpb.RaftCommand{
 			ReplicatedEvalResult: res.Replicated,
 			WriteBatch:           res.WriteBatch,
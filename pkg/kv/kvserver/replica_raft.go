@@ -335,6 +335,41 @@ func (r *Replica) propose(ctx context.Context, p *ProposalData) (index int64, pE
 	return int64(maxLeaseIndex), nil
 }
 
+// InflightProposalAge describes a command which was proposed to Raft by the
+// replica and is waiting to be applied.
+type InflightProposalAge struct {
+	CmdID kvserverbase.CmdIDKey
+	// ProposedAtTicks is the replica's tick count at the time the command was
+	// last (re-)proposed.
+	ProposedAtTicks int
+	// AgeTicks is the number of ticks the replica has seen since.
+	AgeTicks int
+}
+
+// InflightProposalAges reports the age in ticks of the replica's pending
+// proposals, oldest first, which helps debugging commands that are slow to
+// apply. Commands still in the proposal buffer haven't been proposed to Raft
+// yet and aren't reported.
+func (r *Replica) InflightProposalAges() []InflightProposalAge {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	ages := make([]InflightProposalAge, 0, len(r.mu.proposals))
+	for id, p := range r.mu.proposals {
+		ages = append(ages, InflightProposalAge{
+			CmdID:           id,
+			ProposedAtTicks: p.proposedAtTicks,
+			AgeTicks:        r.mu.ticks - p.proposedAtTicks,
+		})
+	}
+	sort.Slice(ages, func(i, j int) bool {
+		if ages[i].ProposedAtTicks != ages[j].ProposedAtTicks {
+			return ages[i].ProposedAtTicks < ages[j].ProposedAtTicks
+		}
+		return ages[i].CmdID < ages[j].CmdID
+	})
+	return ages
+}
+
 func (r *Replica) numPendingProposalsRLocked() int {
 	return len(r.mu.proposals) + r.mu.proposalBuf.Len()
 }
@@ -905,8 +940,6 @@ func (r *Replica) tick(livenessMap IsLiveMap) (bool, error) {
 		return false, nil
 	}
 
-	r.maybeTransferRaftLeadershipLocked(ctx)
-
 	// For followers, we update lastUpdateTimes when we step a message from them
 	// into the local Raft group. The leader won't hit that path, so we update
 	// it whenever it ticks. In effect, this makes sure it always sees itself as
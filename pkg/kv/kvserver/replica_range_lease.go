@@ -621,6 +621,14 @@ func (r *Replica) requestLeaseLocked(
 		ctx, repDesc, status, r.mu.state.Desc.StartKey.AsRawKey(), false /* transfer */)
 }
 
+// inLeaseTransferCooldown returns whether the replica's lease changed hands
+// too recently for it to be transferred again for rebalancing.
+func (r *Replica) inLeaseTransferCooldown() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return timeutil.Now().Before(r.mu.leaseTransferCooldownUntil)
+}
+
 // AdminTransferLease transfers the LeaderLease to another replica. A
 // valid LeaseStatus must be supplied. Only the current holder of the
 // LeaderLease can do a transfer, because it needs to stop serving
@@ -23,7 +23,6 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/storage"
 	"github.com/cockroachdb/cockroach/pkg/util"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
-	"github.com/kr/pretty"
 )
 
 // executeReadOnlyBatch is the execution logic for client requests which do not
@@ -97,7 +96,7 @@ func (r *Replica) executeReadOnlyBatch(
 		// range descriptor cache has an in-flight RangeLookup request which
 		// prohibits any concurrent requests for the same range. See #17760.
 		allowSyncProcessing := ba.ReadConsistency == roachpb.CONSISTENT
-		if err := r.store.intentResolver.CleanupIntentsAsync(ctx, intents, allowSyncProcessing); err != nil {
+		if err := r.cleanupEncounteredIntentsAsync(ctx, intents, allowSyncProcessing); err != nil {
 			log.Warningf(ctx, "%v", err)
 		}
 	}
@@ -176,8 +175,8 @@ func (r *Replica) handleReadOnlyLocalEvalResult(
 		lResult.MaybeWatchForMerge = false
 	}
 
-	if !lResult.IsZero() {
-		log.Fatalf(ctx, "unhandled field in LocalEvalResult: %s", pretty.Diff(lResult, result.LocalResult{}))
+	if !lResult.IsZero() && !r.tolerateUnhandledEvalResult(ctx, "LocalEvalResult", &lResult) {
+		log.Fatalf(ctx, "unhandled field in LocalEvalResult: %s", &lResult)
 	}
 	return nil
 }
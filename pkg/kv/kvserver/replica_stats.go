@@ -15,6 +15,7 @@ import (
 	"time"
 
 	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/storage/enginepb"
 	"github.com/cockroachdb/cockroach/pkg/util/hlc"
 	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
 	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
@@ -224,3 +225,117 @@ func (rs *replicaStats) resetRequestCounts() {
 	rs.mu.lastRotate = timeutil.Unix(0, rs.clock.PhysicalNow())
 	rs.mu.lastReset = rs.mu.lastRotate
 }
+
+const (
+	// statsDeltaBucketDuration is the granularity at which statsDeltaHistory
+	// accumulates the applied MVCCStats deltas.
+	statsDeltaBucketDuration = 10 * time.Second
+	// statsDeltaBucketCount bounds the window covered by statsDeltaHistory.
+	statsDeltaBucketCount = 60
+)
+
+// MaxStatsDeltaWindow is the largest window accepted by
+// Replica.RecentStatsDeltas.
+const MaxStatsDeltaWindow = statsDeltaBucketCount * statsDeltaBucketDuration
+
+// statsDeltaHistory accumulates the MVCCStats deltas applied to a replica into
+// a circular buffer of fixed-duration buckets, so that the churn of the range
+// over a recent window can be reported. The zero value is ready to use. It is
+// not safe for concurrent use; Replica keeps it under r.mu.
+type statsDeltaHistory struct {
+	buckets [statsDeltaBucketCount]statsDeltaBucket
+}
+
+// statsDeltaBucket holds the fields of the MVCCStats deltas which measure
+// churn. The age fields are left out: a delta's ages depend on when it was
+// aged, so summing them across deltas yields nothing meaningful.
+type statsDeltaBucket struct {
+	// epoch is the number of statsDeltaBucketDurations since the Unix epoch at
+	// the start of the bucket.
+	epoch int64
+
+	liveBytes, keyBytes, valBytes, intentBytes, sysBytes int64
+	liveCount, keyCount, valCount, intentCount, sysCount int64
+}
+
+func (b *statsDeltaBucket) add(delta *enginepb.MVCCStats) {
+	b.liveBytes += delta.LiveBytes
+	b.keyBytes += delta.KeyBytes
+	b.valBytes += delta.ValBytes
+	b.intentBytes += delta.IntentBytes
+	b.sysBytes += delta.SysBytes
+	b.liveCount += delta.LiveCount
+	b.keyCount += delta.KeyCount
+	b.valCount += delta.ValCount
+	b.intentCount += delta.IntentCount
+	b.sysCount += delta.SysCount
+}
+
+func (b *statsDeltaBucket) addTo(ms *enginepb.MVCCStats) {
+	ms.LiveBytes += b.liveBytes
+	ms.KeyBytes += b.keyBytes
+	ms.ValBytes += b.valBytes
+	ms.IntentBytes += b.intentBytes
+	ms.SysBytes += b.sysBytes
+	ms.LiveCount += b.liveCount
+	ms.KeyCount += b.keyCount
+	ms.ValCount += b.valCount
+	ms.IntentCount += b.intentCount
+	ms.SysCount += b.sysCount
+}
+
+func statsDeltaEpoch(t time.Time) int64 {
+	return t.UnixNano() / int64(statsDeltaBucketDuration)
+}
+
+// record adds a delta applied at the given time.
+func (h *statsDeltaHistory) record(now time.Time, delta *enginepb.MVCCStats) {
+	epoch := statsDeltaEpoch(now)
+	b := &h.buckets[epoch%statsDeltaBucketCount]
+	if b.epoch != epoch {
+		*b = statsDeltaBucket{epoch: epoch}
+	}
+	b.add(delta)
+}
+
+// sum returns the sum of the deltas recorded over the window preceding now.
+// The window is rounded up to a multiple of statsDeltaBucketDuration and
+// capped at MaxStatsDeltaWindow. Only the fields kept by statsDeltaBucket are
+// set.
+func (h *statsDeltaHistory) sum(now time.Time, window time.Duration) enginepb.MVCCStats {
+	n := int64((window + statsDeltaBucketDuration - 1) / statsDeltaBucketDuration)
+	if n > statsDeltaBucketCount {
+		n = statsDeltaBucketCount
+	}
+	cur := statsDeltaEpoch(now)
+	var sum enginepb.MVCCStats
+	for i := range h.buckets {
+		if b := &h.buckets[i]; b.epoch > cur-n && b.epoch <= cur {
+			b.addTo(&sum)
+		}
+	}
+	return sum
+}
+
+// growthRate returns the average rate, in bytes per second, at which the
+// deltas retained by the history grew the range's total size (see
+// MVCCStats.Total). ok is false if all of the retained deltas were applied
+// within the current bucket, which is too little history to extrapolate from.
+func (h *statsDeltaHistory) growthRate(now time.Time) (bytesPerSecond float64, ok bool) {
+	cur := statsDeltaEpoch(now)
+	oldest := cur
+	var total int64
+	for i := range h.buckets {
+		if b := &h.buckets[i]; b.epoch > cur-statsDeltaBucketCount && b.epoch <= cur {
+			total += b.keyBytes + b.valBytes
+			if b.epoch < oldest {
+				oldest = b.epoch
+			}
+		}
+	}
+	if oldest == cur {
+		return 0, false
+	}
+	elapsed := now.Sub(timeutil.Unix(0, oldest*int64(statsDeltaBucketDuration)))
+	return float64(total) / elapsed.Seconds(), true
+}
@@ -17,8 +17,10 @@ import (
 	"time"
 
 	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/storage/enginepb"
 	"github.com/cockroachdb/cockroach/pkg/util/hlc"
 	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
 	"github.com/kr/pretty"
 )
 
@@ -369,3 +371,61 @@ func TestReplicaStatsDecaySmoothing(t *testing.T) {
 		}
 	}
 }
+
+func TestStatsDeltaHistory(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	var h statsDeltaHistory
+	start := timeutil.Unix(0, 0).Add(1000 * statsDeltaBucketDuration)
+	// The deltas carry an age, which isn't summed.
+	delta := func(n int64) *enginepb.MVCCStats {
+		return &enginepb.MVCCStats{LiveBytes: n, LiveCount: 1, GCBytesAge: n}
+	}
+	churn := func(ms *enginepb.MVCCStats) enginepb.MVCCStats {
+		return enginepb.MVCCStats{LiveBytes: ms.LiveBytes, LiveCount: ms.LiveCount}
+	}
+
+	// Apply a delta at the start of each bucket, for more than the history
+	// retains.
+	for i := 0; i < 2*statsDeltaBucketCount; i++ {
+		h.record(start.Add(time.Duration(i)*statsDeltaBucketDuration), delta(int64(i)))
+	}
+	now := start.Add((2*statsDeltaBucketCount - 1) * statsDeltaBucketDuration)
+	// expected sums the deltas of the last n buckets.
+	expected := func(n int64) enginepb.MVCCStats {
+		var sum enginepb.MVCCStats
+		for i := 2*statsDeltaBucketCount - n; i < 2*statsDeltaBucketCount; i++ {
+			sum.Add(churn(delta(i)))
+		}
+		return sum
+	}
+	for _, tc := range []struct {
+		window  time.Duration
+		buckets int64
+	}{
+		{statsDeltaBucketDuration, 1},
+		{statsDeltaBucketDuration + 1, 2},
+		{5 * statsDeltaBucketDuration, 5},
+		{MaxStatsDeltaWindow, statsDeltaBucketCount},
+		// Windows larger than the history are capped.
+		{2 * MaxStatsDeltaWindow, statsDeltaBucketCount},
+	} {
+		if sum := h.sum(now, tc.window); sum != expected(tc.buckets) {
+			t.Errorf("window %s: expected %+v, got %+v", tc.window, expected(tc.buckets), sum)
+		}
+	}
+
+	// Deltas applied within a bucket accumulate.
+	h.record(now.Add(time.Second), delta(1000))
+	exp := expected(1)
+	exp.Add(churn(delta(1000)))
+	if sum := h.sum(now.Add(time.Second), statsDeltaBucketDuration); sum != exp {
+		t.Errorf("expected %+v, got %+v", exp, sum)
+	}
+
+	// Once time passes, the old buckets no longer count.
+	later := now.Add(MaxStatsDeltaWindow)
+	if sum := h.sum(later, MaxStatsDeltaWindow); sum != (enginepb.MVCCStats{}) {
+		t.Errorf("expected empty sum, got %+v", sum)
+	}
+}
@@ -62,10 +62,12 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/util/randutil"
 	"github.com/cockroachdb/cockroach/pkg/util/stop"
 	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
 	"github.com/cockroachdb/cockroach/pkg/util/tracing"
 	"github.com/cockroachdb/cockroach/pkg/util/uuid"
 	"github.com/cockroachdb/errors"
 	"github.com/cockroachdb/logtags"
+	"github.com/cockroachdb/redact"
 	"github.com/gogo/protobuf/proto"
 	"github.com/kr/pretty"
 	opentracing "github.com/opentracing/opentracing-go"
@@ -1198,6 +1200,195 @@ func TestReplicaLeaseCounters(t *testing.T) {
 	}
 }
 
+// TestReplicaLeaseCountersFailedAfterCommit verifies that a lease request which
+// evaluates successfully but then fails once committed to the Raft log is
+// counted as a failed lease request.
+func TestReplicaLeaseCountersFailedAfterCommit(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	stopper := stop.NewStopper()
+	defer stopper.Stop(context.Background())
+
+	var tc testContext
+	cfg := TestStoreConfig(nil)
+	cfg.TestingKnobs.DisableRefreshReasonNewLeader = true
+	cfg.TestingKnobs.DisableRefreshReasonNewLeaderOrConfigChange = true
+	cfg.TestingKnobs.DisableAutomaticLeaseRenewal = true
+	var failLease int32
+	cfg.TestingKnobs.TestingPostApplyFilter = func(
+		args kvserverbase.ApplyFilterArgs,
+	) (int, *roachpb.Error) {
+		if args.IsLeaseRequest && atomic.LoadInt32(&failLease) == 1 {
+			return 0, roachpb.NewErrorf("injected error")
+		}
+		return 0, nil
+	}
+	tc.StartWithStoreConfig(t, stopper, cfg)
+
+	metrics := tc.repl.store.metrics
+	successes := metrics.LeaseRequestSuccessCount.Count()
+	errs := metrics.LeaseRequestErrorCount.Count()
+
+	atomic.StoreInt32(&failLease, 1)
+	now := tc.Clock().Now()
+	err := sendLeaseRequest(tc.repl, &roachpb.Lease{
+		Start:      now,
+		Expiration: now.Add(10, 0).Clone(),
+		Replica: roachpb.ReplicaDescriptor{
+			ReplicaID: 1,
+			NodeID:    1,
+			StoreID:   1,
+		},
+	})
+	require.True(t, testutils.IsError(err, "injected error"), "%v", err)
+	require.Equal(t, successes, metrics.LeaseRequestSuccessCount.Count())
+	require.Equal(t, errs+1, metrics.LeaseRequestErrorCount.Count())
+}
+
+// TestReplicaNodeLivenessGossipRetry verifies that a failed attempt to gossip
+// node liveness records after a command applies is retried asynchronously
+// until it succeeds, and that a gossip of other records doesn't supersede the
+// retry.
+func TestReplicaNodeLivenessGossipRetry(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	origOpts := nodeLivenessGossipRetryOptions
+	defer func() { nodeLivenessGossipRetryOptions = origOpts }()
+	nodeLivenessGossipRetryOptions.InitialBackoff = time.Millisecond
+	nodeLivenessGossipRetryOptions.MaxBackoff = time.Millisecond
+
+	ctx := context.Background()
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+
+	// Only intercept the gossip for our own spans so that unrelated commands
+	// applied during the test don't affect the attempt counts.
+	span1 := roachpb.Span{Key: keys.NodeLivenessKey(1), EndKey: keys.NodeLivenessKey(2)}
+	span2 := roachpb.Span{Key: keys.NodeLivenessKey(2), EndKey: keys.NodeLivenessKey(3)}
+	const failures = 2
+	var attempts1, attempts2 int32
+	retried := make(chan struct{})
+	var tc testContext
+	cfg := TestStoreConfig(nil)
+	cfg.TestingKnobs.NodeLivenessGossipFilter = func(s roachpb.Span) error {
+		switch {
+		case s.Equal(span1):
+			switch a := atomic.AddInt32(&attempts1, 1); {
+			case a == 1:
+				// Gossip another record before the retry gets a chance to run.
+				tc.repl.maybeGossipNodeLivenessWithRetry(ctx, span2)
+				return errors.New("injected gossip failure")
+			case a <= failures:
+				return errors.New("injected gossip failure")
+			case a == failures+1:
+				close(retried)
+			default:
+				// The retry succeeded, so no further attempts should be made.
+				t.Errorf("unexpected gossip attempt %d", a)
+			}
+		case s.Equal(span2):
+			atomic.AddInt32(&attempts2, 1)
+		}
+		return nil
+	}
+	tc.StartWithStoreConfig(t, stopper, cfg)
+
+	tc.repl.maybeGossipNodeLivenessWithRetry(ctx, span1)
+	select {
+	case <-retried:
+	case <-time.After(testutils.DefaultSucceedsSoonDuration):
+		t.Fatalf("gossip wasn't retried; got %d attempt(s)", atomic.LoadInt32(&attempts1))
+	}
+	require.Equal(t, int32(1), atomic.LoadInt32(&attempts2))
+}
+
+// TestReplicaRecentLeaseHistory verifies that lease transitions are recorded
+// in order along with whether the replica acquired or lost the lease.
+func TestReplicaRecentLeaseHistory(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	stopper := stop.NewStopper()
+	defer stopper.Stop(context.Background())
+
+	tc := testContext{manualClock: hlc.NewManualClock(123)}
+	cfg := TestStoreConfig(hlc.NewClock(tc.manualClock.UnixNano, time.Nanosecond))
+	cfg.TestingKnobs.DisableAutomaticLeaseRenewal = true
+	tc.StartWithStoreConfig(t, stopper, cfg)
+
+	secondReplica, err := tc.addBogusReplicaToRangeDesc(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	firstReplica, err := tc.repl.GetReplicaDescriptor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	initial := len(tc.repl.RecentLeaseHistory())
+	if initial == 0 {
+		t.Fatal("expected the initial lease acquisition to be recorded")
+	}
+
+	// Lose the lease to the second replica, then win it back.
+	for _, target := range []roachpb.ReplicaDescriptor{secondReplica, firstReplica} {
+		tc.manualClock.Set(leaseExpiry(tc.repl))
+		start := tc.Clock().Now()
+		if err := sendLeaseRequest(tc.repl, &roachpb.Lease{
+			Start:      start,
+			Expiration: start.Add(10, 0).Clone(),
+			Replica:    target,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	history := tc.repl.RecentLeaseHistory()
+	if e, a := initial+2, len(history); e != a {
+		t.Fatalf("expected %d lease transitions, got %d: %+v", e, a, history)
+	}
+	lost, acquired := history[initial], history[initial+1]
+	if lost.PrevStoreID != firstReplica.StoreID || lost.NewStoreID != secondReplica.StoreID ||
+		!lost.Lost || lost.Acquired {
+		t.Errorf("unexpected transition to second replica: %+v", lost)
+	}
+	if acquired.PrevStoreID != secondReplica.StoreID || acquired.NewStoreID != firstReplica.StoreID ||
+		!acquired.Acquired || acquired.Lost {
+		t.Errorf("unexpected transition back to first replica: %+v", acquired)
+	}
+	if !lost.Timestamp.Less(acquired.Timestamp) {
+		t.Errorf("expected transitions in order: %+v", history)
+	}
+}
+
+// TestReplicaRecentAnomalies verifies that a tolerated anomaly, here a
+// regressing truncated state, is recorded by the replica.
+func TestReplicaRecentAnomalies(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+	tc := testContext{}
+	tc.Start(t, stopper)
+	r := tc.repl
+
+	require.Empty(t, r.RecentAnomalies())
+
+	r.raftMu.Lock()
+	r.mu.RLock()
+	base := *r.mu.state.TruncatedState
+	r.mu.RUnlock()
+	newer := roachpb.RaftTruncatedState{Index: base.Index + 20, Term: base.Term}
+	r.handleTruncatedStateResult(ctx, &newer)
+	older := roachpb.RaftTruncatedState{Index: base.Index + 10, Term: base.Term}
+	r.handleTruncatedStateResult(ctx, &older)
+	r.raftMu.Unlock()
+
+	anomalies := r.RecentAnomalies()
+	require.Len(t, anomalies, 1)
+	require.Equal(t, "truncated state regression", anomalies[0].Condition)
+	require.Contains(t, anomalies[0].Details, fmt.Sprintf("%+v", &older))
+	require.NotZero(t, anomalies[0].Timestamp)
+}
+
 // TestReplicaGossipConfigsOnLease verifies that config info is gossiped
 // upon acquisition of the range lease.
 func TestReplicaGossipConfigsOnLease(t *testing.T) {
@@ -1343,6 +1534,10 @@ func TestReplicaTSCacheLowWaterOnLease(t *testing.T) {
 	}
 
 	for i, test := range testCases {
+		// Leases starting further ahead of the local clock than the maximum
+		// clock offset are rejected, so move the clock along as the proposer's
+		// would have.
+		tc.manualClock.Set(test.start.WallTime)
 		if err := sendLeaseRequest(tc.repl, &roachpb.Lease{
 			Start:      test.start,
 			Expiration: test.expiration.Clone(),
@@ -1366,13 +1561,133 @@ func TestReplicaTSCacheLowWaterOnLease(t *testing.T) {
 	}
 }
 
-// TestReplicaLeaseRejectUnknownRaftNodeID ensures that a replica cannot
-// obtain the range lease if it is not part of the current range descriptor.
-// TODO(mrtracy): This should probably be tested in client_raft_test package,
-// using a real second store.
-func TestReplicaLeaseRejectUnknownRaftNodeID(t *testing.T) {
+// TestReplicaTSCacheLowWaterOnLeaseInterceptor verifies that the
+// LeasePostApplyInterceptor testing knob postpones the update of the timestamp
+// cache's low water mark when the replica acquires the lease.
+func TestReplicaTSCacheLowWaterOnLeaseInterceptor(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	const delay = 100 * time.Millisecond
+	testutils.RunTrueAndFalse(t, "suppress", func(t *testing.T, suppress bool) {
+		stopper := stop.NewStopper()
+		defer stopper.Stop(context.Background())
+
+		var intercepted int32
+		var active atomic.Value
+		active.Store(false)
+		tc := testContext{manualClock: hlc.NewManualClock(123)}
+		cfg := TestStoreConfig(hlc.NewClock(tc.manualClock.UnixNano, time.Nanosecond))
+		cfg.TestingKnobs.DisableAutomaticLeaseRenewal = true
+		cfg.TestingKnobs.LeasePostApplyInterceptor = func(
+			context.Context, roachpb.Lease,
+		) (time.Duration, bool) {
+			if !active.Load().(bool) {
+				return 0, false
+			}
+			atomic.AddInt32(&intercepted, 1)
+			return delay, suppress
+		}
+		tc.StartWithStoreConfig(t, stopper, cfg)
+
+		secondReplica, err := tc.addBogusReplicaToRangeDesc(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		tc.manualClock.Set(leaseExpiry(tc.repl))
+		now := tc.Clock().Now()
+
+		// Hand the lease to the other replica, then take it back.
+		if err := sendLeaseRequest(tc.repl, &roachpb.Lease{
+			Start:      now,
+			Expiration: now.Add(10, 0).Clone(),
+			Replica:    secondReplica,
+		}); err != nil {
+			t.Fatal(err)
+		}
+		replDesc, err := tc.repl.GetReplicaDescriptor()
+		if err != nil {
+			t.Fatal(err)
+		}
+		active.Store(true)
+		tc.manualClock.Set(now.Add(20, 0).WallTime)
+		start := timeutil.Now()
+		if err := sendLeaseRequest(tc.repl, &roachpb.Lease{
+			Start:      now.Add(20, 0),
+			Expiration: now.Add(30, 0).Clone(),
+			Replica:    replDesc,
+		}); err != nil {
+			t.Fatal(err)
+		}
+		require.Equal(t, int32(1), atomic.LoadInt32(&intercepted))
+
+		// The new lease is wound back to the end of the previous one.
+		expLowWater := now.Add(10, 0).WallTime
+		lowWater := func() int64 {
+			rTS, _ := tc.repl.store.tsCache.GetMax(roachpb.Key("a"), nil /* end */)
+			return rTS.WallTime
+		}
+		if !suppress {
+			// Applying the lease blocked until the low water mark was updated.
+			require.True(t, timeutil.Since(start) >= delay)
+			require.Equal(t, expLowWater, lowWater())
+			return
+		}
+		// Applying the lease didn't wait for the low water mark update.
+		if lw := lowWater(); lw >= expLowWater {
+			t.Fatalf("expected low water update to be postponed; found %d", lw)
+		}
+		testutils.SucceedsSoon(t, func() error {
+			if lw := lowWater(); lw != expLowWater {
+				return errors.Errorf("expected low water %d; found %d", expLowWater, lw)
+			}
+			return nil
+		})
+		require.True(t, timeutil.Since(start) >= delay)
+	})
+}
+
+// TestReplicaIdenticalLeaseResultIsNoop verifies that applying a lease which
+// is identical to the replica's current lease skips the post-apply work.
+func TestReplicaIdenticalLeaseResultIsNoop(t *testing.T) {
 	defer leaktest.AfterTest(t)()
 
+	ctx := context.Background()
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+
+	var intercepted int32
+	tc := testContext{}
+	cfg := TestStoreConfig(nil)
+	cfg.TestingKnobs.LeasePostApplyInterceptor = func(
+		context.Context, roachpb.Lease,
+	) (time.Duration, bool) {
+		atomic.AddInt32(&intercepted, 1)
+		return 0, false
+	}
+	tc.StartWithStoreConfig(t, stopper, cfg)
+
+	lease, _ := tc.repl.GetLease()
+	require.True(t, lease.OwnedBy(tc.store.StoreID()))
+	before := atomic.LoadInt32(&intercepted)
+	history := tc.repl.leaseHistory.get()
+
+	tc.repl.handleLeaseResult(ctx, &lease, time.Time{} /* proposedAt */)
+	require.Equal(t, before, atomic.LoadInt32(&intercepted))
+	require.Equal(t, history, tc.repl.leaseHistory.get())
+
+	// A lease that differs, even only in its expiration, is still applied.
+	extended := lease
+	extended.Expiration = lease.Expiration.Add(1, 0).Clone()
+	tc.repl.handleLeaseResult(ctx, &extended, time.Time{} /* proposedAt */)
+	require.Equal(t, before+1, atomic.LoadInt32(&intercepted))
+	require.Contains(t, tc.repl.leaseHistory.get(), extended)
+}
+
+// TestReplicaLeaseLowWaterJumpMetrics verifies that acquiring a lease records
+// the amount by which the timestamp cache low water mark was raised, and
+// counts acquisitions which raised it by a large amount.
+func TestReplicaLeaseLowWaterJumpMetrics(t *testing.T) {
+	defer leaktest.AfterTest(t)()
 	stopper := stop.NewStopper()
 	defer stopper.Stop(context.Background())
 
@@ -1381,84 +1696,290 @@ func TestReplicaLeaseRejectUnknownRaftNodeID(t *testing.T) {
 	cfg.TestingKnobs.DisableAutomaticLeaseRenewal = true
 	tc.StartWithStoreConfig(t, stopper, cfg)
 
+	secondReplica, err := tc.addBogusReplicaToRangeDesc(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
 	tc.manualClock.Set(leaseExpiry(tc.repl))
 	now := tc.Clock().Now()
-	lease := &roachpb.Lease{
+
+	// Hand the lease to the other replica, then take it back after the
+	// other replica's lease has ended, which raises the low water mark well
+	// beyond any timestamp served so far.
+	if err := sendLeaseRequest(tc.repl, &roachpb.Lease{
 		Start:      now,
-		Expiration: now.Add(10, 0).Clone(),
-		Replica: roachpb.ReplicaDescriptor{
-			ReplicaID: 2,
-			NodeID:    2,
-			StoreID:   2,
-		},
+		Expiration: now.Add(10*time.Second.Nanoseconds(), 0).Clone(),
+		Replica:    secondReplica,
+	}); err != nil {
+		t.Fatal(err)
 	}
-	exLease, _ := tc.repl.GetLease()
-	ba := roachpb.BatchRequest{}
-	ba.Timestamp = tc.repl.store.Clock().Now()
-	ba.Add(&roachpb.RequestLeaseRequest{Lease: *lease})
-	ch, _, _, pErr := tc.repl.evalAndPropose(context.Background(), &ba, allSpansGuard(), &exLease)
-	if pErr == nil {
-		// Next if the command was committed, wait for the range to apply it.
-		// TODO(bdarnell): refactor to a more conventional error-handling pattern.
-		// Remove ambiguity about where the "replica not found" error comes from.
-		pErr = (<-ch).Err
+	replDesc, err := tc.repl.GetReplicaDescriptor()
+	if err != nil {
+		t.Fatal(err)
 	}
-	if !testutils.IsPError(pErr, "replica.*not found") {
-		t.Errorf("unexpected error obtaining lease for invalid store: %v", pErr)
+
+	// A read served just before the lease is reacquired doesn't raise the low
+	// water mark, and so doesn't make the jump look smaller.
+	tc.store.tsCache.Add(
+		roachpb.Key("a"), nil, now.Add(19*time.Second.Nanoseconds(), 0), uuid.UUID{},
+	)
+
+	metrics := tc.store.Metrics()
+	jumpsBefore := metrics.LeaseLowWaterJump.Snapshot().TotalCount()
+	largeBefore := metrics.LeaseLowWaterJumpLarge.Count()
+	tc.manualClock.Set(now.Add(20*time.Second.Nanoseconds(), 0).WallTime)
+	if err := sendLeaseRequest(tc.repl, &roachpb.Lease{
+		Start:      now.Add(20*time.Second.Nanoseconds(), 0),
+		Expiration: now.Add(30*time.Second.Nanoseconds(), 0).Clone(),
+		Replica:    replDesc,
+	}); err != nil {
+		t.Fatal(err)
 	}
+
+	jumps := metrics.LeaseLowWaterJump.Snapshot()
+	require.Equal(t, jumpsBefore+1, jumps.TotalCount())
+	require.True(t, jumps.Max() >= 10*time.Second.Nanoseconds(), "max jump %d", jumps.Max())
+	require.Equal(t, largeBefore+1, metrics.LeaseLowWaterJumpLarge.Count())
 }
 
-// TestReplicaDrainLease makes sure that no new leases are granted when
-// the Store is draining.
-func TestReplicaDrainLease(t *testing.T) {
+// TestReplicaLeaseFutureStartRejected verifies that a lease whose start is
+// further ahead of the evaluating replica's clock than the maximum clock offset
+// is rejected and leaves the timestamp cache low water mark alone.
+func TestReplicaLeaseFutureStartRejected(t *testing.T) {
 	defer leaktest.AfterTest(t)()
-	tc := testContext{}
 	stopper := stop.NewStopper()
 	defer stopper.Stop(context.Background())
-	tc.Start(t, stopper)
 
-	// Acquire initial lease.
-	ctx := context.Background()
-	status, pErr := tc.repl.redirectOnOrAcquireLease(ctx)
-	if pErr != nil {
-		t.Fatal(pErr)
+	tc := testContext{manualClock: hlc.NewManualClock(123)}
+	cfg := TestStoreConfig(hlc.NewClock(tc.manualClock.UnixNano, time.Nanosecond))
+	cfg.TestingKnobs.DisableAutomaticLeaseRenewal = true
+	tc.StartWithStoreConfig(t, stopper, cfg)
+
+	tc.manualClock.Set(leaseExpiry(tc.repl))
+	now := tc.Clock().Now()
+	prevLease, _ := tc.repl.GetLease()
+
+	replDesc, err := tc.repl.GetReplicaDescriptor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sendLeaseRequest(tc.repl, &roachpb.Lease{
+		Start:      now.Add(time.Hour.Nanoseconds(), 0),
+		Expiration: now.Add(2*time.Hour.Nanoseconds(), 0).Clone(),
+		Replica:    replDesc,
+	}); !testutils.IsError(err, "more than the maximum clock offset ahead") {
+		t.Fatalf("expected lease to be rejected, got %v", err)
 	}
+	lease, _ := tc.repl.GetLease()
+	require.True(t, lease.Equivalent(prevLease), "lease changed from %s to %s", prevLease, lease)
 
-	tc.store.SetDraining(true, nil /* reporter */)
-	tc.repl.mu.Lock()
-	pErr = <-tc.repl.requestLeaseLocked(ctx, status).C()
-	tc.repl.mu.Unlock()
-	_, ok := pErr.GetDetail().(*roachpb.NotLeaseHolderError)
-	if !ok {
-		t.Fatalf("expected NotLeaseHolderError, not %v", pErr)
+	lowWater, _ := tc.store.tsCache.GetMax(roachpb.Key("a"), nil /* end */)
+	require.True(t, lowWater.Less(now.Add(time.Hour.Nanoseconds(), 0)), "low water %s", lowWater)
+}
+
+// TestReplicaLeaseStartSkewMetric verifies that applying a lease which changes
+// hands records the distance between the lease's start and the local clock.
+func TestReplicaLeaseStartSkewMetric(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	stopper := stop.NewStopper()
+	defer stopper.Stop(context.Background())
+
+	tc := testContext{manualClock: hlc.NewManualClock(123)}
+	cfg := TestStoreConfig(hlc.NewClock(tc.manualClock.UnixNano, time.Nanosecond))
+	cfg.TestingKnobs.DisableAutomaticLeaseRenewal = true
+	tc.StartWithStoreConfig(t, stopper, cfg)
+
+	secondReplica, err := tc.addBogusReplicaToRangeDesc(context.Background())
+	if err != nil {
+		t.Fatal(err)
 	}
-	tc.store.SetDraining(false, nil /* reporter */)
-	// Newly undrained, leases work again.
-	if _, pErr := tc.repl.redirectOnOrAcquireLease(ctx); pErr != nil {
-		t.Fatal(pErr)
+	tc.manualClock.Set(leaseExpiry(tc.repl))
+	now := tc.Clock().Now()
+
+	// Hand the lease to the other replica with a start ahead of the local
+	// clock, as a proposer with a fast clock would.
+	const skew = 5 * time.Second
+	metrics := tc.store.Metrics()
+	before := metrics.LeaseStartSkew.Snapshot().TotalCount()
+	if err := sendLeaseRequest(tc.repl, &roachpb.Lease{
+		Start:      now.Add(skew.Nanoseconds(), 0),
+		Expiration: now.Add(10*time.Second.Nanoseconds(), 0).Clone(),
+		Replica:    secondReplica,
+	}); err != nil {
+		t.Fatal(err)
 	}
+
+	skews := metrics.LeaseStartSkew.Snapshot()
+	require.Equal(t, before+1, skews.TotalCount())
+	// The histogram only retains a limited precision.
+	require.InDelta(t, skew.Nanoseconds(), skews.Max(), float64(skew.Nanoseconds())/10)
 }
 
-// TestReplicaGossipFirstRange verifies that the first range gossips its
-// location and the cluster ID.
-func TestReplicaGossipFirstRange(t *testing.T) {
+// TestReplicaApplySideEffectsLatencyMetrics verifies that the side effects of
+// a command applied by the replica which proposed it are timed separately from
+// those of commands proposed by other replicas.
+func TestReplicaApplySideEffectsLatencyMetrics(t *testing.T) {
 	defer leaktest.AfterTest(t)()
 	tc := testContext{}
 	stopper := stop.NewStopper()
 	defer stopper.Stop(context.Background())
 	tc.Start(t, stopper)
-	for _, key := range []string{gossip.KeyClusterID, gossip.KeyFirstRangeDescriptor, gossip.KeySentinel} {
-		bytes, err := tc.gossip.GetInfo(key)
-		if err != nil {
-			t.Errorf("missing first range gossip of key %s", key)
-		}
-		if key == gossip.KeyFirstRangeDescriptor {
-			var rangeDesc roachpb.RangeDescriptor
-			if err := protoutil.Unmarshal(bytes, &rangeDesc); err != nil {
-				t.Fatal(err)
-			}
-		}
-		if key == gossip.KeyClusterID && len(bytes) == 0 {
+
+	metrics := tc.store.Metrics()
+	proposerBefore := metrics.RaftApplySideEffectsProposerLatency.Snapshot().TotalCount()
+	followerBefore := metrics.RaftApplySideEffectsFollowerLatency.Snapshot().TotalCount()
+
+	// Every command of this single-replica range is proposed locally.
+	pArgs := putArgs(roachpb.Key("a"), []byte("value"))
+	if _, pErr := tc.SendWrapped(&pArgs); pErr != nil {
+		t.Fatal(pErr)
+	}
+
+	require.Greater(t, metrics.RaftApplySideEffectsProposerLatency.Snapshot().TotalCount(), proposerBefore)
+	require.Equal(t, followerBefore, metrics.RaftApplySideEffectsFollowerLatency.Snapshot().TotalCount())
+}
+
+// TestReplicaLeaseApplyLatencyMetric verifies that applying a lease proposed
+// by the local replica records the time since its proposal, while leases
+// proposed elsewhere aren't recorded.
+func TestReplicaLeaseApplyLatencyMetric(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+
+	tc := testContext{manualClock: hlc.NewManualClock(123)}
+	cfg := TestStoreConfig(hlc.NewClock(tc.manualClock.UnixNano, time.Nanosecond))
+	cfg.TestingKnobs.DisableAutomaticLeaseRenewal = true
+	tc.StartWithStoreConfig(t, stopper, cfg)
+
+	lease, _ := tc.repl.GetLease()
+	metrics := tc.store.Metrics()
+	before := metrics.LeaseApplyLatency.Snapshot().TotalCount()
+
+	// Lease requests proposed through raft by the local replica are recorded.
+	tc.manualClock.Set(leaseExpiry(tc.repl))
+	now := tc.Clock().Now()
+	if err := sendLeaseRequest(tc.repl, &roachpb.Lease{
+		Start:      now,
+		Expiration: now.Add(10*time.Second.Nanoseconds(), 0).Clone(),
+		Replica:    lease.Replica,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	require.Equal(t, before+1, metrics.LeaseApplyLatency.Snapshot().TotalCount())
+
+	// A lease proposed by another replica carries no proposal time.
+	lease, _ = tc.repl.GetLease()
+	extended := lease
+	extended.Expiration = lease.Expiration.Add(1, 0).Clone()
+	tc.repl.handleLeaseResult(ctx, &extended, time.Time{} /* proposedAt */)
+	require.Equal(t, before+1, metrics.LeaseApplyLatency.Snapshot().TotalCount())
+
+	// The latency is measured from the injected proposal time.
+	const latency = 5 * time.Second
+	extended.Expiration = extended.Expiration.Add(1, 0).Clone()
+	tc.repl.handleLeaseResult(ctx, &extended, timeutil.Now().Add(-latency))
+	latencies := metrics.LeaseApplyLatency.Snapshot()
+	require.Equal(t, before+2, latencies.TotalCount())
+	// The histogram only retains a limited precision.
+	require.InDelta(t, latency.Nanoseconds(), latencies.Max(), float64(latency.Nanoseconds())/10)
+}
+
+// TestReplicaLeaseRejectUnknownRaftNodeID ensures that a replica cannot
+// obtain the range lease if it is not part of the current range descriptor.
+// TODO(mrtracy): This should probably be tested in client_raft_test package,
+// using a real second store.
+func TestReplicaLeaseRejectUnknownRaftNodeID(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	stopper := stop.NewStopper()
+	defer stopper.Stop(context.Background())
+
+	tc := testContext{manualClock: hlc.NewManualClock(123)}
+	cfg := TestStoreConfig(hlc.NewClock(tc.manualClock.UnixNano, time.Nanosecond))
+	cfg.TestingKnobs.DisableAutomaticLeaseRenewal = true
+	tc.StartWithStoreConfig(t, stopper, cfg)
+
+	tc.manualClock.Set(leaseExpiry(tc.repl))
+	now := tc.Clock().Now()
+	lease := &roachpb.Lease{
+		Start:      now,
+		Expiration: now.Add(10, 0).Clone(),
+		Replica: roachpb.ReplicaDescriptor{
+			ReplicaID: 2,
+			NodeID:    2,
+			StoreID:   2,
+		},
+	}
+	exLease, _ := tc.repl.GetLease()
+	ba := roachpb.BatchRequest{}
+	ba.Timestamp = tc.repl.store.Clock().Now()
+	ba.Add(&roachpb.RequestLeaseRequest{Lease: *lease})
+	ch, _, _, pErr := tc.repl.evalAndPropose(context.Background(), &ba, allSpansGuard(), &exLease)
+	if pErr == nil {
+		// Next if the command was committed, wait for the range to apply it.
+		// TODO(bdarnell): refactor to a more conventional error-handling pattern.
+		// Remove ambiguity about where the "replica not found" error comes from.
+		pErr = (<-ch).Err
+	}
+	if !testutils.IsPError(pErr, "replica.*not found") {
+		t.Errorf("unexpected error obtaining lease for invalid store: %v", pErr)
+	}
+}
+
+// TestReplicaDrainLease makes sure that no new leases are granted when
+// the Store is draining.
+func TestReplicaDrainLease(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	tc := testContext{}
+	stopper := stop.NewStopper()
+	defer stopper.Stop(context.Background())
+	tc.Start(t, stopper)
+
+	// Acquire initial lease.
+	ctx := context.Background()
+	status, pErr := tc.repl.redirectOnOrAcquireLease(ctx)
+	if pErr != nil {
+		t.Fatal(pErr)
+	}
+
+	tc.store.SetDraining(true, nil /* reporter */)
+	tc.repl.mu.Lock()
+	pErr = <-tc.repl.requestLeaseLocked(ctx, status).C()
+	tc.repl.mu.Unlock()
+	_, ok := pErr.GetDetail().(*roachpb.NotLeaseHolderError)
+	if !ok {
+		t.Fatalf("expected NotLeaseHolderError, not %v", pErr)
+	}
+	tc.store.SetDraining(false, nil /* reporter */)
+	// Newly undrained, leases work again.
+	if _, pErr := tc.repl.redirectOnOrAcquireLease(ctx); pErr != nil {
+		t.Fatal(pErr)
+	}
+}
+
+// TestReplicaGossipFirstRange verifies that the first range gossips its
+// location and the cluster ID.
+func TestReplicaGossipFirstRange(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	tc := testContext{}
+	stopper := stop.NewStopper()
+	defer stopper.Stop(context.Background())
+	tc.Start(t, stopper)
+	for _, key := range []string{gossip.KeyClusterID, gossip.KeyFirstRangeDescriptor, gossip.KeySentinel} {
+		bytes, err := tc.gossip.GetInfo(key)
+		if err != nil {
+			t.Errorf("missing first range gossip of key %s", key)
+		}
+		if key == gossip.KeyFirstRangeDescriptor {
+			var rangeDesc roachpb.RangeDescriptor
+			if err := protoutil.Unmarshal(bytes, &rangeDesc); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if key == gossip.KeyClusterID && len(bytes) == 0 {
 			t.Errorf("expected non-empty gossiped cluster ID, got %q", bytes)
 		}
 		if key == gossip.KeySentinel && len(bytes) == 0 {
@@ -1467,6 +1988,193 @@ func TestReplicaGossipFirstRange(t *testing.T) {
 	}
 }
 
+// TestReplicaGossipFirstRangeLeaseCheck verifies that a command requesting
+// that the first range be gossiped results in the sentinel being gossiped
+// unless the lease check reports that another replica holds the lease.
+func TestReplicaGossipFirstRangeLeaseCheck(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	ctx := context.Background()
+
+	testCases := []struct {
+		name      string
+		hasLease  bool
+		pErr      *roachpb.Error
+		expGossip bool
+	}{
+		{name: "has-lease", hasLease: true, expGossip: true},
+		{name: "no-lease", hasLease: false, expGossip: false},
+		{name: "error", hasLease: false, pErr: roachpb.NewErrorf("boom"), expGossip: true},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			tc := testContext{manualClock: hlc.NewManualClock(123)}
+			stopper := stop.NewStopper()
+			defer stopper.Stop(ctx)
+			checked := make(chan struct{}, 1)
+			cfg := TestStoreConfig(hlc.NewClock(tc.manualClock.UnixNano, time.Nanosecond))
+			cfg.TestingKnobs.GossipFirstRangeLeaseCheck = func(context.Context) (bool, *roachpb.Error) {
+				select {
+				case checked <- struct{}{}:
+				default:
+				}
+				return c.hasLease, c.pErr
+			}
+			tc.StartWithStoreConfig(t, stopper, cfg)
+
+			sentinelStamp := func() int64 {
+				return tc.gossip.GetInfoStatus().Infos[gossip.KeySentinel].OrigStamp
+			}
+			// Wait for the store's initial gossip of the first range so that it
+			// doesn't interfere with the assertions below.
+			testutils.SucceedsSoon(t, func() error {
+				if sentinelStamp() == 0 {
+					return errors.New("sentinel not gossiped yet")
+				}
+				return nil
+			})
+			before := sentinelStamp()
+			numTasks := stopper.NumTasks()
+
+			// Make sure that the gossip isn't coalesced with one requested while
+			// the store started up.
+			tc.manualClock.Increment(firstRangeGossipThrottleDuration.Nanoseconds())
+			tc.repl.handleReadWriteLocalEvalResult(ctx, result.LocalResult{GossipFirstRange: true})
+			<-checked
+			testutils.SucceedsSoon(t, func() error {
+				if n := stopper.NumTasks(); n > numTasks {
+					return errors.Errorf("%d tasks still running", n)
+				}
+				return nil
+			})
+			require.Equal(t, c.expGossip, sentinelStamp() > before)
+		})
+	}
+}
+
+// TestReplicaGossipFirstRangeThrottle verifies that commands requesting that
+// the first range be gossiped in quick succession result in one gossip right
+// away and one at the end of the throttle interval.
+func TestReplicaGossipFirstRangeThrottle(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	ctx := context.Background()
+	tc := testContext{manualClock: hlc.NewManualClock(123)}
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+	var blocked int32
+	var gossips int32
+	checked := make(chan struct{})
+	unblock := make(chan struct{})
+	cfg := TestStoreConfig(hlc.NewClock(tc.manualClock.UnixNano, time.Nanosecond))
+	cfg.TestingKnobs.GossipFirstRangeLeaseCheck = func(context.Context) (bool, *roachpb.Error) {
+		atomic.AddInt32(&gossips, 1)
+		if atomic.CompareAndSwapInt32(&blocked, 1, 0) {
+			checked <- struct{}{}
+			<-unblock
+		}
+		// Don't actually gossip.
+		return false, nil
+	}
+	tc.StartWithStoreConfig(t, stopper, cfg)
+
+	waitForTasks := func(numTasks int) {
+		testutils.SucceedsSoon(t, func() error {
+			if n := stopper.NumTasks(); n > numTasks {
+				return errors.Errorf("%d tasks still running", n)
+			}
+			return nil
+		})
+	}
+	numTasks := stopper.NumTasks()
+
+	// Hold up the first gossip of the burst until the rest of it has been
+	// requested, so that the rest isn't coalesced into it.
+	tc.manualClock.Increment(firstRangeGossipThrottleDuration.Nanoseconds())
+	before := atomic.LoadInt32(&gossips)
+	atomic.StoreInt32(&blocked, 1)
+	tc.repl.handleReadWriteLocalEvalResult(ctx, result.LocalResult{GossipFirstRange: true})
+	<-checked
+	for i := 0; i < 4; i++ {
+		tc.repl.handleReadWriteLocalEvalResult(ctx, result.LocalResult{GossipFirstRange: true})
+	}
+	require.Equal(t, int32(1), atomic.LoadInt32(&gossips)-before)
+	close(unblock)
+	waitForTasks(numTasks)
+	require.Equal(t, int32(2), atomic.LoadInt32(&gossips)-before)
+
+	// Once the interval has passed, the first range is gossiped right away.
+	tc.manualClock.Increment(2 * firstRangeGossipThrottleDuration.Nanoseconds())
+	tc.repl.handleReadWriteLocalEvalResult(ctx, result.LocalResult{GossipFirstRange: true})
+	waitForTasks(numTasks)
+	require.Equal(t, int32(3), atomic.LoadInt32(&gossips)-before)
+}
+
+// TestReplicaGossipFirstRangeSingleFlight verifies that requests to gossip
+// the first range made while a previous one is stalled acquiring the lease are
+// coalesced into a single follow-up gossip rather than spawning further tasks.
+func TestReplicaGossipFirstRangeSingleFlight(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	ctx := context.Background()
+	tc := testContext{manualClock: hlc.NewManualClock(123)}
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+	var blocked int32
+	var checks int32
+	unblock := make(chan struct{})
+	cfg := TestStoreConfig(hlc.NewClock(tc.manualClock.UnixNano, time.Nanosecond))
+	cfg.TestingKnobs.GossipFirstRangeLeaseCheck = func(context.Context) (bool, *roachpb.Error) {
+		atomic.AddInt32(&checks, 1)
+		if atomic.LoadInt32(&blocked) == 1 {
+			<-unblock
+		}
+		// Don't actually gossip.
+		return false, nil
+	}
+	tc.StartWithStoreConfig(t, stopper, cfg)
+
+	waitForTasks := func(numTasks int) {
+		testutils.SucceedsSoon(t, func() error {
+			if n := stopper.NumTasks(); n > numTasks {
+				return errors.Errorf("%d tasks still running", n)
+			}
+			return nil
+		})
+	}
+	waitForChecks := func(before, exp int32) {
+		testutils.SucceedsSoon(t, func() error {
+			if n := atomic.LoadInt32(&checks) - before; n != exp {
+				return errors.Errorf("%d lease checks, expected %d", n, exp)
+			}
+			return nil
+		})
+	}
+	numTasks := stopper.NumTasks()
+
+	// Stall the lease check and fire many triggers, each of which would
+	// otherwise pass the throttle.
+	atomic.StoreInt32(&blocked, 1)
+	before := atomic.LoadInt32(&checks)
+	tc.manualClock.Increment(firstRangeGossipThrottleDuration.Nanoseconds())
+	tc.repl.handleReadWriteLocalEvalResult(ctx, result.LocalResult{GossipFirstRange: true})
+	waitForChecks(before, 1)
+	for i := 0; i < 10; i++ {
+		tc.manualClock.Increment(firstRangeGossipThrottleDuration.Nanoseconds())
+		tc.repl.handleReadWriteLocalEvalResult(ctx, result.LocalResult{GossipFirstRange: true})
+	}
+	require.Equal(t, int32(1), atomic.LoadInt32(&checks)-before)
+
+	// Once the stalled gossip is done, the triggers received in the meantime
+	// result in a single further gossip.
+	atomic.StoreInt32(&blocked, 0)
+	close(unblock)
+	waitForTasks(numTasks)
+	require.Equal(t, int32(2), atomic.LoadInt32(&checks)-before)
+
+	tc.manualClock.Increment(firstRangeGossipThrottleDuration.Nanoseconds())
+	tc.repl.handleReadWriteLocalEvalResult(ctx, result.LocalResult{GossipFirstRange: true})
+	waitForTasks(numTasks)
+	require.Equal(t, int32(3), atomic.LoadInt32(&checks)-before)
+}
+
 // TestReplicaGossipAllConfigs verifies that all config types are gossiped.
 func TestReplicaGossipAllConfigs(t *testing.T) {
 	defer leaktest.AfterTest(t)()
@@ -7484,6 +8192,48 @@ func TestDiffRange(t *testing.T) {
 	}
 }
 
+// TestDiffKeyRanges verifies that diffKeyRanges groups the differences between
+// two kv dumps into spans of adjacent differing keys.
+func TestDiffKeyRanges(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	timestamp := hlc.Timestamp{WallTime: 1729, Logical: 1}
+	value := []byte("foo")
+	snapshot := func(keys ...string) *roachpb.RaftSnapshotData {
+		var data roachpb.RaftSnapshotData
+		for _, k := range keys {
+			data.KV = append(data.KV, roachpb.RaftSnapshotData_KeyValue{
+				Key: []byte(k), Timestamp: timestamp, Value: value,
+			})
+		}
+		return &data
+	}
+
+	ref := snapshot("a", "b", "c", "d", "e")
+	require.Nil(t, diffKeyRanges(ref, ref))
+
+	// Two snapshots differing in the value of a single key.
+	cur := snapshot("a", "b", "c", "d", "e")
+	cur.KV[2].Value = []byte("bar")
+	diffs := diffKeyRanges(ref, cur)
+	require.Len(t, diffs, 1)
+	require.Equal(t, roachpb.Span{Key: roachpb.Key("c"), EndKey: roachpb.Key("c").Next()}, diffs[0].Span)
+	require.Equal(t, ReplicaSnapshotDiffSlice{
+		{LeaseHolder: true, Key: roachpb.Key("c"), Timestamp: timestamp, Value: value},
+		{LeaseHolder: false, Key: roachpb.Key("c"), Timestamp: timestamp, Value: []byte("bar")},
+	}, diffs[0].Diff)
+
+	// Adjacent differing keys are grouped, while keys the snapshots agree on
+	// separate the spans.
+	cur = snapshot("a", "bb", "c", "dd", "e")
+	diffs = diffKeyRanges(ref, cur)
+	require.Len(t, diffs, 2)
+	require.Equal(t, roachpb.Span{Key: roachpb.Key("b"), EndKey: roachpb.Key("bb").Next()}, diffs[0].Span)
+	require.Len(t, diffs[0].Diff, 2)
+	require.Equal(t, roachpb.Span{Key: roachpb.Key("d"), EndKey: roachpb.Key("dd").Next()}, diffs[1].Span)
+	require.Len(t, diffs[1].Diff, 2)
+}
+
 func TestSyncSnapshot(t *testing.T) {
 	defer leaktest.AfterTest(t)()
 
@@ -7768,40 +8518,214 @@ func TestReplicaBurstPendingCommandsAndRepropose(t *testing.T) {
 	}
 }
 
-func TestReplicaRefreshPendingCommandsTicks(t *testing.T) {
+// TestReplicaRecentStatsDeltas verifies that the MVCCStats deltas applied to
+// a replica are reflected in RecentStatsDeltas until they age out.
+func TestReplicaRecentStatsDeltas(t *testing.T) {
 	defer leaktest.AfterTest(t)()
-	var tc testContext
-	cfg := TestStoreConfig(nil)
-	// Disable ticks which would interfere with the manual ticking in this test.
-	cfg.RaftTickInterval = math.MaxInt32
+	tc := testContext{manualClock: hlc.NewManualClock(123)}
+	cfg := TestStoreConfig(hlc.NewClock(tc.manualClock.UnixNano, time.Nanosecond))
+	cfg.TestingKnobs.DisableAutomaticLeaseRenewal = true
 	stopper := stop.NewStopper()
 	defer stopper.Stop(context.Background())
 	tc.StartWithStoreConfig(t, stopper, cfg)
 
-	// Flush a write all the way through the Raft proposal pipeline. This
-	// ensures that leadership settles down before we start manually submitting
-	// proposals and that we don't see any unexpected proposal refreshes due to
-	// reasons like reasonNewLeaderOrConfigChange.
-	args := incrementArgs([]byte("a"), 1)
-	if _, pErr := tc.SendWrapped(args); pErr != nil {
-		t.Fatal(pErr)
+	// Move on to a fresh bucket, so that only the writes below count.
+	tc.manualClock.Increment(MaxStatsDeltaWindow.Nanoseconds())
+	before := tc.repl.GetMVCCStats()
+	for _, key := range []string{"a", "b", "c"} {
+		if _, pErr := tc.SendWrapped(putArgs(roachpb.Key(key), []byte("value"))); pErr != nil {
+			t.Fatal(pErr)
+		}
 	}
+	expected := tc.repl.GetMVCCStats()
+	expected.Subtract(before)
+
+	deltas := tc.repl.RecentStatsDeltas(statsDeltaBucketDuration)
+	require.Equal(t, int64(3), deltas.LiveCount)
+	require.Equal(t, expected.LiveBytes, deltas.LiveBytes)
+	require.Equal(t, expected.KeyBytes, deltas.KeyBytes)
+	require.Equal(t, expected.ValBytes, deltas.ValBytes)
+
+	tc.manualClock.Increment(MaxStatsDeltaWindow.Nanoseconds())
+	require.Zero(t, tc.repl.RecentStatsDeltas(MaxStatsDeltaWindow).LiveCount)
+}
 
+// TestReplicaEstimatedTimeToSplit verifies that EstimatedTimeToSplit
+// extrapolates a steady growth rate to the range's split size.
+func TestReplicaEstimatedTimeToSplit(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	tc := testContext{manualClock: hlc.NewManualClock(123)}
+	cfg := TestStoreConfig(hlc.NewClock(tc.manualClock.UnixNano, time.Nanosecond))
+	cfg.TestingKnobs.DisableAutomaticLeaseRenewal = true
+	stopper := stop.NewStopper()
+	defer stopper.Stop(context.Background())
+	tc.StartWithStoreConfig(t, stopper, cfg)
 	r := tc.repl
-	electionTicks := tc.store.cfg.RaftElectionTimeoutTicks
-	{
-		// The verifications of the reproposal counts below rely on r.mu.ticks
-		// starting with a value of 0 (modulo electionTicks). Move the replica into
-		// that state in case the replica was ticked before we grabbed
-		// processRaftMu.
-		r.mu.Lock()
-		ticks := r.mu.ticks
-		r.mu.Unlock()
-		for ; (ticks % electionTicks) != 0; ticks++ {
-			if _, err := r.tick(nil); err != nil {
-				t.Fatal(err)
-			}
-		}
+
+	const bytesPerSecond = 1 << 10
+	total := r.GetMVCCStats().Total()
+	zone := zonepb.DefaultZoneConfig()
+	zone.RangeMaxBytes = proto.Int64(total + 1000*bytesPerSecond)
+	r.SetZoneConfig(&zone)
+
+	// Keep commands from applying, so that only the deltas recorded below
+	// make up the history.
+	r.raftMu.Lock()
+	defer r.raftMu.Unlock()
+	now := func() time.Time {
+		return timeutil.Unix(0, tc.manualClock.UnixNano())
+	}
+	grow := &enginepb.MVCCStats{ValBytes: bytesPerSecond}
+
+	// Start at a bucket boundary, with no history.
+	tc.manualClock.Set((tc.manualClock.UnixNano()/int64(statsDeltaBucketDuration) + 1) *
+		int64(statsDeltaBucketDuration))
+	r.mu.Lock()
+	r.mu.statsDeltas = nil
+	r.mu.Unlock()
+	_, ok := r.EstimatedTimeToSplit()
+	require.False(t, ok, "expected no estimate without history")
+
+	// Deltas within the current bucket aren't enough to extrapolate from.
+	r.mu.Lock()
+	r.mu.statsDeltas = &statsDeltaHistory{}
+	r.mu.statsDeltas.record(now(), grow)
+	r.mu.Unlock()
+	_, ok = r.EstimatedTimeToSplit()
+	require.False(t, ok, "expected no estimate from the current bucket alone")
+
+	// Apply deltas which grow the range at a steady rate, for longer than the
+	// history retains.
+	r.mu.Lock()
+	for i := time.Duration(0); i < 2*MaxStatsDeltaWindow; i += time.Second {
+		r.mu.statsDeltas.record(now(), grow)
+		tc.manualClock.Increment(time.Second.Nanoseconds())
+	}
+	r.mu.Unlock()
+
+	// The range has 1000 seconds worth of growth left until it exceeds its
+	// max bytes.
+	d, ok := r.EstimatedTimeToSplit()
+	require.True(t, ok)
+	require.InDelta(t, 1000, d.Seconds(), 1)
+
+	// A range which already needs to be split is due now.
+	r.mu.Lock()
+	r.mu.state.Stats.ValBytes += 1000 * bytesPerSecond
+	r.mu.Unlock()
+	d, ok = r.EstimatedTimeToSplit()
+	require.True(t, ok)
+	require.Zero(t, d)
+
+	// A range which isn't growing has no estimate.
+	r.mu.Lock()
+	r.mu.state.Stats.ValBytes -= 1000 * bytesPerSecond
+	r.mu.statsDeltas = &statsDeltaHistory{}
+	r.mu.statsDeltas.record(now().Add(-time.Minute), &enginepb.MVCCStats{ValBytes: -bytesPerSecond})
+	r.mu.Unlock()
+	_, ok = r.EstimatedTimeToSplit()
+	require.False(t, ok)
+}
+
+// TestReplicaInflightProposalAges verifies that InflightProposalAges reports
+// the ticks elapsed since a pending command was proposed.
+func TestReplicaInflightProposalAges(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	var tc testContext
+	cfg := TestStoreConfig(nil)
+	// Disable ticks which would interfere with the manual ticking in this test,
+	// as well as the reproposals which would reset the age of the command.
+	cfg.RaftTickInterval = math.MaxInt32
+	cfg.TestingKnobs.DisableRefreshReasonTicks = true
+	stopper := stop.NewStopper()
+	defer stopper.Stop(context.Background())
+	tc.StartWithStoreConfig(t, stopper, cfg)
+
+	// Flush a write all the way through the Raft proposal pipeline, so that no
+	// other commands are pending.
+	args := incrementArgs([]byte("a"), 1)
+	if _, pErr := tc.SendWrapped(args); pErr != nil {
+		t.Fatal(pErr)
+	}
+	r := tc.repl
+	require.Empty(t, r.InflightProposalAges())
+
+	// Propose a command which never makes it to Raft, so that it remains
+	// pending.
+	r.mu.Lock()
+	r.mu.proposalBuf.testing.submitProposalFilter = func(*ProposalData) (drop bool, _ error) {
+		return true, nil
+	}
+	r.mu.Unlock()
+	ctx := context.Background()
+	var ba roachpb.BatchRequest
+	ba.Timestamp = tc.Clock().Now()
+	ba.Add(&roachpb.PutRequest{RequestHeader: roachpb.RequestHeader{Key: roachpb.Key("b")}})
+	lease, _ := r.GetLease()
+	id := kvserverbase.CmdIDKey("00000001")
+	cmd, pErr := r.requestToProposal(ctx, id, &ba, &allSpans)
+	if pErr != nil {
+		t.Fatal(pErr)
+	}
+	cmd.command.ProposerLeaseSequence = lease.Sequence
+	if _, pErr := r.propose(ctx, cmd); pErr != nil {
+		t.Fatal(pErr)
+	}
+	r.mu.Lock()
+	if err := r.mu.proposalBuf.flushLocked(); err != nil {
+		t.Fatal(err)
+	}
+	ticks := r.mu.ticks
+	r.mu.Unlock()
+
+	require.Equal(t, []InflightProposalAge{
+		{CmdID: id, ProposedAtTicks: ticks, AgeTicks: 0},
+	}, r.InflightProposalAges())
+
+	for i := 1; i <= 3; i++ {
+		if _, err := r.tick(nil); err != nil {
+			t.Fatal(err)
+		}
+		require.Equal(t, []InflightProposalAge{
+			{CmdID: id, ProposedAtTicks: ticks, AgeTicks: i},
+		}, r.InflightProposalAges())
+	}
+}
+
+func TestReplicaRefreshPendingCommandsTicks(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	var tc testContext
+	cfg := TestStoreConfig(nil)
+	// Disable ticks which would interfere with the manual ticking in this test.
+	cfg.RaftTickInterval = math.MaxInt32
+	stopper := stop.NewStopper()
+	defer stopper.Stop(context.Background())
+	tc.StartWithStoreConfig(t, stopper, cfg)
+
+	// Flush a write all the way through the Raft proposal pipeline. This
+	// ensures that leadership settles down before we start manually submitting
+	// proposals and that we don't see any unexpected proposal refreshes due to
+	// reasons like reasonNewLeaderOrConfigChange.
+	args := incrementArgs([]byte("a"), 1)
+	if _, pErr := tc.SendWrapped(args); pErr != nil {
+		t.Fatal(pErr)
+	}
+
+	r := tc.repl
+	electionTicks := tc.store.cfg.RaftElectionTimeoutTicks
+	{
+		// The verifications of the reproposal counts below rely on r.mu.ticks
+		// starting with a value of 0 (modulo electionTicks). Move the replica into
+		// that state in case the replica was ticked before we grabbed
+		// processRaftMu.
+		r.mu.Lock()
+		ticks := r.mu.ticks
+		r.mu.Unlock()
+		for ; (ticks % electionTicks) != 0; ticks++ {
+			if _, err := r.tick(nil); err != nil {
+				t.Fatal(err)
+			}
+		}
 	}
 
 	var dropProposals struct {
@@ -8307,6 +9231,89 @@ func TestCommandTimeThreshold(t *testing.T) {
 	}
 }
 
+// TestReplicaGCSuggestsCompaction verifies that a GC request which removes
+// a lot of data suggests a compaction of the keys it collected on every
+// replica, while one which removes little data doesn't. Only RocksDB stores,
+// which have a compactor, get suggestions.
+func TestReplicaGCSuggestsCompaction(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	var mu syncutil.Mutex
+	var suggested []kvserverpb.SuggestedCompaction
+	tsc := TestStoreConfig(nil)
+	tsc.TestingKnobs.TestingApplyFilter = func(filterArgs kvserverbase.ApplyFilterArgs) (int, *roachpb.Error) {
+		mu.Lock()
+		defer mu.Unlock()
+		suggested = append(suggested, filterArgs.SuggestedCompactions...)
+		return 0, nil
+	}
+	tc := testContext{}
+	stopper := stop.NewStopper()
+	defer stopper.Stop(context.Background())
+	tc.StartWithStoreConfig(t, stopper, tsc)
+
+	// writeAndDelete writes a value of the given size to each key and then
+	// deletes it, returning the GC keys which remove both versions.
+	writeAndDelete := func(prefix string, n, size int) []roachpb.GCRequest_GCKey {
+		value := []byte(strings.Repeat("x", size))
+		var gcKeys []roachpb.GCRequest_GCKey
+		for i := 0; i < n; i++ {
+			key := roachpb.Key(fmt.Sprintf("%s%03d", prefix, i))
+			pArgs := putArgs(key, value)
+			if _, pErr := tc.SendWrappedWith(roachpb.Header{Timestamp: tc.Clock().Now()}, &pArgs); pErr != nil {
+				t.Fatal(pErr)
+			}
+			ts := tc.Clock().Now()
+			dArgs := deleteArgs(key)
+			if _, pErr := tc.SendWrappedWith(roachpb.Header{Timestamp: ts}, &dArgs); pErr != nil {
+				t.Fatal(pErr)
+			}
+			gcKeys = append(gcKeys, gcKey(key, ts))
+		}
+		return gcKeys
+	}
+	runGC := func(gcKeys []roachpb.GCRequest_GCKey) []kvserverpb.SuggestedCompaction {
+		mu.Lock()
+		suggested = nil
+		mu.Unlock()
+		gArgs := gcArgs(gcKeys[0].Key, gcKeys[len(gcKeys)-1].Key.Next(), gcKeys...)
+		if _, pErr := tc.SendWrappedWith(roachpb.Header{RangeID: 1}, &gArgs); pErr != nil {
+			t.Fatal(pErr)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		return suggested
+	}
+
+	// A handful of small keys isn't worth a compaction.
+	if sc := runGC(writeAndDelete("small", 4, 10)); len(sc) != 0 {
+		t.Fatalf("expected no suggested compactions, got %+v", sc)
+	}
+
+	// Collecting more than ClearRangeBytesThreshold is.
+	const size = 10 << 10
+	n := batcheval.ClearRangeBytesThreshold/size + 1
+	gcKeys := writeAndDelete("large", n, size)
+	sc := runGC(gcKeys)
+	if tc.engine.Type() == enginepb.EngineTypePebble {
+		if len(sc) != 0 {
+			t.Fatalf("expected no suggested compactions on pebble, got %+v", sc)
+		}
+		return
+	}
+	if len(sc) != 1 {
+		t.Fatalf("expected one suggested compaction, got %+v", sc)
+	}
+	if !sc[0].StartKey.Equal(gcKeys[0].Key) || !sc[0].EndKey.Equal(gcKeys[n-1].Key.Next()) {
+		t.Errorf("expected compaction of [%s,%s), got [%s,%s)",
+			gcKeys[0].Key, gcKeys[n-1].Key.Next(), sc[0].StartKey, sc[0].EndKey)
+	}
+	if sc[0].Bytes < batcheval.ClearRangeBytesThreshold {
+		t.Errorf("expected at least %d bytes to be compacted, got %d",
+			batcheval.ClearRangeBytesThreshold, sc[0].Bytes)
+	}
+}
+
 func TestReplicaTimestampCacheBumpNotLost(t *testing.T) {
 	defer leaktest.AfterTest(t)()
 
@@ -9620,7 +10627,7 @@ func TestReplicaServersideRefreshes(t *testing.T) {
 		// Regression test for #31870.
 		snap := tc.engine.NewSnapshot()
 		defer snap.Close()
-		res, err := tc.repl.sha512(context.Background(), *tc.repl.Desc(), tc.engine, nil /* diff */, roachpb.ChecksumMode_CHECK_FULL, limit.NewLimiter(rate.Inf))
+		res, err := tc.repl.sha512(context.Background(), *tc.repl.Desc(), tc.engine, nil /* diff */, roachpb.ChecksumMode_CHECK_FULL, hlc.Timestamp{}, nil /* excluded */, limit.NewLimiter(rate.Inf), nil /* storeLimiter */, 1 /* shards */, nil /* progress */, nil /* intents */)
 		if err != nil {
 			return hlc.Timestamp{}, err
 		}
@@ -12644,3 +13651,608 @@ support contract. Otherwise, please open an issue at:
 
 	require.Equal(t, exp, act)
 }
+
+// TestLeadershipTransfereeCaughtUp verifies that raft leadership is only
+// considered transferable to replicas whose log has caught up to the commit
+// index and is within the configured gap of the leader's.
+func TestLeadershipTransfereeCaughtUp(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	const lastIndex = 100
+	status := &raft.Status{Progress: make(map[uint64]tracker.Progress)}
+	status.Commit = lastIndex - 11
+	status.Progress[1] = tracker.Progress{Match: lastIndex, State: tracker.StateReplicate}
+	status.Progress[2] = tracker.Progress{Match: lastIndex - 10, State: tracker.StateReplicate}
+	status.Progress[3] = tracker.Progress{Match: lastIndex - 11, State: tracker.StateReplicate}
+	status.Progress[4] = tracker.Progress{Match: lastIndex - 12, State: tracker.StateReplicate}
+
+	testCases := []struct {
+		replicaID uint64
+		maxGap    uint64
+		expected  bool
+	}{
+		{replicaID: 1, maxGap: 0, expected: true},
+		{replicaID: 2, maxGap: 0, expected: false},
+		{replicaID: 2, maxGap: 10, expected: true},
+		{replicaID: 3, maxGap: 10, expected: false},
+		{replicaID: 3, maxGap: 11, expected: true},
+		// Replicas behind the commit index are never caught up.
+		{replicaID: 4, maxGap: lastIndex, expected: false},
+		// Neither are replicas without progress.
+		{replicaID: 5, maxGap: lastIndex, expected: false},
+	}
+	for _, c := range testCases {
+		t.Run(fmt.Sprintf("r%d/gap=%d", c.replicaID, c.maxGap), func(t *testing.T) {
+			require.Equal(t, c.expected,
+				leadershipTransfereeCaughtUp(status, c.replicaID, lastIndex, c.maxGap))
+		})
+	}
+}
+
+// TestReplicaLeadershipTransferSkippedBehind verifies that the raft leader
+// doesn't transfer leadership to a leaseholder that is too far behind, and
+// records that transfers started being skipped.
+func TestReplicaLeadershipTransferSkippedBehind(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	ctx := context.Background()
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+
+	tc := testContext{manualClock: hlc.NewManualClock(123)}
+	cfg := TestStoreConfig(hlc.NewClock(tc.manualClock.UnixNano, time.Nanosecond))
+	cfg.TestingKnobs.DisableAutomaticLeaseRenewal = true
+	tc.StartWithStoreConfig(t, stopper, cfg)
+
+	// The bogus replica isn't part of the raft group, so the leader has no
+	// progress for it.
+	secondReplica, err := tc.addBogusReplicaToRangeDesc(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc.manualClock.Set(leaseExpiry(tc.repl))
+	now := tc.Clock().Now()
+	if err := sendLeaseRequest(tc.repl, &roachpb.Lease{
+		Start:      now,
+		Expiration: now.Add(10, 0).Clone(),
+		Replica:    secondReplica,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	skipped := tc.store.Metrics().RangeRaftLeaderTransfersSkipped.Count()
+	transfers := tc.store.Metrics().RangeRaftLeaderTransfers.Count()
+	tc.repl.mu.Lock()
+	tc.repl.mu.leadershipTransferSkipped = false
+	tc.repl.mu.Unlock()
+	require.Equal(t, raftLeadershipTransferSkippedBehind, tc.repl.maybeTransferRaftLeadership(ctx))
+	require.Equal(t, skipped+1, tc.store.Metrics().RangeRaftLeaderTransfersSkipped.Count())
+	require.Equal(t, transfers, tc.store.Metrics().RangeRaftLeaderTransfers.Count())
+
+	// Checks which keep finding the leaseholder behind aren't counted again.
+	require.Equal(t, raftLeadershipTransferSkippedBehind, tc.repl.maybeTransferRaftLeadership(ctx))
+	require.Equal(t, skipped+1, tc.store.Metrics().RangeRaftLeaderTransfersSkipped.Count())
+}
+
+// testLeaseholderDirectory is a LeaseholderDirectory recording the latest
+// leaseholder reported for each range.
+type testLeaseholderDirectory struct {
+	syncutil.Mutex
+	leaseholders map[roachpb.RangeID]roachpb.ReplicaDescriptor
+}
+
+func (d *testLeaseholderDirectory) UpdateLeaseholder(
+	rangeID roachpb.RangeID, leaseholder roachpb.ReplicaDescriptor,
+) {
+	d.Lock()
+	defer d.Unlock()
+	d.leaseholders[rangeID] = leaseholder
+}
+
+func (d *testLeaseholderDirectory) get(rangeID roachpb.RangeID) roachpb.ReplicaDescriptor {
+	d.Lock()
+	defer d.Unlock()
+	return d.leaseholders[rangeID]
+}
+
+// TestReplicaLeaseholderDirectory verifies that the store's leaseholder
+// directory is informed when a replica loses and acquires the lease.
+func TestReplicaLeaseholderDirectory(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	ctx := context.Background()
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+
+	dir := &testLeaseholderDirectory{leaseholders: map[roachpb.RangeID]roachpb.ReplicaDescriptor{}}
+	tc := testContext{manualClock: hlc.NewManualClock(123)}
+	cfg := TestStoreConfig(hlc.NewClock(tc.manualClock.UnixNano, time.Nanosecond))
+	cfg.TestingKnobs.DisableAutomaticLeaseRenewal = true
+	cfg.LeaseholderDirectory = dir
+	tc.StartWithStoreConfig(t, stopper, cfg)
+
+	// Acquiring the lease publishes this replica as the leaseholder.
+	replDesc, err := tc.repl.GetReplicaDescriptor()
+	require.NoError(t, err)
+	_, pErr := tc.repl.redirectOnOrAcquireLease(ctx)
+	require.Nil(t, pErr)
+	require.Equal(t, replDesc, dir.get(tc.repl.RangeID))
+
+	// Transfer the lease away. The directory learns about the new leaseholder.
+	secondReplica, err := tc.addBogusReplicaToRangeDesc(ctx)
+	require.NoError(t, err)
+	tc.manualClock.Set(leaseExpiry(tc.repl))
+	now := tc.Clock().Now()
+	require.NoError(t, sendLeaseRequest(tc.repl, &roachpb.Lease{
+		Start:      now,
+		Expiration: now.Add(10, 0).Clone(),
+		Replica:    secondReplica,
+	}))
+	require.Equal(t, secondReplica, dir.get(tc.repl.RangeID))
+
+	// Once that lease expires, reacquire it.
+	tc.manualClock.Set(leaseExpiry(tc.repl))
+	_, pErr = tc.repl.redirectOnOrAcquireLease(ctx)
+	require.Nil(t, pErr)
+	require.Equal(t, replDesc, dir.get(tc.repl.RangeID))
+}
+
+// TestReplicaLeaseLostEvent verifies that a replica emits a "lease lost" event
+// when a lease held by its store moves elsewhere, and a "new range lease"
+// event when it acquires the lease.
+func TestReplicaLeaseLostEvent(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	ctx := context.Background()
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+
+	// For expiration-based leases, the events are only emitted with verbose
+	// logging enabled.
+	require.NoError(t, log.SetVModule("replica_proposal=1"))
+	defer func() { _ = log.SetVModule("") }()
+
+	tc := testContext{manualClock: hlc.NewManualClock(123)}
+	cfg := TestStoreConfig(hlc.NewClock(tc.manualClock.UnixNano, time.Nanosecond))
+	cfg.TestingKnobs.DisableAutomaticLeaseRenewal = true
+	tc.StartWithStoreConfig(t, stopper, cfg)
+	_, pErr := tc.repl.redirectOnOrAcquireLease(ctx)
+	require.Nil(t, pErr)
+	replDesc, err := tc.repl.GetReplicaDescriptor()
+	require.NoError(t, err)
+	secondReplica, err := tc.addBogusReplicaToRangeDesc(ctx)
+	require.NoError(t, err)
+	lost := tc.store.Metrics().LeaseLostCount
+
+	// proposeLease proposes the lease on the local replica and returns the trace
+	// of the proposal, which includes the application of the lease.
+	proposeLease := func(replica roachpb.ReplicaDescriptor) string {
+		tc.manualClock.Set(leaseExpiry(tc.repl))
+		now := tc.Clock().Now()
+		recCtx, collect, cancel := tracing.ContextWithRecordingSpan(ctx, "test-recording")
+		defer cancel()
+		var ba roachpb.BatchRequest
+		ba.Timestamp = now
+		ba.Add(&roachpb.RequestLeaseRequest{Lease: roachpb.Lease{
+			Start:      now,
+			Expiration: now.Add(10, 0).Clone(),
+			Replica:    replica,
+		}})
+		exLease, _ := tc.repl.GetLease()
+		ch, _, _, pErr := tc.repl.evalAndPropose(recCtx, &ba, allSpansGuard(), &exLease)
+		require.Nil(t, pErr)
+		require.Nil(t, (<-ch).Err)
+		return collect().String()
+	}
+
+	// Transferring the lease away is a loss for this store.
+	before := lost.Count()
+	trace := proposeLease(secondReplica)
+	require.Equal(t, before+1, lost.Count())
+	require.Contains(t, trace, fmt.Sprintf("lost to s%d", secondReplica.StoreID))
+	require.NotContains(t, trace, "new range lease")
+
+	// Reacquiring it is not.
+	trace = proposeLease(replDesc)
+	require.Equal(t, before+1, lost.Count())
+	require.Contains(t, trace, "new range lease")
+	require.NotContains(t, trace, "lost to")
+}
+
+// TestStoreLeadershipTransferChecker verifies that the store's periodic check
+// transfers raft leadership to the leaseholder of a range whose raft leader
+// doesn't hold the lease.
+func TestStoreLeadershipTransferChecker(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	ctx := context.Background()
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+
+	tc := testContext{manualClock: hlc.NewManualClock(123)}
+	cfg := TestStoreConfig(hlc.NewClock(tc.manualClock.UnixNano, time.Nanosecond))
+	cfg.TestingKnobs.DisableAutomaticLeaseRenewal = true
+	leadershipTransferCheckInterval.Override(&cfg.Settings.SV, 0)
+	tc.StartWithStoreConfig(t, stopper, cfg)
+	_, pErr := tc.repl.redirectOnOrAcquireLease(ctx)
+	require.Nil(t, pErr)
+	secondReplica, err := tc.addBogusReplicaToRangeDesc(ctx)
+	require.NoError(t, err)
+
+	// Hand the lease to the bogus replica. It's not part of the raft group, so
+	// it's considered too far behind for the handoff to transfer leadership.
+	tc.manualClock.Set(leaseExpiry(tc.repl))
+	now := tc.Clock().Now()
+	var ba roachpb.BatchRequest
+	ba.Timestamp = now
+	ba.Add(&roachpb.RequestLeaseRequest{Lease: roachpb.Lease{
+		Start:      now,
+		Expiration: now.Add(time.Hour.Nanoseconds(), 0).Clone(),
+		Replica:    secondReplica,
+	}})
+	exLease, _ := tc.repl.GetLease()
+	ch, _, _, pErr := tc.repl.evalAndPropose(ctx, &ba, allSpansGuard(), &exLease)
+	require.Nil(t, pErr)
+	require.Nil(t, (<-ch).Err)
+
+	// Draining replicas transfer leadership regardless of how far behind the
+	// leaseholder is, so the next check will initiate a transfer.
+	tc.repl.mu.Lock()
+	tc.repl.mu.draining = true
+	tc.repl.mu.Unlock()
+	transfers := tc.store.Metrics().RangeRaftLeaderTransfers
+	before := transfers.Count()
+	leadershipTransferCheckInterval.Override(&cfg.Settings.SV, time.Millisecond)
+	testutils.SucceedsSoon(t, func() error {
+		if transfers.Count() == before {
+			return errors.New("raft leadership transfer not initiated")
+		}
+		return nil
+	})
+}
+
+// TestReplicaLeaseTransitionLogTags verifies that the "new range lease"
+// message is logged with the fields of the lease transition as tags.
+func TestReplicaLeaseTransitionLogTags(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	ctx := context.Background()
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+
+	tc := testContext{manualClock: hlc.NewManualClock(123)}
+	cfg := TestStoreConfig(hlc.NewClock(tc.manualClock.UnixNano, time.Nanosecond))
+	cfg.TestingKnobs.DisableAutomaticLeaseRenewal = true
+	tc.StartWithStoreConfig(t, stopper, cfg)
+	_, pErr := tc.repl.redirectOnOrAcquireLease(ctx)
+	require.Nil(t, pErr)
+
+	var mu syncutil.Mutex
+	var tags []string
+	log.Intercept(ctx, func(entry log.Entry) {
+		if strings.Contains(entry.Message, "new range lease") {
+			mu.Lock()
+			defer mu.Unlock()
+			tags = append(tags, redact.RedactableString(entry.Tags).StripMarkers())
+		}
+	})
+	defer log.Intercept(ctx, nil)
+
+	// Let the lease expire and reacquire it.
+	prevLease, _ := tc.repl.GetLease()
+	tc.manualClock.Set(leaseExpiry(tc.repl))
+	_, pErr = tc.repl.redirectOnOrAcquireLease(ctx)
+	require.Nil(t, pErr)
+	newLease, _ := tc.repl.GetLease()
+	log.Intercept(ctx, nil)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, tags, 1)
+	fields := map[string]string{}
+	for _, tag := range strings.Split(tags[0], ",") {
+		if kv := strings.SplitN(tag, "=", 2); len(kv) == 2 {
+			fields[kv[0]] = kv[1]
+		}
+	}
+	require.Equal(t, fmt.Sprint(tc.repl.RangeID), fields["range_id"])
+	require.Equal(t, fmt.Sprint(prevLease.Replica.StoreID), fields["prev_store"])
+	require.Equal(t, fmt.Sprint(newLease.Replica.StoreID), fields["new_store"])
+	require.Equal(t, newLease.Start.GoTime().UTC().Format(time.RFC3339Nano), fields["lease_start"])
+	require.Equal(t, "expiration", fields["lease_type"])
+	require.Equal(t, tc.Clock().PhysicalTime().UTC().Format(time.RFC3339Nano), fields["physical_time"])
+}
+
+// TestReplicaEncounteredIntentsInterceptor verifies that the intents a request
+// encounters are handed to the store's interceptor on their way to the intent
+// resolver.
+func TestReplicaEncounteredIntentsInterceptor(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	ctx := context.Background()
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+
+	var mu syncutil.Mutex
+	var intercepted [][]roachpb.Intent
+	tc := testContext{}
+	cfg := TestStoreConfig(nil)
+	cfg.TestingKnobs.EncounteredIntentsInterceptor = func(
+		rangeID roachpb.RangeID, intents []roachpb.Intent,
+	) []roachpb.Intent {
+		mu.Lock()
+		defer mu.Unlock()
+		intercepted = append(intercepted, intents)
+		// Keep the intents away from the resolver.
+		return nil
+	}
+	tc.StartWithStoreConfig(t, stopper, cfg)
+
+	txn := newTransaction("test", roachpb.Key("a"), 1, tc.Clock())
+	intentKeys := []roachpb.Key{roachpb.Key("a"), roachpb.Key("b")}
+	for _, key := range intentKeys {
+		pArgs := putArgs(key, []byte("value"))
+		assignSeqNumsForReqs(txn, &pArgs)
+		if _, pErr := tc.SendWrappedWith(roachpb.Header{Txn: txn}, &pArgs); pErr != nil {
+			t.Fatal(pErr)
+		}
+	}
+
+	// An inconsistent scan reads past the intents and reports them as
+	// encountered.
+	sArgs := scanArgs(roachpb.Key("a"), roachpb.Key("c"))
+	if _, pErr := tc.SendWrappedWith(roachpb.Header{
+		ReadConsistency: roachpb.INCONSISTENT,
+	}, sArgs); pErr != nil {
+		t.Fatal(pErr)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, intercepted, 1)
+	require.Len(t, intercepted[0], len(intentKeys))
+	for i, intent := range intercepted[0] {
+		require.Equal(t, intentKeys[i], intent.Key)
+		require.Equal(t, txn.ID, intent.Txn.ID)
+	}
+}
+
+// TestProposalDurableApply verifies that the done channel of a proposal whose
+// command requires a durable application isn't signaled until the application
+// has been synced, while its endCmds are released right away. Errors are
+// returned without waiting.
+func TestProposalDurableApply(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	ctx := context.Background()
+	tc := testContext{}
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+	tc.Start(t, stopper)
+
+	newProposal := func() *ProposalData {
+		p := &ProposalData{
+			ctx:     ctx,
+			doneCh:  make(chan proposalResult, 1),
+			ec:      endCmds{repl: tc.repl},
+			Request: &roachpb.BatchRequest{},
+		}
+		p.durableApply.required = true
+		return p
+	}
+
+	t.Run("success", func(t *testing.T) {
+		p := newProposal()
+		doneCh := p.doneCh
+		p.finishApplication(ctx, proposalResult{Reply: &roachpb.BatchResponse{}})
+		require.Nil(t, p.ec.repl, "endCmds not released")
+		select {
+		case pr := <-doneCh:
+			t.Fatalf("unexpected result before durable application: %+v", pr)
+		default:
+		}
+
+		p.signalDurableApply()
+		select {
+		case pr := <-doneCh:
+			require.Nil(t, pr.Err)
+			require.NotNil(t, pr.Reply)
+		default:
+			t.Fatal("expected result after durable application")
+		}
+	})
+
+	t.Run("synced before finish", func(t *testing.T) {
+		p := newProposal()
+		doneCh := p.doneCh
+		p.signalDurableApply()
+		p.finishApplication(ctx, proposalResult{Reply: &roachpb.BatchResponse{}})
+		select {
+		case pr := <-doneCh:
+			require.Nil(t, pr.Err)
+		default:
+			t.Fatal("expected result after durable application")
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		p := newProposal()
+		doneCh := p.doneCh
+		p.finishApplication(ctx, proposalResult{Err: roachpb.NewErrorf("boom")})
+		require.Nil(t, p.ec.repl, "endCmds not released")
+		select {
+		case pr := <-doneCh:
+			require.True(t, testutils.IsPError(pr.Err, "boom"))
+		default:
+			t.Fatal("expected error without durable application")
+		}
+	})
+}
+
+// TestReplicaQuickStateCheck verifies that QuickStateCheck identifies the
+// on-disk field that diverges from the in-memory state.
+func TestReplicaQuickStateCheck(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	ctx := context.Background()
+	tc := testContext{}
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+	tc.Start(t, stopper)
+
+	ok, field := tc.repl.QuickStateCheck()
+	require.True(t, ok)
+	require.Empty(t, field)
+
+	tc.repl.raftMu.Lock()
+	tc.repl.mu.RLock()
+	state := tc.repl.mu.state
+	tc.repl.mu.RUnlock()
+	rsl := tc.repl.raftMu.stateLoader
+	require.NoError(t, rsl.SetRangeAppliedState(
+		ctx, tc.engine, state.RaftAppliedIndex, state.LeaseAppliedIndex+1, state.Stats))
+	tc.repl.raftMu.Unlock()
+
+	ok, field = tc.repl.QuickStateCheck()
+	require.False(t, ok)
+	require.Equal(t, "LeaseAppliedIndex", field)
+
+	// Restore the applied state and bump the generation of the on-disk
+	// descriptor instead.
+	tc.repl.raftMu.Lock()
+	require.NoError(t, rsl.SetRangeAppliedState(
+		ctx, tc.engine, state.RaftAppliedIndex, state.LeaseAppliedIndex, state.Stats))
+	desc := *state.Desc
+	desc.Generation++
+	require.NoError(t, storage.MVCCPutProto(
+		ctx, tc.engine, nil, keys.RangeDescriptorKey(desc.StartKey), tc.Clock().Now(), nil, &desc))
+	tc.repl.raftMu.Unlock()
+
+	ok, field = tc.repl.QuickStateCheck()
+	require.False(t, ok)
+	require.Equal(t, "Desc.Generation", field)
+}
+
+// TestReplicaLeaseTransferCooldown verifies that a replica whose lease just
+// moved to another store is not considered for another lease transfer until
+// kv.allocator.lease_transfer_cooldown has elapsed, and that a store
+// reacquiring its own lease doesn't start the cooldown.
+func TestReplicaLeaseTransferCooldown(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	ctx := context.Background()
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+
+	tc := testContext{manualClock: hlc.NewManualClock(123)}
+	cfg := TestStoreConfig(hlc.NewClock(tc.manualClock.UnixNano, time.Nanosecond))
+	cfg.TestingKnobs.DisableAutomaticLeaseRenewal = true
+	leaseTransferCooldown.Override(&cfg.Settings.SV, time.Hour)
+	tc.StartWithStoreConfig(t, stopper, cfg)
+
+	// Neither the range's first lease nor reacquiring it after it expired
+	// starts the cooldown.
+	require.False(t, tc.repl.inLeaseTransferCooldown())
+	tc.manualClock.Set(leaseExpiry(tc.repl))
+	_, pErr := tc.repl.redirectOnOrAcquireLease(ctx)
+	require.Nil(t, pErr)
+	require.False(t, tc.repl.inLeaseTransferCooldown())
+
+	// A handoff to another replica starts it, subject to the current value of
+	// the setting.
+	leaseTransferCooldown.Override(&cfg.Settings.SV, 0)
+	secondReplica, err := tc.addBogusReplicaToRangeDesc(ctx)
+	require.NoError(t, err)
+	tc.manualClock.Set(leaseExpiry(tc.repl))
+	now := tc.Clock().Now()
+	require.NoError(t, sendLeaseRequest(tc.repl, &roachpb.Lease{
+		Start:      now,
+		Expiration: now.Add(10*time.Second.Nanoseconds(), 0).Clone(),
+		Replica:    secondReplica,
+	}))
+	require.False(t, tc.repl.inLeaseTransferCooldown())
+
+	// Once the lease is transferred back, the cooldown suppresses another
+	// transfer.
+	leaseTransferCooldown.Override(&cfg.Settings.SV, time.Hour)
+	replDesc, err := tc.repl.GetReplicaDescriptor()
+	require.NoError(t, err)
+	now = tc.Clock().Now()
+	var ba roachpb.BatchRequest
+	ba.Timestamp = now
+	ba.Add(&roachpb.TransferLeaseRequest{Lease: roachpb.Lease{
+		Start:      now,
+		Expiration: now.Add(10*time.Second.Nanoseconds(), 0).Clone(),
+		Replica:    replDesc,
+	}})
+	exLease, _ := tc.repl.GetLease()
+	ch, _, _, pErr := tc.repl.evalAndPropose(ctx, &ba, allSpansGuard(), &exLease)
+	if pErr == nil {
+		pErr = (<-ch).Err
+	}
+	require.Nil(t, pErr)
+	require.True(t, tc.repl.inLeaseTransferCooldown())
+}
+
+// TestProposalEndCmdsDoneObserver verifies that the OnProposalEndCmdsDone
+// testing knob is notified of the release of each proposal's latches, in
+// completion order and before the client is acknowledged.
+func TestProposalEndCmdsDoneObserver(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	ctx := context.Background()
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+
+	key := roachpb.Key("a")
+	var mu syncutil.Mutex
+	var proposed, done []kvserverbase.CmdIDKey
+	tc := testContext{}
+	cfg := TestStoreConfig(nil)
+	cfg.TestingKnobs.TestingProposalFilter = func(args kvserverbase.ProposalFilterArgs) *roachpb.Error {
+		if put, ok := args.Req.GetArg(roachpb.Put); ok && put.Header().Key.Equal(key) {
+			mu.Lock()
+			defer mu.Unlock()
+			proposed = append(proposed, args.CmdID)
+		}
+		return nil
+	}
+	cfg.TestingKnobs.OnProposalEndCmdsDone = func(cmdID kvserverbase.CmdIDKey, reproposed bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, id := range proposed {
+			if id == cmdID {
+				if reproposed {
+					t.Errorf("command %x unexpectedly reproposed", cmdID)
+				}
+				done = append(done, cmdID)
+			}
+		}
+	}
+	tc.StartWithStoreConfig(t, stopper, cfg)
+
+	for i := 0; i < 5; i++ {
+		put := putArgs(key, []byte(fmt.Sprintf("value-%d", i)))
+		if _, pErr := tc.SendWrapped(&put); pErr != nil {
+			t.Fatal(pErr)
+		}
+		// The observer was notified before the client was acknowledged.
+		mu.Lock()
+		require.Len(t, done, i+1)
+		mu.Unlock()
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, proposed, done)
+}
+
+// TestMaybeCountMergeConflict verifies that a conflict reported by
+// result.MergeAndDestroy increments the telemetry counter of its field, and
+// that other errors are not counted.
+func TestMaybeCountMergeConflict(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	counter := telemetry.GetCounter("kv.eval_result.merge_conflict.Merge")
+	before := telemetry.Read(counter)
+
+	newResult := func() result.Result {
+		return result.Result{Replicated: kvserverpb.ReplicatedEvalResult{Merge: &kvserverpb.Merge{}}}
+	}
+	p := newResult()
+	err := p.MergeAndDestroy(newResult())
+	require.Error(t, err)
+	maybeCountMergeConflict(roachpb.NewReplicaCorruptionError(errors.New("unrelated")))
+	require.Equal(t, before, telemetry.Read(counter))
+	maybeCountMergeConflict(err)
+	require.Equal(t, before+1, telemetry.Read(counter))
+}
@@ -187,7 +187,7 @@ func (r *Replica) executeWriteBatch(
 				// both leave intents to GC that don't hit this code path. No good
 				// solution presents itself at the moment and such intents will be
 				// resolved on reads.
-				if err := r.store.intentResolver.CleanupIntentsAsync(
+				if err := r.cleanupEncounteredIntentsAsync(
 					ctx, propResult.EncounteredIntents, true, /* allowSync */
 				); err != nil {
 					log.Warningf(ctx, "%v", err)
@@ -462,6 +462,7 @@ func (r *Replica) evaluate1PC(
 			}
 		}
 		if err := res.MergeAndDestroy(innerResult); err != nil {
+			maybeCountMergeConflict(err)
 			return onePCResult{
 				success: onePCFailed,
 				pErr:    roachpb.NewError(err),
@@ -59,6 +59,18 @@ var minLeaseTransferInterval = settings.RegisterNonNegativeDurationSetting(
 	1*time.Second,
 )
 
+// leaseTransferCooldown is the per-range counterpart of
+// minLeaseTransferInterval: it prevents the lease of a range from being
+// transferred for rebalancing again shortly after it changed hands, which
+// could otherwise make the lease bounce back and forth between stores.
+var leaseTransferCooldown = settings.RegisterNonNegativeDurationSetting(
+	"kv.allocator.lease_transfer_cooldown",
+	"minimum time after a range's lease changed hands before it can be "+
+		"transferred again for rebalancing (0 to disable). It does not prevent "+
+		"transferring leases in order to allow a replica to be removed from a range.",
+	0,
+)
+
 var (
 	metaReplicateQueueAddReplicaCount = metric.Metadata{
 		Name:        "queue.replicate.addreplica",
@@ -243,7 +255,7 @@ func (rq *replicateQueue) shouldQueue(
 
 	// If the lease is valid, check to see if we should transfer it.
 	if lease, _ := repl.GetLease(); repl.IsLeaseValid(lease, now) {
-		if rq.canTransferLease() &&
+		if rq.canTransferLease() && !repl.inLeaseTransferCooldown() &&
 			rq.allocator.ShouldTransferLease(
 				ctx, zone, voterReplicas, lease.Replica.StoreID, repl.leaseholderStats) {
 			log.VEventf(ctx, 2, "lease transfer needed, enqueuing")
@@ -899,7 +911,7 @@ func (rq *replicateQueue) considerRebalance(
 		}
 	}
 
-	if canTransferLease() {
+	if canTransferLease() && !repl.inLeaseTransferCooldown() {
 		// We require the lease in order to process replicas, so
 		// repl.store.StoreID() corresponds to the lease-holder's store ID.
 		transferred, err := rq.findTargetAndTransferLease(
@@ -12,6 +12,7 @@ package kvserver
 
 import (
 	"context"
+	"fmt"
 	"math"
 	"testing"
 
@@ -104,3 +105,107 @@ func TestSplitQueueShouldQueue(t *testing.T) {
 		}
 	}
 }
+
+// TestSplitQueueBacklogBackpressure verifies that once the split queue is
+// backlogged, a range which has outgrown its split size backpressures writes
+// before reaching the regular backpressure threshold, and that ranges past the
+// byte tolerance remain exempt.
+func TestSplitQueueBacklogBackpressure(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	tc := testContext{}
+	stopper := stop.NewStopper()
+	defer stopper.Stop(context.Background())
+	tc.Start(t, stopper)
+	ctx := context.Background()
+
+	const backlog = 3
+	sv := &tc.store.cfg.Settings.SV
+	backpressureSplitQueueBacklog.Override(sv, backlog)
+	backpressureRangeSizeMultiplier.Override(sv, 2)
+	backpressureByteTolerance.Override(sv, 0)
+
+	// Prevent the split queue from processing anything, so that whatever we
+	// add to it stays queued.
+	sq := tc.store.splitQueue
+	sq.SetDisabled(false)
+	unlockProcessing := sq.lockProcessing()
+	defer unlockProcessing()
+
+	var keyIdx int
+	write := func() {
+		key := roachpb.Key(fmt.Sprintf("flood-%04d", keyIdx))
+		keyIdx++
+		pArgs := putArgs(key, make([]byte, 1<<10))
+		if _, pErr := tc.SendWrapped(&pArgs); pErr != nil {
+			t.Fatal(pErr)
+		}
+	}
+	// setMaxBytes sets the range's split size to the given fraction of its
+	// current size.
+	setMaxBytes := func(frac float64) {
+		zone := zonepb.DefaultZoneConfig()
+		zone.RangeMinBytes = proto.Int64(0)
+		zone.RangeMaxBytes = proto.Int64(int64(frac * float64(tc.repl.GetMVCCStats().Total())))
+		tc.repl.SetZoneConfig(&zone)
+		tc.repl.mu.Lock()
+		tc.repl.mu.largestPreviousMaxRangeSizeBytes = 0
+		tc.repl.mu.Unlock()
+	}
+
+	// The range is over its split size, but not twice that.
+	for i := 0; i < 10; i++ {
+		write()
+	}
+	setMaxBytes(0.75)
+	write()
+	if tc.repl.shouldBackpressureWrites() {
+		t.Fatal("unexpected backpressure without a split queue backlog")
+	}
+
+	// Fill up the split queue with unrelated ranges.
+	var fakeIDs []roachpb.RangeID
+	for i := 0; i < backlog; i++ {
+		rangeID := roachpb.RangeID(1000 + i)
+		fakeIDs = append(fakeIDs, rangeID)
+		desc := &roachpb.RangeDescriptor{
+			RangeID:  rangeID,
+			StartKey: roachpb.RKey(fmt.Sprintf("fake-%d", i)),
+			EndKey:   roachpb.RKey(fmt.Sprintf("fake-%d-end", i)),
+		}
+		if _, err := sq.addInternal(ctx, desc, 1 /* replicaID */, 1 /* priority */); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer func() {
+		// Empty the queue before processing resumes.
+		sq.SetDisabled(true)
+		for _, rangeID := range append(fakeIDs, tc.repl.RangeID) {
+			sq.MaybeRemove(rangeID)
+		}
+	}()
+
+	write()
+	if !tc.repl.shouldBackpressureWrites() {
+		t.Fatal("expected backpressure with a backlogged split queue")
+	}
+
+	// A range that exceeds the regular backpressure threshold by more than the
+	// byte tolerance is exempt, backlog or not.
+	setMaxBytes(0.25)
+	write()
+	if tc.repl.shouldBackpressureWrites() {
+		t.Fatal("unexpected backpressure past the byte tolerance")
+	}
+
+	// Disabling the setting clears the signal on the next write.
+	setMaxBytes(0.75)
+	write()
+	if !tc.repl.shouldBackpressureWrites() {
+		t.Fatal("expected backpressure with a backlogged split queue")
+	}
+	backpressureSplitQueueBacklog.Override(sv, 0)
+	write()
+	if tc.repl.shouldBackpressureWrites() {
+		t.Fatal("unexpected backpressure after disabling the backlog setting")
+	}
+}
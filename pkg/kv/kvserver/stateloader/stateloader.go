@@ -103,6 +103,10 @@ func (rsl StateLoader) Load(
 	}
 	s.TruncatedState = &truncState
 
+	if s.RaftLogQueueDisabled, err = rsl.LoadRaftLogQueueDisabled(ctx, reader); err != nil {
+		return kvserverpb.ReplicaState{}, err
+	}
+
 	return s, nil
 }
 
@@ -165,6 +169,11 @@ func (rsl StateLoader) Save(
 			return enginepb.MVCCStats{}, err
 		}
 	}
+	if state.RaftLogQueueDisabled {
+		if err := rsl.SetRaftLogQueueDisabled(ctx, readWriter, ms, true); err != nil {
+			return enginepb.MVCCStats{}, err
+		}
+	}
 	return *ms, nil
 }
 
@@ -470,6 +479,35 @@ func (rsl StateLoader) SetGCThreshold(
 		rsl.RangeLastGCKey(), hlc.Timestamp{}, nil, threshold)
 }
 
+// LoadRaftLogQueueDisabled loads the flag which records whether the
+// application of commands refrains from offering the range to the raft log
+// queue.
+func (rsl StateLoader) LoadRaftLogQueueDisabled(
+	ctx context.Context, reader storage.Reader,
+) (bool, error) {
+	v, _, err := storage.MVCCGet(ctx, reader, rsl.RangeRaftLogQueueDisabledKey(),
+		hlc.Timestamp{}, storage.MVCCGetOptions{})
+	if err != nil || v == nil {
+		return false, err
+	}
+	return v.GetBool()
+}
+
+// SetRaftLogQueueDisabled sets the flag which records whether the application
+// of commands refrains from offering the range to the raft log queue. The
+// flag is stored only while set.
+func (rsl StateLoader) SetRaftLogQueueDisabled(
+	ctx context.Context, readWriter storage.ReadWriter, ms *enginepb.MVCCStats, disabled bool,
+) error {
+	key := rsl.RangeRaftLogQueueDisabledKey()
+	if !disabled {
+		return storage.MVCCDelete(ctx, readWriter, ms, key, hlc.Timestamp{}, nil)
+	}
+	var v roachpb.Value
+	v.SetBool(true)
+	return storage.MVCCPut(ctx, readWriter, ms, key, hlc.Timestamp{}, v, nil)
+}
+
 // The rest is not technically part of ReplicaState.
 
 // LoadLastIndex loads the last index.
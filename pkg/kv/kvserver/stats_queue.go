@@ -0,0 +1,140 @@
+// Copyright 2020 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package kvserver
+
+import (
+	"context"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/config"
+	"github.com/cockroachdb/cockroach/pkg/gossip"
+	"github.com/cockroachdb/cockroach/pkg/kv"
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/settings"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+)
+
+const (
+	// statsQueueTimerDuration is the duration between stats reconciliations of
+	// queued replicas. Reconciliation scans the whole range, so it is paced
+	// well below the other queues.
+	statsQueueTimerDuration = 10 * time.Second
+
+	statsReconciliationPriority float64 = 0
+)
+
+// statsReconciliationAppliedBytes is the number of bytes of write batches a
+// replica applies between reconciliations of its MVCCStats. The stats are
+// maintained incrementally from the deltas computed during evaluation, so any
+// error in those deltas (or estimates they carry) is otherwise only corrected
+// when the range is split or checked for consistency. Each reconciliation
+// scans the whole range, so it's disabled by default.
+var statsReconciliationAppliedBytes = settings.RegisterByteSizeSetting(
+	"kv.range_stats.reconciliation_applied_bytes",
+	"number of bytes applied to a range after which its MVCC stats are "+
+		"recomputed and any estimates cleared (0 to disable)",
+	0,
+)
+
+// statsQueue recomputes the MVCCStats of replicas which the apply path has
+// flagged for reconciliation, correcting any drift accumulated through
+// incremental updates and clearing ContainsEstimates.
+type statsQueue struct {
+	*baseQueue
+	db *kv.DB
+}
+
+// newStatsQueue returns a new instance of statsQueue.
+func newStatsQueue(store *Store, db *kv.DB, g *gossip.Gossip) *statsQueue {
+	sq := &statsQueue{db: db}
+	sq.baseQueue = newBaseQueue(
+		"stats", sq, store, g,
+		queueConfig{
+			maxSize: defaultQueueMaxSize,
+			// The recomputation is evaluated on the leaseholder, so there is no
+			// point in having followers queue up for it.
+			needsLease:           true,
+			needsSystemConfig:    false,
+			acceptsUnsplitRanges: true,
+			successes:            store.metrics.StatsQueueSuccesses,
+			failures:             store.metrics.StatsQueueFailures,
+			pending:              store.metrics.StatsQueuePending,
+			processingNanos:      store.metrics.StatsQueueProcessingNanos,
+		},
+	)
+	return sq
+}
+
+// noteAppliedBytesLocked accounts for the given number of bytes applied to
+// the replica's state machine and returns whether the replica should be
+// offered to the stats queue as a result, given the current value of
+// statsReconciliationAppliedBytes. Only the leaseholder, which is the replica
+// processing the queue (and thus clearing the flag), keeps track; followers
+// drop any flag left over from holding the lease. Requires that r.mu is held.
+func (r *Replica) noteAppliedBytesLocked(n, threshold int64) bool {
+	if !r.mu.state.Lease.OwnedBy(r.store.StoreID()) {
+		r.mu.appliedBytesSinceStatsReconciliation = 0
+		r.mu.statsReconciliationPending = false
+		return false
+	}
+	if threshold <= 0 {
+		return false
+	}
+	r.mu.appliedBytesSinceStatsReconciliation += n
+	if r.mu.appliedBytesSinceStatsReconciliation < threshold {
+		return false
+	}
+	r.mu.appliedBytesSinceStatsReconciliation = 0
+	r.mu.statsReconciliationPending = true
+	return true
+}
+
+func (sq *statsQueue) shouldQueue(
+	ctx context.Context, now hlc.Timestamp, repl *Replica, _ *config.SystemConfig,
+) (shouldQ bool, priority float64) {
+	repl.mu.RLock()
+	defer repl.mu.RUnlock()
+	return repl.mu.statsReconciliationPending, statsReconciliationPriority
+}
+
+// process recomputes the replica's MVCCStats through a RecomputeStatsRequest,
+// which applies the difference to the true stats through Raft. The replica
+// stays flagged for reconciliation if the recomputation fails, so that it is
+// queued again.
+func (sq *statsQueue) process(
+	ctx context.Context, repl *Replica, _ *config.SystemConfig,
+) (processed bool, err error) {
+	req := roachpb.RecomputeStatsRequest{
+		RequestHeader: roachpb.RequestHeader{Key: repl.Desc().StartKey.AsRawKey()},
+	}
+	var b kv.Batch
+	b.AddRawRequest(&req)
+	if err := sq.db.Run(ctx, &b); err != nil {
+		return false, err
+	}
+	repl.mu.Lock()
+	repl.mu.statsReconciliationPending = false
+	repl.mu.Unlock()
+	if log.V(1) {
+		delta := b.RawResponse().Responses[0].GetRecomputeStats().AddedDelta
+		log.Infof(ctx, "reconciled stats with delta of %+v", delta)
+	}
+	return true, nil
+}
+
+func (*statsQueue) timer(_ time.Duration) time.Duration {
+	return statsQueueTimerDuration
+}
+
+func (*statsQueue) purgatoryChan() <-chan time.Time {
+	return nil
+}
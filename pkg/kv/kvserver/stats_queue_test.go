@@ -0,0 +1,109 @@
+// Copyright 2020 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package kvserver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/kv/kvserver/rditer"
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/testutils"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/stop"
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// TestStatsQueueReconcilesDrift verifies that once a replica has applied
+// enough bytes, the apply path enqueues it into the stats queue, which
+// replaces drifted stats with the true ones and clears ContainsEstimates.
+func TestStatsQueueReconcilesDrift(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	ctx := context.Background()
+	tc := testContext{}
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+	cfg := TestStoreConfig(hlc.NewClock(hlc.UnixNano, 0))
+	statsReconciliationAppliedBytes.Override(&cfg.Settings.SV, 4<<10)
+	tc.StartWithStoreConfig(t, stopper, cfg)
+
+	// Pretend that estimated deltas have been applied which don't match the
+	// data in the range.
+	tc.repl.mu.Lock()
+	tc.repl.mu.state.Stats.ContainsEstimates = 1
+	tc.repl.mu.state.Stats.LiveBytes += 12345
+	tc.repl.mu.state.Stats.KeyCount += 7
+	tc.repl.mu.Unlock()
+
+	// The drift is carried along by the writes until enough of them have been
+	// applied to trigger a reconciliation.
+	value := []byte(strings.Repeat("v", 1<<10))
+	for i := 0; i < 8; i++ {
+		pArgs := putArgs(roachpb.Key(fmt.Sprintf("a%d", i)), value)
+		if _, pErr := tc.SendWrapped(&pArgs); pErr != nil {
+			t.Fatal(pErr)
+		}
+	}
+
+	testutils.SucceedsSoon(t, func() error {
+		if n := tc.store.metrics.StatsQueueSuccesses.Count(); n == 0 {
+			return errors.New("stats queue has not processed the replica")
+		}
+		return nil
+	})
+
+	tc.repl.mu.RLock()
+	require.False(t, tc.repl.mu.statsReconciliationPending)
+	tc.repl.mu.RUnlock()
+
+	now := tc.Clock().Now().WallTime
+	actual := tc.repl.GetMVCCStats()
+	actual.AgeTo(now)
+	require.Zero(t, actual.ContainsEstimates)
+	expected, err := rditer.ComputeStatsForRange(tc.repl.Desc(), tc.engine, now)
+	require.NoError(t, err)
+	require.Equal(t, expected, actual)
+}
+
+// TestStatsQueueLeaseholderOnly verifies that only the leaseholder flags
+// itself for stats reconciliation, since only the leaseholder processes the
+// stats queue and clears the flag.
+func TestStatsQueueLeaseholderOnly(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	ctx := context.Background()
+	tc := testContext{}
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+	tc.Start(t, stopper)
+	_, pErr := tc.repl.redirectOnOrAcquireLease(ctx)
+	require.Nil(t, pErr)
+
+	r := tc.repl
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	require.True(t, r.noteAppliedBytesLocked(10, 10 /* threshold */))
+	require.True(t, r.mu.statsReconciliationPending)
+
+	// Once the lease is elsewhere, the replica drops the flag and doesn't set
+	// it again.
+	lease := *r.mu.state.Lease
+	otherLease := lease
+	otherLease.Replica.StoreID++
+	r.mu.state.Lease = &otherLease
+	defer func() { r.mu.state.Lease = &lease }()
+	require.False(t, r.noteAppliedBytesLocked(10, 10 /* threshold */))
+	require.False(t, r.mu.statsReconciliationPending)
+	require.Zero(t, r.mu.appliedBytesSinceStatsReconciliation)
+}
@@ -402,6 +402,7 @@ type Store struct {
 	tsMaintenanceQueue *timeSeriesMaintenanceQueue // Time series maintenance queue
 	scanner            *replicaScanner             // Replica scanner
 	consistencyQueue   *consistencyQueue           // Replica consistency check queue
+	statsQueue         *statsQueue                 // MVCCStats reconciliation queue
 	metrics            *StoreMetrics
 	intentResolver     *intentresolver.IntentResolver
 	recoveryMgr        txnrecovery.Manager
@@ -585,6 +586,18 @@ type Store struct {
 
 	scheduler *raftScheduler
 
+	// checksumScheduler admits the checksum computations for consistency checks
+	// of this store's replicas.
+	checksumScheduler *checksumScheduler
+	// consistencyIOBudget holds the *limit.LimiterBurstDisabled which paces the
+	// reads of all checksum computations on this store according to
+	// server.consistency_check.store_io_budget. It is replaced whenever the
+	// setting changes.
+	consistencyIOBudget atomic.Value
+	// firstRangeGossipThrottle rate limits the gossips of the first range
+	// requested by commands applied on this store's replica of the first range.
+	firstRangeGossipThrottle firstRangeGossipThrottle
+
 	// livenessMap is a map from nodeID to a bool indicating
 	// liveness. It is updated periodically in raftTickLoop().
 	livenessMap atomic.Value
@@ -642,6 +655,10 @@ type StoreConfig struct {
 	// maintenance queue to dispatch individual maintenance tasks.
 	TimeSeriesDataStore TimeSeriesDataStore
 
+	// LeaseholderDirectory, if set, is informed of lease changes involving the
+	// store's replicas as they are applied.
+	LeaseholderDirectory LeaseholderDirectory
+
 	// CoalescedHeartbeatsInterval is the interval for which heartbeat messages
 	// are queued and then sent as a single coalesced heartbeat; it is a
 	// fraction of the RaftTickInterval so that heartbeats don't get delayed by
@@ -720,6 +737,17 @@ type StoreConfig struct {
 	ProtectedTimestampCache protectedts.Cache
 }
 
+// LeaseholderDirectory is an interface which can be implemented by a cache of
+// the leaseholders of ranges to be kept up to date by the stores, instead of
+// polling for lease changes. A store informs the directory whenever one of its
+// replicas acquires or loses the lease of its range. Changes among replicas on
+// other stores are not reported; those stores are responsible for them.
+type LeaseholderDirectory interface {
+	// UpdateLeaseholder records that the given replica holds the lease for the
+	// range. It is called once the lease has been applied, and must not block.
+	UpdateLeaseholder(rangeID roachpb.RangeID, leaseholder roachpb.ReplicaDescriptor)
+}
+
 // ConsistencyTestingKnobs is a BatchEvalTestingKnobs struct used to control the
 // behavior of the consistency checker for tests.
 type ConsistencyTestingKnobs struct {
@@ -730,6 +758,15 @@ type ConsistencyTestingKnobs struct {
 	// checksum mismatch to report the diff between snapshots.
 	BadChecksumReportDiff      func(roachpb.StoreIdent, ReplicaSnapshotDiffSlice)
 	ConsistencyQueueResultHook func(response roachpb.CheckConsistencyResponse)
+	// If non-nil, ChecksumSnapshot is called by computeChecksumPostApply in
+	// place of opening an engine snapshot to obtain the replica data to be
+	// hashed. The returned function is called once the computation no longer
+	// needs the reader. This lets tests feed controlled data to the checksum.
+	ChecksumSnapshot func(rangeID roachpb.RangeID) (storage.Reader, func())
+	// If nonzero, ChecksumGCInterval overrides the time for which the results
+	// of checksum computations are retained (see
+	// batcheval.ReplicaChecksumGCInterval).
+	ChecksumGCInterval time.Duration
 }
 
 // Valid returns true if the StoreConfig is populated correctly.
@@ -807,6 +844,13 @@ func NewStore(
 
 	s.draining.Store(false)
 	s.scheduler = newRaftScheduler(s.metrics, s, storeSchedulerConcurrency)
+	s.checksumScheduler = newChecksumScheduler(func() int {
+		return int(consistencyCheckConcurrency.Get(&cfg.Settings.SV))
+	})
+	s.consistencyIOBudget.Store(newConsistencyIOLimiter(&cfg.Settings.SV))
+	consistencyCheckStoreIOBudget.SetOnChange(&cfg.Settings.SV, func() {
+		s.consistencyIOBudget.Store(newConsistencyIOLimiter(&cfg.Settings.SV))
+	})
 
 	s.raftEntryCache = raftentry.NewCache(cfg.RaftEntryCacheSize)
 	s.metrics.registry.AddMetricStruct(s.raftEntryCache.Metrics())
@@ -922,12 +966,13 @@ func NewStore(
 		s.raftLogQueue = newRaftLogQueue(s, s.db, s.cfg.Gossip)
 		s.raftSnapshotQueue = newRaftSnapshotQueue(s, s.cfg.Gossip)
 		s.consistencyQueue = newConsistencyQueue(s, s.cfg.Gossip)
+		s.statsQueue = newStatsQueue(s, s.db, s.cfg.Gossip)
 		// NOTE: If more queue types are added, please also add them to the list of
 		// queues on the EnqueueRange debug page as defined in
 		// pkg/ui/src/views/reports/containers/enqueueRange/index.tsx
 		s.scanner.AddQueues(
 			s.gcQueue, s.mergeQueue, s.splitQueue, s.replicateQueue, s.replicaGCQueue,
-			s.raftLogQueue, s.raftSnapshotQueue, s.consistencyQueue)
+			s.raftLogQueue, s.raftSnapshotQueue, s.consistencyQueue, s.statsQueue)
 
 		if s.cfg.TimeSeriesDataStore != nil {
 			s.tsMaintenanceQueue = newTimeSeriesMaintenanceQueue(
@@ -1557,6 +1602,8 @@ func (s *Store) Start(ctx context.Context, stopper *stop.Stopper) error {
 		s.startLeaseRenewer(ctx)
 	}
 
+	s.startLeadershipTransferChecker(ctx)
+
 	// Connect rangefeeds to closed timestamp updates.
 	s.startClosedTimestampRangefeedSubscriber(ctx)
 
@@ -1725,6 +1772,70 @@ func (s *Store) startLeaseRenewer(ctx context.Context) {
 	})
 }
 
+// startLeadershipTransferChecker runs an infinite loop in a goroutine which,
+// every kv.raft.leadership_transfer.check_interval, checks whether any of the
+// store's replicas is the raft leader of a range whose lease is held elsewhere
+// and if so, transfers raft leadership to the leaseholder.
+func (s *Store) startLeadershipTransferChecker(ctx context.Context) {
+	intervalChanged := make(chan struct{}, 1)
+	leadershipTransferCheckInterval.SetOnChange(&s.cfg.Settings.SV, func() {
+		select {
+		case intervalChanged <- struct{}{}:
+		default:
+		}
+	})
+
+	s.stopper.RunWorker(ctx, func(ctx context.Context) {
+		timer := timeutil.NewTimer()
+		defer timer.Stop()
+		for {
+			if interval := leadershipTransferCheckInterval.Get(&s.cfg.Settings.SV); interval > 0 {
+				timer.Reset(interval)
+			}
+			select {
+			case <-intervalChanged:
+			case <-timer.C:
+				timer.Read = true
+				if leadershipTransferCheckInterval.Get(&s.cfg.Settings.SV) > 0 {
+					s.checkLeadershipTransfers(ctx)
+				}
+			case <-s.stopper.ShouldStop():
+				return
+			}
+		}
+	})
+}
+
+// checkLeadershipTransfers transfers raft leadership to the leaseholder for
+// each of the store's replicas which is the raft leader but doesn't hold the
+// lease. See maybeTransferRaftLeadership.
+//
+// Only unquiesced replicas are considered: a range only quiesces if its raft
+// leader holds the lease, so quiesced replicas never need a transfer.
+func (s *Store) checkLeadershipTransfers(ctx context.Context) {
+	var rangeIDs []roachpb.RangeID
+	s.unquiescedReplicas.Lock()
+	for rangeID := range s.unquiescedReplicas.m {
+		rangeIDs = append(rangeIDs, rangeID)
+	}
+	s.unquiescedReplicas.Unlock()
+
+	for _, rangeID := range rangeIDs {
+		r, err := s.GetReplica(rangeID)
+		if err != nil {
+			continue
+		}
+		// Followers are skipped under the read lock, which doesn't contend with
+		// readers on hot ranges.
+		r.mu.RLock()
+		leader := r.mu.leaderID == r.mu.replicaID
+		r.mu.RUnlock()
+		if leader {
+			r.maybeTransferRaftLeadership(r.AnnotateCtx(ctx))
+		}
+	}
+}
+
 // startClosedTimestampRangefeedSubscriber establishes a new ClosedTimestamp
 // subscription and runs an infinite loop to listen for closed timestamp updates
 // and inform Replicas with active Rangefeeds about them.
@@ -2420,6 +2531,7 @@ func (s *Store) updateReplicationGauges(ctx context.Context) error {
 	clusterNodes := s.ClusterNodeCount()
 
 	var minMaxClosedTS hlc.Timestamp
+	var oldestChecksum time.Time
 	newStoreReplicaVisitor(s).Visit(func(rep *Replica) bool {
 		metrics := rep.Metrics(ctx, timestamp, livenessMap, clusterNodes)
 		if metrics.Leader {
@@ -2464,6 +2576,9 @@ func (s *Store) updateReplicationGauges(ctx context.Context) error {
 		if ok && (minMaxClosedTS.IsEmpty() || mc.Less(minMaxClosedTS)) {
 			minMaxClosedTS = mc
 		}
+		if start, ok := rep.oldestPendingChecksum(); ok && (oldestChecksum.IsZero() || start.Before(oldestChecksum)) {
+			oldestChecksum = start
+		}
 		return true // more
 	})
 
@@ -2488,6 +2603,12 @@ func (s *Store) updateReplicationGauges(ctx context.Context) error {
 		s.metrics.ClosedTimestampMaxBehindNanos.Update(nanos)
 	}
 
+	var oldestChecksumNanos int64
+	if !oldestChecksum.IsZero() {
+		oldestChecksumNanos = timeutil.Since(oldestChecksum).Nanoseconds()
+	}
+	s.metrics.ConsistencyQueueOldestChecksumNanos.Update(oldestChecksumNanos)
+
 	return nil
 }
 
@@ -0,0 +1,124 @@
+// Copyright 2020 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package kvserver
+
+import (
+	"context"
+
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/errors"
+	"golang.org/x/time/rate"
+)
+
+// ConsistencySweepSummary is the outcome of Store.RunConsistencySweep.
+type ConsistencySweepSummary struct {
+	// Results holds the outcome of the check of each range the sweep checked,
+	// in key order.
+	Results []roachpb.CheckConsistencyResponse_Result
+	// Errors holds the ranges whose check could not be carried out.
+	Errors map[roachpb.RangeID]error
+	// Consistent, Inconsistent and Indeterminate count the Results by status.
+	// The RANGE_CONSISTENT_STATS_* statuses count as consistent.
+	Consistent, Inconsistent, Indeterminate int
+	// Skipped counts the ranges which weren't checked because their lease is
+	// held by another store, which is expected to check them in its own sweep.
+	Skipped int
+	// ResumeKey is set if the sweep was interrupted before it got to every
+	// range. Passing it to RunConsistencySweep picks the sweep up at the first
+	// range that wasn't checked.
+	ResumeKey roachpb.RKey
+}
+
+func (s *ConsistencySweepSummary) add(res roachpb.CheckConsistencyResponse_Result) {
+	switch res.Status {
+	case roachpb.CheckConsistencyResponse_RANGE_CONSISTENT,
+		roachpb.CheckConsistencyResponse_RANGE_CONSISTENT_STATS_ESTIMATED,
+		roachpb.CheckConsistencyResponse_RANGE_CONSISTENT_STATS_INCORRECT:
+		s.Consistent++
+	case roachpb.CheckConsistencyResponse_RANGE_INCONSISTENT:
+		s.Inconsistent++
+	default:
+		s.Indeterminate++
+	}
+	s.Results = append(s.Results, res)
+}
+
+// RunConsistencySweep runs a full consistency check on each range for which
+// this store holds (or can acquire) the lease, starting at the range
+// containing resumeKey (or at the first range, if resumeKey is empty) and
+// proceeding in key order. Checks are run one at a time, at most limit ranges
+// per second (without limit if it isn't positive), and their checksum
+// computations are subject to the store's checksum worker pool like any other.
+//
+// The sweep stops when ctx is canceled, in which case the summary of the
+// ranges checked so far is returned along with the context's error, and its
+// ResumeKey can be used to continue the sweep later.
+func (s *Store) RunConsistencySweep(
+	ctx context.Context, limit rate.Limit, resumeKey roachpb.RKey,
+) (ConsistencySweepSummary, error) {
+	if len(resumeKey) == 0 {
+		resumeKey = roachpb.RKeyMin
+	}
+	// Collect the replicas up front; VisitReplicasByKey holds store.mu, which
+	// must not be held across the checks.
+	var repls []*Replica
+	s.VisitReplicasByKey(ctx, resumeKey, roachpb.RKeyMax, func(_ context.Context, kr KeyRange) bool {
+		if repl, ok := kr.(*Replica); ok {
+			repls = append(repls, repl)
+		}
+		return true
+	})
+
+	if limit <= 0 {
+		limit = rate.Inf
+	}
+	summary := ConsistencySweepSummary{Errors: map[roachpb.RangeID]error{}}
+	limiter := rate.NewLimiter(limit, 1)
+	for _, repl := range repls {
+		if err := limiter.Wait(ctx); err != nil {
+			summary.ResumeKey = repl.Desc().StartKey
+			return summary, err
+		}
+		if _, err := repl.IsDestroyed(); err != nil {
+			continue
+		}
+		if _, pErr := repl.redirectOnOrAcquireLease(ctx); pErr != nil {
+			if err := ctx.Err(); err != nil {
+				summary.ResumeKey = repl.Desc().StartKey
+				return summary, err
+			}
+			switch v := pErr.GetDetail().(type) {
+			case *roachpb.NotLeaseHolderError, *roachpb.RangeNotFoundError:
+				log.VEventf(ctx, 3, "%s: %s; skipping", repl, v)
+				summary.Skipped++
+			default:
+				summary.Errors[repl.RangeID] = errors.Wrapf(pErr.GoError(), "%s: could not obtain lease", repl)
+			}
+			continue
+		}
+		resp, pErr := repl.CheckConsistency(ctx, roachpb.CheckConsistencyRequest{
+			Mode: roachpb.ChecksumMode_CHECK_FULL,
+		})
+		if pErr != nil {
+			if err := ctx.Err(); err != nil {
+				summary.ResumeKey = repl.Desc().StartKey
+				return summary, err
+			}
+			summary.Errors[repl.RangeID] = pErr.GoError()
+			continue
+		}
+		for _, res := range resp.Result {
+			summary.add(res)
+		}
+	}
+	return summary, nil
+}
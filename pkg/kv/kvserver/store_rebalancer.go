@@ -377,6 +377,12 @@ func (sr *StoreRebalancer) chooseLeaseToTransfer(
 			continue
 		}
 
+		if replWithStats.repl.inLeaseTransferCooldown() {
+			log.VEventf(ctx, 3, "r%d's lease changed hands recently, not moving it",
+				replWithStats.repl.RangeID)
+			continue
+		}
+
 		// Don't bother moving leases whose QPS is below some small fraction of the
 		// store's QPS (unless the store has extra leases to spare anyway). It's
 		// just unnecessary churn with no benefit to move leases responsible for,
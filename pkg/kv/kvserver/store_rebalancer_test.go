@@ -15,6 +15,7 @@ import (
 	"reflect"
 	"sort"
 	"testing"
+	"time"
 
 	"github.com/cockroachdb/cockroach/pkg/roachpb"
 	"github.com/cockroachdb/cockroach/pkg/storage/enginepb"
@@ -22,6 +23,7 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/util/hlc"
 	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
 	"github.com/cockroachdb/cockroach/pkg/util/stop"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
 	"github.com/gogo/protobuf/proto"
 	"go.etcd.io/etcd/raft"
 	"go.etcd.io/etcd/raft/tracker"
@@ -189,6 +191,74 @@ func TestChooseLeaseToTransfer(t *testing.T) {
 	}
 }
 
+// TestChooseLeaseToTransferCooldown verifies that the store rebalancer doesn't
+// transfer the lease of a range away again while the range is in its lease
+// transfer cooldown.
+func TestChooseLeaseToTransferCooldown(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	stopper, g, _, a, _ := createTestAllocator(10, false /* deterministic */)
+	defer stopper.Stop(ctx)
+	gossiputil.NewStoreGossiper(g).GossipStores(noLocalityStores, t)
+	storeList, _, _ := a.storePool.getStoreList(storeFilterThrottled)
+	storeMap := storeListToMap(storeList)
+
+	const minQPS = 800
+	const maxQPS = 1200
+
+	localDesc := *noLocalityStores[0]
+	cfg := TestStoreConfig(nil)
+	s := createTestStoreWithoutStart(t, stopper, testStoreOpts{createSystemRanges: true}, &cfg)
+	s.Ident = &roachpb.StoreIdent{StoreID: localDesc.StoreID}
+	rq := newReplicateQueue(s, g, a)
+	rr := newReplicaRankings()
+
+	sr := NewStoreRebalancer(cfg.AmbientCtx, cfg.Settings, rq, rr)
+	sr.getRaftStatusFn = func(r *Replica) *raft.Status {
+		status := &raft.Status{
+			Progress: make(map[uint64]tracker.Progress),
+		}
+		status.Lead = uint64(r.ReplicaID())
+		status.Commit = 1
+		for _, replica := range r.Desc().InternalReplicas {
+			status.Progress[uint64(replica.ReplicaID)] = tracker.Progress{
+				Match: 1,
+				State: tracker.StateReplicate,
+			}
+		}
+		return status
+	}
+
+	loadRanges(rr, s, []testRange{{storeIDs: []roachpb.StoreID{1, 5}, qps: 100}})
+	chooseTarget := func() roachpb.StoreID {
+		hottestRanges := rr.topQPS()
+		_, target, _ := sr.chooseLeaseToTransfer(
+			ctx, &hottestRanges, &localDesc, storeList, storeMap, minQPS, maxQPS)
+		return target.StoreID
+	}
+	if target := chooseTarget(); target != 5 {
+		t.Fatalf("got target store %d; want 5", target)
+	}
+
+	// Pretend that the lease just moved to the local store. The transfer is
+	// skipped until the cooldown has elapsed.
+	repl := rr.topQPS()[0].repl
+	repl.mu.Lock()
+	repl.mu.leaseTransferCooldownUntil = timeutil.Now().Add(time.Hour)
+	repl.mu.Unlock()
+	if target := chooseTarget(); target != 0 {
+		t.Fatalf("got target store %d during the cooldown; want none", target)
+	}
+
+	repl.mu.Lock()
+	repl.mu.leaseTransferCooldownUntil = timeutil.Now()
+	repl.mu.Unlock()
+	if target := chooseTarget(); target != 5 {
+		t.Fatalf("got target store %d after the cooldown; want 5", target)
+	}
+}
+
 func TestChooseReplicaToRebalance(t *testing.T) {
 	defer leaktest.AfterTest(t)()
 
@@ -13,11 +13,13 @@ package kvserver
 import (
 	"bytes"
 	"context"
+	"time"
 
 	"github.com/cockroachdb/cockroach/pkg/kv/kvserver/stateloader"
 	"github.com/cockroachdb/cockroach/pkg/roachpb"
 	"github.com/cockroachdb/cockroach/pkg/storage"
 	"github.com/cockroachdb/cockroach/pkg/storage/enginepb"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
 	"github.com/cockroachdb/errors"
 	"go.etcd.io/etcd/raft"
@@ -155,6 +157,15 @@ func splitPreApply(
 	rightRepl.mu.Unlock()
 }
 
+// maybeAddToSplitQueueAfterApply offers the replica to the split queue
+// following the application of a command.
+func (r *Replica) maybeAddToSplitQueueAfterApply(ctx context.Context, now hlc.Timestamp) {
+	if fn := r.store.TestingKnobs().SplitQueueEnqueueAfterApplyEvent; fn != nil {
+		fn(r)
+	}
+	r.store.splitQueue.MaybeAddAsync(ctx, r, now)
+}
+
 // splitPostApply is the part of the split trigger which coordinates the actual
 // split with the Store. Requires that Replica.raftMu is held.
 func splitPostApply(
@@ -175,12 +186,19 @@ func splitPostApply(
 	// Update store stats with difference in stats before and after split.
 	r.store.metrics.addMVCCStats(deltaMS)
 
+	// The stats of the RHS were written by the split trigger rather than
+	// accumulated through the application of deltas, so validate them here.
+	if validateAppliedStats && rightReplOrNil != nil {
+		rhsStats := rightReplOrNil.GetMVCCStats()
+		_ = rightReplOrNil.maybeValidateAppliedStats(ctx, &rhsStats, deltaMS)
+	}
+
 	now := r.store.Clock().Now()
 
 	// While performing the split, zone config changes or a newly created table
 	// might require the range to be split again. Enqueue both the left and right
 	// ranges to speed up such splits. See #10160.
-	r.store.splitQueue.MaybeAddAsync(ctx, r, now)
+	r.maybeAddToSplitQueueAfterApply(ctx, now)
 	// If the range was not properly replicated before the split, the replicate
 	// queue may not have picked it up (due to the need for a split). Enqueue
 	// both the left and right ranges to speed up a potentially necessary
@@ -188,7 +206,7 @@ func splitPostApply(
 	r.store.replicateQueue.MaybeAddAsync(ctx, r, now)
 
 	if rightReplOrNil != nil {
-		r.store.splitQueue.MaybeAddAsync(ctx, rightReplOrNil, now)
+		rightReplOrNil.maybeAddToSplitQueueAfterApply(ctx, now)
 		r.store.replicateQueue.MaybeAddAsync(ctx, rightReplOrNil, now)
 		if len(split.RightDesc.Replicas().All()) == 1 {
 			// TODO(peter): In single-node clusters, we enqueue the right-hand side of
@@ -236,10 +254,12 @@ func prepareRightReplicaForSplit(
 		log.Fatalf(ctx, "%v", err)
 	}
 
-	// Copy the minLeaseProposedTS from the LHS and grab the RHS's lease.
+	// Copy the minLeaseProposedTS and tsCacheLowWater from the LHS and grab
+	// the RHS's lease.
 	r.mu.RLock()
 	rightRepl.mu.Lock()
 	rightRepl.mu.minLeaseProposedTS = r.mu.minLeaseProposedTS
+	rightRepl.mu.tsCacheLowWater = r.mu.tsCacheLowWater
 	rightLease := *rightRepl.mu.state.Lease
 	rightRepl.mu.Unlock()
 	r.mu.RUnlock()
@@ -263,7 +283,7 @@ func prepareRightReplicaForSplit(
 	// Invoke the leasePostApply method to ensure we properly initialize
 	// the replica according to whether it holds the lease. This enables
 	// the txnWaitQueue.
-	rightRepl.leasePostApply(ctx, rightLease, false /* permitJump */)
+	rightRepl.leasePostApply(ctx, rightLease, false /* permitJump */, time.Time{} /* proposedAt */)
 	return rightRepl
 }
 
@@ -11,6 +11,7 @@
 package kvserver
 
 import (
+	"context"
 	"time"
 
 	"github.com/cockroachdb/cockroach/pkg/kv/kvserver/kvserverbase"
@@ -57,11 +58,36 @@ type StoreTestingKnobs struct {
 	// It is only called on the replica the proposed the command.
 	TestingPostApplyFilter kvserverbase.ReplicaApplyFilter
 
+	// OnProposalEndCmdsDone, if set, is called whenever a proposal releases its
+	// latches (i.e. calls endCmds.done) after it finished applying or was
+	// rejected, before the client is acknowledged. reproposed is true if the
+	// command was reproposed with a new lease index after first failing to
+	// apply. It is only called on the replica which proposed the command.
+	OnProposalEndCmdsDone func(cmdID kvserverbase.CmdIDKey, reproposed bool)
+
 	// TestingResponseFilter is called after the replica processes a
 	// command in order for unittests to modify the batch response,
 	// error returned to the client, or to simulate network failures.
 	TestingResponseFilter kvserverbase.ReplicaResponseFilter
 
+	// NodeLivenessGossipFilter is called before each attempt to gossip node
+	// liveness records in response to an applied command. If it returns an
+	// error, the attempt fails with that error and is retried.
+	NodeLivenessGossipFilter func(span roachpb.Span) error
+
+	// WriteBatchTransform, if set, is called with the WriteBatch of each
+	// command applied by the store's replicas, and the batch it returns is
+	// staged in its place. It is used to experiment with transformations of
+	// the replicated mutations, such as encryption. Since it runs on every
+	// replica, it must be deterministic for the replicas to remain consistent.
+	// An error fails the application of the command before anything is staged.
+	WriteBatchTransform func(data []byte) ([]byte, error)
+
+	// ReplicatedEvalResultStepEvent, if set, is called for each step carried
+	// out while applying the side effects of a ReplicatedEvalResult, naming the
+	// step. See replicatedEvalResultHandlers.
+	ReplicatedEvalResultStepEvent func(rangeID roachpb.RangeID, step string)
+
 	// TestingRangefeedFilter is called before a replica processes a rangefeed
 	// in order for unit tests to modify the request, error returned to the client
 	// or data.
@@ -94,10 +120,32 @@ type StoreTestingKnobs struct {
 	// called to acquire a new lease. This can be used to assert that a request
 	// triggers a lease acquisition.
 	LeaseRequestEvent func(ts hlc.Timestamp)
+	// LeasePostApplyInterceptor, if set, is called at the top of
+	// replica.leasePostApply() whenever the replica applies a lease naming it
+	// the leaseholder. If the lease changed hands, the update of the timestamp
+	// cache's low water mark to the start of the new lease is postponed by the
+	// returned delay. If suppress is false, leasePostApply blocks for the delay
+	// before carrying out the update. Otherwise, leasePostApply proceeds without
+	// the update, which is instead carried out asynchronously once the delay
+	// has elapsed.
+	LeasePostApplyInterceptor func(
+		ctx context.Context, newLease roachpb.Lease,
+	) (delay time.Duration, suppress bool)
 	// LeaseTransferBlockedOnExtensionEvent, if set, is called when
 	// replica.TransferLease() encounters an in-progress lease extension.
 	// nextLeader is the replica that we're trying to transfer the lease to.
 	LeaseTransferBlockedOnExtensionEvent func(nextLeader roachpb.ReplicaDescriptor)
+	// GossipFirstRangeLeaseCheck, if set, is called in place of
+	// replica.getLeaseForGossip() when a command requests that the first range
+	// be gossiped. It allows tests to decide deterministically whether the
+	// replica holds the lease.
+	GossipFirstRangeLeaseCheck func(ctx context.Context) (hasLease bool, pErr *roachpb.Error)
+	// EncounteredIntentsInterceptor, if set, is handed the intents encountered
+	// by a request before they are passed to the intent resolver for
+	// asynchronous cleanup. The returned intents are cleaned up in their stead,
+	// which lets tests filter or reorder them. Returning no intents skips the
+	// cleanup altogether.
+	EncounteredIntentsInterceptor func(rangeID roachpb.RangeID, intents []roachpb.Intent) []roachpb.Intent
 	// DisableGCQueue disables the GC queue.
 	DisableGCQueue bool
 	// DisableMergeQueue disables the merge queue.
@@ -115,6 +163,9 @@ type StoreTestingKnobs struct {
 	DisableLoadBasedSplitting bool
 	// DisableSplitQueue disables the split queue.
 	DisableSplitQueue bool
+	// SplitQueueEnqueueAfterApplyEvent, if set, is called whenever the
+	// application of a command offers a replica to the split queue.
+	SplitQueueEnqueueAfterApplyEvent func(*Replica)
 	// DisableTimeSeriesMaintenanceQueue disables the time series maintenance
 	// queue.
 	DisableTimeSeriesMaintenanceQueue bool
@@ -68,6 +68,10 @@ type Cache interface {
 	// returned for the read timestamps.
 	GetMax(start, end roachpb.Key) (hlc.Timestamp, uuid.UUID)
 
+	// GetLowWater returns the low water mark of the cache as a whole. The low
+	// water mark of individual spans may be higher (see SetLowWater).
+	GetLowWater() hlc.Timestamp
+
 	// Metrics returns the Cache's metrics struct.
 	Metrics() Metrics
 
@@ -75,8 +79,6 @@ type Cache interface {
 	//
 	// clear clears the cache and resets the low-water mark.
 	clear(lowWater hlc.Timestamp)
-	// getLowWater return the low water mark.
-	getLowWater() hlc.Timestamp
 }
 
 // New returns a new timestamp cache with the supplied hybrid-logical clock.
@@ -63,8 +63,8 @@ func (tc *sklImpl) SetLowWater(start, end roachpb.Key, ts hlc.Timestamp) {
 	tc.Add(start, end, ts, noTxnID)
 }
 
-// getLowWater implements the Cache interface.
-func (tc *sklImpl) getLowWater() hlc.Timestamp {
+// GetLowWater implements the Cache interface.
+func (tc *sklImpl) GetLowWater() hlc.Timestamp {
 	return tc.cache.FloorTS()
 }
 
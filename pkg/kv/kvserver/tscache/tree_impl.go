@@ -451,8 +451,8 @@ func (tc *treeImpl) SetLowWater(start, end roachpb.Key, ts hlc.Timestamp) {
 	tc.Add(start, end, ts, noTxnID)
 }
 
-// getLowWater implements the Cache interface.
-func (tc *treeImpl) getLowWater() hlc.Timestamp {
+// GetLowWater implements the Cache interface.
+func (tc *treeImpl) GetLowWater() hlc.Timestamp {
 	tc.RLock()
 	defer tc.RUnlock()
 	return tc.lowWater
@@ -652,6 +652,9 @@ func (*RangeStatsRequest) Method() Method { return RangeStats }
 // Method implements the Request interface.
 func (*AdminVerifyProtectedTimestampRequest) Method() Method { return AdminVerifyProtectedTimestamp }
 
+// Method implements the Request interface.
+func (*SetRaftLogQueueDisabledRequest) Method() Method { return SetRaftLogQueueDisabled }
+
 // ShallowCopy implements the Request interface.
 func (gr *GetRequest) ShallowCopy() Request {
 	shallowCopy := *gr
@@ -916,6 +919,12 @@ func (r *AdminVerifyProtectedTimestampRequest) ShallowCopy() Request {
 	return &shallowCopy
 }
 
+// ShallowCopy implements the Request interface.
+func (r *SetRaftLogQueueDisabledRequest) ShallowCopy() Request {
+	shallowCopy := *r
+	return &shallowCopy
+}
+
 // NewGet returns a Request initialized to get the value at key.
 func NewGet(key Key) Request {
 	return &GetRequest{
@@ -1218,8 +1227,9 @@ func (r *RefreshRangeRequest) flags() int {
 	return isRead | isTxn | isRange | updatesTSCache
 }
 
-func (*SubsumeRequest) flags() int    { return isRead | isAlone | updatesTSCache }
-func (*RangeStatsRequest) flags() int { return isRead }
+func (*SubsumeRequest) flags() int                 { return isRead | isAlone | updatesTSCache }
+func (*RangeStatsRequest) flags() int              { return isRead }
+func (*SetRaftLogQueueDisabledRequest) flags() int { return isWrite | isAlone }
 
 // IsParallelCommit returns whether the EndTxn request is attempting to perform
 // a parallel commit. See txn_interceptor_committer.go for a discussion about
@@ -155,6 +155,9 @@ const (
 	// VerifyProtectedTimestamp determines whether the specified protection record
 	// will be respected by this Range.
 	AdminVerifyProtectedTimestamp
+	// SetRaftLogQueueDisabled sets whether the replicas of a range may be
+	// offered to the raft log queue when they apply commands.
+	SetRaftLogQueueDisabled
 	// NumMethods represents the total number of API methods.
 	NumMethods
 )
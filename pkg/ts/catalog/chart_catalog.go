@@ -793,6 +793,14 @@ var charts = []sectionDescription{
 					"intentresolver.async.throttled",
 				},
 			},
+			{
+				Title: "Asynchronously Resolved Intents",
+				Metrics: []string{
+					"intentresolver.async.handedoff",
+					"intentresolver.async.completed",
+					"intentresolver.async.failed",
+				},
+			},
 			{
 				Title: "Overview",
 				Metrics: []string{
@@ -1005,6 +1013,34 @@ var charts = []sectionDescription{
 				Title:   "Time Spent",
 				Metrics: []string{"queue.consistency.processingnanos"},
 			},
+			{
+				Title:   "Oldest Pending Checksum",
+				Metrics: []string{"queue.consistency.oldest_pending_checksum_nanos"},
+			},
+			{
+				Title:   "Checksums Over GC Interval",
+				Metrics: []string{"queue.consistency.checksums_over_gc_interval"},
+			},
+		},
+	},
+	{
+		Organization: [][]string{{ReplicationLayer, "Stats Reconciliation Queue"}},
+		Charts: []chartDescription{
+			{
+				Title:   "Pending",
+				Metrics: []string{"queue.stats.pending"},
+			},
+			{
+				Title: "Successes",
+				Metrics: []string{
+					"queue.stats.process.failure",
+					"queue.stats.process.success",
+				},
+			},
+			{
+				Title:   "Time Spent",
+				Metrics: []string{"queue.stats.processingnanos"},
+			},
 		},
 	},
 	{
@@ -1060,6 +1096,26 @@ var charts = []sectionDescription{
 					"leases.transfers.success",
 				},
 			},
+			{
+				Title:   "Timestamp Cache Low Water Jump",
+				Metrics: []string{"leases.tscache_low_water_jump"},
+			},
+			{
+				Title:   "Large Timestamp Cache Low Water Jumps",
+				Metrics: []string{"leases.tscache_low_water_jump.large"},
+			},
+			{
+				Title:   "Leases Lost",
+				Metrics: []string{"leases.lost"},
+			},
+			{
+				Title:   "Lease Start Skew",
+				Metrics: []string{"leases.start_skew"},
+			},
+			{
+				Title:   "Lease Application Latency",
+				Metrics: []string{"leases.apply_latency"},
+			},
 		},
 	},
 	{
@@ -1137,6 +1193,14 @@ var charts = []sectionDescription{
 				Title:   "Apply Committed",
 				Metrics: []string{"raft.process.applycommitted.latency"},
 			},
+			{
+				Title:   "Apply Side Effects (Proposer)",
+				Metrics: []string{"raft.process.applysideeffects.proposer.latency"},
+			},
+			{
+				Title:   "Apply Side Effects (Follower)",
+				Metrics: []string{"raft.process.applysideeffects.follower.latency"},
+			},
 			{
 				Title:   "Command Commit",
 				Metrics: []string{"raft.process.commandcommit.latency"},
@@ -1171,6 +1235,10 @@ var charts = []sectionDescription{
 				Title:   "Commands Count",
 				Metrics: []string{"raft.commandsapplied"},
 			},
+			{
+				Title:   "Write Batch Bytes Applied",
+				Metrics: []string{"raft.commands.writebytes"},
+			},
 			{
 				Title:   "Enqueued",
 				Metrics: []string{"raft.enqueued.pending"},
@@ -1183,6 +1251,10 @@ var charts = []sectionDescription{
 				Title:   "Leader Transfers",
 				Metrics: []string{"range.raftleadertransfers"},
 			},
+			{
+				Title:   "Skipped Leader Transfers",
+				Metrics: []string{"range.raftleadertransfers.skipped"},
+			},
 			{
 				Title:   "Leaders",
 				Metrics: []string{"replicas.leaders"},